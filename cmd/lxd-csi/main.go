@@ -3,30 +3,92 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
 
 	"k8s.io/klog/v2"
 
 	"github.com/canonical/lxd-csi-driver/internal/driver"
+	"github.com/canonical/lxd-csi-driver/internal/fs"
 )
 
 var (
 	driverName       = flag.String("driver-name", driver.DefaultDriverName, "Name of the CSI driver")
 	endpoint         = flag.String("endpoint", driver.DefaultDriverEndpoint, "CSI endpoint (unix socket path)")
-	devLXDEndpoint   = flag.String("devlxd-endpoint", driver.DefaultDevLXDEndpoint, "Devlxd endpoint (devlxd unix socket path)")
+	devLXDEndpoint   = flag.String("devlxd-endpoint", driver.DefaultDevLXDEndpoint, "Devlxd endpoint (devlxd unix socket path), or a comma separated list of endpoints tried in order")
+	devLXDTokenFile  = flag.String("devlxd-token-file", driver.DefaultDevLXDTokenFile, "Path to the file containing the devLXD bearer token")
 	volumeNamePrefix = flag.String("volume-name-prefix", driver.DefaultVolumeNamePrefix, "Prefix used for LXD volume names")
+	poolPrefixMap    = flag.String("pool-prefix-map", "", `Prefixes used for LXD volume names per storage pool (e.g. "fast=prod,slow=scratch")`)
+	snapshotPrefix   = flag.String("snapshot-name-prefix", "", "Prefix used for LXD volume snapshot names (defaults to the prefix of the requested snapshot name)")
+	fsMountPath      = flag.String("filesystem-mount-path", driver.DefaultFileSystemMountPath, "Path within the node where LXD mounts filesystem volumes (must match between controller and node)")
 	nodeID           = flag.String("node-id", "", "Kubernetes node ID")
+	nodeInstanceMap  = flag.String("node-instance-map", "", `Names of the LXD instances per Kubernetes node ID, if they differ (e.g. "worker-1=k8s-worker-1")`)
 	isController     = flag.Bool("controller", false, "Start LXD CSI driver controller server")
+	cancelPolicy     = flag.String("create-volume-cancel-policy", driver.DefaultCreateVolumeCancelPolicy, `Policy for volumes created by a cancelled CreateVolume request ("keep" or "delete")`)
+	snapshotsPolicy  = flag.String("delete-volume-snapshots-policy", driver.DefaultDeleteVolumeSnapshotsPolicy, `Policy for LXD snapshots of a volume that is being deleted ("refuse" or "delete")`)
+	maxOperations    = flag.Int("max-concurrent-operations", 0, "Maximum number of concurrent long-running LXD operations in the controller server (0 means unlimited)")
+	maxVolumes       = flag.Int("max-volumes-per-node", 0, "Maximum number of disk devices attached to the node, including devices not managed by the driver (0 means unlimited)")
+	unmountRetries   = flag.Int("unmount-retries", driver.DefaultUnmountRetries, "Number of attempts to unmount a volume")
+	unmountInterval  = flag.Duration("unmount-retry-interval", driver.DefaultUnmountRetryInterval, "Initial interval between attempts to unmount a volume, doubled after each attempt up to 2s")
+	targetDirMode    = flag.String("mount-target-dir-mode", fmt.Sprintf("%#o", uint32(fs.DefaultMountTargetDirMode)), "Mode (octal) of directories created as mount targets of filesystem volumes")
+	targetFileMode   = flag.String("mount-target-file-mode", fmt.Sprintf("%#o", uint32(fs.DefaultMountTargetFileMode)), "Mode (octal) of files created as mount targets of block volumes")
+	enableSnapshots  = flag.Bool("enable-snapshots", true, "Enable volume snapshot support in the controller server")
+	leaderElection   = flag.Bool("leader-election", false, "Serve controller requests only while elected as the leader using a Kubernetes lease (the CSI sidecars must run without leader election)")
+	leaderElectionNS = flag.String("leader-election-namespace", "", "Namespace of the leader election lease (defaults to the namespace of the pod)")
+	distributedLocks = flag.Bool("distributed-locking", false, "Guard volumes against concurrent requests from other controller replicas using locks stored in LXD")
 	showVersion      = flag.Bool("version", false, "Show driver version and exit")
+	printConfig      = flag.Bool("print-config", false, "Print effective driver configuration as JSON and exit")
+	selfTest         = flag.Bool("self-test", false, "Check connectivity and permissions against devLXD and exit")
+	selfTestPools    = flag.String("self-test-storage-pools", "", "Comma separated list of storage pools checked by the self-test")
 )
 
 func run() error {
+	poolPrefixes, err := driver.ParsePoolPrefixMap(*poolPrefixMap)
+	if err != nil {
+		return err
+	}
+
+	nodeInstances, err := driver.ParseNodeInstanceMap(*nodeInstanceMap)
+	if err != nil {
+		return err
+	}
+
+	dirMode, err := parseFileMode(*targetDirMode)
+	if err != nil {
+		return fmt.Errorf("Invalid mount target directory mode: %w", err)
+	}
+
+	fileMode, err := parseFileMode(*targetFileMode)
+	if err != nil {
+		return fmt.Errorf("Invalid mount target file mode: %w", err)
+	}
+
 	d := driver.NewDriver(driver.DriverOptions{
 		Name:             *driverName,
 		Endpoint:         *endpoint,
 		DevLXDEndpoint:   *devLXDEndpoint,
+		DevLXDTokenFile:  *devLXDTokenFile,
 		VolumeNamePrefix: *volumeNamePrefix,
+		PoolPrefixMap:    poolPrefixes,
 		NodeID:           *nodeID,
+		NodeInstanceMap:  nodeInstances,
 		IsController:     *isController,
+
+		FileSystemMountPath:         *fsMountPath,
+		SnapshotNamePrefix:          *snapshotPrefix,
+		EnableSnapshots:             *enableSnapshots,
+		LeaderElection:              *leaderElection,
+		LeaderElectionNamespace:     *leaderElectionNS,
+		DistributedLocking:          *distributedLocks,
+		CreateVolumeCancelPolicy:    *cancelPolicy,
+		DeleteVolumeSnapshotsPolicy: *snapshotsPolicy,
+		MaxConcurrentOperations:     *maxOperations,
+		MaxVolumesPerNode:           *maxVolumes,
+		UnmountRetries:              *unmountRetries,
+		UnmountRetryInterval:        *unmountInterval,
+		MountTargetDirMode:          dirMode,
+		MountTargetFileMode:         fileMode,
 	})
 
 	if *showVersion {
@@ -34,9 +96,43 @@ func run() error {
 		return nil
 	}
 
+	if *printConfig {
+		config, err := d.EffectiveConfig()
+		if err != nil {
+			return err
+		}
+
+		out, err := config.JSON()
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(out)
+		return nil
+	}
+
+	if *selfTest {
+		var pools []string
+		if *selfTestPools != "" {
+			pools = strings.Split(*selfTestPools, ",")
+		}
+
+		return d.SelfTest(os.Stdout, pools)
+	}
+
 	return d.Run()
 }
 
+// parseFileMode parses a file mode in octal notation.
+func parseFileMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Mode %q is not a valid octal number", value)
+	}
+
+	return os.FileMode(mode), nil
+}
+
 func main() {
 	klog.InitFlags(nil)
 	flag.Parse()
@@ -1,8 +1,12 @@
 package devlxd
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"slices"
+	"strings"
 
 	"k8s.io/klog/v2"
 
@@ -15,6 +19,44 @@ const (
 	devLXDUserAgent = "lxd-csi-driver"
 )
 
+var (
+	// ErrSocketNotFound is returned by [Connect] if the devLXD socket does not
+	// exist. This is expected shortly after the instance starts, before LXD
+	// exposes the devLXD socket, and the connection can be retried.
+	ErrSocketNotFound = errors.New("Socket does not exist")
+
+	// ErrNotSocket is returned by [Connect] if the devLXD socket path exists
+	// but is not a unix socket. This indicates a misconfiguration, as the path
+	// is not going to become a socket on its own.
+	ErrNotSocket = errors.New("Not a socket")
+)
+
+// ParseEndpoints parses a comma separated list of devLXD endpoints and
+// ensures each of them is a valid unix socket URL. The order of the
+// endpoints is preserved.
+func ParseEndpoints(value string) ([]string, error) {
+	var endpoints []string
+	for endpoint := range strings.SplitSeq(value, ",") {
+		endpoint = strings.TrimSpace(endpoint)
+		if endpoint == "" {
+			return nil, fmt.Errorf("Invalid devLXD endpoints %q: Endpoint cannot be empty", value)
+		}
+
+		_, _, err := utils.ParseUnixSocketURL(endpoint)
+		if err != nil {
+			return nil, err
+		}
+
+		if slices.Contains(endpoints, endpoint) {
+			return nil, fmt.Errorf("Invalid devLXD endpoints %q: Duplicate endpoint %q", value, endpoint)
+		}
+
+		endpoints = append(endpoints, endpoint)
+	}
+
+	return endpoints, nil
+}
+
 // Connect establishes a connection to the devLXD server at the specified endpoint.
 func Connect(endpoint string, bearerToken string) (lxdClient.DevLXDServer, error) {
 	// Parse and verify devLXD address.
@@ -25,11 +67,15 @@ func Connect(endpoint string, bearerToken string) (lxdClient.DevLXDServer, error
 
 	socketInfo, err := os.Stat(socket)
 	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("DevLXD socket %q is not available: %w", socket, ErrSocketNotFound)
+		}
+
 		return nil, err
 	}
 
 	if socketInfo.Mode()&os.ModeSocket == 0 {
-		return nil, fmt.Errorf("Invalid devLXD socket path %q: Not a socket", socket)
+		return nil, fmt.Errorf("Invalid devLXD socket path %q: %w", socket, ErrNotSocket)
 	}
 
 	// Connect to devLXD.
@@ -0,0 +1,45 @@
+package devlxd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectSocketErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	regularFile := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(regularFile, nil, 0o600))
+
+	tests := []struct {
+		Name        string
+		Endpoint    string
+		expectError error
+	}{
+		{
+			Name:        "Missing socket",
+			Endpoint:    "unix://" + filepath.Join(dir, "missing.sock"),
+			expectError: ErrSocketNotFound,
+		},
+		{
+			Name:        "Regular file",
+			Endpoint:    "unix://" + regularFile,
+			expectError: ErrNotSocket,
+		},
+		{
+			Name:        "Directory",
+			Endpoint:    "unix://" + dir + "/.",
+			expectError: ErrNotSocket,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			_, err := Connect(test.Endpoint, "token")
+			require.ErrorIs(t, err, test.expectError)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package driver
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 )
@@ -38,6 +39,10 @@ func ValidateVolumeCapabilities(volCaps ...*csi.VolumeCapability) error {
 	accessTypeMount := false
 
 	for _, c := range volCaps {
+		if c == nil {
+			return errors.New("Volume capability is missing")
+		}
+
 		if c.GetBlock() != nil {
 			accessTypeBlock = true
 		}
@@ -58,17 +63,111 @@ func ValidateVolumeCapabilities(volCaps ...*csi.VolumeCapability) error {
 	return nil
 }
 
+// withDefaultAccessType returns the given volume capabilities with the access type
+// matching the given content type ("block" or "filesystem") if none of them specifies
+// an access type. Otherwise, the capabilities are returned unchanged, so that the
+// access type requested by the capabilities always takes precedence.
+func withDefaultAccessType(contentType string, volCaps ...*csi.VolumeCapability) []*csi.VolumeCapability {
+	for _, c := range volCaps {
+		if c.GetBlock() != nil || c.GetMount() != nil {
+			return volCaps
+		}
+	}
+
+	caps := make([]*csi.VolumeCapability, 0, len(volCaps))
+	for _, c := range volCaps {
+		if c == nil {
+			caps = append(caps, c)
+			continue
+		}
+
+		defaultCap := &csi.VolumeCapability{AccessMode: c.AccessMode}
+		if contentType == "block" {
+			defaultCap.AccessType = &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}
+		} else {
+			defaultCap.AccessType = &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}
+		}
+
+		caps = append(caps, defaultCap)
+	}
+
+	return caps
+}
+
 // ParseContentType parses the content type from the given VolumeCapability array.
+// It returns "block" if all capabilities request the block access type and
+// "filesystem" if all capabilities request the mount access type. Capabilities
+// without an access type are ignored. An empty string is returned when no access
+// type is requested or when block and mount access types are mixed, which is
+// consistent with ValidateVolumeCapabilities rejecting such capabilities.
 func ParseContentType(volCaps ...*csi.VolumeCapability) string {
+	contentType := ""
+
 	for _, c := range volCaps {
+		var capContentType string
 		if c.GetBlock() != nil {
-			return "block"
+			capContentType = "block"
+		} else if c.GetMount() != nil {
+			capContentType = "filesystem"
+		} else {
+			continue
 		}
 
-		if c.GetMount() != nil {
-			return "filesystem"
+		if contentType != "" && contentType != capContentType {
+			return ""
 		}
+
+		contentType = capContentType
 	}
 
-	return ""
+	return contentType
+}
+
+// ParseFilesystemType parses the filesystem type from the given VolumeCapability
+// array. It returns the filesystem type requested by the mount capabilities, or
+// an empty string if none of them requests a specific filesystem type. An error
+// is returned if the capabilities request different filesystem types, as they
+// cannot be satisfied by a single volume.
+func ParseFilesystemType(volCaps ...*csi.VolumeCapability) (string, error) {
+	fsType := ""
+
+	for _, c := range volCaps {
+		capFsType := c.GetMount().GetFsType()
+		if capFsType == "" {
+			continue
+		}
+
+		if fsType != "" && fsType != capFsType {
+			return "", fmt.Errorf("VolumeCapabilities cannot request different filesystem types %q and %q", fsType, capFsType)
+		}
+
+		fsType = capFsType
+	}
+
+	return fsType, nil
+}
+
+// isReadOnlyAccessMode returns true if the access mode of the given volume
+// capability allows only reading from the volume.
+func isReadOnlyAccessMode(volCap *csi.VolumeCapability) bool {
+	switch volCap.GetAccessMode().GetMode() {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY, csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		return true
+	default:
+		return false
+	}
+}
+
+// isSingleNodeAccessMode returns true if the access mode of the given volume
+// capability allows the volume to be published only on a single node.
+func isSingleNodeAccessMode(volCap *csi.VolumeCapability) bool {
+	switch volCap.GetAccessMode().GetMode() {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:
+		return true
+	default:
+		return false
+	}
 }
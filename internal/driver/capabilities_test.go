@@ -0,0 +1,198 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateVolumeCapabilities(t *testing.T) {
+	blockCapability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
+	}
+
+	mountCapability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{},
+		},
+	}
+
+	tests := []struct {
+		Name         string
+		Capabilities []*csi.VolumeCapability
+		expectError  string
+	}{
+		{
+			Name:         "Block capability",
+			Capabilities: []*csi.VolumeCapability{blockCapability},
+		},
+		{
+			Name:         "Mount capability",
+			Capabilities: []*csi.VolumeCapability{mountCapability, mountCapability},
+		},
+		{
+			Name:        "No capabilities",
+			expectError: "Request has no volume capabilities",
+		},
+		{
+			Name:         "Nil capability",
+			Capabilities: []*csi.VolumeCapability{nil},
+			expectError:  "Volume capability is missing",
+		},
+		{
+			Name:         "Nil capability among valid ones",
+			Capabilities: []*csi.VolumeCapability{mountCapability, nil},
+			expectError:  "Volume capability is missing",
+		},
+		{
+			Name:         "Capability without access type",
+			Capabilities: []*csi.VolumeCapability{{}},
+			expectError:  "access types undefined",
+		},
+		{
+			Name:         "Mixed access types",
+			Capabilities: []*csi.VolumeCapability{blockCapability, mountCapability},
+			expectError:  "access types defined",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			err := ValidateVolumeCapabilities(test.Capabilities...)
+			if test.expectError == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, test.expectError)
+			}
+		})
+	}
+}
+
+func TestParseContentType(t *testing.T) {
+	blockCapability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
+	}
+
+	mountCapability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{},
+		},
+	}
+
+	tests := []struct {
+		Name              string
+		Capabilities      []*csi.VolumeCapability
+		expectContentType string
+	}{
+		{
+			Name:              "Block only",
+			Capabilities:      []*csi.VolumeCapability{blockCapability, blockCapability},
+			expectContentType: "block",
+		},
+		{
+			Name:              "Mount only",
+			Capabilities:      []*csi.VolumeCapability{mountCapability},
+			expectContentType: "filesystem",
+		},
+		{
+			Name:              "Mount and capability without access type",
+			Capabilities:      []*csi.VolumeCapability{{}, mountCapability, nil},
+			expectContentType: "filesystem",
+		},
+		{
+			Name:              "Mixed block and mount",
+			Capabilities:      []*csi.VolumeCapability{blockCapability, mountCapability},
+			expectContentType: "",
+		},
+		{
+			Name:              "Mixed mount and block",
+			Capabilities:      []*csi.VolumeCapability{mountCapability, blockCapability},
+			expectContentType: "",
+		},
+		{
+			Name:              "Capability without access type",
+			Capabilities:      []*csi.VolumeCapability{{}},
+			expectContentType: "",
+		},
+		{
+			Name:              "Empty",
+			expectContentType: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			require.Equal(t, test.expectContentType, ParseContentType(test.Capabilities...))
+		})
+	}
+}
+
+func TestParseFilesystemType(t *testing.T) {
+	blockCapability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
+	}
+
+	mountCapability := func(fsType string) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{FsType: fsType},
+			},
+		}
+	}
+
+	tests := []struct {
+		Name         string
+		Capabilities []*csi.VolumeCapability
+		expectFsType string
+		expectError  string
+	}{
+		{
+			Name:         "Mount without filesystem type",
+			Capabilities: []*csi.VolumeCapability{mountCapability("")},
+		},
+		{
+			Name:         "Mount with filesystem type",
+			Capabilities: []*csi.VolumeCapability{mountCapability("xfs")},
+			expectFsType: "xfs",
+		},
+		{
+			Name:         "Mounts with and without filesystem type",
+			Capabilities: []*csi.VolumeCapability{mountCapability(""), mountCapability("ext4"), nil},
+			expectFsType: "ext4",
+		},
+		{
+			Name:         "Mounts with matching filesystem types",
+			Capabilities: []*csi.VolumeCapability{mountCapability("ext4"), mountCapability("ext4")},
+			expectFsType: "ext4",
+		},
+		{
+			Name:         "Mounts with different filesystem types",
+			Capabilities: []*csi.VolumeCapability{mountCapability("ext4"), mountCapability("xfs")},
+			expectError:  `cannot request different filesystem types "ext4" and "xfs"`,
+		},
+		{
+			Name:         "Block",
+			Capabilities: []*csi.VolumeCapability{blockCapability},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			fsType, err := ParseFilesystemType(test.Capabilities...)
+			if test.expectError != "" {
+				require.ErrorContains(t, err, test.expectError)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.expectFsType, fsType)
+		})
+	}
+}
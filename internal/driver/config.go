@@ -0,0 +1,78 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// EffectiveConfig represents the resolved configuration of the driver
+// together with the environment detected through devLXD.
+type EffectiveConfig struct {
+	Name                string   `json:"name"`
+	Version             string   `json:"version"`
+	Endpoint            string   `json:"endpoint"`
+	DevLXDEndpoint      string   `json:"devlxd_endpoint"`
+	NodeID              string   `json:"node_id"`
+	Role                string   `json:"role"`
+	VolumeNamePrefix    string   `json:"volume_name_prefix"`
+	FileSystemMountPath string   `json:"filesystem_mount_path"`
+	Clustered           bool     `json:"clustered"`
+	Location            string   `json:"location"`
+	Capabilities        []string `json:"capabilities"`
+}
+
+// EffectiveConfig connects to devLXD to detect the environment and returns
+// the effective configuration of the driver.
+func (d *Driver) EffectiveConfig() (*EffectiveConfig, error) {
+	_, err := d.DevLXDClient()
+	if err != nil {
+		return nil, err
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	config := d.effectiveConfig()
+	return &config, nil
+}
+
+// effectiveConfig returns the effective configuration of the driver based on
+// its current state, without connecting to devLXD.
+func (d *Driver) effectiveConfig() EffectiveConfig {
+	config := EffectiveConfig{
+		Name:                d.name,
+		Version:             d.version,
+		Endpoint:            d.endpoint,
+		DevLXDEndpoint:      d.devLXDEndpoint,
+		NodeID:              d.nodeID,
+		Role:                "node",
+		VolumeNamePrefix:    d.volumeNamePrefix,
+		FileSystemMountPath: d.fileSystemMountPath,
+		Clustered:           d.isClustered,
+		Location:            d.location,
+		Capabilities:        []string{},
+	}
+
+	if d.isController {
+		config.Role = "controller"
+		for _, c := range d.controllerServiceCapabilities() {
+			config.Capabilities = append(config.Capabilities, c.String())
+		}
+	} else {
+		for _, c := range nodeServiceCapabilities() {
+			config.Capabilities = append(config.Capabilities, c.String())
+		}
+	}
+
+	return config
+}
+
+// JSON returns the configuration serialized as indented JSON.
+func (c EffectiveConfig) JSON() (string, error) {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("Failed to serialize configuration: %w", err)
+	}
+
+	return string(data), nil
+}
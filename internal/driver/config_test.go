@@ -0,0 +1,90 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEffectiveConfig(t *testing.T) {
+	tests := []struct {
+		Name       string
+		Driver     *Driver
+		expectJSON string
+	}{
+		{
+			Name: "Controller in clustered LXD",
+			Driver: &Driver{
+				name:                "lxd.csi.canonical.com",
+				version:             "v1.0.0",
+				endpoint:            "unix:///csi/csi.sock",
+				devLXDEndpoint:      "unix:///dev/lxd/sock",
+				nodeID:              "node-1",
+				isController:        true,
+				volumeNamePrefix:    "csi",
+				fileSystemMountPath: "/mnt/lxd-csi",
+				enableSnapshots:     true,
+				isClustered:         true,
+				location:            "member-1",
+			},
+			expectJSON: `{
+  "name": "lxd.csi.canonical.com",
+  "version": "v1.0.0",
+  "endpoint": "unix:///csi/csi.sock",
+  "devlxd_endpoint": "unix:///dev/lxd/sock",
+  "node_id": "node-1",
+  "role": "controller",
+  "volume_name_prefix": "csi",
+  "filesystem_mount_path": "/mnt/lxd-csi",
+  "clustered": true,
+  "location": "member-1",
+  "capabilities": [
+    "CREATE_DELETE_VOLUME",
+    "PUBLISH_UNPUBLISH_VOLUME",
+    "EXPAND_VOLUME",
+    "CLONE_VOLUME",
+    "MODIFY_VOLUME",
+    "PUBLISH_READONLY",
+    "CREATE_DELETE_SNAPSHOT"
+  ]
+}`,
+		},
+		{
+			Name: "Node in standalone LXD",
+			Driver: &Driver{
+				name:                "lxd.csi.canonical.com",
+				version:             "dev",
+				endpoint:            "unix:///csi/csi.sock",
+				devLXDEndpoint:      "unix:///dev/lxd/sock",
+				nodeID:              "node-2",
+				volumeNamePrefix:    "csi",
+				fileSystemMountPath: "/mnt/lxd-csi",
+				location:            "none",
+			},
+			expectJSON: `{
+  "name": "lxd.csi.canonical.com",
+  "version": "dev",
+  "endpoint": "unix:///csi/csi.sock",
+  "devlxd_endpoint": "unix:///dev/lxd/sock",
+  "node_id": "node-2",
+  "role": "node",
+  "volume_name_prefix": "csi",
+  "filesystem_mount_path": "/mnt/lxd-csi",
+  "clustered": false,
+  "location": "none",
+  "capabilities": [
+    "GET_VOLUME_STATS",
+    "VOLUME_CONDITION"
+  ]
+}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			out, err := test.Driver.effectiveConfig().JSON()
+			require.NoError(t, err)
+			require.JSONEq(t, test.expectJSON, out)
+		})
+	}
+}
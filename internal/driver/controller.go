@@ -2,35 +2,167 @@ package driver
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"maps"
 	"net/http"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/klog/v2"
 
 	"github.com/canonical/lxd-csi-driver/internal/lxderrors"
+	lxdClient "github.com/canonical/lxd/client"
 	"github.com/canonical/lxd/lxd/locking"
+	"github.com/canonical/lxd/shared"
 	"github.com/canonical/lxd/shared/api"
 	"github.com/canonical/lxd/shared/units"
+	lxdValidate "github.com/canonical/lxd/shared/validate"
 )
 
+// snapshotScheduleAliases contains the schedule aliases accepted by LXD
+// in addition to the standard cron syntax.
+var snapshotScheduleAliases = []string{"@hourly", "@daily", "@midnight", "@weekly", "@monthly", "@annually", "@yearly"}
+
+// volumeConfigParameters maps the storage class parameters that are passed
+// through to the LXD volume configuration to their validators.
+var volumeConfigParameters = map[string]func(value string) error{
+	ParameterSnapshotsSchedule: lxdValidate.Optional(lxdValidate.IsCron(snapshotScheduleAliases)),
+	ParameterSnapshotsExpiry:   validateSnapshotsExpiry,
+	ParameterSnapshotsPattern:  lxdValidate.Optional(validateSnapshotsPattern),
+	ParameterBlockFilesystem:   lxdValidate.Optional(lxdValidate.IsOneOf("btrfs", "ext4", "xfs")),
+	ParameterBlockMountOptions: lxdValidate.IsAny,
+}
+
+// storageDriverVolumeConfigParameters maps storage driver names to the
+// storage class parameters that are specific to the storage driver and
+// are passed through to the LXD volume configuration, along with their
+// validators. They are rejected for storage pools using other drivers.
+var storageDriverVolumeConfigParameters = map[string]map[string]func(value string) error{
+	"lvm": {
+		ParameterLVMStripes:     lxdValidate.Optional(lxdValidate.IsUint32),
+		ParameterLVMStripesSize: lxdValidate.Optional(lxdValidate.IsSize),
+	},
+}
+
+// deviceConfigParameters maps the storage class parameters that are passed
+// through to the configuration of the LXD disk device to their validators.
+// They are applied when the volume is attached to a node and are removed
+// together with the device when the volume is detached.
+var deviceConfigParameters = map[string]func(value string) error{
+	ParameterLimitsRead:  lxdValidate.Optional(validateDeviceIOLimit),
+	ParameterLimitsWrite: lxdValidate.Optional(validateDeviceIOLimit),
+	ParameterLimitsMax:   lxdValidate.Optional(validateDeviceIOLimit),
+	ParameterIOBus:       lxdValidate.Optional(lxdValidate.IsOneOf("nvme", "virtio-blk", "virtio-scsi", "usb")),
+	ParameterIOCache:     lxdValidate.Optional(lxdValidate.IsOneOf("none", "writeback", "unsafe")),
+}
+
+// vmOnlyDeviceParameters contains the device configuration parameters that
+// LXD supports only for disk devices of virtual machines. They are ignored
+// when the volume is attached to a container.
+var vmOnlyDeviceParameters = []string{
+	ParameterIOBus,
+	ParameterIOCache,
+}
+
+// mutableVolumeParameters contains the volume parameters that can be changed
+// after the volume is provisioned, for example using a VolumeAttributesClass.
+// They map to LXD volume configuration keys that are applied in place.
+var mutableVolumeParameters = []string{
+	ParameterSnapshotsSchedule,
+	ParameterSnapshotsExpiry,
+	ParameterSnapshotsPattern,
+}
+
+// filesystemOnlyParameters contains the storage class parameters that
+// are only accepted for volumes with filesystem content type.
+var filesystemOnlyParameters = []string{
+	ParameterBlockFilesystem,
+	ParameterBlockMountOptions,
+	ParameterUID,
+	ParameterGID,
+	ParameterSubPath,
+}
+
+// blockOnlyParameters contains the storage class parameters that
+// are only accepted for volumes with block content type.
+var blockOnlyParameters = []string{
+	ParameterIOBus,
+	ParameterIOCache,
+}
+
+// defaultMinVolumeSizes maps storage driver names to the minimum size of
+// volumes created on storage pools using the driver, unless the storage
+// class sets a different minimum. LVM allocates logical volumes in extents
+// of 4MiB by default, so smaller volumes are not supported.
+var defaultMinVolumeSizes = map[string]int64{
+	"lvm": 4 * 1024 * 1024,
+}
+
+// storagePoolDriverCacheTTL is the duration for which the storage driver
+// information of a storage pool is cached by the controller server.
+var storagePoolDriverCacheTTL = 5 * time.Minute
+
+// cancelledVolumeCleanupTimeout is the maximum duration for deleting a volume
+// created by a cancelled CreateVolume request.
+const cancelledVolumeCleanupTimeout = 30 * time.Second
+
+// cancelledVolumeCreateTimeout is the maximum duration for waiting for the
+// creation of a volume to complete after its CreateVolume request was cancelled.
+const cancelledVolumeCreateTimeout = 10 * time.Minute
+
+// volumeAttachedNodesConfigKey is the configuration key of an LXD custom volume
+// listing the nodes to which the volume was attached. It is used to detect
+// volumes in use, as devLXD does not report volume usage, and is shared by
+// all controller replicas, unlike the state of a single controller.
+const volumeAttachedNodesConfigKey = "user.lxd-csi.attached-nodes"
+
+// volumeContentSourceConfigKey is the configuration key of an LXD custom volume
+// holding the ID of the snapshot or volume it was created from. It is used to
+// check whether an existing volume matches a retried CreateVolume request.
+const volumeContentSourceConfigKey = "user.lxd-csi.content-source"
+
+// storagePoolDriverCacheEntry is a cached storage driver information of a storage pool.
+type storagePoolDriverCacheEntry struct {
+	driver    api.DevLXDServerStorageDriverInfo
+	expiresAt time.Time
+}
+
 type controllerServer struct {
 	driver *Driver
 
+	// Cache of storage driver information keyed by storage pool name and its driver name.
+	poolDriverCache     map[string]storagePoolDriverCacheEntry
+	poolDriverCacheLock sync.Mutex
+
+	// Guard of volumes across controller replicas, or nil if distributed
+	// locking is disabled.
+	volumeLocks volumeLocker
+
 	// Must be embedded for forward compatibility.
 	csi.UnimplementedControllerServer
 }
 
 // NewControllerServer returns a new instance of the CSI controller server.
 func NewControllerServer(driver *Driver) *controllerServer {
-	return &controllerServer{
-		driver: driver,
+	c := &controllerServer{
+		driver:          driver,
+		poolDriverCache: make(map[string]storagePoolDriverCacheEntry),
 	}
+
+	if driver.distributedLocking {
+		c.volumeLocks = newLXDVolumeLocker(driver, volumeLockHolder(driver))
+	}
+
+	return c
 }
 
 // ControllerGetCapabilities returns the capabilities of the controller server.
@@ -48,38 +180,62 @@ func (c *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "CreateVolume: %v", err)
 	}
 
-	// Construct volume name.
-	// The volume name is constructed from a prefix and the remaining UUID of [req.Name]
-	// after the first dash, with all dashes removed from the UUID. This shortens the
-	// volume name while still keeping it unique.
-	volPrefix, volUUID, found := strings.Cut(req.Name, "-")
-	if !found {
-		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Unexpected volume name format: %q", req.Name)
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "CreateVolume: Volume name cannot be empty")
 	}
 
-	// Override volume prefix if configured.
-	if c.driver.volumeNamePrefix != "" {
-		volPrefix = c.driver.volumeNamePrefix
-	}
+	contentSource := req.VolumeContentSource
 
-	volName := volPrefix + "-" + strings.ReplaceAll(volUUID, "-", "")
+	// Some clients omit the access type from the volume capabilities, in which
+	// case the default volume mode of the storage class is used, if set.
+	volCaps := req.VolumeCapabilities
+	defaultVolumeMode := req.GetParameters()[ParameterDefaultVolumeMode]
+	if defaultVolumeMode != "" {
+		err = lxdValidate.IsOneOf("block", "filesystem")(defaultVolumeMode)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Invalid value %q for parameter %q in storage class: %v", defaultVolumeMode, ParameterDefaultVolumeMode, err)
+		}
 
-	contentSource := req.VolumeContentSource
+		volCaps = withDefaultAccessType(defaultVolumeMode, volCaps...)
+	}
 
-	err = ValidateVolumeCapabilities(req.VolumeCapabilities...)
+	err = ValidateVolumeCapabilities(volCaps...)
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: %v", err)
 	}
 
-	contentType := ParseContentType(req.VolumeCapabilities...)
+	contentType := ParseContentType(volCaps...)
 	if contentType == "" {
 		return nil, status.Error(codes.InvalidArgument, "CreateVolume: Volume capability must specify either block or filesystem access type")
 	}
 
+	// The content type of the storage class, if set, must match the content
+	// type requested by the volume capabilities.
+	expectContentType := req.GetParameters()[ParameterContentType]
+	if expectContentType != "" {
+		err = lxdValidate.IsOneOf("block", "filesystem")(expectContentType)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Invalid value %q for parameter %q in storage class: %v", expectContentType, ParameterContentType, err)
+		}
+
+		if expectContentType != contentType {
+			return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Content type %q in storage class does not match the requested volume content type %q", expectContentType, contentType)
+		}
+	}
+
+	fsType, err := ParseFilesystemType(volCaps...)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: %v", err)
+	}
+
 	// Validate volume size.
-	sizeBytes := req.CapacityRange.RequiredBytes
-	if sizeBytes < 1 {
-		return nil, status.Error(codes.InvalidArgument, "CreateVolume: Volume size cannot be zero or negative")
+	if req.CapacityRange == nil {
+		return nil, status.Error(codes.InvalidArgument, "CreateVolume: Capacity range is required")
+	}
+
+	sizeBytes, err := getCapacityRangeSize(req.CapacityRange)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: %v", err)
 	}
 
 	// Validate storage class parameters.
@@ -94,12 +250,117 @@ func (c *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 			continue
 		}
 
+		if contentType != "filesystem" && slices.Contains(filesystemOnlyParameters, k) {
+			return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Parameter %q in storage class is only supported for filesystem volumes", k)
+		}
+
+		if contentType != "block" && slices.Contains(blockOnlyParameters, k) {
+			return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Parameter %q in storage class is only supported for block volumes", k)
+		}
+
 		switch k {
 		case ParameterStoragePool:
 			parameters[k] = v
+		case ParameterDefaultVolumeMode, ParameterContentType:
+			// Validated when the volume capabilities are parsed.
+		case ParameterAccessibleMembers:
+			_, err := parseAccessibleMembers(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Invalid value %q for parameter %q in storage class: %v", v, k, err)
+			}
+		case ParameterUID, ParameterGID:
+			_, err := parseOwnerID(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Invalid value %q for parameter %q in storage class: %v", v, k, err)
+			}
+		case ParameterSubPath:
+			err := validateSubPath(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Invalid value %q for parameter %q in storage class: %v", v, k, err)
+			}
+		case ParameterMkfsOptions:
+			return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Parameter %q in storage class is not supported, as LXD does not allow customizing mkfs options", k)
+		case ParameterVolumeNamePrefix:
+			err := lxdValidate.IsHostname(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Invalid value %q for parameter %q in storage class: %v", v, k, err)
+			}
+		case ParameterMaxVolumeSize, ParameterMinVolumeSize:
+			_, err := parseVolumeSizeParameter(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Invalid value %q for parameter %q in storage class: %v", v, k, err)
+			}
+		case ParameterProtected:
+			err := lxdValidate.Optional(lxdValidate.IsBool)(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Invalid value %q for parameter %q in storage class: %v", v, k, err)
+			}
+		case ParameterSourceSnapshotPool, ParameterSourceSnapshotVolume, ParameterSourceSnapshotName:
+			if v == "" || strings.Contains(v, "/") {
+				return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Invalid value %q for parameter %q in storage class: Value cannot be empty or contain \"/\"", v, k)
+			}
 		default:
-			return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Invalid parameter %q in storage class", k)
+			validator, ok := volumeConfigParameters[k]
+			if !ok {
+				validator, ok = deviceConfigParameters[k]
+			}
+
+			if !ok {
+				_, validator, ok = getStorageDriverParameter(k)
+			}
+
+			if !ok {
+				return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Invalid parameter %q in storage class", k)
+			}
+
+			err := validator(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Invalid value %q for parameter %q in storage class: %v", v, k, err)
+			}
+		}
+	}
+
+	_, err = getDeviceConfig(parameters)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: %v", err)
+	}
+
+	// The source volume snapshot can be set in the storage class, for example
+	// to create volumes from an LXD snapshot that has no VolumeSnapshot object.
+	parameterSource, err := getSourceSnapshotParameters(parameters)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: %v", err)
+	}
+
+	if parameterSource != nil {
+		if contentSource != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Storage class parameters %q, %q, and %q cannot be combined with a volume content source", ParameterSourceSnapshotPool, ParameterSourceSnapshotVolume, ParameterSourceSnapshotName)
+		}
+
+		contentSource = parameterSource
+	}
+
+	// LXD formats block-backed filesystem volumes itself, so the filesystem
+	// requested by the volume capabilities must match the configured one.
+	blockFilesystem := parameters[ParameterBlockFilesystem]
+	if fsType != "" && blockFilesystem != "" && fsType != blockFilesystem {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Volume capability requests filesystem type %q, but parameter %q in storage class is set to %q", fsType, ParameterBlockFilesystem, blockFilesystem)
+	}
+
+	// Enforce the maximum volume size imposed by the storage class.
+	minSizeBytes, _ := parseVolumeSizeParameter(parameters[ParameterMinVolumeSize])
+	maxSizeBytes, _ := parseVolumeSizeParameter(parameters[ParameterMaxVolumeSize])
+	if minSizeBytes > 0 && maxSizeBytes > 0 && minSizeBytes > maxSizeBytes {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Parameter %q in storage class cannot be larger than parameter %q", ParameterMinVolumeSize, ParameterMaxVolumeSize)
+	}
+
+	if maxSizeBytes > 0 && sizeBytes > maxSizeBytes {
+		metadata := map[string]string{
+			"requestedBytes": strconv.FormatInt(sizeBytes, 10),
+			"maxBytes":       strconv.FormatInt(maxSizeBytes, 10),
 		}
+
+		return nil, statusWithReason(codes.OutOfRange, ErrorReasonVolumeSizeTooLarge, metadata, "CreateVolume: Requested volume size %s exceeds the maximum volume size %s of the storage class", units.GetByteSizeStringIEC(sizeBytes, 2), units.GetByteSizeStringIEC(maxSizeBytes, 2))
 	}
 
 	poolName := req.Parameters[ParameterStoragePool]
@@ -107,28 +368,96 @@ func (c *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Storage class parameter %q is required and cannot be empty", ParameterStoragePool)
 	}
 
-	pool, _, err := client.GetStoragePool(poolName)
+	err = validateMutableParameters(req.GetMutableParameters())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: %v", err)
+	}
+
+	// Construct volume name.
+	// The prefix set in the storage class takes precedence over the prefix configured
+	// for the storage pool, which in turn takes precedence over the global prefix.
+	volPrefix := c.driver.volumeNamePrefix
+	if parameters[ParameterVolumeNamePrefix] != "" {
+		volPrefix = parameters[ParameterVolumeNamePrefix]
+	} else if c.driver.poolPrefixMap[poolName] != "" {
+		volPrefix = c.driver.poolPrefixMap[poolName]
+	}
+
+	volName, err := getVolumeName(req.Name, volPrefix)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: %v", err)
+	}
+
+	// The lock volume is not a CSI volume, so it must not be returned as an
+	// existing volume.
+	if volName == VolumeLockVolumeName {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Volume name %q is reserved for the locks of the controller", volName)
+	}
+
+	var pool *api.DevLXDStoragePool
+	err = withRetry(ctx, func() error {
+		pool, _, err = client.GetStoragePool(poolName)
+		return err
+	})
 	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return nil, statusWithReason(codes.NotFound, ErrorReasonStoragePoolNotFound, map[string]string{"storagePool": poolName}, "CreateVolume: Failed to retrieve storage pool %q: %v", poolName, err)
+		}
+
 		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "CreateVolume: Failed to retrieve storage pool %q: %v", poolName, err)
 	}
 
 	// Fetch the information about storage pool driver and ensure
 	// it is supported.
-	state, err := client.GetState()
+	driver, err := c.getStoragePoolDriver(client, pool)
 	if err != nil {
 		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "CreateVolume: %v", err)
 	}
 
-	var driver *api.DevLXDServerStorageDriverInfo
-	for _, d := range state.SupportedStorageDrivers {
-		if d.Name == pool.Driver {
-			driver = &d
-			break
+	supported, reason := isSupportedStorageDriver(driver)
+	if !supported {
+		metadata := map[string]string{
+			"storagePool":   poolName,
+			"storageDriver": pool.Driver,
+		}
+
+		return nil, statusWithReason(codes.InvalidArgument, ErrorReasonUnsupportedStorageDriver, metadata, "CreateVolume: Storage pool %q uses unsupported storage driver %q: %s", poolName, pool.Driver, reason)
+	}
+
+	// Round the volume size up to the minimum volume size of the storage
+	// class, or of the storage driver if the storage class does not set one.
+	if minSizeBytes == 0 {
+		minSizeBytes = defaultMinVolumeSizes[driver.Name]
+	}
+
+	if sizeBytes < minSizeBytes {
+		limitBytes := req.CapacityRange.GetLimitBytes()
+		if limitBytes > 0 && minSizeBytes > limitBytes {
+			metadata := map[string]string{
+				"minBytes":   strconv.FormatInt(minSizeBytes, 10),
+				"limitBytes": strconv.FormatInt(limitBytes, 10),
+			}
+
+			return nil, statusWithReason(codes.OutOfRange, ErrorReasonVolumeSizeLimitTooSmall, metadata, "CreateVolume: Minimum volume size %s exceeds the volume size limit %s", units.GetByteSizeStringIEC(minSizeBytes, 2), units.GetByteSizeStringIEC(limitBytes, 2))
+		}
+
+		klog.InfoS("Rounding volume size up to the minimum volume size", "volumeName", volName, "requestedBytes", sizeBytes, "minBytes", minSizeBytes)
+		sizeBytes = minSizeBytes
+	}
+
+	// Reject parameters specific to other storage drivers.
+	for k := range parameters {
+		paramDriver, _, ok := getStorageDriverParameter(k)
+		if ok && paramDriver != driver.Name {
+			return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Parameter %q in storage class is only supported for storage pools using the %q driver, but storage pool %q uses %q", k, paramDriver, poolName, driver.Name)
 		}
 	}
 
-	if driver == nil || driver.Name == "cephobject" {
-		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: CSI does not support storage driver %q", pool.Driver)
+	// Restrict the topology of volumes on remote storage pools that are
+	// reachable only from some cluster members.
+	accessibleMembers, _ := parseAccessibleMembers(parameters[ParameterAccessibleMembers])
+	if len(accessibleMembers) > 0 && !driver.Remote {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Parameter %q in storage class is only supported for remote storage drivers", ParameterAccessibleMembers)
 	}
 
 	// Reject request for immediate binding of local volumes.
@@ -177,10 +506,32 @@ func (c *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 			// Only set the target when LXD is clustered.
 			if c.driver.isClustered {
 				client = client.UseTarget(target)
+
+				// Ensure the storage pool is available on the selected cluster member,
+				// as local storage pools may not be set up on all members.
+				err = c.ensureStoragePoolOnMember(ctx, client, poolName, target)
+				if err != nil {
+					return nil, err
+				}
 			}
 		}
 	}
 
+	if driver.Remote && len(accessibleMembers) > 0 {
+		err = validateAccessibleMembers(accessibleMembers, req.GetAccessibilityRequirements())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: %v", err)
+		}
+
+		for _, member := range accessibleMembers {
+			accessibleTopology = append(accessibleTopology, &csi.Topology{
+				Segments: map[string]string{
+					AnnotationLXDClusterMember: member,
+				},
+			})
+		}
+	}
+
 	volumeID := getVolumeID(target, poolName, volName)
 
 	unlock := locking.TryLock(volumeID)
@@ -190,13 +541,38 @@ func (c *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 
 	defer unlock()
 
+	// Guard the volume against concurrent requests from other controllers.
+	unlockVolume, err := c.lockVolume(ctx, volumeID)
+	if err != nil {
+		return nil, status.Errorf(volumeLockErrorCode(err), "CreateVolume: %v", err)
+	}
+
+	defer unlockVolume()
+
 	vol, _, err := client.GetStoragePoolVolume(poolName, "custom", volName)
 	if err != nil && !api.StatusErrorCheck(err, http.StatusNotFound) {
 		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "CreateVolume: Failed to retrieve storage volume %q from pool %q: %v", volName, poolName, err)
 	}
 
 	if vol != nil {
-		return nil, status.Errorf(codes.AlreadyExists, "CreateVolume: Volume with the same name %q already exists", volName)
+		existingSizeBytes, err := checkExistingVolume(vol, contentType, sizeBytes, req.CapacityRange.GetLimitBytes(), contentSource)
+		if err != nil {
+			return nil, status.Errorf(codes.AlreadyExists, "CreateVolume: Volume with the same name %q already exists: %v", volName, err)
+		}
+
+		// The volume was created by a previous request with the same name,
+		// for example, one that was cancelled while creating the volume.
+		parameters[ParameterStorageDriver] = driver.Name
+
+		return &csi.CreateVolumeResponse{
+			Volume: &csi.Volume{
+				VolumeId:           volumeID,
+				CapacityBytes:      existingSizeBytes,
+				VolumeContext:      parameters,
+				ContentSource:      req.VolumeContentSource,
+				AccessibleTopology: accessibleTopology,
+			},
+		}, nil
 	}
 
 	// If PVC name was passed to the driver, use it as the volume description.
@@ -214,7 +590,19 @@ func (c *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		volumeDescription = volumeDescription + " " + pvcIdentifier
 	}
 
+	volumeConfig := getVolumeConfig(sizeBytes, parameters)
+
+	// Mutable parameters, for example from a VolumeAttributesClass,
+	// take precedence over the storage class parameters.
+	for k, v := range req.GetMutableParameters() {
+		if v != "" {
+			volumeConfig[k] = v
+		}
+	}
+
 	if contentSource != nil {
+		volumeConfig[volumeContentSourceConfigKey] = getContentSourceID(contentSource)
+
 		var sourcePoolName string
 		var sourceVolName string
 		var sourceTarget string
@@ -310,6 +698,27 @@ func (c *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 			return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Unsupported source volume content %q", contentSource.String())
 		}
 
+		// Ensure the volume can be copied when the source volume is in a different storage pool.
+		if sourcePoolName != poolName {
+			var sourcePool *api.DevLXDStoragePool
+			err = withRetry(ctx, func() error {
+				sourcePool, _, err = client.GetStoragePool(sourcePoolName)
+				return err
+			})
+			if err != nil {
+				return nil, status.Errorf(lxderrors.ToGRPCCode(err), "CreateVolume: Failed to retrieve source storage pool %q: %v", sourcePoolName, err)
+			}
+
+			sourceDriver, err := c.getStoragePoolDriver(client, sourcePool)
+			if err != nil {
+				return nil, status.Errorf(lxderrors.ToGRPCCode(err), "CreateVolume: %v", err)
+			}
+
+			if !supportsCrossPoolCopy(sourceDriver, driver) {
+				return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Cannot clone volume from storage pool %q (driver %q) to storage pool %q (driver %q): Source and target storage pool must be the same", sourcePoolName, sourcePool.Driver, poolName, pool.Driver)
+			}
+		}
+
 		// Create volume from a copy.
 		poolReq := api.DevLXDStorageVolumesPost{
 			Name:        volName,
@@ -323,18 +732,26 @@ func (c *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 			},
 			DevLXDStorageVolumePut: api.DevLXDStorageVolumePut{
 				Description: volumeDescription,
-				Config: map[string]string{
-					"size": strconv.FormatInt(sizeBytes, 10),
-				},
+				Config:      volumeConfig,
 			},
 		}
 
-		op, err := client.CreateStoragePoolVolume(poolName, poolReq)
-		if err == nil {
-			err = op.WaitContext(ctx)
-		}
+		// Creating a volume is not retried, as a retry after the volume record
+		// was created fails with a conflict that hides the original error. The
+		// CSI sidecar retries the request, which then returns the volume.
+		var op lxdClient.DevLXDOperation
+		err := c.driver.runOperation(ctx, func() (lxdClient.DevLXDOperation, error) {
+			var err error
+			op, err = client.CreateStoragePoolVolume(poolName, poolReq)
+			return op, err
+		})
 
 		if err != nil {
+			// The request may have been cancelled while LXD was still creating the volume.
+			if ctx.Err() != nil && op != nil {
+				return nil, c.cancelVolumeCreation(ctx, client, op, poolName, volName)
+			}
+
 			return nil, status.Errorf(lxderrors.ToGRPCCode(err), "CreateVolume: Failed to create volume %q in storage pool %q from volume %q in storage pool %q: %v", volName, poolName, sourceVolName, sourcePoolName, err)
 		}
 	} else {
@@ -345,31 +762,53 @@ func (c *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 			ContentType: contentType,
 			DevLXDStorageVolumePut: api.DevLXDStorageVolumePut{
 				Description: volumeDescription,
-				Config: map[string]string{
-					"size": strconv.FormatInt(sizeBytes, 10),
-				},
+				Config:      volumeConfig,
 			},
 		}
 
-		op, err := client.CreateStoragePoolVolume(poolName, poolReq)
-		if err == nil {
-			err = op.WaitContext(ctx)
-		}
+		// Creating a volume is not retried, as a retry after the volume record
+		// was created fails with a conflict that hides the original error. The
+		// CSI sidecar retries the request, which then returns the volume.
+		var op lxdClient.DevLXDOperation
+		err := c.driver.runOperation(ctx, func() (lxdClient.DevLXDOperation, error) {
+			var err error
+			op, err = client.CreateStoragePoolVolume(poolName, poolReq)
+			return op, err
+		})
 
 		if err != nil {
+			// The request may have been cancelled while LXD was still creating the volume.
+			if ctx.Err() != nil && op != nil {
+				return nil, c.cancelVolumeCreation(ctx, client, op, poolName, volName)
+			}
+
 			return nil, status.Errorf(lxderrors.ToGRPCCode(err), "CreateVolume: Failed to create volume %q in storage pool %q: %v", volName, poolName, err)
 		}
 	}
 
+	// The volume has been created, but the request may have been cancelled
+	// in the meantime, in which case the response never reaches the caller.
+	err = ctx.Err()
+	if err != nil {
+		if c.driver.createVolumeCancelPolicy == CreateVolumeCancelPolicyDelete {
+			c.cleanupCancelledVolume(client, poolName, volName)
+			return nil, status.Errorf(lxderrors.ToGRPCCode(err), "CreateVolume: Request cancelled after volume %q was created in storage pool %q: %v", volName, poolName, err)
+		}
+
+		klog.InfoS("CreateVolume request cancelled after volume was created, keeping the volume", "volumeID", volumeID, "err", err)
+	}
+
 	// Set additional parameters to the volume for later use.
 	parameters[ParameterStorageDriver] = driver.Name
 
+	// LXD stores the size of the volume as requested, even if the storage
+	// driver rounds the allocation up, so the requested size is reported.
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
 			VolumeId:           volumeID,
 			CapacityBytes:      sizeBytes,
 			VolumeContext:      parameters,
-			ContentSource:      contentSource,
+			ContentSource:      req.VolumeContentSource,
 			AccessibleTopology: accessibleTopology,
 		},
 	}, nil
@@ -387,6 +826,11 @@ func (c *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolu
 		return nil, status.Errorf(codes.InvalidArgument, "DeleteVolume: %v", err)
 	}
 
+	// Deleting the lock volume would release the locks held by all controllers.
+	if volName == VolumeLockVolumeName {
+		return nil, status.Errorf(codes.InvalidArgument, "DeleteVolume: Volume name %q is reserved for the locks of the controller", volName)
+	}
+
 	// Set target if provided and LXD is clustered.
 	if target != "" && c.driver.isClustered {
 		client = client.UseTarget(target)
@@ -399,13 +843,68 @@ func (c *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolu
 
 	defer unlock()
 
-	// Delete storage volume. If volume does not exist, we consider
-	// the operation successful.
-	op, err := client.DeleteStoragePoolVolume(poolName, "custom", volName)
+	// Guard the volume against concurrent requests from other controllers.
+	// If the storage pool does not exist, neither does the volume, so there
+	// is nothing to guard.
+	unlockVolume, err := c.lockVolume(ctx, req.VolumeId)
+	if err != nil && !api.StatusErrorCheck(err, http.StatusNotFound) {
+		return nil, status.Errorf(volumeLockErrorCode(err), "DeleteVolume: %v", err)
+	}
+
 	if err == nil {
-		err = op.WaitContext(ctx)
+		defer unlockVolume()
+	}
+
+	// Refuse to delete protected volumes, unless the deletion is explicitly
+	// allowed on the volume. This guards against data loss, for example
+	// when the reclaim policy of the storage class is misconfigured.
+	var vol *api.DevLXDStorageVolume
+	err = withRetry(ctx, func() error {
+		vol, _, err = client.GetStoragePoolVolume(poolName, "custom", volName)
+		return err
+	})
+	if err != nil && !api.StatusErrorCheck(err, http.StatusNotFound) {
+		if target == "" && c.driver.isClustered && isAmbiguousVolumeLocationError(err) {
+			return nil, status.Errorf(codes.FailedPrecondition, "DeleteVolume: Volume %q exists in storage pool %q on more than one cluster member and its ID %q does not include the cluster member: %v", volName, poolName, req.VolumeId, err)
+		}
+
+		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "DeleteVolume: Failed to retrieve volume %q from storage pool %q: %v", volName, poolName, err)
+	}
+
+	// Volumes created by older versions of the driver do not include the
+	// cluster member in their ID. LXD locates such volumes if they exist on a
+	// single cluster member, so delete the volume on the member it is located on.
+	if target == "" && c.driver.isClustered && vol != nil && vol.Location != "" && vol.Location != "none" {
+		klog.InfoS("Deleting volume on the cluster member it is located on", "volumeID", req.VolumeId, "location", vol.Location)
+		client = client.UseTarget(vol.Location)
+	}
+
+	if vol != nil && isVolumeProtected(vol.Config) {
+		metadata := map[string]string{
+			"storagePool": poolName,
+			"volume":      volName,
+		}
+
+		return nil, statusWithReason(codes.FailedPrecondition, ErrorReasonVolumeProtected, metadata, "DeleteVolume: Volume %q in storage pool %q is protected from deletion: Set %q to \"true\" on the volume to allow it", volName, poolName, volumeConfigAllowDelete)
+	}
+
+	// LXD deletes the snapshots of a volume together with the volume, which
+	// would silently destroy the data of the volume snapshots.
+	if vol != nil {
+		err = c.deleteVolumeSnapshots(ctx, client, poolName, volName)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	// Delete storage volume. If volume does not exist, we consider
+	// the operation successful.
+	err = withRetry(ctx, func() error {
+		return c.driver.runOperation(ctx, func() (lxdClient.DevLXDOperation, error) {
+			return client.DeleteStoragePoolVolume(poolName, "custom", volName)
+		})
+	})
+
 	if err != nil && !api.StatusErrorCheck(err, http.StatusNotFound) {
 		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "DeleteVolume: Failed to delete volume %q from storage pool %q: %v", volName, poolName, err)
 	}
@@ -413,8 +912,74 @@ func (c *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolu
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
+// volumeSnapshotDescriptionPrefix is the prefix of the description of the LXD
+// snapshots created by the driver for VolumeSnapshots.
+const volumeSnapshotDescriptionPrefix = "Managed by Kubernetes VolumeSnapshot "
+
+// deleteVolumeSnapshots applies the delete volume snapshots policy to the LXD
+// snapshots of the given volume. Depending on the policy, it either fails if the
+// volume has any snapshots, or deletes them. Snapshots backing VolumeSnapshots
+// cannot be told apart from the ones created by LXD or by hand with certainty,
+// so no snapshot is deleted unless the policy allows it. Snapshots that no
+// longer exist are ignored.
+func (c *controllerServer) deleteVolumeSnapshots(ctx context.Context, client DevLXDClient, poolName string, volName string) error {
+	var snapshots []api.DevLXDStorageVolumeSnapshot
+	err := withRetry(ctx, func() error {
+		var err error
+		snapshots, err = client.GetStoragePoolVolumeSnapshots(poolName, "custom", volName)
+		return err
+	})
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return nil
+		}
+
+		return status.Errorf(lxderrors.ToGRPCCode(err), "DeleteVolume: Failed to retrieve snapshots of volume %q from storage pool %q: %v", volName, poolName, err)
+	}
+
+	if len(snapshots) == 0 {
+		return nil
+	}
+
+	snapshotNames := make([]string, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		snapshotNames = append(snapshotNames, snapshot.Name)
+	}
+
+	slices.Sort(snapshotNames)
+
+	if c.driver.deleteVolumeSnapshotsPolicy != DeleteVolumeSnapshotsPolicyDelete {
+		metadata := map[string]string{
+			"storagePool": poolName,
+			"volume":      volName,
+			"snapshots":   strings.Join(snapshotNames, ","),
+		}
+
+		return statusWithReason(codes.FailedPrecondition, ErrorReasonVolumeHasSnapshots, metadata, "DeleteVolume: Volume %q in storage pool %q has snapshots %q: Delete the VolumeSnapshots and the LXD snapshots before the volume", volName, poolName, snapshotNames)
+	}
+
+	for _, snapshotName := range snapshotNames {
+		klog.InfoS("Deleting snapshot of volume that is being deleted", "storagePool", poolName, "volume", volName, "snapshot", snapshotName)
+
+		err = withRetry(ctx, func() error {
+			return c.driver.runOperation(ctx, func() (lxdClient.DevLXDOperation, error) {
+				return client.DeleteStoragePoolVolumeSnapshot(poolName, "custom", volName, snapshotName)
+			})
+		})
+		if err != nil && !api.StatusErrorCheck(err, http.StatusNotFound) {
+			return status.Errorf(lxderrors.ToGRPCCode(err), "DeleteVolume: Failed to delete snapshot %q of volume %q from storage pool %q: %v", snapshotName, volName, poolName, err)
+		}
+	}
+
+	return nil
+}
+
 // CreateSnapshot creates a snapshot of a PVC that references an existing LXD custom volume.
 func (c *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	if !c.driver.hasControllerServiceCapability(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT) {
+		return nil, status.Error(codes.Unimplemented, "CreateSnapshot: Volume snapshots are disabled")
+	}
+
 	client, err := c.driver.DevLXDClient()
 	if err != nil {
 		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "CreateSnapshot: %v", err)
@@ -429,14 +994,24 @@ func (c *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSn
 	}
 
 	// Generate snapshot name and ID.
-	// Snapshot name is constructed from the requested snapshot name by removing dashes
-	// from the UUID portion. This shortens the snapshot name while keeping it unique.
-	snapshotPrefix, snapshotUUID, found := strings.Cut(req.Name, "-")
-	if !found {
-		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: Unexpected volume name format: %q", req.Name)
+	// The prefix set in the volume snapshot class takes precedence over the
+	// prefix configured on the driver.
+	snapshotPrefix := c.driver.snapshotNamePrefix
+	classPrefix, ok := req.Parameters[ParameterSnapshotNamePrefix]
+	if ok {
+		err := lxdValidate.IsHostname(classPrefix)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "CreateSnapshot: Invalid value %q for parameter %q in volume snapshot class: %v", classPrefix, ParameterSnapshotNamePrefix, err)
+		}
+
+		snapshotPrefix = classPrefix
+	}
+
+	snapshotName, err := getSnapshotName(req.Name, snapshotPrefix)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateSnapshot: %v", err)
 	}
 
-	snapshotName := snapshotPrefix + "-" + strings.ReplaceAll(snapshotUUID, "-", "")
 	snapshotID := req.SourceVolumeId + "/" + snapshotName
 
 	target, poolName, volName, err := splitVolumeID(req.SourceVolumeId)
@@ -462,17 +1037,30 @@ func (c *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSn
 			return nil, status.Errorf(lxderrors.ToGRPCCode(err), "CreateSnapshot: Failed to retrieve snapshot %q of volume %q from pool %q: %v", snapshotName, volName, poolName, err)
 		}
 
+		// LXD records the content type of the source volume on the snapshot,
+		// which is validated when a volume is restored from the snapshot.
+		// Ensure the snapshot can later be restored as a block or filesystem
+		// volume, as other content types cannot be requested using CSI.
+		sourceVol, _, err := client.GetStoragePoolVolume(poolName, "custom", volName)
+		if err != nil {
+			return nil, status.Errorf(lxderrors.ToGRPCCode(err), "CreateSnapshot: Failed to retrieve source volume %q from pool %q: %v", volName, poolName, err)
+		}
+
+		if sourceVol.ContentType != "block" && sourceVol.ContentType != "filesystem" {
+			return nil, status.Errorf(codes.InvalidArgument, "CreateSnapshot: Source volume %q has unsupported content type %q", volName, sourceVol.ContentType)
+		}
+
 		// Create snapshot of storage volume.
 		snapshotReq := api.DevLXDStorageVolumeSnapshotsPost{
 			Name:        snapshotName,
-			Description: "Managed by Kubernetes VolumeSnapshot " + snapshotName,
+			Description: volumeSnapshotDescriptionPrefix + snapshotName,
 		}
 
-		// Snapshot does not exist yet. Create it.
-		op, err := client.CreateStoragePoolVolumeSnapshot(poolName, "custom", volName, snapshotReq)
-		if err == nil {
-			err = op.WaitContext(ctx)
-		}
+		// Snapshot does not exist yet. Create it. Like volumes, snapshots
+		// are not created again on failure, but by the retried request.
+		err = c.driver.runOperation(ctx, func() (lxdClient.DevLXDOperation, error) {
+			return client.CreateStoragePoolVolumeSnapshot(poolName, "custom", volName, snapshotReq)
+		})
 
 		if err != nil {
 			return nil, status.Errorf(lxderrors.ToGRPCCode(err), "CreateSnapshot: %v", err)
@@ -492,6 +1080,10 @@ func (c *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSn
 // DeleteSnapshot deletes a snapshot of an LXD custom volume.
 // Missing snapshots are treated as successfully deleted.
 func (c *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	if !c.driver.hasControllerServiceCapability(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT) {
+		return nil, status.Error(codes.Unimplemented, "DeleteSnapshot: Volume snapshots are disabled")
+	}
+
 	client, err := c.driver.DevLXDClient()
 	if err != nil {
 		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "DeleteSnapshot: %v", err)
@@ -514,12 +1106,33 @@ func (c *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSn
 
 	defer unlock()
 
-	op, err := client.DeleteStoragePoolVolumeSnapshot(poolName, "custom", volName, snapshotName)
-	if err == nil {
-		err = op.WaitContext(ctx)
+	// Both a missing snapshot and a missing parent volume are considered a
+	// successful deletion, but they are distinguished in the logs, as the
+	// latter indicates that the volume was deleted out of band.
+	err = withRetry(ctx, func() error {
+		_, _, err := client.GetStoragePoolVolume(poolName, "custom", volName)
+		return err
+	})
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			klog.InfoS("Parent volume of snapshot not found, considering the snapshot deleted", "snapshotID", req.SnapshotId)
+			return &csi.DeleteSnapshotResponse{}, nil
+		}
+
+		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "DeleteSnapshot: Failed to retrieve volume %q from storage pool %q: %v", volName, poolName, err)
 	}
 
-	if err != nil && !api.StatusErrorCheck(err, http.StatusNotFound) {
+	err = withRetry(ctx, func() error {
+		return c.driver.runOperation(ctx, func() (lxdClient.DevLXDOperation, error) {
+			return client.DeleteStoragePoolVolumeSnapshot(poolName, "custom", volName, snapshotName)
+		})
+	})
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			klog.InfoS("Snapshot not found, considering it deleted", "snapshotID", req.SnapshotId)
+			return &csi.DeleteSnapshotResponse{}, nil
+		}
+
 		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "DeleteSnapshot: %v", err)
 	}
 
@@ -544,6 +1157,11 @@ func (c *controllerServer) ControllerPublishVolume(ctx context.Context, req *csi
 		client = client.UseTarget(target)
 	}
 
+	err = ValidateVolumeCapabilities(req.VolumeCapability)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "ControllerPublishVolume: %v", err)
+	}
+
 	contentType := ParseContentType(req.VolumeCapability)
 	if contentType == "" {
 		return nil, status.Error(codes.InvalidArgument, "ControllerPublishVolume: Volume capability must specify either block or filesystem access type")
@@ -557,7 +1175,7 @@ func (c *controllerServer) ControllerPublishVolume(ctx context.Context, req *csi
 	defer unlock()
 
 	// Get existing storage pool volume.
-	_, _, err = client.GetStoragePoolVolume(poolName, "custom", volName)
+	vol, _, err := client.GetStoragePoolVolume(poolName, "custom", volName)
 	if err != nil {
 		if api.StatusErrorCheck(err, http.StatusNotFound) {
 			return nil, status.Errorf(codes.NotFound, "ControllerPublishVolume: Volume %q not found in storage pool %q", volName, poolName)
@@ -566,24 +1184,84 @@ func (c *controllerServer) ControllerPublishVolume(ctx context.Context, req *csi
 		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ControllerPublishVolume: Failed to retrieve volume %q from storage pool %q: %v", volName, poolName, err)
 	}
 
-	inst, etag, err := client.GetInstance(req.NodeId)
+	instName := c.driver.instanceName(req.NodeId)
+
+	var inst *api.DevLXDInstance
+	var etag string
+	err = withRetry(ctx, func() error {
+		inst, etag, err = client.GetInstance(instName)
+		return err
+	})
 	if err != nil {
 		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ControllerPublishVolume: %v", err)
 	}
 
-	dev, ok := inst.Devices[volName]
+	devName := getDeviceName(poolName, volName)
+
+	// Attach the volume read-only if requested, so that the disk device itself
+	// is protected and not only the mount on the node.
+	readonly := req.Readonly || isReadOnlyAccessMode(req.VolumeCapability)
+
+	dev, ok := inst.Devices[devName]
 	if ok {
 		// If the device already exists, ensure it matches the expected parameters.
-		if dev["type"] != "disk" || dev["source"] != volName || dev["pool"] != poolName {
-			return nil, status.Errorf(codes.AlreadyExists, "ControllerPublishVolume: Device %q already exists on node %q but does not match expected parameters", volName, req.NodeId)
+		if !isVolumeDevice(dev, poolName, volName) {
+			return nil, status.Errorf(codes.AlreadyExists, "ControllerPublishVolume: Device %q already exists on node %q but does not match expected parameters", devName, req.NodeId)
 		}
 
-		return &csi.ControllerPublishVolumeResponse{}, nil
-	}
+		if shared.IsTrue(dev["readonly"]) != readonly {
+			return nil, status.Errorf(codes.AlreadyExists, "ControllerPublishVolume: Volume %q is already attached to node %q with incompatible read-only mode", volName, req.NodeId)
+		}
 
-	reqInst := api.DevLXDInstancePut{
+		err = c.setAttachedNode(ctx, client, poolName, volName, req.NodeId, true)
+		if err != nil {
+			return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ControllerPublishVolume: %v", err)
+		}
+
+		return &csi.ControllerPublishVolumeResponse{
+			PublishContext: getPublishContext(devName, contentType),
+		}, nil
+	}
+
+	// Volumes attached by older versions of the driver use the volume
+	// name as the device name.
+	if isVolumeDevice(inst.Devices[volName], poolName, volName) {
+		if shared.IsTrue(inst.Devices[volName]["readonly"]) != readonly {
+			return nil, status.Errorf(codes.AlreadyExists, "ControllerPublishVolume: Volume %q is already attached to node %q with incompatible read-only mode", volName, req.NodeId)
+		}
+
+		err = c.setAttachedNode(ctx, client, poolName, volName, req.NodeId, true)
+		if err != nil {
+			return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ControllerPublishVolume: %v", err)
+		}
+
+		return &csi.ControllerPublishVolumeResponse{
+			PublishContext: getPublishContext(volName, contentType),
+		}, nil
+	}
+
+	// Attaching a volume with a single node access mode to another node would
+	// allow both nodes to write to it and corrupt the data. Reject the request,
+	// so that Kubernetes detaches the volume from the other node first.
+	if isSingleNodeAccessMode(req.VolumeCapability) {
+		node, err := c.findAttachedNode(ctx, client, poolName, volName, getAttachedNodes(vol), req.NodeId)
+		if err != nil {
+			return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ControllerPublishVolume: %v", err)
+		}
+
+		if node != "" {
+			metadata := map[string]string{
+				"volume": volName,
+				"node":   node,
+			}
+
+			return nil, statusWithReason(codes.FailedPrecondition, ErrorReasonVolumeInUse, metadata, "ControllerPublishVolume: Volume %q is already attached to node %q", volName, node)
+		}
+	}
+
+	reqInst := api.DevLXDInstancePut{
 		Devices: map[string]map[string]string{
-			volName: {
+			devName: {
 				"source": volName,
 				"pool":   poolName,
 				"type":   "disk",
@@ -593,15 +1271,59 @@ func (c *controllerServer) ControllerPublishVolume(ctx context.Context, req *csi
 
 	if contentType == "filesystem" {
 		// For filesystem volumes, provide the path where the volume is mounted.
-		reqInst.Devices[volName]["path"] = filepath.Join(driverFileSystemMountPath, volName)
+		reqInst.Devices[devName]["path"] = filepath.Join(c.driver.fileSystemMountPath, volName)
 	}
 
-	err = client.UpdateInstance(req.NodeId, reqInst, etag)
+	if readonly {
+		reqInst.Devices[devName]["readonly"] = "true"
+	}
+
+	// Apply the disk device configuration from the storage class parameters.
+	deviceConfig, err := getDeviceConfig(req.VolumeContext)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "ControllerPublishVolume: %v", err)
+	}
+
+	maps.Copy(reqInst.Devices[devName], deviceConfig)
+
+	// Record the attachment before attaching the volume, so that it is not
+	// lost if the controller stops in between. A recorded node without the
+	// volume device is ignored when checking whether the volume is in use.
+	err = c.setAttachedNode(ctx, client, poolName, volName, req.NodeId, true)
+	if err != nil {
+		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ControllerPublishVolume: %v", err)
+	}
+
+	err = withInstanceUpdateRetry(ctx, client, instName, etag, func(etag string) error {
+		err := client.UpdateInstance(instName, reqInst, etag)
+		if err != nil && isContainerDeviceConfigError(err) {
+			// The devLXD API does not expose the type of other instances, so the
+			// node is known to be a container only once LXD rejects the options
+			// that apply to virtual machines. Attach the volume without them.
+			ignored := make([]string, 0, len(vmOnlyDeviceParameters))
+			for _, k := range vmOnlyDeviceParameters {
+				_, ok := reqInst.Devices[devName][k]
+				if ok {
+					delete(reqInst.Devices[devName], k)
+					ignored = append(ignored, k)
+				}
+			}
+
+			if len(ignored) > 0 {
+				klog.InfoS("Ignoring disk device options that apply only to virtual machines, as the node is a container", "volumeID", req.VolumeId, "node", req.NodeId, "options", ignored)
+				err = client.UpdateInstance(instName, reqInst, etag)
+			}
+		}
+
+		return err
+	})
 	if err != nil {
 		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ControllerPublishVolume: Failed to attach volume %q: %v", volName, err)
 	}
 
-	return &csi.ControllerPublishVolumeResponse{}, nil
+	return &csi.ControllerPublishVolumeResponse{
+		PublishContext: getPublishContext(devName, contentType),
+	}, nil
 }
 
 // ControllerUnpublishVolume detaches LXD custom volume from a node.
@@ -612,7 +1334,7 @@ func (c *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *c
 		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ControllerUnpublishVolume: %v", err)
 	}
 
-	target, _, volName, err := splitVolumeID(req.VolumeId)
+	target, poolName, volName, err := splitVolumeID(req.VolumeId)
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "ControllerUnpublishVolume: %v", err)
 	}
@@ -629,29 +1351,62 @@ func (c *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *c
 
 	defer unlock()
 
-	// Fetch existing instance to retrieve the ETag.
-	_, etag, err := client.GetInstance(req.NodeId)
+	instName := c.driver.instanceName(req.NodeId)
+
+	// Fetch existing instance to retrieve its devices and the ETag.
+	var inst *api.DevLXDInstance
+	var etag string
+	err = withRetry(ctx, func() error {
+		inst, etag, err = client.GetInstance(instName)
+		return err
+	})
 	if err != nil {
-		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ControllerUnpublishVolume: Failed to retrieve instance %q: %v", req.NodeId, err)
+		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ControllerUnpublishVolume: Failed to retrieve instance %q: %v", instName, err)
 	}
 
 	reqInst := api.DevLXDInstancePut{
-		Devices: map[string]map[string]string{
-			volName: nil,
-		},
+		Devices: make(map[string]map[string]string),
 	}
 
-	// Detach volume.
-	// If volume attachment does not exist, consider the operation successful.
-	err = client.UpdateInstance(req.NodeId, reqInst, etag)
+	// Remove the device regardless of whether it was attached using the
+	// current device name or the volume name used by older driver versions.
+	for _, devName := range []string{getDeviceName(poolName, volName), volName} {
+		if isVolumeDevice(inst.Devices[devName], poolName, volName) {
+			reqInst.Devices[devName] = nil
+		}
+	}
+
+	if len(reqInst.Devices) > 0 {
+		// Volumes attached by older versions of the driver are not recorded.
+		// Record the attachment before detaching the volume, so that it is
+		// still detected if the volume cannot be detached.
+		err = c.setAttachedNode(ctx, client, poolName, volName, req.NodeId, true)
+		if err != nil {
+			return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ControllerUnpublishVolume: %v", err)
+		}
+
+		// Detach volume.
+		// If volume attachment does not exist, consider the operation successful.
+		err = withInstanceUpdateRetry(ctx, client, instName, etag, func(etag string) error {
+			return client.UpdateInstance(instName, reqInst, etag)
+		})
+		if err != nil && !api.StatusErrorCheck(err, http.StatusNotFound) {
+			return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ControllerUnpublishVolume: Failed to detach volume %q: %v", volName, err)
+		}
+	}
+
+	// Remove the attachment record, unless the volume has been deleted.
+	err = c.setAttachedNode(ctx, client, poolName, volName, req.NodeId, false)
 	if err != nil && !api.StatusErrorCheck(err, http.StatusNotFound) {
-		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ControllerUnpublishVolume: Failed to detach volume %q: %v", volName, err)
+		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ControllerUnpublishVolume: %v", err)
 	}
 
 	return &csi.ControllerUnpublishVolumeResponse{}, nil
 }
 
 // ControllerExpandVolume resizes an existing LXD custom volume.
+// Request secrets are ignored, as the driver authenticates with devLXD
+// using its own bearer token.
 func (c *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
 	client, err := c.driver.DevLXDClient()
 	if err != nil {
@@ -673,6 +1428,10 @@ func (c *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.
 		return nil, status.Errorf(codes.InvalidArgument, "ExpandVolume: %v", err)
 	}
 
+	if req.CapacityRange == nil {
+		return nil, status.Error(codes.InvalidArgument, "ExpandVolume: Capacity range is required")
+	}
+
 	unlock := locking.TryLock(req.VolumeId)
 	if unlock == nil {
 		return nil, status.Errorf(codes.Aborted, "ExpandVolume: Failed to obtain lock %q: %v", req.VolumeId, err)
@@ -680,8 +1439,46 @@ func (c *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.
 
 	defer unlock()
 
+	var pool *api.DevLXDStoragePool
+	err = withRetry(ctx, func() error {
+		pool, _, err = client.GetStoragePool(poolName)
+		return err
+	})
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return nil, statusWithReason(codes.NotFound, ErrorReasonStoragePoolNotFound, map[string]string{"storagePool": poolName}, "ExpandVolume: Failed to retrieve storage pool %q: %v", poolName, err)
+		}
+
+		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ExpandVolume: Failed to retrieve storage pool %q: %v", poolName, err)
+	}
+
+	// Reject expansion of volumes whose size is not enforced by the storage
+	// driver, instead of pretending the volume was resized.
+	driver, err := c.getStoragePoolDriver(client, pool)
+	if err != nil {
+		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ExpandVolume: %v", err)
+	}
+
+	if driver != nil && !supportsVolumeSize(driver) {
+		metadata := map[string]string{
+			"storagePool":   poolName,
+			"storageDriver": driver.Name,
+		}
+
+		return nil, statusWithReason(codes.Unimplemented, ErrorReasonUnsupportedStorageDriver, metadata, "ExpandVolume: Storage pool %q uses storage driver %q which does not support volume size", poolName, driver.Name)
+	}
+
 	vol, etag, err := client.GetStoragePoolVolume(poolName, "custom", volName)
 	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			metadata := map[string]string{
+				"storagePool": poolName,
+				"volume":      volName,
+			}
+
+			return nil, statusWithReason(codes.NotFound, ErrorReasonVolumeNotFound, metadata, "ExpandVolume: %v", err)
+		}
+
 		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ExpandVolume: %v", err)
 	}
 
@@ -703,7 +1500,12 @@ func (c *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.
 	if newSizeBytes < oldSizeBytes {
 		oldSizePretty := units.GetByteSizeStringIEC(oldSizeBytes, 2)
 		newSizePretty := units.GetByteSizeStringIEC(newSizeBytes, 2)
-		return nil, status.Errorf(codes.InvalidArgument, "ExpandVolume: Requested size %q is less than the current size %q", newSizePretty, oldSizePretty)
+		metadata := map[string]string{
+			"requestedBytes": strconv.FormatInt(newSizeBytes, 10),
+			"currentBytes":   strconv.FormatInt(oldSizeBytes, 10),
+		}
+
+		return nil, statusWithReason(codes.InvalidArgument, ErrorReasonVolumeShrinkUnsupported, metadata, "ExpandVolume: Requested size %q is less than the current size %q", newSizePretty, oldSizePretty)
 	}
 
 	if newSizeBytes == oldSizeBytes {
@@ -714,6 +1516,26 @@ func (c *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.
 		}, nil
 	}
 
+	// Volumes that cannot be grown while in use are rejected upfront if they
+	// are attached to any node, as LXD would fail with a generic error. The
+	// nodes are taken from the attachment record in the volume configuration,
+	// so that attachments made by other controller replicas are detected.
+	if requiresDetachToExpand(vol.ContentType) {
+		node, err := c.findAttachedNode(ctx, client, poolName, volName, getAttachedNodes(vol), "")
+		if err != nil {
+			return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ExpandVolume: %v", err)
+		}
+
+		if node != "" {
+			metadata := map[string]string{
+				"volume": volName,
+				"node":   node,
+			}
+
+			return nil, statusWithReason(codes.FailedPrecondition, ErrorReasonVolumeInUse, metadata, "ExpandVolume: Volume %q is in use by node %q, detach it to expand: Block volumes can only be expanded while detached", volName, node)
+		}
+	}
+
 	// Expand volume.
 	config := maps.Clone(vol.Config)
 	config["size"] = strconv.FormatInt(newSizeBytes, 10)
@@ -723,12 +1545,18 @@ func (c *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.
 		Config:      config,
 	}
 
-	op, err := client.UpdateStoragePoolVolume(poolName, "custom", volName, volReq, etag)
-	if err == nil {
-		err = op.WaitContext(ctx)
-	}
+	err = c.driver.runOperation(ctx, func() (lxdClient.DevLXDOperation, error) {
+		return client.UpdateStoragePoolVolume(poolName, "custom", volName, volReq, etag)
+	})
 
 	if err != nil {
+		// LXD locks volumes that cannot be grown while attached to a running
+		// instance. Report it explicitly, so that the resize error on the PVC
+		// tells the user how to proceed.
+		if api.StatusErrorCheck(err, http.StatusLocked) && requiresDetachToExpand(vol.ContentType) {
+			return nil, statusWithReason(codes.FailedPrecondition, ErrorReasonVolumeInUse, map[string]string{"volume": volName}, "ExpandVolume: Volume %q is in use, detach it to expand: %v", volName, err)
+		}
+
 		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ExpandVolume: Failed to expand volume: %v", err)
 	}
 
@@ -737,3 +1565,649 @@ func (c *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.
 		NodeExpansionRequired: false,
 	}, nil
 }
+
+// ControllerModifyVolume applies the mutable parameters, for example from a
+// VolumeAttributesClass, to the configuration of an existing LXD custom volume.
+// An empty parameter value unsets the corresponding configuration key.
+func (c *controllerServer) ControllerModifyVolume(ctx context.Context, req *csi.ControllerModifyVolumeRequest) (*csi.ControllerModifyVolumeResponse, error) {
+	client, err := c.driver.DevLXDClient()
+	if err != nil {
+		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ModifyVolume: %v", err)
+	}
+
+	target, poolName, volName, err := splitVolumeID(req.VolumeId)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "ModifyVolume: %v", err)
+	}
+
+	// Set target if provided and LXD is clustered.
+	if target != "" && c.driver.isClustered {
+		client = client.UseTarget(target)
+	}
+
+	err = validateMutableParameters(req.MutableParameters)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "ModifyVolume: %v", err)
+	}
+
+	unlock := locking.TryLock(req.VolumeId)
+	if unlock == nil {
+		return nil, status.Errorf(codes.Aborted, "ModifyVolume: Failed to obtain lock %q", req.VolumeId)
+	}
+
+	defer unlock()
+
+	vol, etag, err := client.GetStoragePoolVolume(poolName, "custom", volName)
+	if err != nil {
+		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ModifyVolume: Failed to retrieve volume %q from storage pool %q: %v", volName, poolName, err)
+	}
+
+	config := maps.Clone(vol.Config)
+	if config == nil {
+		config = make(map[string]string)
+	}
+
+	for k, v := range req.MutableParameters {
+		if v == "" {
+			delete(config, k)
+		} else {
+			config[k] = v
+		}
+	}
+
+	// Skip the update if the volume already has the requested configuration.
+	if maps.Equal(config, vol.Config) {
+		return &csi.ControllerModifyVolumeResponse{}, nil
+	}
+
+	volReq := api.DevLXDStorageVolumePut{
+		Description: vol.Description,
+		Config:      config,
+	}
+
+	err = c.driver.runOperation(ctx, func() (lxdClient.DevLXDOperation, error) {
+		return client.UpdateStoragePoolVolume(poolName, "custom", volName, volReq, etag)
+	})
+	if err != nil {
+		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "ModifyVolume: Failed to update volume %q in storage pool %q: %v", volName, poolName, err)
+	}
+
+	return &csi.ControllerModifyVolumeResponse{}, nil
+}
+
+// getAttachedNodes returns the nodes recorded in the configuration of the
+// given volume as the nodes the volume is attached to.
+func getAttachedNodes(vol *api.DevLXDStorageVolume) []string {
+	value := vol.Config[volumeAttachedNodesConfigKey]
+	if value == "" {
+		return nil
+	}
+
+	return strings.Split(value, ",")
+}
+
+// setAttachedNode records in the volume configuration whether the volume is
+// attached to the given node. The volume is updated using its ETag, and the
+// update is retried if the volume was modified in the meantime, for example,
+// by another controller replica.
+func (c *controllerServer) setAttachedNode(ctx context.Context, client DevLXDClient, poolName string, volName string, nodeID string, attached bool) error {
+	err := withRetry(ctx, func() error {
+		vol, etag, err := client.GetStoragePoolVolume(poolName, "custom", volName)
+		if err != nil {
+			return err
+		}
+
+		nodes := getAttachedNodes(vol)
+		if slices.Contains(nodes, nodeID) == attached {
+			return nil
+		}
+
+		if attached {
+			nodes = append(nodes, nodeID)
+			slices.Sort(nodes)
+		} else {
+			nodes = slices.DeleteFunc(nodes, func(node string) bool { return node == nodeID })
+		}
+
+		config := maps.Clone(vol.Config)
+		if config == nil {
+			config = make(map[string]string)
+		}
+
+		if len(nodes) > 0 {
+			config[volumeAttachedNodesConfigKey] = strings.Join(nodes, ",")
+		} else {
+			delete(config, volumeAttachedNodesConfigKey)
+		}
+
+		volReq := api.DevLXDStorageVolumePut{
+			Description: vol.Description,
+			Config:      config,
+		}
+
+		op, err := client.UpdateStoragePoolVolume(poolName, "custom", volName, volReq, etag)
+		if err != nil {
+			return err
+		}
+
+		return op.WaitContext(ctx)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to record attachment of volume %q in storage pool %q to node %q: %w", volName, poolName, nodeID, err)
+	}
+
+	return nil
+}
+
+// findAttachedNode returns the name of a node the volume is currently attached to,
+// or an empty string if the volume is not attached. As devLXD does not allow
+// listing instances, only the given nodes recorded in the volume configuration
+// are checked. Recorded nodes that no longer exist or do not have the volume
+// device are ignored. The node with the excluded name, if any, is not checked.
+func (c *controllerServer) findAttachedNode(ctx context.Context, client DevLXDClient, poolName string, volName string, nodes []string, excludedNode string) (string, error) {
+	for _, node := range nodes {
+		if node == excludedNode {
+			continue
+		}
+
+		var inst *api.DevLXDInstance
+		err := withRetry(ctx, func() error {
+			var err error
+			inst, _, err = client.GetInstance(c.driver.instanceName(node))
+			return err
+		})
+		if err != nil {
+			if api.StatusErrorCheck(err, http.StatusNotFound) {
+				continue
+			}
+
+			return "", fmt.Errorf("Failed to retrieve instance %q: %w", c.driver.instanceName(node), err)
+		}
+
+		if isVolumeAttached(inst, poolName, volName) {
+			return node, nil
+		}
+	}
+
+	return "", nil
+}
+
+// cancelVolumeCreation applies the cancel policy to a volume whose creation
+// operation was started by a CreateVolume request that has been cancelled
+// before the operation completed. With [CreateVolumeCancelPolicyDelete], the
+// operation is awaited regardless of the cancellation and the created volume
+// is deleted. Otherwise, the operation continues in LXD, and a retried request
+// for a volume with the same name, content type, size and content source
+// returns the volume. The returned error reports the cancellation.
+func (c *controllerServer) cancelVolumeCreation(ctx context.Context, client DevLXDClient, op lxdClient.DevLXDOperation, poolName string, volName string) error {
+	if c.driver.createVolumeCancelPolicy == CreateVolumeCancelPolicyDelete {
+		waitCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cancelledVolumeCreateTimeout)
+		err := op.WaitContext(waitCtx)
+		cancel()
+		if err != nil {
+			klog.InfoS("Volume creation of a cancelled CreateVolume request did not complete", "pool", poolName, "volume", volName, "err", err)
+		}
+
+		// The volume may have been partially created even if the operation failed.
+		c.cleanupCancelledVolume(client, poolName, volName)
+	} else {
+		klog.InfoS("CreateVolume request cancelled while creating volume, keeping the volume", "pool", poolName, "volume", volName, "err", ctx.Err())
+	}
+
+	return status.Errorf(lxderrors.ToGRPCCode(ctx.Err()), "CreateVolume: Request cancelled while creating volume %q in storage pool %q: %v", volName, poolName, ctx.Err())
+}
+
+// checkExistingVolume checks whether an existing volume matches a CreateVolume
+// request for a volume of the given content type, size, size limit and content
+// source, and returns the size of the existing volume.
+func checkExistingVolume(vol *api.DevLXDStorageVolume, contentType string, sizeBytes int64, limitBytes int64, contentSource *csi.VolumeContentSource) (int64, error) {
+	if vol.ContentType != contentType {
+		return 0, fmt.Errorf("Content type %q does not match the requested content type %q", vol.ContentType, contentType)
+	}
+
+	volSizeBytes, err := units.ParseByteSizeString(vol.Config["size"])
+	if err != nil {
+		return 0, fmt.Errorf("Failed to parse volume size %q: %w", vol.Config["size"], err)
+	}
+
+	if volSizeBytes < sizeBytes || (limitBytes > 0 && volSizeBytes > limitBytes) {
+		return 0, fmt.Errorf("Volume size %d does not match the requested size %d", volSizeBytes, sizeBytes)
+	}
+
+	sourceID := getContentSourceID(contentSource)
+	if vol.Config[volumeContentSourceConfigKey] != sourceID {
+		return 0, fmt.Errorf("Content source %q does not match the requested content source %q", vol.Config[volumeContentSourceConfigKey], sourceID)
+	}
+
+	return volSizeBytes, nil
+}
+
+// getContentSourceID returns the ID of the snapshot or volume of the given
+// volume content source, or an empty string if the source is not set.
+func getContentSourceID(contentSource *csi.VolumeContentSource) string {
+	switch contentSource.GetType().(type) {
+	case *csi.VolumeContentSource_Snapshot:
+		return contentSource.GetSnapshot().GetSnapshotId()
+	case *csi.VolumeContentSource_Volume:
+		return contentSource.GetVolume().GetVolumeId()
+	default:
+		return ""
+	}
+}
+
+// cleanupCancelledVolume deletes a volume that was created by a cancelled CreateVolume
+// request. The deletion is best-effort, therefore errors are only logged.
+func (c *controllerServer) cleanupCancelledVolume(client DevLXDClient, poolName string, volName string) {
+	// Request context is already done, so use a separate context for cleanup.
+	ctx, cancel := context.WithTimeout(context.Background(), cancelledVolumeCleanupTimeout)
+	defer cancel()
+
+	err := withRetry(ctx, func() error {
+		return c.driver.runOperation(ctx, func() (lxdClient.DevLXDOperation, error) {
+			return client.DeleteStoragePoolVolume(poolName, "custom", volName)
+		})
+	})
+	if err != nil && !api.StatusErrorCheck(err, http.StatusNotFound) {
+		klog.ErrorS(err, "Failed to delete volume created by a cancelled CreateVolume request", "pool", poolName, "volume", volName)
+		return
+	}
+
+	klog.InfoS("Deleted volume created by a cancelled CreateVolume request", "pool", poolName, "volume", volName)
+}
+
+// getStoragePoolDriver returns the information about the storage driver of the given
+// storage pool, or nil if the driver is not supported by the LXD server.
+// The driver information is cached for [storagePoolDriverCacheTTL]. The cache is keyed
+// by both the pool name and its driver, so a change of the pool's driver results in
+// a cache miss.
+func (c *controllerServer) getStoragePoolDriver(client DevLXDClient, pool *api.DevLXDStoragePool) (*api.DevLXDServerStorageDriverInfo, error) {
+	key := pool.Name + "/" + pool.Driver
+
+	c.poolDriverCacheLock.Lock()
+	entry, ok := c.poolDriverCache[key]
+	c.poolDriverCacheLock.Unlock()
+
+	if ok && time.Now().Before(entry.expiresAt) {
+		return &entry.driver, nil
+	}
+
+	state, err := client.GetState()
+	if err != nil {
+		return nil, err
+	}
+
+	var driver *api.DevLXDServerStorageDriverInfo
+	for _, d := range state.SupportedStorageDrivers {
+		if d.Name == pool.Driver {
+			driver = &d
+			break
+		}
+	}
+
+	// Unsupported drivers are not cached, so that the lookup
+	// is retried in case the LXD server starts supporting it.
+	if driver != nil {
+		c.poolDriverCacheLock.Lock()
+		c.poolDriverCache[key] = storagePoolDriverCacheEntry{
+			driver:    *driver,
+			expiresAt: time.Now().Add(storagePoolDriverCacheTTL),
+		}
+		c.poolDriverCacheLock.Unlock()
+	}
+
+	return driver, nil
+}
+
+// ensureStoragePoolOnMember checks that the storage pool exists and is created
+// on the cluster member targeted by the given client.
+func (c *controllerServer) ensureStoragePoolOnMember(ctx context.Context, client DevLXDClient, poolName string, member string) error {
+	var pool *api.DevLXDStoragePool
+	err := withRetry(ctx, func() error {
+		var err error
+		pool, _, err = client.GetStoragePool(poolName)
+		return err
+	})
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return status.Errorf(codes.FailedPrecondition, "CreateVolume: Storage pool %q is not available on cluster member %q", poolName, member)
+		}
+
+		return status.Errorf(lxderrors.ToGRPCCode(err), "CreateVolume: Failed to retrieve storage pool %q on cluster member %q: %v", poolName, member, err)
+	}
+
+	if pool.Status != api.StoragePoolStatusCreated {
+		return status.Errorf(codes.FailedPrecondition, "CreateVolume: Storage pool %q is not available on cluster member %q: Pool status is %q", poolName, member, pool.Status)
+	}
+
+	return nil
+}
+
+// isSupportedStorageDriver checks whether the given LXD storage driver can be
+// used to back Kubernetes persistent volumes. If not, the returned string
+// explains why the driver is not supported.
+func isSupportedStorageDriver(driver *api.DevLXDServerStorageDriverInfo) (bool, string) {
+	if driver == nil {
+		return false, "Storage driver is not supported by the LXD server"
+	}
+
+	if driver.Name == "cephobject" {
+		return false, "Storage driver provides object storage which cannot back PVCs that require block or filesystem volumes"
+	}
+
+	return true, ""
+}
+
+// supportsVolumeSize checks whether the given LXD storage driver enforces
+// the configured volume size, which is required for volume expansion.
+func supportsVolumeSize(driver *api.DevLXDServerStorageDriverInfo) bool {
+	return driver.Name != "dir"
+}
+
+// supportsCrossPoolCopy checks whether a volume can be copied from a storage pool
+// using the source driver to a different storage pool using the target driver.
+// Volumes are cloned across storage pools only if both use the same driver,
+// as otherwise LXD fails with an error that is not actionable for the user.
+func supportsCrossPoolCopy(source *api.DevLXDServerStorageDriverInfo, target *api.DevLXDServerStorageDriverInfo) bool {
+	return source != nil && target != nil && source.Name == target.Name
+}
+
+// requiresDetachToExpand checks whether volumes of the given content type must
+// be detached from running instances before they can be expanded. LXD does not
+// grow custom block volumes that are in use.
+func requiresDetachToExpand(contentType string) bool {
+	return contentType == "block"
+}
+
+// parseAccessibleMembers parses a comma separated list of LXD cluster members.
+// An empty value results in no members, while a value that does not contain
+// any member name is considered invalid.
+func parseAccessibleMembers(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var members []string
+	for member := range strings.SplitSeq(value, ",") {
+		member = strings.TrimSpace(member)
+		if member == "" || slices.Contains(members, member) {
+			continue
+		}
+
+		members = append(members, member)
+	}
+
+	if len(members) == 0 {
+		return nil, errors.New("At least one cluster member must be listed")
+	}
+
+	return members, nil
+}
+
+// validateAccessibleMembers ensures the given cluster members are part of the
+// cluster. The cluster members are determined from the topologies of nodes
+// passed by the external-provisioner in the accessibility requirements.
+// If no requirements are provided, the members cannot be validated.
+func validateAccessibleMembers(members []string, requirements *csi.TopologyRequirement) error {
+	var clusterMembers []string
+	for _, topology := range slices.Concat(requirements.GetRequisite(), requirements.GetPreferred()) {
+		clusterMember, ok := topology.GetSegments()[AnnotationLXDClusterMember]
+		if ok {
+			clusterMembers = append(clusterMembers, clusterMember)
+		}
+	}
+
+	if len(clusterMembers) == 0 {
+		return nil
+	}
+
+	for _, member := range members {
+		if !slices.Contains(clusterMembers, member) {
+			return fmt.Errorf("Cluster member %q is not part of the cluster or runs no Kubernetes node", member)
+		}
+	}
+
+	return nil
+}
+
+// getCapacityRangeSize returns the volume size for the given capacity range.
+// The required bytes are used if set, otherwise the volume is provisioned at
+// the limit bytes.
+func getCapacityRangeSize(capacityRange *csi.CapacityRange) (int64, error) {
+	requiredBytes := capacityRange.GetRequiredBytes()
+	limitBytes := capacityRange.GetLimitBytes()
+
+	if requiredBytes < 0 {
+		return 0, errors.New("Required volume size cannot be negative")
+	}
+
+	if limitBytes < 0 {
+		return 0, errors.New("Volume size limit cannot be negative")
+	}
+
+	if requiredBytes == 0 && limitBytes == 0 {
+		return 0, errors.New("Either required volume size or volume size limit must be set")
+	}
+
+	if limitBytes > 0 && requiredBytes > limitBytes {
+		return 0, fmt.Errorf("Required volume size %d is larger than the volume size limit %d", requiredBytes, limitBytes)
+	}
+
+	if requiredBytes > 0 {
+		return requiredBytes, nil
+	}
+
+	return limitBytes, nil
+}
+
+// validateSnapshotsExpiry checks whether the given value is a valid LXD
+// snapshot expiry in format "<integer>(S|M|H|d|w|m|y)", for example "1d 3H".
+func validateSnapshotsExpiry(value string) error {
+	_, err := shared.GetExpiry(time.Time{}, value)
+	return err
+}
+
+// validateSnapshotsPattern checks whether the given snapshot name pattern can
+// be rendered the same way LXD renders it when creating automatic snapshots.
+// LXD accepts any pattern when the volume is created, and fails only once
+// the scheduled snapshot is taken.
+func validateSnapshotsPattern(value string) error {
+	name, err := shared.RenderTemplate(value, map[string]any{
+		"creation_date": time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to render snapshot pattern: %w", err)
+	}
+
+	if strings.Count(name, "%d") > 1 {
+		return errors.New(`Snapshot pattern may contain "%d" only once`)
+	}
+
+	if strings.Contains(name, "/") {
+		return errors.New(`Snapshot pattern cannot contain "/"`)
+	}
+
+	return nil
+}
+
+// validateMutableParameters checks whether the given mutable parameters can be
+// applied to a volume. Parameters that are fixed when the volume is provisioned,
+// such as the storage pool or the filesystem, are rejected.
+func validateMutableParameters(parameters map[string]string) error {
+	for k, v := range parameters {
+		if !slices.Contains(mutableVolumeParameters, k) {
+			_, isVolumeConfig := volumeConfigParameters[k]
+			if isVolumeConfig || k == ParameterStoragePool {
+				return fmt.Errorf("Parameter %q cannot be modified after provisioning", k)
+			}
+
+			return fmt.Errorf("Invalid mutable parameter %q", k)
+		}
+
+		err := volumeConfigParameters[k](v)
+		if err != nil {
+			return fmt.Errorf("Invalid value %q for mutable parameter %q: %w", v, k, err)
+		}
+	}
+
+	return nil
+}
+
+// getStorageDriverParameter returns the storage driver the given storage
+// class parameter is specific to, along with the parameter's validator.
+// False is returned if the parameter is not specific to any storage driver.
+func getStorageDriverParameter(key string) (string, func(value string) error, bool) {
+	for driverName, parameters := range storageDriverVolumeConfigParameters {
+		validator, ok := parameters[key]
+		if ok {
+			return driverName, validator, true
+		}
+	}
+
+	return "", nil, false
+}
+
+// getDeviceConfig returns the LXD disk device configuration from the given
+// storage class parameters. An error is returned if a parameter is invalid
+// or if the parameters conflict with each other.
+func getDeviceConfig(parameters map[string]string) (map[string]string, error) {
+	config := make(map[string]string)
+
+	for k, validator := range deviceConfigParameters {
+		v := parameters[k]
+		if v == "" {
+			continue
+		}
+
+		err := validator(v)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value %q for parameter %q: %w", v, k, err)
+		}
+
+		config[k] = v
+	}
+
+	// LXD sets both the read and write limit from the maximum limit.
+	if config[ParameterLimitsMax] != "" && (config[ParameterLimitsRead] != "" || config[ParameterLimitsWrite] != "") {
+		return nil, fmt.Errorf("Parameter %q cannot be combined with %q or %q", ParameterLimitsMax, ParameterLimitsRead, ParameterLimitsWrite)
+	}
+
+	return config, nil
+}
+
+// parseVolumeSizeParameter parses a volume size set in the storage class,
+// such as the maximum volume size. Zero is returned if the value is empty.
+func parseVolumeSizeParameter(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	sizeBytes, err := units.ParseByteSizeString(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if sizeBytes <= 0 {
+		return 0, errors.New("Volume size must be greater than zero")
+	}
+
+	return sizeBytes, nil
+}
+
+// validateDeviceIOLimit validates the I/O limit of a disk device, which is
+// either a number of bytes per second (for example, "10MB") or a number of
+// operations per second (for example, "100iops").
+func validateDeviceIOLimit(value string) error {
+	iops, ok := strings.CutSuffix(value, "iops")
+	if ok {
+		_, err := strconv.ParseUint(iops, 10, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid number of operations per second %q", iops)
+		}
+
+		return nil
+	}
+
+	_, err := units.ParseByteSizeString(value)
+	if err != nil {
+		return fmt.Errorf("Invalid number of bytes per second: %w", err)
+	}
+
+	return nil
+}
+
+// getVolumeConfig returns the LXD volume configuration for a volume of the
+// given size. Storage class parameters that map to the LXD volume configuration
+// are merged into the returned configuration, while empty values are ignored.
+func getVolumeConfig(sizeBytes int64, parameters map[string]string) map[string]string {
+	config := map[string]string{
+		"size": strconv.FormatInt(sizeBytes, 10),
+	}
+
+	for k, v := range parameters {
+		_, ok := volumeConfigParameters[k]
+		if !ok {
+			_, _, ok = getStorageDriverParameter(k)
+		}
+
+		if ok && v != "" {
+			config[k] = v
+		}
+	}
+
+	if shared.IsTrue(parameters[ParameterProtected]) {
+		config[volumeConfigProtected] = "true"
+	}
+
+	return config
+}
+
+// isAmbiguousVolumeLocationError checks whether the given error is returned by
+// LXD when a volume is looked up without a target, but volumes with the same
+// name exist on multiple cluster members.
+func isAmbiguousVolumeLocationError(err error) bool {
+	return api.StatusErrorCheck(err, http.StatusConflict) || strings.Contains(strings.ToLower(err.Error()), "more than one cluster member")
+}
+
+// isContainerDeviceConfigError checks whether the given error is returned by
+// LXD when a disk device option that applies only to virtual machines is set
+// on a device of a container.
+func isContainerDeviceConfigError(err error) bool {
+	return api.StatusErrorCheck(err, http.StatusBadRequest) && strings.Contains(err.Error(), "cannot be applied to containers")
+}
+
+// getSourceSnapshotParameters returns the volume content source referencing the
+// LXD volume snapshot set by the storage class parameters [ParameterSourceSnapshotPool],
+// [ParameterSourceSnapshotVolume], and [ParameterSourceSnapshotName]. It returns
+// nil if none of the parameters is set, and an error if only some of them are.
+func getSourceSnapshotParameters(parameters map[string]string) (*csi.VolumeContentSource, error) {
+	keys := []string{ParameterSourceSnapshotPool, ParameterSourceSnapshotVolume, ParameterSourceSnapshotName}
+
+	values := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if parameters[k] != "" {
+			values = append(values, parameters[k])
+		}
+	}
+
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	if len(values) != len(keys) {
+		return nil, fmt.Errorf("Storage class parameters %q, %q, and %q must be set together", keys[0], keys[1], keys[2])
+	}
+
+	return &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{
+				SnapshotId: strings.Join(values, "/"),
+			},
+		},
+	}, nil
+}
+
+// isVolumeProtected returns true if the volume with the given configuration
+// is protected from deletion and its deletion is not explicitly allowed.
+func isVolumeProtected(config map[string]string) bool {
+	return shared.IsTrue(config[volumeConfigProtected]) && !shared.IsTrue(config[volumeConfigAllowDelete])
+}
@@ -1,12 +1,20 @@
 package driver
 
 import (
+	"bytes"
 	"context"
 	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
 
 	lxdClient "github.com/canonical/lxd/client"
 	"github.com/canonical/lxd/shared/api"
@@ -21,12 +29,58 @@ func (f *fakeDevLXDOperation) WaitContext(ctx context.Context) error {
 	return nil
 }
 
-// fakeDevLXDServer mocks lxdClient.DevLXDServer for testing.
+// fakeDevLXDServer mocks DevLXDClient for testing.
 type fakeDevLXDServer struct {
-	lxdClient.DevLXDServer
+	DevLXDClient
 
-	getVolFunc    func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error)
-	updateVolFunc func(pool string, volType string, name string, volume api.DevLXDStorageVolumePut, ETag string) (lxdClient.DevLXDOperation, error)
+	getStateFunc   func() (*api.DevLXDGet, error)
+	getPoolFunc    func(pool string) (*api.DevLXDStoragePool, string, error)
+	getVolFunc     func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error)
+	createVolFunc  func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error)
+	updateVolFunc  func(pool string, volType string, name string, volume api.DevLXDStorageVolumePut, ETag string) (lxdClient.DevLXDOperation, error)
+	deleteVolFunc  func(pool string, volType string, name string) (lxdClient.DevLXDOperation, error)
+	getSnapsFunc   func(pool string, volType string, volName string) ([]api.DevLXDStorageVolumeSnapshot, error)
+	getSnapFunc    func(pool string, volType string, volName string, name string) (*api.DevLXDStorageVolumeSnapshot, string, error)
+	createSnapFunc func(pool string, volType string, volName string, snapshot api.DevLXDStorageVolumeSnapshotsPost) (lxdClient.DevLXDOperation, error)
+	deleteSnapFunc func(pool string, volType string, volName string, name string) (lxdClient.DevLXDOperation, error)
+	getInstFunc    func(name string) (*api.DevLXDInstance, string, error)
+	updateInstFunc func(name string, inst api.DevLXDInstancePut, ETag string) error
+
+	// Targets set using UseTarget.
+	targets []string
+}
+
+func (f *fakeDevLXDServer) UseTarget(name string) DevLXDClient {
+	f.targets = append(f.targets, name)
+	return f
+}
+
+func (f *fakeDevLXDServer) GetState() (*api.DevLXDGet, error) {
+	if f.getStateFunc != nil {
+		return f.getStateFunc()
+	}
+	return &api.DevLXDGet{}, nil
+}
+
+func (f *fakeDevLXDServer) GetStoragePool(pool string) (*api.DevLXDStoragePool, string, error) {
+	if f.getPoolFunc != nil {
+		return f.getPoolFunc(pool)
+	}
+	return &api.DevLXDStoragePool{Name: pool}, "", nil
+}
+
+func (f *fakeDevLXDServer) CreateStoragePoolVolume(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+	if f.createVolFunc != nil {
+		return f.createVolFunc(pool, volume)
+	}
+	return &fakeDevLXDOperation{}, nil
+}
+
+func (f *fakeDevLXDServer) DeleteStoragePoolVolume(pool string, volType string, name string) (lxdClient.DevLXDOperation, error) {
+	if f.deleteVolFunc != nil {
+		return f.deleteVolFunc(pool, volType, name)
+	}
+	return &fakeDevLXDOperation{}, nil
 }
 
 func (f *fakeDevLXDServer) GetStoragePoolVolume(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
@@ -43,6 +97,48 @@ func (f *fakeDevLXDServer) UpdateStoragePoolVolume(pool string, volType string,
 	return &fakeDevLXDOperation{}, nil
 }
 
+func (f *fakeDevLXDServer) GetStoragePoolVolumeSnapshots(pool string, volType string, volName string) ([]api.DevLXDStorageVolumeSnapshot, error) {
+	if f.getSnapsFunc != nil {
+		return f.getSnapsFunc(pool, volType, volName)
+	}
+	return []api.DevLXDStorageVolumeSnapshot{}, nil
+}
+
+func (f *fakeDevLXDServer) GetStoragePoolVolumeSnapshot(pool string, volType string, volName string, name string) (*api.DevLXDStorageVolumeSnapshot, string, error) {
+	if f.getSnapFunc != nil {
+		return f.getSnapFunc(pool, volType, volName, name)
+	}
+	return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage volume snapshot not found")
+}
+
+func (f *fakeDevLXDServer) CreateStoragePoolVolumeSnapshot(pool string, volType string, volName string, snapshot api.DevLXDStorageVolumeSnapshotsPost) (lxdClient.DevLXDOperation, error) {
+	if f.createSnapFunc != nil {
+		return f.createSnapFunc(pool, volType, volName, snapshot)
+	}
+	return &fakeDevLXDOperation{}, nil
+}
+
+func (f *fakeDevLXDServer) DeleteStoragePoolVolumeSnapshot(pool string, volType string, volName string, name string) (lxdClient.DevLXDOperation, error) {
+	if f.deleteSnapFunc != nil {
+		return f.deleteSnapFunc(pool, volType, volName, name)
+	}
+	return &fakeDevLXDOperation{}, nil
+}
+
+func (f *fakeDevLXDServer) GetInstance(name string) (*api.DevLXDInstance, string, error) {
+	if f.getInstFunc != nil {
+		return f.getInstFunc(name)
+	}
+	return &api.DevLXDInstance{Name: name}, "", nil
+}
+
+func (f *fakeDevLXDServer) UpdateInstance(name string, inst api.DevLXDInstancePut, ETag string) error {
+	if f.updateInstFunc != nil {
+		return f.updateInstFunc(name, inst, ETag)
+	}
+	return nil
+}
+
 func TestControllerExpandVolumePreservesConfig(t *testing.T) {
 	// Initialize driver and controller server
 	d := &Driver{
@@ -120,3 +216,4103 @@ func TestControllerExpandVolumePreservesConfig(t *testing.T) {
 	require.True(t, calledGet, "GetStoragePoolVolume should have been called")
 	require.True(t, calledUpdate, "UpdateStoragePoolVolume should have been called")
 }
+
+func TestVolumeConfigParameters(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Key         string
+		Value       string
+		expectError bool
+	}{
+		{Name: "Valid cron schedule", Key: ParameterSnapshotsSchedule, Value: "0 6 * * *"},
+		{Name: "Valid multiple cron schedules", Key: ParameterSnapshotsSchedule, Value: "0 6 * * *, 0 18 * * *"},
+		{Name: "Valid schedule alias", Key: ParameterSnapshotsSchedule, Value: "@daily"},
+		{Name: "Empty schedule", Key: ParameterSnapshotsSchedule, Value: ""},
+		{Name: "Invalid schedule field count", Key: ParameterSnapshotsSchedule, Value: "0 6 * *", expectError: true},
+		{Name: "Invalid schedule alias", Key: ParameterSnapshotsSchedule, Value: "@sometimes", expectError: true},
+		{Name: "Valid expiry", Key: ParameterSnapshotsExpiry, Value: "1d"},
+		{Name: "Valid compound expiry", Key: ParameterSnapshotsExpiry, Value: "2w 3d 4H"},
+		{Name: "Empty expiry", Key: ParameterSnapshotsExpiry, Value: ""},
+		{Name: "Invalid expiry unit", Key: ParameterSnapshotsExpiry, Value: "1x", expectError: true},
+		{Name: "Invalid expiry format", Key: ParameterSnapshotsExpiry, Value: "tomorrow", expectError: true},
+		{Name: "Valid plain pattern", Key: ParameterSnapshotsPattern, Value: "auto%d"},
+		{Name: "Valid date pattern", Key: ParameterSnapshotsPattern, Value: "snap-{{ creation_date|date:'2006-01-02' }}"},
+		{Name: "Empty pattern", Key: ParameterSnapshotsPattern, Value: ""},
+		{Name: "Invalid pattern template", Key: ParameterSnapshotsPattern, Value: "snap-{{ creation_date", expectError: true},
+		{Name: "Invalid pattern with multiple counters", Key: ParameterSnapshotsPattern, Value: "snap%d-%d", expectError: true},
+		{Name: "Invalid pattern with slash", Key: ParameterSnapshotsPattern, Value: "snap/%d", expectError: true},
+		{Name: "Valid block filesystem", Key: ParameterBlockFilesystem, Value: "xfs"},
+		{Name: "Empty block filesystem", Key: ParameterBlockFilesystem, Value: ""},
+		{Name: "Invalid block filesystem", Key: ParameterBlockFilesystem, Value: "ntfs", expectError: true},
+		{Name: "Valid block mount options", Key: ParameterBlockMountOptions, Value: "noatime,discard"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			validator, ok := volumeConfigParameters[test.Key]
+			require.True(t, ok, "Parameter %q has no validator", test.Key)
+
+			err := validator(test.Value)
+			if test.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGetVolumeConfig(t *testing.T) {
+	parameters := map[string]string{
+		ParameterStoragePool:       "default",
+		ParameterPVCName:           "pvc",
+		ParameterSnapshotsSchedule: "@hourly",
+		ParameterSnapshotsExpiry:   "",
+		ParameterLVMStripes:        "2",
+		ParameterProtected:         "true",
+	}
+
+	config := getVolumeConfig(1024, parameters)
+	require.Equal(t, map[string]string{
+		"size":                     "1024",
+		ParameterSnapshotsSchedule: "@hourly",
+		ParameterLVMStripes:        "2",
+		volumeConfigProtected:      "true",
+	}, config)
+}
+
+func TestControllerDeleteVolumeProtected(t *testing.T) {
+	tests := []struct {
+		Name         string
+		Config       map[string]string
+		GetVolErr    error
+		expectCode   codes.Code
+		expectDelete bool
+	}{
+		{
+			Name:         "Unprotected volume",
+			Config:       map[string]string{"size": "1024"},
+			expectDelete: true,
+		},
+		{
+			Name:         "Volume with protection disabled",
+			Config:       map[string]string{"size": "1024", volumeConfigProtected: "false"},
+			expectDelete: true,
+		},
+		{
+			Name:       "Protected volume",
+			Config:     map[string]string{"size": "1024", volumeConfigProtected: "true"},
+			expectCode: codes.FailedPrecondition,
+		},
+		{
+			Name:         "Protected volume with deletion allowed",
+			Config:       map[string]string{"size": "1024", volumeConfigProtected: "true", volumeConfigAllowDelete: "true"},
+			expectDelete: true,
+		},
+		{
+			Name:         "Missing volume",
+			GetVolErr:    api.StatusErrorf(http.StatusNotFound, "Storage volume not found"),
+			expectDelete: true,
+		},
+		{
+			Name:       "Failure to retrieve volume",
+			GetVolErr:  api.StatusErrorf(http.StatusForbidden, "Forbidden"),
+			expectCode: codes.PermissionDenied,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var deleted bool
+			fakeClient := &fakeDevLXDServer{
+				getVolFunc: func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+					if test.GetVolErr != nil {
+						return nil, "", test.GetVolErr
+					}
+
+					return &api.DevLXDStorageVolume{Name: name, Config: test.Config}, "", nil
+				},
+				deleteVolFunc: func(pool string, volType string, name string) (lxdClient.DevLXDOperation, error) {
+					deleted = true
+					return &fakeDevLXDOperation{}, nil
+				},
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			_, err := controller.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "remote/pvc-vol"})
+			require.Equal(t, test.expectCode, status.Code(err))
+			require.Equal(t, test.expectDelete, deleted)
+		})
+	}
+}
+
+func TestControllerDeleteVolumeSnapshots(t *testing.T) {
+	// Names of snapshots created by the driver for VolumeSnapshots.
+	snapshotA := "snapshot-0b6f7c384b0e4d6c9a3e4f2b0e7e8a11"
+	snapshotB := "snapshot-9c2e1f4a5b6d4e7f8a9b0c1d2e3f4a5b"
+
+	tests := []struct {
+		Name                string
+		Policy              string
+		Snapshots           []string
+		Config              map[string]string
+		GetSnapsErr         error
+		DeleteSnapErr       error
+		expectCode          codes.Code
+		expectMessage       string
+		expectDeletedSnaps  []string
+		expectDeletedVolume bool
+	}{
+		{
+			Name:                "Volume without snapshots",
+			expectDeletedVolume: true,
+		},
+		{
+			Name:          "Volume with snapshots is refused by default",
+			Snapshots:     []string{snapshotB, snapshotA},
+			expectCode:    codes.FailedPrecondition,
+			expectMessage: `Volume "pvc-vol" in storage pool "remote" has snapshots ["` + snapshotA + `" "` + snapshotB + `"]`,
+		},
+		{
+			Name:          "Volume with snapshots is refused",
+			Policy:        DeleteVolumeSnapshotsPolicyRefuse,
+			Snapshots:     []string{snapshotA},
+			expectCode:    codes.FailedPrecondition,
+			expectMessage: `has snapshots ["` + snapshotA + `"]`,
+		},
+		{
+			Name:          "Volume created with snapshots.schedule is refused with its scheduled snapshots",
+			Snapshots:     []string{"snap1", "snap0"},
+			Config:        map[string]string{ParameterSnapshotsSchedule: "@daily"},
+			expectCode:    codes.FailedPrecondition,
+			expectMessage: `has snapshots ["snap0" "snap1"]`,
+		},
+		{
+			Name:          "All snapshots are listed in the refusal",
+			Snapshots:     []string{"snap0", snapshotA},
+			Config:        map[string]string{ParameterSnapshotsSchedule: "@daily"},
+			expectCode:    codes.FailedPrecondition,
+			expectMessage: `has snapshots ["snap0" "` + snapshotA + `"]`,
+		},
+		{
+			Name:                "Volume created with snapshots.schedule is deleted with its scheduled snapshots",
+			Policy:              DeleteVolumeSnapshotsPolicyDelete,
+			Snapshots:           []string{"snap1", "snap0"},
+			Config:              map[string]string{ParameterSnapshotsSchedule: "@daily"},
+			expectDeletedSnaps:  []string{"snap0", "snap1"},
+			expectDeletedVolume: true,
+		},
+		{
+			Name:                "Snapshots are deleted before the volume",
+			Policy:              DeleteVolumeSnapshotsPolicyDelete,
+			Snapshots:           []string{snapshotB, "snap0", snapshotA},
+			expectDeletedSnaps:  []string{"snap0", snapshotA, snapshotB},
+			expectDeletedVolume: true,
+		},
+		{
+			Name:                "Snapshots deleted in the meantime are ignored",
+			Policy:              DeleteVolumeSnapshotsPolicyDelete,
+			Snapshots:           []string{"snapshot-a"},
+			DeleteSnapErr:       api.StatusErrorf(http.StatusNotFound, "Storage volume snapshot not found"),
+			expectDeletedSnaps:  []string{"snapshot-a"},
+			expectDeletedVolume: true,
+		},
+		{
+			Name:               "Failure to delete snapshot",
+			Policy:             DeleteVolumeSnapshotsPolicyDelete,
+			Snapshots:          []string{"snapshot-a"},
+			DeleteSnapErr:      api.StatusErrorf(http.StatusForbidden, "Forbidden"),
+			expectCode:         codes.PermissionDenied,
+			expectMessage:      `Failed to delete snapshot "snapshot-a"`,
+			expectDeletedSnaps: []string{"snapshot-a"},
+		},
+		{
+			Name:                "Volume deleted in the meantime",
+			GetSnapsErr:         api.StatusErrorf(http.StatusNotFound, "Storage volume not found"),
+			expectDeletedVolume: true,
+		},
+		{
+			Name:          "Failure to retrieve snapshots",
+			GetSnapsErr:   api.StatusErrorf(http.StatusForbidden, "Forbidden"),
+			expectCode:    codes.PermissionDenied,
+			expectMessage: `Failed to retrieve snapshots of volume "pvc-vol"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var deletedSnaps []string
+			var deletedVolume bool
+			fakeClient := &fakeDevLXDServer{
+				getVolFunc: func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+					return &api.DevLXDStorageVolume{Name: name, Config: test.Config}, "", nil
+				},
+				getSnapsFunc: func(pool string, volType string, volName string) ([]api.DevLXDStorageVolumeSnapshot, error) {
+					if test.GetSnapsErr != nil {
+						return nil, test.GetSnapsErr
+					}
+
+					snapshots := make([]api.DevLXDStorageVolumeSnapshot, 0, len(test.Snapshots))
+					for _, name := range test.Snapshots {
+						snapshots = append(snapshots, api.DevLXDStorageVolumeSnapshot{Name: name})
+					}
+
+					return snapshots, nil
+				},
+				deleteSnapFunc: func(pool string, volType string, volName string, name string) (lxdClient.DevLXDOperation, error) {
+					deletedSnaps = append(deletedSnaps, name)
+					if test.DeleteSnapErr != nil {
+						return nil, test.DeleteSnapErr
+					}
+
+					return &fakeDevLXDOperation{}, nil
+				},
+				deleteVolFunc: func(pool string, volType string, name string) (lxdClient.DevLXDOperation, error) {
+					deletedVolume = true
+					return &fakeDevLXDOperation{}, nil
+				},
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient, deleteVolumeSnapshotsPolicy: test.Policy})
+
+			_, err := controller.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "remote/pvc-vol"})
+			require.Equal(t, test.expectCode, status.Code(err))
+			if test.expectMessage != "" {
+				require.ErrorContains(t, err, test.expectMessage)
+			}
+
+			if test.expectCode == codes.FailedPrecondition {
+				info := requireErrorInfo(t, err, codes.FailedPrecondition)
+				require.Equal(t, ErrorReasonVolumeHasSnapshots, info.Reason)
+			}
+
+			require.Equal(t, test.expectDeletedSnaps, deletedSnaps)
+			require.Equal(t, test.expectDeletedVolume, deletedVolume)
+		})
+	}
+}
+
+func TestControllerDeleteVolumeClusterMember(t *testing.T) {
+	tests := []struct {
+		Name          string
+		VolumeID      string
+		Clustered     bool
+		Location      string
+		GetVolErr     error
+		expectCode    codes.Code
+		expectTargets []string
+	}{
+		{
+			Name:          "Volume ID with cluster member",
+			VolumeID:      "lxd01:local/pvc-vol",
+			Clustered:     true,
+			Location:      "lxd01",
+			expectTargets: []string{"lxd01"},
+		},
+		{
+			Name:          "Volume ID without cluster member",
+			VolumeID:      "local/pvc-vol",
+			Clustered:     true,
+			Location:      "lxd02",
+			expectTargets: []string{"lxd02"},
+		},
+		{
+			Name:      "Volume ID without cluster member on remote storage pool",
+			VolumeID:  "remote/pvc-vol",
+			Clustered: true,
+			Location:  "none",
+		},
+		{
+			Name:     "Volume ID without cluster member on non-clustered LXD",
+			VolumeID: "local/pvc-vol",
+			Location: "lxd01",
+		},
+		{
+			Name:       "Volume on more than one cluster member",
+			VolumeID:   "local/pvc-vol",
+			Clustered:  true,
+			GetVolErr:  api.StatusErrorf(http.StatusConflict, "Storage volume found on more than one cluster member. Please target a specific member"),
+			expectCode: codes.FailedPrecondition,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var deleteTargets []string
+			var deleted bool
+
+			fakeClient := &fakeDevLXDServer{
+				getVolFunc: func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+					if test.GetVolErr != nil {
+						return nil, "", test.GetVolErr
+					}
+
+					return &api.DevLXDStorageVolume{Name: name, Location: test.Location}, "", nil
+				},
+			}
+
+			fakeClient.deleteVolFunc = func(pool string, volType string, name string) (lxdClient.DevLXDOperation, error) {
+				deleted = true
+				deleteTargets = slices.Clone(fakeClient.targets)
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient, isClustered: test.Clustered})
+
+			_, err := controller.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: test.VolumeID})
+			require.Equal(t, test.expectCode, status.Code(err))
+			if test.expectCode != codes.OK {
+				require.ErrorContains(t, err, "more than one cluster member")
+				require.False(t, deleted)
+				return
+			}
+
+			require.True(t, deleted)
+			require.Equal(t, test.expectTargets, deleteTargets)
+		})
+	}
+}
+
+func TestCreateVolumeStorageDriverParameters(t *testing.T) {
+	tests := []struct {
+		Name         string
+		PoolDriver   string
+		Parameters   map[string]string
+		expectConfig map[string]string
+		expectError  string
+	}{
+		{
+			Name:       "LVM parameters on LVM pool",
+			PoolDriver: "lvm",
+			Parameters: map[string]string{
+				ParameterLVMStripes:     "2",
+				ParameterLVMStripesSize: "64KiB",
+			},
+			expectConfig: map[string]string{
+				"size":                  "1073741824",
+				ParameterLVMStripes:     "2",
+				ParameterLVMStripesSize: "64KiB",
+			},
+		},
+		{
+			Name:       "LVM parameters on Ceph pool",
+			PoolDriver: "ceph",
+			Parameters: map[string]string{
+				ParameterLVMStripes: "2",
+			},
+			expectError: `Parameter "lvm.stripes" in storage class is only supported for storage pools using the "lvm" driver`,
+		},
+		{
+			Name:       "Invalid LVM stripes",
+			PoolDriver: "lvm",
+			Parameters: map[string]string{
+				ParameterLVMStripes: "two",
+			},
+			expectError: `Invalid value "two" for parameter "lvm.stripes"`,
+		},
+		{
+			Name:       "Invalid LVM stripe size",
+			PoolDriver: "lvm",
+			Parameters: map[string]string{
+				ParameterLVMStripesSize: "big",
+			},
+			expectError: `Invalid value "big" for parameter "lvm.stripes.size"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var createdConfig map[string]string
+			fakeClient := newFakePoolDevLXDServer(test.PoolDriver, test.PoolDriver == "ceph")
+			fakeClient.createVolFunc = func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+				createdConfig = volume.Config
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			parameters := map[string]string{
+				ParameterStoragePool: "pool",
+			}
+
+			maps.Copy(parameters, test.Parameters)
+
+			_, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "pvc-5d6e7f80-1a2b-4c3d-8e9f-0a1b2c3d4e5f",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+						AccessType: &csi.VolumeCapability_Block{
+							Block: &csi.VolumeCapability_BlockVolume{},
+						},
+					},
+				},
+				Parameters: parameters,
+			})
+			if test.expectError != "" {
+				require.Error(t, err)
+				require.Equal(t, codes.InvalidArgument, status.Code(err))
+				require.ErrorContains(t, err, test.expectError)
+				require.Nil(t, createdConfig)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.expectConfig, createdConfig)
+		})
+	}
+}
+
+func TestCreateVolumeSnapshotsParameters(t *testing.T) {
+	tests := []struct {
+		Name         string
+		Parameters   map[string]string
+		expectConfig map[string]string
+		expectError  string
+	}{
+		{
+			Name: "Snapshot schedule, expiry, and pattern",
+			Parameters: map[string]string{
+				ParameterSnapshotsSchedule: "0 6 * * *",
+				ParameterSnapshotsExpiry:   "1w",
+				ParameterSnapshotsPattern:  "auto-{{ creation_date|date:'2006-01-02' }}-%d",
+			},
+			expectConfig: map[string]string{
+				"size":                     "1073741824",
+				ParameterSnapshotsSchedule: "0 6 * * *",
+				ParameterSnapshotsExpiry:   "1w",
+				ParameterSnapshotsPattern:  "auto-{{ creation_date|date:'2006-01-02' }}-%d",
+			},
+		},
+		{
+			Name: "Invalid snapshot schedule",
+			Parameters: map[string]string{
+				ParameterSnapshotsSchedule: "every day",
+			},
+			expectError: `Invalid value "every day" for parameter "snapshots.schedule"`,
+		},
+		{
+			Name: "Invalid snapshot expiry",
+			Parameters: map[string]string{
+				ParameterSnapshotsExpiry: "1 week",
+			},
+			expectError: `Invalid value "1 week" for parameter "snapshots.expiry"`,
+		},
+		{
+			Name: "Invalid snapshot pattern",
+			Parameters: map[string]string{
+				ParameterSnapshotsPattern: "snap-%d-%d",
+			},
+			expectError: `Invalid value "snap-%d-%d" for parameter "snapshots.pattern"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var createdConfig map[string]string
+			fakeClient := newFakePoolDevLXDServer("ceph", true)
+			fakeClient.createVolFunc = func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+				createdConfig = volume.Config
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			parameters := map[string]string{
+				ParameterStoragePool: "pool",
+			}
+
+			maps.Copy(parameters, test.Parameters)
+
+			_, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "pvc-5d6e7f80-1a2b-4c3d-8e9f-0a1b2c3d4e5f",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+						AccessType: &csi.VolumeCapability_Block{
+							Block: &csi.VolumeCapability_BlockVolume{},
+						},
+					},
+				},
+				Parameters: parameters,
+			})
+			if test.expectError != "" {
+				require.Error(t, err)
+				require.Equal(t, codes.InvalidArgument, status.Code(err))
+				require.ErrorContains(t, err, test.expectError)
+				require.Nil(t, createdConfig)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.expectConfig, createdConfig)
+		})
+	}
+}
+
+func TestIsSupportedStorageDriver(t *testing.T) {
+	tests := []struct {
+		Name            string
+		Driver          *api.DevLXDServerStorageDriverInfo
+		expectSupported bool
+		expectReason    string
+	}{
+		{
+			Name:            "Nil driver",
+			Driver:          nil,
+			expectSupported: false,
+			expectReason:    "not supported by the LXD server",
+		},
+		{
+			Name:            "Object storage driver",
+			Driver:          &api.DevLXDServerStorageDriverInfo{Name: "cephobject", Remote: true},
+			expectSupported: false,
+			expectReason:    "object storage",
+		},
+		{
+			Name:            "Local driver",
+			Driver:          &api.DevLXDServerStorageDriverInfo{Name: "zfs", Remote: false},
+			expectSupported: true,
+		},
+		{
+			Name:            "Remote driver",
+			Driver:          &api.DevLXDServerStorageDriverInfo{Name: "ceph", Remote: true},
+			expectSupported: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			supported, reason := isSupportedStorageDriver(test.Driver)
+			require.Equal(t, test.expectSupported, supported)
+
+			if test.expectSupported {
+				require.Empty(t, reason)
+			} else {
+				require.Contains(t, reason, test.expectReason)
+			}
+		})
+	}
+}
+
+func TestGetStoragePoolDriverCache(t *testing.T) {
+	getStateCalls := 0
+	fakeClient := &fakeDevLXDServer{
+		getStateFunc: func() (*api.DevLXDGet, error) {
+			getStateCalls++
+			return &api.DevLXDGet{
+				DevLXDGetUntrusted: api.DevLXDGetUntrusted{
+					SupportedStorageDrivers: []api.DevLXDServerStorageDriverInfo{
+						{Name: "zfs", Remote: false},
+						{Name: "ceph", Remote: true},
+					},
+				},
+			}, nil
+		},
+	}
+
+	controller := NewControllerServer(&Driver{})
+
+	// First lookup populates the cache.
+	driver, err := controller.getStoragePoolDriver(fakeClient, &api.DevLXDStoragePool{Name: "pool", Driver: "zfs"})
+	require.NoError(t, err)
+	require.Equal(t, "zfs", driver.Name)
+	require.Equal(t, 1, getStateCalls)
+
+	// Subsequent lookup within TTL is served from the cache.
+	driver, err = controller.getStoragePoolDriver(fakeClient, &api.DevLXDStoragePool{Name: "pool", Driver: "zfs"})
+	require.NoError(t, err)
+	require.Equal(t, "zfs", driver.Name)
+	require.Equal(t, 1, getStateCalls)
+
+	// Change of the pool's driver results in a cache miss.
+	driver, err = controller.getStoragePoolDriver(fakeClient, &api.DevLXDStoragePool{Name: "pool", Driver: "ceph"})
+	require.NoError(t, err)
+	require.Equal(t, "ceph", driver.Name)
+	require.True(t, driver.Remote)
+	require.Equal(t, 2, getStateCalls)
+
+	// Unsupported drivers are not cached.
+	for range 2 {
+		driver, err = controller.getStoragePoolDriver(fakeClient, &api.DevLXDStoragePool{Name: "pool", Driver: "unknown"})
+		require.NoError(t, err)
+		require.Nil(t, driver)
+	}
+
+	require.Equal(t, 4, getStateCalls)
+
+	// Expired entries are refreshed.
+	oldTTL := storagePoolDriverCacheTTL
+	storagePoolDriverCacheTTL = 0
+	t.Cleanup(func() { storagePoolDriverCacheTTL = oldTTL })
+
+	controller = NewControllerServer(&Driver{})
+	for range 2 {
+		_, err = controller.getStoragePoolDriver(fakeClient, &api.DevLXDStoragePool{Name: "pool", Driver: "zfs"})
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 6, getStateCalls)
+}
+
+// fakeCancelledDevLXDOperation is an operation during which the request is
+// cancelled. Waiting for it fails with the cancellation of the request, while
+// waiting on a context that is not cancelled succeeds.
+type fakeCancelledDevLXDOperation struct {
+	lxdClient.DevLXDOperation
+
+	cancel func()
+	waits  int
+}
+
+func (f *fakeCancelledDevLXDOperation) WaitContext(ctx context.Context) error {
+	f.waits++
+	if f.waits == 1 {
+		f.cancel()
+	}
+
+	return ctx.Err()
+}
+
+func TestCreateVolumeCancelledAfterCreate(t *testing.T) {
+	tests := []struct {
+		Name             string
+		CancelPolicy     string
+		CancelDuringWait bool
+		expectDelete     bool
+		expectWaits      int
+	}{
+		{
+			Name:         "Keep volume created by cancelled request",
+			CancelPolicy: CreateVolumeCancelPolicyKeep,
+			expectDelete: false,
+		},
+		{
+			Name:         "Delete volume created by cancelled request",
+			CancelPolicy: CreateVolumeCancelPolicyDelete,
+			expectDelete: true,
+		},
+		{
+			Name:             "Keep volume of request cancelled while waiting for creation",
+			CancelPolicy:     CreateVolumeCancelPolicyKeep,
+			CancelDuringWait: true,
+			expectDelete:     false,
+			expectWaits:      1,
+		},
+		{
+			Name:             "Delete volume of request cancelled while waiting for creation",
+			CancelPolicy:     CreateVolumeCancelPolicyDelete,
+			CancelDuringWait: true,
+			expectDelete:     true,
+			expectWaits:      2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var createdVol, deletedVol string
+			op := &fakeCancelledDevLXDOperation{cancel: cancel}
+			fakeClient := newFakePoolDevLXDServer("ceph", true)
+			fakeClient.createVolFunc = func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+				createdVol = volume.Name
+
+				if test.CancelDuringWait {
+					return op, nil
+				}
+
+				// Simulate request cancellation right after the volume is created.
+				cancel()
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			fakeClient.deleteVolFunc = func(pool string, volType string, name string) (lxdClient.DevLXDOperation, error) {
+				deletedVol = name
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			d := &Driver{
+				devLXD:                   fakeClient,
+				createVolumeCancelPolicy: test.CancelPolicy,
+			}
+
+			controller := NewControllerServer(d)
+
+			req := &csi.CreateVolumeRequest{
+				Name: "pvc-e9d4c1a0-6b0b-4f4e-9a55-3a1f0b2d8c11",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+					},
+				},
+				Parameters: map[string]string{
+					ParameterStoragePool: "remote",
+				},
+			}
+
+			resp, err := controller.CreateVolume(ctx, req)
+			require.Equal(t, "pvc-e9d4c1a06b0b4f4e9a553a1f0b2d8c11", createdVol)
+			require.Equal(t, test.expectWaits, op.waits)
+
+			if test.CancelDuringWait {
+				require.Equal(t, codes.Canceled, status.Code(err))
+				if test.expectDelete {
+					require.Equal(t, createdVol, deletedVol)
+				} else {
+					require.Empty(t, deletedVol)
+				}
+
+				return
+			}
+
+			if test.expectDelete {
+				require.Error(t, err)
+				require.Equal(t, codes.Canceled, status.Code(err))
+				require.Equal(t, createdVol, deletedVol)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, "remote/"+createdVol, resp.Volume.VolumeId)
+				require.Empty(t, deletedVol)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeRetryAfterCancel(t *testing.T) {
+	const sizeBytes = 1024 * 1024 * 1024
+
+	tests := []struct {
+		Name              string
+		RetryBytes        int64
+		RetryContentType  string
+		RetrySource       *csi.VolumeContentSource
+		expectCode        codes.Code
+		expectCreateCalls int
+	}{
+		{
+			Name:              "Retried request returns the kept volume",
+			RetryBytes:        sizeBytes,
+			RetryContentType:  "filesystem",
+			expectCreateCalls: 1,
+		},
+		{
+			Name:              "Retried request with a larger size",
+			RetryBytes:        2 * sizeBytes,
+			RetryContentType:  "filesystem",
+			expectCode:        codes.AlreadyExists,
+			expectCreateCalls: 1,
+		},
+		{
+			Name:              "Retried request with another content type",
+			RetryBytes:        sizeBytes,
+			RetryContentType:  "block",
+			expectCode:        codes.AlreadyExists,
+			expectCreateCalls: 1,
+		},
+		{
+			Name:             "Retried request with a content source",
+			RetryBytes:       sizeBytes,
+			RetryContentType: "filesystem",
+			RetrySource: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Volume{
+					Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: "remote/pvc-source"},
+				},
+			},
+			expectCode:        codes.AlreadyExists,
+			expectCreateCalls: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			volumes := map[string]*api.DevLXDStorageVolume{}
+			createCalls := 0
+			fakeClient := newFakePoolDevLXDServer("ceph", true)
+			fakeClient.getVolFunc = func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+				vol, ok := volumes[name]
+				if !ok {
+					return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage volume not found")
+				}
+
+				return vol, "", nil
+			}
+
+			fakeClient.createVolFunc = func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+				createCalls++
+				volumes[volume.Name] = &api.DevLXDStorageVolume{Name: volume.Name, ContentType: volume.ContentType, Config: volume.Config}
+
+				// The request is cancelled while LXD is still creating the volume.
+				return &fakeCancelledDevLXDOperation{cancel: cancel}, nil
+			}
+
+			d := &Driver{
+				devLXD:                   fakeClient,
+				createVolumeCancelPolicy: CreateVolumeCancelPolicyKeep,
+			}
+
+			controller := NewControllerServer(d)
+
+			newRequest := func(sizeBytes int64, capability *csi.VolumeCapability, source *csi.VolumeContentSource) *csi.CreateVolumeRequest {
+				return &csi.CreateVolumeRequest{
+					Name:                "pvc-e9d4c1a0-6b0b-4f4e-9a55-3a1f0b2d8c11",
+					CapacityRange:       &csi.CapacityRange{RequiredBytes: sizeBytes},
+					VolumeCapabilities:  []*csi.VolumeCapability{capability},
+					VolumeContentSource: source,
+					Parameters: map[string]string{
+						ParameterStoragePool: "remote",
+					},
+				}
+			}
+
+			mountCapability := &csi.VolumeCapability{
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			}
+
+			_, err := controller.CreateVolume(ctx, newRequest(sizeBytes, mountCapability, nil))
+			require.Equal(t, codes.Canceled, status.Code(err))
+
+			retryCapability := mountCapability
+			if test.RetryContentType == "block" {
+				retryCapability = &csi.VolumeCapability{
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+					AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+				}
+			}
+
+			resp, err := controller.CreateVolume(context.Background(), newRequest(test.RetryBytes, retryCapability, test.RetrySource))
+			require.Equal(t, test.expectCreateCalls, createCalls)
+			if test.expectCode != codes.OK {
+				require.Equal(t, test.expectCode, status.Code(err))
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, "remote/pvc-e9d4c1a06b0b4f4e9a553a1f0b2d8c11", resp.Volume.VolumeId)
+			require.Equal(t, int64(sizeBytes), resp.Volume.CapacityBytes)
+		})
+	}
+}
+
+func TestCreateVolumeFilesystemOnlyParameters(t *testing.T) {
+	mountCapability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{},
+		},
+	}
+
+	blockCapability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
+	}
+
+	tests := []struct {
+		Name         string
+		Capability   *csi.VolumeCapability
+		Parameters   map[string]string
+		expectConfig map[string]string
+		expectCode   codes.Code
+	}{
+		{
+			Name:       "Filesystem volume with block options",
+			Capability: mountCapability,
+			Parameters: map[string]string{
+				ParameterBlockFilesystem:   "xfs",
+				ParameterBlockMountOptions: "noatime",
+			},
+			expectConfig: map[string]string{
+				"size":                     "1073741824",
+				ParameterBlockFilesystem:   "xfs",
+				ParameterBlockMountOptions: "noatime",
+			},
+		},
+		{
+			Name:       "Block volume with block mount options",
+			Capability: blockCapability,
+			Parameters: map[string]string{
+				ParameterBlockMountOptions: "noatime",
+			},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name:       "Block volume with block filesystem",
+			Capability: blockCapability,
+			Parameters: map[string]string{
+				ParameterBlockFilesystem: "ext4",
+			},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name:       "Filesystem volume with ownership",
+			Capability: mountCapability,
+			Parameters: map[string]string{
+				ParameterUID: "1000",
+				ParameterGID: "1000",
+			},
+			expectConfig: map[string]string{
+				"size": "1073741824",
+			},
+		},
+		{
+			Name:       "Filesystem volume with negative UID",
+			Capability: mountCapability,
+			Parameters: map[string]string{
+				ParameterUID: "-1",
+			},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name:       "Filesystem volume with subpath",
+			Capability: mountCapability,
+			Parameters: map[string]string{
+				ParameterSubPath: "data/app",
+			},
+			expectConfig: map[string]string{
+				"size": "1073741824",
+			},
+		},
+		{
+			Name:       "Filesystem volume with subpath escaping the volume root",
+			Capability: mountCapability,
+			Parameters: map[string]string{
+				ParameterSubPath: "data/../../app",
+			},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name:       "Block volume with subpath",
+			Capability: blockCapability,
+			Parameters: map[string]string{
+				ParameterSubPath: "data",
+			},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name:       "Block volume with ownership",
+			Capability: blockCapability,
+			Parameters: map[string]string{
+				ParameterGID: "1000",
+			},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name:       "Filesystem volume with mkfs options",
+			Capability: mountCapability,
+			Parameters: map[string]string{
+				ParameterMkfsOptions: "-m 0",
+			},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name:       "Block volume with device limits",
+			Capability: blockCapability,
+			Parameters: map[string]string{
+				ParameterLimitsRead:  "10MB",
+				ParameterLimitsWrite: "100iops",
+				ParameterIOBus:       "virtio-blk",
+				ParameterIOCache:     "writeback",
+			},
+			expectConfig: map[string]string{
+				"size": "1073741824",
+			},
+		},
+		{
+			Name:       "Block volume with invalid device cache mode",
+			Capability: blockCapability,
+			Parameters: map[string]string{
+				ParameterIOCache: "writethrough",
+			},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name:       "Volume with invalid device limit",
+			Capability: mountCapability,
+			Parameters: map[string]string{
+				ParameterLimitsRead: "fast",
+			},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name:       "Volume with conflicting device limits",
+			Capability: mountCapability,
+			Parameters: map[string]string{
+				ParameterLimitsMax:   "10MB",
+				ParameterLimitsWrite: "5MB",
+			},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name:       "Filesystem volume with device bus",
+			Capability: mountCapability,
+			Parameters: map[string]string{
+				ParameterIOBus: "nvme",
+			},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name:       "Filesystem volume with device cache mode",
+			Capability: mountCapability,
+			Parameters: map[string]string{
+				ParameterIOCache: "none",
+			},
+			expectCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var createdConfig map[string]string
+			fakeClient := newFakePoolDevLXDServer("ceph", true)
+			fakeClient.createVolFunc = func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+				createdConfig = volume.Config
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			parameters := map[string]string{
+				ParameterStoragePool: "remote",
+			}
+
+			maps.Copy(parameters, test.Parameters)
+
+			req := &csi.CreateVolumeRequest{
+				Name: "pvc-e9d4c1a0-6b0b-4f4e-9a55-3a1f0b2d8c11",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{test.Capability},
+				Parameters:         parameters,
+			}
+
+			resp, err := controller.CreateVolume(context.Background(), req)
+			if test.expectCode != codes.OK {
+				require.Error(t, err)
+				require.Equal(t, test.expectCode, status.Code(err))
+				require.Nil(t, createdConfig)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.expectConfig, createdConfig)
+
+			// Parameters are carried in the volume context for the node server.
+			for k, v := range test.Parameters {
+				require.Equal(t, v, resp.Volume.VolumeContext[k])
+			}
+		})
+	}
+}
+
+func TestControllerPublishVolumeDeviceName(t *testing.T) {
+	devName := getDeviceName("remote", "pvc-vol")
+	volDevice := map[string]string{"type": "disk", "source": "pvc-vol", "pool": "remote"}
+	userDevice := map[string]string{"type": "disk", "source": "/data", "path": "/data"}
+
+	tests := []struct {
+		Name           string
+		Devices        map[string]map[string]string
+		expectDevName  string
+		expectAttached bool
+		expectCode     codes.Code
+	}{
+		{
+			Name:           "Attach volume using hashed device name",
+			expectDevName:  devName,
+			expectAttached: true,
+		},
+		{
+			Name:           "Attach volume when user device uses volume name",
+			Devices:        map[string]map[string]string{"pvc-vol": userDevice},
+			expectDevName:  devName,
+			expectAttached: true,
+		},
+		{
+			Name:          "Volume already attached using hashed device name",
+			Devices:       map[string]map[string]string{devName: volDevice},
+			expectDevName: devName,
+		},
+		{
+			Name:          "Volume already attached using legacy device name",
+			Devices:       map[string]map[string]string{"pvc-vol": volDevice},
+			expectDevName: "pvc-vol",
+		},
+		{
+			Name:       "Conflicting device with hashed device name",
+			Devices:    map[string]map[string]string{devName: userDevice},
+			expectCode: codes.AlreadyExists,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var attached map[string]map[string]string
+			fakeClient := &fakeDevLXDServer{
+				getVolFunc: func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+					return &api.DevLXDStorageVolume{Name: name}, "", nil
+				},
+				getInstFunc: func(name string) (*api.DevLXDInstance, string, error) {
+					return &api.DevLXDInstance{Name: name, Devices: test.Devices}, "", nil
+				},
+				updateInstFunc: func(name string, inst api.DevLXDInstancePut, ETag string) error {
+					attached = inst.Devices
+					return nil
+				},
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient, fileSystemMountPath: "/var/lib/lxd-csi"})
+
+			req := &csi.ControllerPublishVolumeRequest{
+				VolumeId: "remote/pvc-vol",
+				NodeId:   "node",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+				},
+			}
+
+			resp, err := controller.ControllerPublishVolume(context.Background(), req)
+			if test.expectCode != codes.OK {
+				require.Error(t, err)
+				require.Equal(t, test.expectCode, status.Code(err))
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.expectDevName, resp.PublishContext[PublishContextDeviceName])
+			require.Equal(t, "filesystem", resp.PublishContext[PublishContextContentType])
+
+			if test.expectAttached {
+				require.Equal(t, map[string]map[string]string{
+					devName: {
+						"type":   "disk",
+						"source": "pvc-vol",
+						"pool":   "remote",
+						"path":   "/var/lib/lxd-csi/pvc-vol",
+					},
+				}, attached)
+			} else {
+				require.Nil(t, attached)
+			}
+		})
+	}
+}
+
+func TestControllerPublishVolumeReadOnly(t *testing.T) {
+	devName := getDeviceName("remote", "pvc-vol")
+	volDevice := map[string]string{"type": "disk", "source": "pvc-vol", "pool": "remote"}
+	roVolDevice := map[string]string{"type": "disk", "source": "pvc-vol", "pool": "remote", "readonly": "true"}
+
+	tests := []struct {
+		Name           string
+		Readonly       bool
+		AccessMode     csi.VolumeCapability_AccessMode_Mode
+		Devices        map[string]map[string]string
+		expectReadonly bool
+		expectAttached bool
+		expectCode     codes.Code
+	}{
+		{
+			Name:           "Attach writable volume",
+			AccessMode:     csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			expectAttached: true,
+		},
+		{
+			Name:           "Attach volume with read-only flag",
+			Readonly:       true,
+			AccessMode:     csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			expectReadonly: true,
+			expectAttached: true,
+		},
+		{
+			Name:           "Attach volume with read-only access mode",
+			AccessMode:     csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+			expectReadonly: true,
+			expectAttached: true,
+		},
+		{
+			Name:       "Volume already attached read-only",
+			Readonly:   true,
+			AccessMode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			Devices:    map[string]map[string]string{devName: roVolDevice},
+		},
+		{
+			Name:       "Volume already attached writable",
+			Readonly:   true,
+			AccessMode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			Devices:    map[string]map[string]string{devName: volDevice},
+			expectCode: codes.AlreadyExists,
+		},
+		{
+			Name:       "Volume already attached read-only using legacy device name",
+			AccessMode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			Devices:    map[string]map[string]string{"pvc-vol": roVolDevice},
+			expectCode: codes.AlreadyExists,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var attached map[string]map[string]string
+			fakeClient := &fakeDevLXDServer{
+				getVolFunc: func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+					return &api.DevLXDStorageVolume{Name: name}, "", nil
+				},
+				getInstFunc: func(name string) (*api.DevLXDInstance, string, error) {
+					return &api.DevLXDInstance{Name: name, Devices: test.Devices}, "", nil
+				},
+				updateInstFunc: func(name string, inst api.DevLXDInstancePut, ETag string) error {
+					attached = inst.Devices
+					return nil
+				},
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			req := &csi.ControllerPublishVolumeRequest{
+				VolumeId: "remote/pvc-vol",
+				NodeId:   "node",
+				Readonly: test.Readonly,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: test.AccessMode,
+					},
+					AccessType: &csi.VolumeCapability_Block{
+						Block: &csi.VolumeCapability_BlockVolume{},
+					},
+				},
+			}
+
+			_, err := controller.ControllerPublishVolume(context.Background(), req)
+			if test.expectCode != codes.OK {
+				require.Error(t, err)
+				require.Equal(t, test.expectCode, status.Code(err))
+				return
+			}
+
+			require.NoError(t, err)
+
+			if !test.expectAttached {
+				require.Nil(t, attached)
+				return
+			}
+
+			expectDevice := maps.Clone(volDevice)
+			if test.expectReadonly {
+				expectDevice["readonly"] = "true"
+			}
+
+			require.Equal(t, map[string]map[string]string{devName: expectDevice}, attached)
+		})
+	}
+}
+
+func TestControllerPublishVolumeDeviceConfig(t *testing.T) {
+	devName := getDeviceName("remote", "pvc-vol")
+
+	tests := []struct {
+		Name          string
+		VolumeContext map[string]string
+		Container     bool
+		expectDevice  map[string]string
+		expectCode    codes.Code
+	}{
+		{
+			Name: "Attach volume without device limits",
+			VolumeContext: map[string]string{
+				ParameterStoragePool: "remote",
+			},
+			expectDevice: map[string]string{
+				"type":   "disk",
+				"source": "pvc-vol",
+				"pool":   "remote",
+			},
+		},
+		{
+			Name: "Attach volume with device limits",
+			VolumeContext: map[string]string{
+				ParameterStoragePool: "remote",
+				ParameterLimitsRead:  "10MB",
+				ParameterLimitsWrite: "100iops",
+				ParameterIOBus:       "virtio-scsi",
+			},
+			expectDevice: map[string]string{
+				"type":               "disk",
+				"source":             "pvc-vol",
+				"pool":               "remote",
+				ParameterLimitsRead:  "10MB",
+				ParameterLimitsWrite: "100iops",
+				ParameterIOBus:       "virtio-scsi",
+			},
+		},
+		{
+			Name: "Attach volume with maximum device limit",
+			VolumeContext: map[string]string{
+				ParameterLimitsMax: "20MB",
+			},
+			expectDevice: map[string]string{
+				"type":             "disk",
+				"source":           "pvc-vol",
+				"pool":             "remote",
+				ParameterLimitsMax: "20MB",
+			},
+		},
+		{
+			Name: "Attach volume with device cache mode to virtual machine",
+			VolumeContext: map[string]string{
+				ParameterIOBus:   "virtio-blk",
+				ParameterIOCache: "unsafe",
+			},
+			expectDevice: map[string]string{
+				"type":           "disk",
+				"source":         "pvc-vol",
+				"pool":           "remote",
+				ParameterIOBus:   "virtio-blk",
+				ParameterIOCache: "unsafe",
+			},
+		},
+		{
+			Name: "Attach volume with virtual machine options to container",
+			VolumeContext: map[string]string{
+				ParameterLimitsRead: "10MB",
+				ParameterIOBus:      "virtio-blk",
+				ParameterIOCache:    "unsafe",
+			},
+			Container: true,
+			expectDevice: map[string]string{
+				"type":              "disk",
+				"source":            "pvc-vol",
+				"pool":              "remote",
+				ParameterLimitsRead: "10MB",
+			},
+		},
+		{
+			Name: "Invalid device cache mode",
+			VolumeContext: map[string]string{
+				ParameterIOCache: "writethrough",
+			},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name: "Invalid device limit",
+			VolumeContext: map[string]string{
+				ParameterLimitsWrite: "10xiops",
+			},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name: "Conflicting device limits",
+			VolumeContext: map[string]string{
+				ParameterLimitsMax:  "20MB",
+				ParameterLimitsRead: "10MB",
+			},
+			expectCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var attached map[string]map[string]string
+			fakeClient := &fakeDevLXDServer{
+				getVolFunc: func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+					return &api.DevLXDStorageVolume{Name: name}, "", nil
+				},
+				getInstFunc: func(name string) (*api.DevLXDInstance, string, error) {
+					return &api.DevLXDInstance{Name: name}, "", nil
+				},
+				updateInstFunc: func(name string, inst api.DevLXDInstancePut, ETag string) error {
+					// Mimic LXD rejecting virtual machine options for containers.
+					if test.Container && inst.Devices[devName][ParameterIOBus] != "" {
+						return api.StatusErrorf(http.StatusBadRequest, "Invalid devices: Device validation failed for %q: IO bus configuration cannot be applied to containers", devName)
+					}
+
+					attached = inst.Devices
+					return nil
+				},
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			req := &csi.ControllerPublishVolumeRequest{
+				VolumeId:      "remote/pvc-vol",
+				NodeId:        "node",
+				VolumeContext: test.VolumeContext,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+					AccessType: &csi.VolumeCapability_Block{
+						Block: &csi.VolumeCapability_BlockVolume{},
+					},
+				},
+			}
+
+			_, err := controller.ControllerPublishVolume(context.Background(), req)
+			if test.expectCode != codes.OK {
+				require.Error(t, err)
+				require.Equal(t, test.expectCode, status.Code(err))
+				require.Nil(t, attached)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, map[string]map[string]string{devName: test.expectDevice}, attached)
+		})
+	}
+}
+
+func TestControllerUnpublishVolumeDeviceName(t *testing.T) {
+	devName := getDeviceName("remote", "pvc-vol")
+	volDevice := map[string]string{"type": "disk", "source": "pvc-vol", "pool": "remote"}
+	userDevice := map[string]string{"type": "disk", "source": "/data", "path": "/data"}
+
+	tests := []struct {
+		Name           string
+		Devices        map[string]map[string]string
+		expectDetached map[string]map[string]string
+	}{
+		{
+			Name:           "Detach volume using hashed device name",
+			Devices:        map[string]map[string]string{devName: volDevice},
+			expectDetached: map[string]map[string]string{devName: nil},
+		},
+		{
+			Name:           "Detach volume using legacy device name",
+			Devices:        map[string]map[string]string{"pvc-vol": volDevice},
+			expectDetached: map[string]map[string]string{"pvc-vol": nil},
+		},
+		{
+			Name:    "Keep user device using volume name",
+			Devices: map[string]map[string]string{"pvc-vol": userDevice},
+		},
+		{
+			Name: "Volume not attached",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var detached map[string]map[string]string
+			fakeClient := &fakeDevLXDServer{
+				getVolFunc: func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+					return &api.DevLXDStorageVolume{Name: name}, "", nil
+				},
+				getInstFunc: func(name string) (*api.DevLXDInstance, string, error) {
+					return &api.DevLXDInstance{Name: name, Devices: test.Devices}, "", nil
+				},
+				updateInstFunc: func(name string, inst api.DevLXDInstancePut, ETag string) error {
+					detached = inst.Devices
+					return nil
+				},
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			req := &csi.ControllerUnpublishVolumeRequest{
+				VolumeId: "remote/pvc-vol",
+				NodeId:   "node",
+			}
+
+			_, err := controller.ControllerUnpublishVolume(context.Background(), req)
+			require.NoError(t, err)
+			require.Equal(t, test.expectDetached, detached)
+		})
+	}
+}
+
+func TestControllerNilCapacityRange(t *testing.T) {
+	mountCapability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{},
+		},
+	}
+
+	controller := NewControllerServer(&Driver{devLXD: &fakeDevLXDServer{}})
+
+	_, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "pvc-e9d4c1a0-6b0b-4f4e-9a55-3a1f0b2d8c11",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability},
+		Parameters: map[string]string{
+			ParameterStoragePool: "remote",
+		},
+	})
+	require.Error(t, err)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.ErrorContains(t, err, "Capacity range is required")
+
+	_, err = controller.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+		VolumeId:         "remote/pvc-vol",
+		VolumeCapability: mountCapability,
+	})
+	require.Error(t, err)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.ErrorContains(t, err, "Capacity range is required")
+}
+
+func TestControllerNilVolumeCapability(t *testing.T) {
+	controller := NewControllerServer(&Driver{devLXD: &fakeDevLXDServer{}})
+
+	_, err := controller.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId: "remote/pvc-vol",
+		NodeId:   "node",
+	})
+	require.Error(t, err)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.ErrorContains(t, err, "Volume capability is missing")
+
+	_, err = controller.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+		VolumeId: "remote/pvc-vol",
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: 1024 * 1024 * 1024,
+		},
+	})
+	require.Error(t, err)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.ErrorContains(t, err, "Volume capability is missing")
+}
+
+func TestCreateVolumeMixedAccessTypes(t *testing.T) {
+	controller := NewControllerServer(&Driver{devLXD: &fakeDevLXDServer{}})
+
+	_, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name: "pvc-vol",
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: 1024 * 1024 * 1024,
+		},
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}},
+			{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}},
+		},
+		Parameters: map[string]string{
+			ParameterStoragePool: "remote",
+		},
+	})
+	require.Error(t, err)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.ErrorContains(t, err, "access types defined")
+}
+
+func TestCreateVolumeDefaultVolumeMode(t *testing.T) {
+	accessMode := &csi.VolumeCapability_AccessMode{
+		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+	}
+
+	silentCapability := &csi.VolumeCapability{AccessMode: accessMode}
+
+	mountCapability := &csi.VolumeCapability{
+		AccessMode: accessMode,
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+	}
+
+	blockCapability := &csi.VolumeCapability{
+		AccessMode: accessMode,
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+	}
+
+	tests := []struct {
+		Name              string
+		Capabilities      []*csi.VolumeCapability
+		DefaultVolumeMode string
+		expectContentType string
+		expectCode        codes.Code
+	}{
+		{
+			Name:         "Silent capability without default",
+			Capabilities: []*csi.VolumeCapability{silentCapability},
+			expectCode:   codes.InvalidArgument,
+		},
+		{
+			Name:              "Silent capability with block default",
+			Capabilities:      []*csi.VolumeCapability{silentCapability},
+			DefaultVolumeMode: "block",
+			expectContentType: "block",
+		},
+		{
+			Name:              "Silent capability with filesystem default",
+			Capabilities:      []*csi.VolumeCapability{silentCapability},
+			DefaultVolumeMode: "filesystem",
+			expectContentType: "filesystem",
+		},
+		{
+			Name:              "Mount capability is not overridden",
+			Capabilities:      []*csi.VolumeCapability{mountCapability},
+			DefaultVolumeMode: "block",
+			expectContentType: "filesystem",
+		},
+		{
+			Name:              "Block capability is not overridden",
+			Capabilities:      []*csi.VolumeCapability{silentCapability, blockCapability},
+			DefaultVolumeMode: "filesystem",
+			expectContentType: "block",
+		},
+		{
+			Name:              "Mixed capabilities are not resolved by the default",
+			Capabilities:      []*csi.VolumeCapability{mountCapability, blockCapability},
+			DefaultVolumeMode: "block",
+			expectCode:        codes.InvalidArgument,
+		},
+		{
+			Name:              "Invalid default",
+			Capabilities:      []*csi.VolumeCapability{silentCapability},
+			DefaultVolumeMode: "raw",
+			expectCode:        codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var createdContentType string
+			fakeClient := newFakePoolDevLXDServer("ceph", true)
+			fakeClient.createVolFunc = func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+				createdContentType = volume.ContentType
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			parameters := map[string]string{
+				ParameterStoragePool: "remote",
+			}
+
+			if test.DefaultVolumeMode != "" {
+				parameters[ParameterDefaultVolumeMode] = test.DefaultVolumeMode
+			}
+
+			_, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "pvc-e9d4c1a0-6b0b-4f4e-9a55-3a1f0b2d8c11",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: test.Capabilities,
+				Parameters:         parameters,
+			})
+			if test.expectCode != codes.OK {
+				require.Equal(t, test.expectCode, status.Code(err))
+				require.Empty(t, createdContentType)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.expectContentType, createdContentType)
+
+			// The capabilities of the request are not modified.
+			require.Nil(t, silentCapability.AccessType)
+		})
+	}
+}
+
+func TestCreateVolumeContentType(t *testing.T) {
+	accessMode := &csi.VolumeCapability_AccessMode{
+		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+	}
+
+	silentCapability := &csi.VolumeCapability{AccessMode: accessMode}
+
+	mountCapability := &csi.VolumeCapability{
+		AccessMode: accessMode,
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+	}
+
+	blockCapability := &csi.VolumeCapability{
+		AccessMode: accessMode,
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+	}
+
+	tests := []struct {
+		Name              string
+		Capabilities      []*csi.VolumeCapability
+		Parameters        map[string]string
+		expectContentType string
+		expectCode        codes.Code
+	}{
+		{
+			Name:              "No content type uses mount capability",
+			Capabilities:      []*csi.VolumeCapability{mountCapability},
+			expectContentType: "filesystem",
+		},
+		{
+			Name:              "No content type uses block capability",
+			Capabilities:      []*csi.VolumeCapability{blockCapability},
+			expectContentType: "block",
+		},
+		{
+			Name:              "Filesystem content type with mount capability",
+			Capabilities:      []*csi.VolumeCapability{mountCapability},
+			Parameters:        map[string]string{ParameterContentType: "filesystem"},
+			expectContentType: "filesystem",
+		},
+		{
+			Name:              "Block content type with block capability",
+			Capabilities:      []*csi.VolumeCapability{blockCapability},
+			Parameters:        map[string]string{ParameterContentType: "block"},
+			expectContentType: "block",
+		},
+		{
+			Name:         "Block content type with mount capability",
+			Capabilities: []*csi.VolumeCapability{mountCapability},
+			Parameters:   map[string]string{ParameterContentType: "block"},
+			expectCode:   codes.InvalidArgument,
+		},
+		{
+			Name:         "Filesystem content type with block capability",
+			Capabilities: []*csi.VolumeCapability{blockCapability},
+			Parameters:   map[string]string{ParameterContentType: "filesystem"},
+			expectCode:   codes.InvalidArgument,
+		},
+		{
+			Name:         "Content type with silent capability",
+			Capabilities: []*csi.VolumeCapability{silentCapability},
+			Parameters:   map[string]string{ParameterContentType: "block"},
+			expectCode:   codes.InvalidArgument,
+		},
+		{
+			Name:         "Content type with silent capability and default volume mode",
+			Capabilities: []*csi.VolumeCapability{silentCapability},
+			Parameters: map[string]string{
+				ParameterContentType:       "block",
+				ParameterDefaultVolumeMode: "block",
+			},
+			expectContentType: "block",
+		},
+		{
+			Name:         "Content type conflicting with default volume mode",
+			Capabilities: []*csi.VolumeCapability{silentCapability},
+			Parameters: map[string]string{
+				ParameterContentType:       "block",
+				ParameterDefaultVolumeMode: "filesystem",
+			},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name:         "Invalid content type",
+			Capabilities: []*csi.VolumeCapability{mountCapability},
+			Parameters:   map[string]string{ParameterContentType: "raw"},
+			expectCode:   codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var createdContentType string
+			fakeClient := newFakePoolDevLXDServer("ceph", true)
+			fakeClient.createVolFunc = func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+				createdContentType = volume.ContentType
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			parameters := map[string]string{
+				ParameterStoragePool: "remote",
+			}
+
+			maps.Copy(parameters, test.Parameters)
+
+			_, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "pvc-3c1f6e2a-8d4b-4a7e-b0c9-5e2d7f1a9b64",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: test.Capabilities,
+				Parameters:         parameters,
+			})
+			if test.expectCode != codes.OK {
+				require.Equal(t, test.expectCode, status.Code(err))
+				require.Empty(t, createdContentType)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.expectContentType, createdContentType)
+		})
+	}
+}
+
+func TestCreateVolumeConflictingCapabilities(t *testing.T) {
+	mountCapability := func(fsType string) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{FsType: fsType},
+			},
+		}
+	}
+
+	blockCapability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
+	}
+
+	tests := []struct {
+		Name         string
+		Capabilities []*csi.VolumeCapability
+		Parameters   map[string]string
+		expectError  string
+	}{
+		{
+			Name:         "Matching filesystem types",
+			Capabilities: []*csi.VolumeCapability{mountCapability("ext4"), mountCapability("")},
+			Parameters:   map[string]string{ParameterBlockFilesystem: "ext4"},
+		},
+		{
+			Name:         "Filesystem type without block filesystem",
+			Capabilities: []*csi.VolumeCapability{mountCapability("xfs")},
+		},
+		{
+			Name:         "Block and mount capabilities",
+			Capabilities: []*csi.VolumeCapability{mountCapability(""), blockCapability},
+			expectError:  "cannot have both the mount and the block access types defined",
+		},
+		{
+			Name:         "Different filesystem types",
+			Capabilities: []*csi.VolumeCapability{mountCapability("ext4"), mountCapability("xfs")},
+			expectError:  `cannot request different filesystem types "ext4" and "xfs"`,
+		},
+		{
+			Name:         "Filesystem type conflicting with block filesystem",
+			Capabilities: []*csi.VolumeCapability{mountCapability("xfs")},
+			Parameters:   map[string]string{ParameterBlockFilesystem: "btrfs"},
+			expectError:  `Volume capability requests filesystem type "xfs", but parameter "block.filesystem" in storage class is set to "btrfs"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var created bool
+			fakeClient := newFakePoolDevLXDServer("lvm", false)
+			fakeClient.createVolFunc = func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+				created = true
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			parameters := map[string]string{ParameterStoragePool: "default"}
+			maps.Copy(parameters, test.Parameters)
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			_, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:               "pvc-vol",
+				CapacityRange:      &csi.CapacityRange{RequiredBytes: 1024 * 1024 * 1024},
+				VolumeCapabilities: test.Capabilities,
+				Parameters:         parameters,
+			})
+			if test.expectError != "" {
+				require.Equal(t, codes.InvalidArgument, status.Code(err))
+				require.ErrorContains(t, err, test.expectError)
+				require.False(t, created)
+				return
+			}
+
+			require.NoError(t, err)
+			require.True(t, created)
+		})
+	}
+}
+
+func TestParseAccessibleMembers(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Value         string
+		expectMembers []string
+		expectError   bool
+	}{
+		{Name: "Not set", Value: ""},
+		{Name: "Single member", Value: "node1", expectMembers: []string{"node1"}},
+		{Name: "Multiple members", Value: "node1, node2,node3", expectMembers: []string{"node1", "node2", "node3"}},
+		{Name: "Duplicate members", Value: "node1,node1", expectMembers: []string{"node1"}},
+		{Name: "No members", Value: " , ", expectError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			members, err := parseAccessibleMembers(test.Value)
+			if test.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, test.expectMembers, members)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeAccessibleMembers(t *testing.T) {
+	tests := []struct {
+		Name           string
+		Driver         string
+		Remote         bool
+		Members        string
+		Requisite      []string
+		expectTopology []string
+		expectCode     codes.Code
+	}{
+		{
+			Name:   "Remote volume is accessible from all members",
+			Driver: "ceph",
+			Remote: true,
+		},
+		{
+			Name:           "Remote volume is accessible from listed members",
+			Driver:         "ceph",
+			Remote:         true,
+			Members:        "node1,node2",
+			Requisite:      []string{"node1", "node2", "node3"},
+			expectTopology: []string{"node1", "node2"},
+		},
+		{
+			Name:           "Members are not validated without accessibility requirements",
+			Driver:         "ceph",
+			Remote:         true,
+			Members:        "node1",
+			expectTopology: []string{"node1"},
+		},
+		{
+			Name:       "Unknown cluster member",
+			Driver:     "ceph",
+			Remote:     true,
+			Members:    "node1,node4",
+			Requisite:  []string{"node1", "node2", "node3"},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name:       "Empty members list",
+			Driver:     "ceph",
+			Remote:     true,
+			Members:    ",",
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name:       "Local storage driver",
+			Driver:     "zfs",
+			Remote:     false,
+			Members:    "node1",
+			expectCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			fakeClient := newFakePoolDevLXDServer(test.Driver, test.Remote)
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			req := &csi.CreateVolumeRequest{
+				Name: "pvc-e9d4c1a0-6b0b-4f4e-9a55-3a1f0b2d8c11",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+					},
+				},
+				Parameters: map[string]string{
+					ParameterStoragePool: "pool",
+				},
+			}
+
+			if test.Members != "" {
+				req.Parameters[ParameterAccessibleMembers] = test.Members
+			}
+
+			if len(test.Requisite) > 0 {
+				req.AccessibilityRequirements = &csi.TopologyRequirement{}
+				for _, member := range test.Requisite {
+					req.AccessibilityRequirements.Requisite = append(req.AccessibilityRequirements.Requisite, &csi.Topology{
+						Segments: map[string]string{AnnotationLXDClusterMember: member},
+					})
+				}
+			}
+
+			resp, err := controller.CreateVolume(context.Background(), req)
+			if test.expectCode != codes.OK {
+				require.Error(t, err)
+				require.Equal(t, test.expectCode, status.Code(err))
+				return
+			}
+
+			require.NoError(t, err)
+
+			var members []string
+			for _, topology := range resp.Volume.AccessibleTopology {
+				members = append(members, topology.Segments[AnnotationLXDClusterMember])
+			}
+
+			require.Equal(t, test.expectTopology, members)
+		})
+	}
+}
+
+func TestControllerUnpublishVolumeTarget(t *testing.T) {
+	tests := []struct {
+		Name          string
+		VolumeID      string
+		IsClustered   bool
+		expectTargets []string
+	}{
+		{
+			Name:          "Clustered LXD with target in volume ID",
+			VolumeID:      "member2:local/pvc-vol",
+			IsClustered:   true,
+			expectTargets: []string{"member2"},
+		},
+		{
+			Name:        "Clustered LXD without target in volume ID",
+			VolumeID:    "local/pvc-vol",
+			IsClustered: true,
+		},
+		{
+			Name:        "Standalone LXD with target in volume ID",
+			VolumeID:    "member2:local/pvc-vol",
+			IsClustered: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var targetsOnDetach []string
+			fakeClient := &fakeDevLXDServer{}
+			fakeClient.getVolFunc = func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+				return &api.DevLXDStorageVolume{Name: name}, "", nil
+			}
+
+			fakeClient.getInstFunc = func(name string) (*api.DevLXDInstance, string, error) {
+				return &api.DevLXDInstance{
+					Name: name,
+					Devices: map[string]map[string]string{
+						"pvc-vol": {"type": "disk", "source": "pvc-vol", "pool": "local"},
+					},
+				}, "", nil
+			}
+
+			fakeClient.updateInstFunc = func(name string, inst api.DevLXDInstancePut, ETag string) error {
+				targetsOnDetach = slices.Clone(fakeClient.targets)
+				return nil
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient, isClustered: test.IsClustered})
+
+			req := &csi.ControllerUnpublishVolumeRequest{
+				VolumeId: test.VolumeID,
+				NodeId:   "node",
+			}
+
+			_, err := controller.ControllerUnpublishVolume(context.Background(), req)
+			require.NoError(t, err)
+			require.Equal(t, test.expectTargets, targetsOnDetach)
+		})
+	}
+}
+
+func TestControllerDevLXDErrors(t *testing.T) {
+	mountCapability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{},
+		},
+	}
+
+	tests := []struct {
+		Name       string
+		Client     *fakeDevLXDServer
+		Call       func(c csi.ControllerServer) error
+		expectCode codes.Code
+	}{
+		{
+			Name: "Create volume in missing storage pool",
+			Client: &fakeDevLXDServer{
+				getPoolFunc: func(pool string) (*api.DevLXDStoragePool, string, error) {
+					return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage pool not found")
+				},
+			},
+			Call: func(c csi.ControllerServer) error {
+				_, err := c.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+					Name:               "pvc-vol",
+					CapacityRange:      &csi.CapacityRange{RequiredBytes: 1024 * 1024 * 1024},
+					VolumeCapabilities: []*csi.VolumeCapability{mountCapability},
+					Parameters:         map[string]string{ParameterStoragePool: "remote"},
+				})
+				return err
+			},
+			expectCode: codes.NotFound,
+		},
+		{
+			Name: "Create volume in storage pool with unsupported driver",
+			Client: &fakeDevLXDServer{
+				getPoolFunc: func(pool string) (*api.DevLXDStoragePool, string, error) {
+					return &api.DevLXDStoragePool{Name: pool, Driver: "cephfs"}, "", nil
+				},
+			},
+			Call: func(c csi.ControllerServer) error {
+				_, err := c.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+					Name:               "pvc-vol",
+					CapacityRange:      &csi.CapacityRange{RequiredBytes: 1024 * 1024 * 1024},
+					VolumeCapabilities: []*csi.VolumeCapability{mountCapability},
+					Parameters:         map[string]string{ParameterStoragePool: "remote"},
+				})
+				return err
+			},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name: "Delete missing volume",
+			Client: &fakeDevLXDServer{
+				deleteVolFunc: func(pool string, volType string, name string) (lxdClient.DevLXDOperation, error) {
+					return nil, api.StatusErrorf(http.StatusNotFound, "Storage volume not found")
+				},
+			},
+			Call: func(c csi.ControllerServer) error {
+				_, err := c.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "remote/pvc-vol"})
+				return err
+			},
+			expectCode: codes.OK,
+		},
+		{
+			Name: "Delete volume without permission",
+			Client: &fakeDevLXDServer{
+				deleteVolFunc: func(pool string, volType string, name string) (lxdClient.DevLXDOperation, error) {
+					return nil, api.StatusErrorf(http.StatusForbidden, "Forbidden")
+				},
+			},
+			Call: func(c csi.ControllerServer) error {
+				_, err := c.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "remote/pvc-vol"})
+				return err
+			},
+			expectCode: codes.PermissionDenied,
+		},
+		{
+			Name: "Publish missing volume",
+			Client: &fakeDevLXDServer{
+				getVolFunc: func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+					return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage volume not found")
+				},
+			},
+			Call: func(c csi.ControllerServer) error {
+				_, err := c.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+					VolumeId:         "remote/pvc-vol",
+					NodeId:           "node",
+					VolumeCapability: mountCapability,
+				})
+				return err
+			},
+			expectCode: codes.NotFound,
+		},
+		{
+			Name: "Publish volume on missing node",
+			Client: &fakeDevLXDServer{
+				getInstFunc: func(name string) (*api.DevLXDInstance, string, error) {
+					return nil, "", api.StatusErrorf(http.StatusNotFound, "Instance not found")
+				},
+			},
+			Call: func(c csi.ControllerServer) error {
+				_, err := c.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+					VolumeId:         "remote/pvc-vol",
+					NodeId:           "node",
+					VolumeCapability: mountCapability,
+				})
+				return err
+			},
+			expectCode: codes.NotFound,
+		},
+		{
+			Name: "Publish volume with concurrent instance update",
+			Client: &fakeDevLXDServer{
+				getVolFunc: func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+					return &api.DevLXDStorageVolume{Name: name}, "", nil
+				},
+				updateInstFunc: func(name string, inst api.DevLXDInstancePut, ETag string) error {
+					return api.StatusErrorf(http.StatusPreconditionFailed, "ETag mismatch")
+				},
+			},
+			Call: func(c csi.ControllerServer) error {
+				_, err := c.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+					VolumeId:         "remote/pvc-vol",
+					NodeId:           "node",
+					VolumeCapability: mountCapability,
+				})
+				return err
+			},
+			expectCode: codes.Unavailable,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			controller := NewControllerServer(&Driver{devLXD: test.Client})
+
+			err := test.Call(controller)
+			require.Equal(t, test.expectCode, status.Code(err))
+		})
+	}
+}
+
+func TestCreateSnapshotContentType(t *testing.T) {
+	tests := []struct {
+		Name        string
+		ContentType string
+		GetVolErr   error
+		expectCode  codes.Code
+	}{
+		{
+			Name:        "Block volume",
+			ContentType: "block",
+		},
+		{
+			Name:        "Filesystem volume",
+			ContentType: "filesystem",
+		},
+		{
+			Name:        "ISO volume",
+			ContentType: "iso",
+			expectCode:  codes.InvalidArgument,
+		},
+		{
+			Name:       "Missing volume",
+			GetVolErr:  api.StatusErrorf(http.StatusNotFound, "Storage volume not found"),
+			expectCode: codes.NotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var created bool
+			fakeClient := &fakeDevLXDServer{
+				getVolFunc: func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+					if test.GetVolErr != nil {
+						return nil, "", test.GetVolErr
+					}
+
+					return &api.DevLXDStorageVolume{Name: name, ContentType: test.ContentType}, "", nil
+				},
+				createSnapFunc: func(pool string, volType string, volName string, snapshot api.DevLXDStorageVolumeSnapshotsPost) (lxdClient.DevLXDOperation, error) {
+					created = true
+					return &fakeDevLXDOperation{}, nil
+				},
+			}
+
+			d := &Driver{devLXD: fakeClient}
+			d.SetControllerServiceCapabilities(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT)
+
+			controller := NewControllerServer(d)
+
+			_, err := controller.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+				Name:           "snapshot-3b8e1f2a-9c4d-4e6f-a0b1-c2d3e4f5a6b7",
+				SourceVolumeId: "remote/pvc-vol",
+			})
+			require.Equal(t, test.expectCode, status.Code(err))
+			require.Equal(t, test.expectCode == codes.OK, created)
+		})
+	}
+}
+
+func TestDeleteSnapshotParentVolume(t *testing.T) {
+	tests := []struct {
+		Name          string
+		GetVolErr     error
+		DeleteSnapErr error
+		expectCode    codes.Code
+		expectDelete  bool
+		expectLog     string
+	}{
+		{
+			Name:         "Volume and snapshot present",
+			expectDelete: true,
+		},
+		{
+			Name:          "Missing snapshot",
+			DeleteSnapErr: api.StatusErrorf(http.StatusNotFound, "Storage volume snapshot not found"),
+			expectDelete:  true,
+			expectLog:     `"Snapshot not found, considering it deleted"`,
+		},
+		{
+			Name:      "Missing volume",
+			GetVolErr: api.StatusErrorf(http.StatusNotFound, "Storage volume not found"),
+			expectLog: `"Parent volume of snapshot not found, considering the snapshot deleted"`,
+		},
+		{
+			Name:       "Failure to retrieve volume",
+			GetVolErr:  api.StatusErrorf(http.StatusForbidden, "Forbidden"),
+			expectCode: codes.PermissionDenied,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			klog.LogToStderr(false)
+			klog.SetOutput(&buf)
+			defer func() {
+				klog.SetOutput(os.Stderr)
+				klog.LogToStderr(true)
+			}()
+
+			var deleted bool
+			fakeClient := &fakeDevLXDServer{
+				getVolFunc: func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+					if test.GetVolErr != nil {
+						return nil, "", test.GetVolErr
+					}
+
+					return &api.DevLXDStorageVolume{Name: name}, "", nil
+				},
+				deleteSnapFunc: func(pool string, volType string, volName string, name string) (lxdClient.DevLXDOperation, error) {
+					deleted = true
+					if test.DeleteSnapErr != nil {
+						return nil, test.DeleteSnapErr
+					}
+
+					return &fakeDevLXDOperation{}, nil
+				},
+			}
+
+			d := &Driver{devLXD: fakeClient}
+			d.SetControllerServiceCapabilities(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT)
+
+			controller := NewControllerServer(d)
+
+			_, err := controller.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{
+				SnapshotId: "remote/pvc-vol/snapshot-1",
+			})
+			require.Equal(t, test.expectCode, status.Code(err))
+			require.Equal(t, test.expectDelete, deleted)
+
+			klog.Flush()
+			if test.expectLog != "" {
+				require.Contains(t, buf.String(), test.expectLog)
+				require.Contains(t, buf.String(), `snapshotID="remote/pvc-vol/snapshot-1"`)
+			} else {
+				require.NotContains(t, buf.String(), "considering")
+			}
+		})
+	}
+}
+
+func TestCreateVolumeFromSnapshotContentType(t *testing.T) {
+	blockCapability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
+	}
+
+	mountCapability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{},
+		},
+	}
+
+	tests := []struct {
+		Name                string
+		SnapshotContentType string
+		Capability          *csi.VolumeCapability
+		expectError         string
+	}{
+		{
+			Name:                "Block snapshot restored as block volume",
+			SnapshotContentType: "block",
+			Capability:          blockCapability,
+		},
+		{
+			Name:                "Filesystem snapshot restored as filesystem volume",
+			SnapshotContentType: "filesystem",
+			Capability:          mountCapability,
+		},
+		{
+			Name:                "Block snapshot restored as filesystem volume",
+			SnapshotContentType: "block",
+			Capability:          mountCapability,
+			expectError:         `Content type "block" of volume snapshot "snap" does not match the requested volume content type "filesystem"`,
+		},
+		{
+			Name:                "Filesystem snapshot restored as block volume",
+			SnapshotContentType: "filesystem",
+			Capability:          blockCapability,
+			expectError:         `Content type "filesystem" of volume snapshot "snap" does not match the requested volume content type "block"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var createReq *api.DevLXDStorageVolumesPost
+			fakeClient := newFakePoolDevLXDServer("ceph", true)
+			fakeClient.getSnapFunc = func(pool string, volType string, volName string, name string) (*api.DevLXDStorageVolumeSnapshot, string, error) {
+				return &api.DevLXDStorageVolumeSnapshot{
+					Name:        name,
+					ContentType: test.SnapshotContentType,
+					Config:      map[string]string{"size": "1073741824"},
+				}, "", nil
+			}
+
+			fakeClient.createVolFunc = func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+				createReq = &volume
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			_, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:               "pvc-restored",
+				CapacityRange:      &csi.CapacityRange{RequiredBytes: 1024 * 1024 * 1024},
+				VolumeCapabilities: []*csi.VolumeCapability{test.Capability},
+				Parameters:         map[string]string{ParameterStoragePool: "remote"},
+				VolumeContentSource: &csi.VolumeContentSource{
+					Type: &csi.VolumeContentSource_Snapshot{
+						Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "remote/pvc-vol/snap"},
+					},
+				},
+			})
+			if test.expectError != "" {
+				require.Equal(t, codes.InvalidArgument, status.Code(err))
+				require.ErrorContains(t, err, test.expectError)
+				require.Nil(t, createReq)
+				return
+			}
+
+			require.NoError(t, err)
+			require.NotNil(t, createReq)
+			require.Equal(t, test.SnapshotContentType, createReq.ContentType)
+			require.Equal(t, "pvc-vol/snap", createReq.Source.Name)
+		})
+	}
+}
+
+func TestCreateVolumeFromSnapshotParameters(t *testing.T) {
+	mountCapability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{},
+		},
+	}
+
+	snapshotSource := &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "remote/pvc-vol/snap"},
+		},
+	}
+
+	tests := []struct {
+		Name          string
+		Parameters    map[string]string
+		ContentSource *csi.VolumeContentSource
+		expectSource  *api.DevLXDStorageVolumeSource
+		expectCode    codes.Code
+		expectError   string
+	}{
+		{
+			Name: "Snapshot source from storage class parameters",
+			Parameters: map[string]string{
+				ParameterSourceSnapshotPool:   "remote",
+				ParameterSourceSnapshotVolume: "golden-image",
+				ParameterSourceSnapshotName:   "v1",
+			},
+			expectSource: &api.DevLXDStorageVolumeSource{
+				Type: api.SourceTypeCopy,
+				Pool: "remote",
+				Name: "golden-image/v1",
+			},
+		},
+		{
+			Name:          "Snapshot source from volume content source",
+			ContentSource: snapshotSource,
+			expectSource: &api.DevLXDStorageVolumeSource{
+				Type: api.SourceTypeCopy,
+				Pool: "remote",
+				Name: "pvc-vol/snap",
+			},
+		},
+		{
+			Name: "Storage class parameters combined with volume content source",
+			Parameters: map[string]string{
+				ParameterSourceSnapshotPool:   "remote",
+				ParameterSourceSnapshotVolume: "golden-image",
+				ParameterSourceSnapshotName:   "v1",
+			},
+			ContentSource: snapshotSource,
+			expectCode:    codes.InvalidArgument,
+			expectError:   "cannot be combined with a volume content source",
+		},
+		{
+			Name: "Incomplete storage class parameters",
+			Parameters: map[string]string{
+				ParameterSourceSnapshotPool:   "remote",
+				ParameterSourceSnapshotVolume: "golden-image",
+			},
+			expectCode:  codes.InvalidArgument,
+			expectError: "must be set together",
+		},
+		{
+			Name: "Invalid storage class parameter",
+			Parameters: map[string]string{
+				ParameterSourceSnapshotPool:   "remote",
+				ParameterSourceSnapshotVolume: "golden-image/v1",
+				ParameterSourceSnapshotName:   "v1",
+			},
+			expectCode:  codes.InvalidArgument,
+			expectError: `Invalid value "golden-image/v1" for parameter "sourceSnapshotVolume"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var createReq *api.DevLXDStorageVolumesPost
+			fakeClient := newFakePoolDevLXDServer("ceph", true)
+			fakeClient.getSnapFunc = func(pool string, volType string, volName string, name string) (*api.DevLXDStorageVolumeSnapshot, string, error) {
+				return &api.DevLXDStorageVolumeSnapshot{
+					Name:        name,
+					ContentType: "filesystem",
+					Config:      map[string]string{"size": "1073741824"},
+				}, "", nil
+			}
+
+			fakeClient.getVolFunc = func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+				if createReq == nil {
+					return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage volume not found")
+				}
+
+				return &api.DevLXDStorageVolume{Name: name, Config: createReq.Config}, "", nil
+			}
+
+			fakeClient.createVolFunc = func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+				createReq = &volume
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			parameters := map[string]string{ParameterStoragePool: "remote"}
+			maps.Copy(parameters, test.Parameters)
+
+			resp, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:                "pvc-restored",
+				CapacityRange:       &csi.CapacityRange{RequiredBytes: 1024 * 1024 * 1024},
+				VolumeCapabilities:  []*csi.VolumeCapability{mountCapability},
+				Parameters:          parameters,
+				VolumeContentSource: test.ContentSource,
+			})
+			if test.expectCode != codes.OK {
+				require.Equal(t, test.expectCode, status.Code(err))
+				require.ErrorContains(t, err, test.expectError)
+				require.Nil(t, createReq)
+				return
+			}
+
+			require.NoError(t, err)
+			require.NotNil(t, createReq)
+			require.Equal(t, *test.expectSource, createReq.Source)
+
+			// The content source is reported only if it was requested.
+			require.Equal(t, test.ContentSource, resp.Volume.ContentSource)
+		})
+	}
+}
+
+func TestControllerGetCapabilities(t *testing.T) {
+	d := NewDriver(DriverOptions{EnableSnapshots: true})
+	d.SetControllerServiceCapabilities(d.controllerServiceCapabilities()...)
+
+	controller := NewControllerServer(d)
+
+	resp, err := controller.ControllerGetCapabilities(context.Background(), &csi.ControllerGetCapabilitiesRequest{})
+	require.NoError(t, err)
+
+	var caps []csi.ControllerServiceCapability_RPC_Type
+	for _, capability := range resp.Capabilities {
+		caps = append(caps, capability.GetRpc().GetType())
+	}
+
+	require.ElementsMatch(t, []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+		csi.ControllerServiceCapability_RPC_MODIFY_VOLUME,
+		csi.ControllerServiceCapability_RPC_PUBLISH_READONLY,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+	}, caps)
+}
+
+func TestCreateVolumeCapacityRange(t *testing.T) {
+	tests := []struct {
+		Name          string
+		RequiredBytes int64
+		LimitBytes    int64
+		expectSize    int64
+		expectError   string
+	}{
+		{
+			Name:          "Only required bytes set",
+			RequiredBytes: 1024,
+			expectSize:    1024,
+		},
+		{
+			Name:       "Only limit bytes set",
+			LimitBytes: 2048,
+			expectSize: 2048,
+		},
+		{
+			Name:          "Required bytes lower than limit bytes",
+			RequiredBytes: 1024,
+			LimitBytes:    2048,
+			expectSize:    1024,
+		},
+		{
+			Name:          "Required bytes equal to limit bytes",
+			RequiredBytes: 2048,
+			LimitBytes:    2048,
+			expectSize:    2048,
+		},
+		{
+			Name:          "Required bytes larger than limit bytes",
+			RequiredBytes: 4096,
+			LimitBytes:    2048,
+			expectError:   "Required volume size 4096 is larger than the volume size limit 2048",
+		},
+		{
+			Name:        "Neither required bytes nor limit bytes set",
+			expectError: "Either required volume size or volume size limit must be set",
+		},
+		{
+			Name:          "Negative required bytes",
+			RequiredBytes: -1,
+			LimitBytes:    2048,
+			expectError:   "Required volume size cannot be negative",
+		},
+		{
+			Name:        "Negative limit bytes",
+			LimitBytes:  -1,
+			expectError: "Volume size limit cannot be negative",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var createdSize string
+			fakeClient := newFakePoolDevLXDServer("zfs", false)
+			fakeClient.getVolFunc = func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+				if createdSize == "" {
+					return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage volume not found")
+				}
+
+				return &api.DevLXDStorageVolume{Name: name, Config: map[string]string{"size": createdSize}}, "", nil
+			}
+
+			fakeClient.createVolFunc = func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+				createdSize = volume.Config["size"]
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			resp, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "pvc-7c2d9e41-5a3b-4f80-b6c1-2e9d8f7a6b5c",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: test.RequiredBytes,
+					LimitBytes:    test.LimitBytes,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+					},
+				},
+				Parameters: map[string]string{ParameterStoragePool: "local"},
+			})
+			if test.expectError != "" {
+				require.Error(t, err)
+				require.Equal(t, codes.InvalidArgument, status.Code(err))
+				require.ErrorContains(t, err, test.expectError)
+				require.Empty(t, createdSize)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, strconv.FormatInt(test.expectSize, 10), createdSize)
+			require.Equal(t, test.expectSize, resp.Volume.CapacityBytes)
+		})
+	}
+}
+
+func TestCreateVolumeMaxVolumeSize(t *testing.T) {
+	const GiB = 1024 * 1024 * 1024
+
+	tests := []struct {
+		Name          string
+		RequiredBytes int64
+		MaxVolumeSize string
+		expectCode    codes.Code
+		expectError   string
+	}{
+		{
+			Name:          "Size below maximum",
+			RequiredBytes: 5 * GiB,
+			MaxVolumeSize: "10GiB",
+		},
+		{
+			Name:          "Size equal to maximum",
+			RequiredBytes: 10 * GiB,
+			MaxVolumeSize: "10GiB",
+		},
+		{
+			Name:          "Size above maximum",
+			RequiredBytes: 10*GiB + 1,
+			MaxVolumeSize: "10GiB",
+			expectCode:    codes.OutOfRange,
+			expectError:   "Requested volume size 10.00GiB exceeds the maximum volume size 10.00GiB of the storage class",
+		},
+		{
+			Name:          "Invalid maximum",
+			RequiredBytes: GiB,
+			MaxVolumeSize: "ten",
+			expectCode:    codes.InvalidArgument,
+			expectError:   `Invalid value "ten" for parameter "maxVolumeSize"`,
+		},
+		{
+			Name:          "Zero maximum",
+			RequiredBytes: GiB,
+			MaxVolumeSize: "0",
+			expectCode:    codes.InvalidArgument,
+			expectError:   "Volume size must be greater than zero",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var created bool
+			fakeClient := newFakePoolDevLXDServer("lvm", false)
+			fakeClient.createVolFunc = func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+				created = true
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			_, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:          "pvc-3b8e1f2a-9c4d-4e6f-a0b1-c2d3e4f5a6b7",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: test.RequiredBytes},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+					},
+				},
+				Parameters: map[string]string{
+					ParameterStoragePool:   "local",
+					ParameterMaxVolumeSize: test.MaxVolumeSize,
+				},
+			})
+			if test.expectCode != codes.OK {
+				require.Error(t, err)
+				require.Equal(t, test.expectCode, status.Code(err))
+				require.ErrorContains(t, err, test.expectError)
+				require.False(t, created)
+				return
+			}
+
+			require.NoError(t, err)
+			require.True(t, created)
+		})
+	}
+}
+
+func TestCreateVolumeMinVolumeSize(t *testing.T) {
+	const MiB = 1024 * 1024
+
+	tests := []struct {
+		Name          string
+		PoolDriver    string
+		RequiredBytes int64
+		LimitBytes    int64
+		Parameters    map[string]string
+		expectSize    int64
+		expectCode    codes.Code
+	}{
+		{
+			Name:          "Size below minimum is rounded up",
+			PoolDriver:    "zfs",
+			RequiredBytes: 100 * MiB,
+			Parameters:    map[string]string{ParameterMinVolumeSize: "1GiB"},
+			expectSize:    1024 * MiB,
+		},
+		{
+			Name:          "Size above minimum is kept",
+			PoolDriver:    "zfs",
+			RequiredBytes: 2048 * MiB,
+			Parameters:    map[string]string{ParameterMinVolumeSize: "1GiB"},
+			expectSize:    2048 * MiB,
+		},
+		{
+			Name:          "Size below storage driver minimum is rounded up",
+			PoolDriver:    "lvm",
+			RequiredBytes: MiB,
+			expectSize:    4 * MiB,
+		},
+		{
+			Name:          "Storage class minimum overrides storage driver minimum",
+			PoolDriver:    "lvm",
+			RequiredBytes: MiB,
+			Parameters:    map[string]string{ParameterMinVolumeSize: "2MiB"},
+			expectSize:    2 * MiB,
+		},
+		{
+			Name:          "Minimum exceeds size limit",
+			PoolDriver:    "zfs",
+			RequiredBytes: 100 * MiB,
+			LimitBytes:    200 * MiB,
+			Parameters:    map[string]string{ParameterMinVolumeSize: "1GiB"},
+			expectCode:    codes.OutOfRange,
+		},
+		{
+			Name:          "Minimum exceeds maximum",
+			PoolDriver:    "zfs",
+			RequiredBytes: 100 * MiB,
+			Parameters:    map[string]string{ParameterMinVolumeSize: "2GiB", ParameterMaxVolumeSize: "1GiB"},
+			expectCode:    codes.InvalidArgument,
+		},
+		{
+			Name:          "Invalid minimum",
+			PoolDriver:    "zfs",
+			RequiredBytes: 100 * MiB,
+			Parameters:    map[string]string{ParameterMinVolumeSize: "small"},
+			expectCode:    codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var createdSize string
+			fakeClient := newFakePoolDevLXDServer(test.PoolDriver, false)
+			fakeClient.getVolFunc = func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+				if createdSize == "" {
+					return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage volume not found")
+				}
+
+				return &api.DevLXDStorageVolume{Name: name, Config: map[string]string{"size": createdSize}}, "", nil
+			}
+
+			fakeClient.createVolFunc = func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+				createdSize = volume.Config["size"]
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			parameters := map[string]string{
+				ParameterStoragePool: "local",
+			}
+
+			maps.Copy(parameters, test.Parameters)
+
+			resp, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "pvc-0e9d8c7b-6a5f-4e3d-b2c1-a0f9e8d7c6b5",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: test.RequiredBytes,
+					LimitBytes:    test.LimitBytes,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+					},
+				},
+				Parameters: parameters,
+			})
+			if test.expectCode != codes.OK {
+				require.Error(t, err)
+				require.Equal(t, test.expectCode, status.Code(err))
+				require.Empty(t, createdSize)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, strconv.FormatInt(test.expectSize, 10), createdSize)
+			require.Equal(t, test.expectSize, resp.Volume.CapacityBytes)
+		})
+	}
+}
+
+func TestCreateVolumeNamePrefix(t *testing.T) {
+	tests := []struct {
+		Name         string
+		GlobalPrefix string
+		PoolPrefixes map[string]string
+		Parameters   map[string]string
+		expectVol    string
+		expectCode   codes.Code
+	}{
+		{
+			Name:      "Prefix from the request name",
+			expectVol: "pvc-1f0e5a3c8d2b4c6e9f710a2b3c4d5e6f",
+		},
+		{
+			Name:         "Global prefix",
+			GlobalPrefix: "csi",
+			expectVol:    "csi-1f0e5a3c8d2b4c6e9f710a2b3c4d5e6f",
+		},
+		{
+			Name:         "Pool prefix overrides global prefix",
+			GlobalPrefix: "csi",
+			PoolPrefixes: map[string]string{"fast": "prod", "slow": "scratch"},
+			expectVol:    "prod-1f0e5a3c8d2b4c6e9f710a2b3c4d5e6f",
+		},
+		{
+			Name:         "Prefix of another pool is ignored",
+			GlobalPrefix: "csi",
+			PoolPrefixes: map[string]string{"slow": "scratch"},
+			expectVol:    "csi-1f0e5a3c8d2b4c6e9f710a2b3c4d5e6f",
+		},
+		{
+			Name:         "Storage class prefix overrides pool prefix",
+			GlobalPrefix: "csi",
+			PoolPrefixes: map[string]string{"fast": "prod"},
+			Parameters:   map[string]string{ParameterVolumeNamePrefix: "team-a"},
+			expectVol:    "team-a-1f0e5a3c8d2b4c6e9f710a2b3c4d5e6f",
+		},
+		{
+			Name:       "Invalid storage class prefix",
+			Parameters: map[string]string{ParameterVolumeNamePrefix: "-team-a"},
+			expectCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var createdVol string
+			fakeClient := newFakePoolDevLXDServer("ceph", true)
+			fakeClient.createVolFunc = func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+				createdVol = volume.Name
+				require.NotContains(t, volume.Config, ParameterVolumeNamePrefix)
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			controller := NewControllerServer(&Driver{
+				devLXD:           fakeClient,
+				volumeNamePrefix: test.GlobalPrefix,
+				poolPrefixMap:    test.PoolPrefixes,
+			})
+
+			parameters := map[string]string{ParameterStoragePool: "fast"}
+			maps.Copy(parameters, test.Parameters)
+
+			resp, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:          "pvc-1f0e5a3c-8d2b-4c6e-9f71-0a2b3c4d5e6f",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 1024 * 1024 * 1024},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+					},
+				},
+				Parameters: parameters,
+			})
+			if test.expectCode != codes.OK {
+				require.Equal(t, test.expectCode, status.Code(err))
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.expectVol, createdVol)
+			require.Equal(t, "fast/"+test.expectVol, resp.Volume.VolumeId)
+		})
+	}
+}
+
+func TestCreateVolumeStoragePoolOnMember(t *testing.T) {
+	tests := []struct {
+		Name       string
+		MemberPool func(pool string) (*api.DevLXDStoragePool, string, error)
+		expectCode codes.Code
+	}{
+		{
+			Name: "Storage pool available on member",
+			MemberPool: func(pool string) (*api.DevLXDStoragePool, string, error) {
+				return &api.DevLXDStoragePool{Name: pool, Driver: "zfs", Status: api.StoragePoolStatusCreated}, "", nil
+			},
+			expectCode: codes.OK,
+		},
+		{
+			Name: "Storage pool missing on member",
+			MemberPool: func(pool string) (*api.DevLXDStoragePool, string, error) {
+				return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage pool not found")
+			},
+			expectCode: codes.FailedPrecondition,
+		},
+		{
+			Name: "Storage pool pending on member",
+			MemberPool: func(pool string) (*api.DevLXDStoragePool, string, error) {
+				return &api.DevLXDStoragePool{Name: pool, Driver: "zfs", Status: "Pending"}, "", nil
+			},
+			expectCode: codes.FailedPrecondition,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var created bool
+			fakeClient := newFakePoolDevLXDServer("zfs", false)
+			fakeClient.getVolFunc = func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+				return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage volume not found")
+			}
+
+			fakeClient.createVolFunc = func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+				created = true
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			fakeClient.getPoolFunc = func(pool string) (*api.DevLXDStoragePool, string, error) {
+				// Return the member specific pool once the target is set.
+				if len(fakeClient.targets) > 0 {
+					return test.MemberPool(pool)
+				}
+
+				return &api.DevLXDStoragePool{Name: pool, Driver: "zfs", Status: api.StoragePoolStatusCreated}, "", nil
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient, isClustered: true})
+
+			_, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:          "pvc-1f0e5a3c-8d2b-4c6e-9f71-0a2b3c4d5e6f",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 1024 * 1024 * 1024},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+					},
+				},
+				Parameters: map[string]string{ParameterStoragePool: "local"},
+				AccessibilityRequirements: &csi.TopologyRequirement{
+					Preferred: []*csi.Topology{
+						{Segments: map[string]string{AnnotationLXDClusterMember: "member-2"}},
+					},
+				},
+			})
+
+			require.Equal(t, test.expectCode, status.Code(err))
+			require.Equal(t, []string{"member-2"}, fakeClient.targets)
+			require.Equal(t, test.expectCode == codes.OK, created)
+			if test.expectCode != codes.OK {
+				require.ErrorContains(t, err, `Storage pool "local" is not available on cluster member "member-2"`)
+			}
+		})
+	}
+}
+
+func TestControllerExpandVolumeUnsupportedDriver(t *testing.T) {
+	tests := []struct {
+		Name         string
+		Driver       string
+		expectCode   codes.Code
+		expectUpdate bool
+	}{
+		{
+			Name:         "Expand volume on zfs storage pool",
+			Driver:       "zfs",
+			expectCode:   codes.OK,
+			expectUpdate: true,
+		},
+		{
+			Name:       "Reject expansion on dir storage pool",
+			Driver:     "dir",
+			expectCode: codes.Unimplemented,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var updated bool
+			fakeClient := newFakePoolDevLXDServer(test.Driver, false)
+			fakeClient.getVolFunc = func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+				return &api.DevLXDStorageVolume{Name: name, Config: map[string]string{"size": "1073741824"}}, "", nil
+			}
+
+			fakeClient.updateVolFunc = func(pool string, volType string, name string, volume api.DevLXDStorageVolumePut, ETag string) (lxdClient.DevLXDOperation, error) {
+				updated = true
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			_, err := controller.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+				VolumeId:      "local/pvc-vol",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 2147483648},
+				VolumeCapability: &csi.VolumeCapability{
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+				},
+			})
+			require.Equal(t, test.expectCode, status.Code(err))
+			require.Equal(t, test.expectUpdate, updated)
+		})
+	}
+}
+
+func TestCreateVolumeCrossPoolClone(t *testing.T) {
+	poolDrivers := map[string]string{
+		"ceph-a": "ceph",
+		"ceph-b": "ceph",
+		"lvm":    "lvm",
+	}
+
+	tests := []struct {
+		Name        string
+		SourcePool  string
+		TargetPool  string
+		expectError string
+	}{
+		{
+			Name:       "Clone within the same storage pool",
+			SourcePool: "ceph-a",
+			TargetPool: "ceph-a",
+		},
+		{
+			Name:       "Clone across storage pools with the same driver",
+			SourcePool: "ceph-a",
+			TargetPool: "ceph-b",
+		},
+		{
+			Name:        "Clone across storage pools with different drivers",
+			SourcePool:  "lvm",
+			TargetPool:  "ceph-a",
+			expectError: `Cannot clone volume from storage pool "lvm" (driver "lvm") to storage pool "ceph-a" (driver "ceph")`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var createReq *api.DevLXDStorageVolumesPost
+			fakeClient := &fakeDevLXDServer{
+				getPoolFunc: func(pool string) (*api.DevLXDStoragePool, string, error) {
+					return &api.DevLXDStoragePool{Name: pool, Driver: poolDrivers[pool]}, "", nil
+				},
+				getStateFunc: func() (*api.DevLXDGet, error) {
+					return &api.DevLXDGet{
+						DevLXDGetUntrusted: api.DevLXDGetUntrusted{
+							SupportedStorageDrivers: []api.DevLXDServerStorageDriverInfo{
+								{Name: "ceph", Remote: true},
+								{Name: "lvm", Remote: false},
+							},
+						},
+					}, nil
+				},
+				getVolFunc: func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+					if name == "pvc-source" && pool == test.SourcePool {
+						return &api.DevLXDStorageVolume{Name: name, ContentType: "filesystem", Config: map[string]string{"size": "1024"}}, "", nil
+					}
+
+					return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage volume not found")
+				},
+				createVolFunc: func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+					createReq = &volume
+					return &fakeDevLXDOperation{}, nil
+				},
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			_, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:          "pvc-2b8e6f1d-3c4a-4d5e-8f90-1a2b3c4d5e6f",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 1024},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+					},
+				},
+				VolumeContentSource: &csi.VolumeContentSource{
+					Type: &csi.VolumeContentSource_Volume{
+						Volume: &csi.VolumeContentSource_VolumeSource{
+							VolumeId: test.SourcePool + "/pvc-source",
+						},
+					},
+				},
+				Parameters: map[string]string{ParameterStoragePool: test.TargetPool},
+			})
+			if test.expectError != "" {
+				require.Error(t, err)
+				require.Equal(t, codes.InvalidArgument, status.Code(err))
+				require.ErrorContains(t, err, test.expectError)
+				require.Nil(t, createReq)
+				return
+			}
+
+			require.NoError(t, err)
+			require.NotNil(t, createReq)
+			require.Equal(t, api.SourceTypeCopy, createReq.Source.Type)
+			require.Equal(t, test.SourcePool, createReq.Source.Pool)
+		})
+	}
+}
+
+func TestControllerModifyVolume(t *testing.T) {
+	tests := []struct {
+		Name              string
+		Config            map[string]string
+		MutableParameters map[string]string
+		expectConfig      map[string]string
+		expectError       string
+	}{
+		{
+			Name:   "Set snapshot schedule and expiry",
+			Config: map[string]string{"size": "1024"},
+			MutableParameters: map[string]string{
+				ParameterSnapshotsSchedule: "@daily",
+				ParameterSnapshotsExpiry:   "1w",
+			},
+			expectConfig: map[string]string{
+				"size":                     "1024",
+				ParameterSnapshotsSchedule: "@daily",
+				ParameterSnapshotsExpiry:   "1w",
+			},
+		},
+		{
+			Name: "Unset snapshot schedule",
+			Config: map[string]string{
+				"size":                     "1024",
+				ParameterSnapshotsSchedule: "@daily",
+			},
+			MutableParameters: map[string]string{
+				ParameterSnapshotsSchedule: "",
+			},
+			expectConfig: map[string]string{"size": "1024"},
+		},
+		{
+			Name: "Configuration already applied",
+			Config: map[string]string{
+				"size":                     "1024",
+				ParameterSnapshotsSchedule: "@daily",
+			},
+			MutableParameters: map[string]string{
+				ParameterSnapshotsSchedule: "@daily",
+			},
+		},
+		{
+			Name:   "Invalid snapshot schedule",
+			Config: map[string]string{"size": "1024"},
+			MutableParameters: map[string]string{
+				ParameterSnapshotsSchedule: "invalid",
+			},
+			expectError: `Invalid value "invalid" for mutable parameter "snapshots.schedule"`,
+		},
+		{
+			Name:   "Parameter requiring data movement",
+			Config: map[string]string{"size": "1024"},
+			MutableParameters: map[string]string{
+				ParameterBlockFilesystem: "xfs",
+			},
+			expectError: `Parameter "block.filesystem" cannot be modified after provisioning`,
+		},
+		{
+			Name:   "Storage pool change",
+			Config: map[string]string{"size": "1024"},
+			MutableParameters: map[string]string{
+				ParameterStoragePool: "other",
+			},
+			expectError: `Parameter "storagePool" cannot be modified after provisioning`,
+		},
+		{
+			Name:   "Unknown parameter",
+			Config: map[string]string{"size": "1024"},
+			MutableParameters: map[string]string{
+				"limits.read": "10MB",
+			},
+			expectError: `Invalid mutable parameter "limits.read"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var updatedConfig map[string]string
+			fakeClient := &fakeDevLXDServer{
+				getVolFunc: func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+					return &api.DevLXDStorageVolume{Name: name, Config: maps.Clone(test.Config)}, "etag", nil
+				},
+				updateVolFunc: func(pool string, volType string, name string, volume api.DevLXDStorageVolumePut, ETag string) (lxdClient.DevLXDOperation, error) {
+					require.Equal(t, "etag", ETag)
+					updatedConfig = volume.Config
+					return &fakeDevLXDOperation{}, nil
+				},
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			_, err := controller.ControllerModifyVolume(context.Background(), &csi.ControllerModifyVolumeRequest{
+				VolumeId:          "remote/pvc-vol",
+				MutableParameters: test.MutableParameters,
+			})
+			if test.expectError != "" {
+				require.Error(t, err)
+				require.Equal(t, codes.InvalidArgument, status.Code(err))
+				require.ErrorContains(t, err, test.expectError)
+				require.Nil(t, updatedConfig)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.expectConfig, updatedConfig)
+		})
+	}
+}
+
+func TestControllerExpandVolumeInUse(t *testing.T) {
+	tests := []struct {
+		Name        string
+		ContentType string
+		UpdateError error
+		expectCode  codes.Code
+		expectError string
+	}{
+		{
+			Name:        "Expand detached block volume",
+			ContentType: "block",
+			expectCode:  codes.OK,
+		},
+		{
+			Name:        "Expand block volume in use",
+			ContentType: "block",
+			UpdateError: api.StatusErrorf(http.StatusLocked, "Cannot resize block volume while in use"),
+			expectCode:  codes.FailedPrecondition,
+			expectError: `Volume "pvc-vol" is in use, detach it to expand`,
+		},
+		{
+			Name:        "Expand filesystem volume locked for another reason",
+			ContentType: "filesystem",
+			UpdateError: api.StatusErrorf(http.StatusLocked, "Volume is locked"),
+			expectCode:  codes.FailedPrecondition,
+			expectError: "Failed to expand volume",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			fakeClient := newFakePoolDevLXDServer("lvm", false)
+			fakeClient.getVolFunc = func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+				return &api.DevLXDStorageVolume{Name: name, ContentType: test.ContentType, Config: map[string]string{"size": "1073741824"}}, "", nil
+			}
+
+			fakeClient.updateVolFunc = func(pool string, volType string, name string, volume api.DevLXDStorageVolumePut, ETag string) (lxdClient.DevLXDOperation, error) {
+				if test.UpdateError != nil {
+					return nil, test.UpdateError
+				}
+
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			// Secrets passed by the external-resizer must not affect the expansion.
+			_, err := controller.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+				VolumeId:      "local/pvc-vol",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 2147483648},
+				Secrets:       map[string]string{"token": "secret"},
+				VolumeCapability: &csi.VolumeCapability{
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+					AccessType: &csi.VolumeCapability_Block{
+						Block: &csi.VolumeCapability_BlockVolume{},
+					},
+				},
+			})
+			require.Equal(t, test.expectCode, status.Code(err))
+			if test.expectError != "" {
+				require.ErrorContains(t, err, test.expectError)
+			}
+		})
+	}
+}
+
+func TestControllerExpandVolumeAttached(t *testing.T) {
+	blockCapability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
+	}
+
+	devices := make(map[string]map[string]string)
+	config := map[string]string{"size": "1073741824"}
+	var updated bool
+	fakeClient := newFakePoolDevLXDServer("ceph", true)
+	fakeClient.getVolFunc = func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+		return &api.DevLXDStorageVolume{Name: name, ContentType: "block", Config: maps.Clone(config)}, "", nil
+	}
+
+	fakeClient.updateVolFunc = func(pool string, volType string, name string, volume api.DevLXDStorageVolumePut, ETag string) (lxdClient.DevLXDOperation, error) {
+		if volume.Config["size"] != config["size"] {
+			updated = true
+		}
+
+		config = volume.Config
+		return &fakeDevLXDOperation{}, nil
+	}
+
+	fakeClient.getInstFunc = func(name string) (*api.DevLXDInstance, string, error) {
+		return &api.DevLXDInstance{Name: name, Devices: maps.Clone(devices)}, "", nil
+	}
+
+	fakeClient.updateInstFunc = func(name string, inst api.DevLXDInstancePut, ETag string) error {
+		for devName, dev := range inst.Devices {
+			if dev == nil {
+				delete(devices, devName)
+			} else {
+				devices[devName] = dev
+			}
+		}
+
+		return nil
+	}
+
+	controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+	expandReq := &csi.ControllerExpandVolumeRequest{
+		VolumeId:         "remote/pvc-vol",
+		CapacityRange:    &csi.CapacityRange{RequiredBytes: 2147483648},
+		VolumeCapability: blockCapability,
+	}
+
+	// Attach the volume to a node.
+	_, err := controller.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId:         "remote/pvc-vol",
+		NodeId:           "node-1",
+		VolumeCapability: blockCapability,
+	})
+	require.NoError(t, err)
+
+	// Expansion of the attached block volume is rejected, also by another
+	// controller that did not attach the volume, for example, after a restart.
+	for _, c := range []*controllerServer{controller, NewControllerServer(&Driver{devLXD: fakeClient})} {
+		_, err = c.ControllerExpandVolume(context.Background(), expandReq)
+		require.Equal(t, codes.FailedPrecondition, status.Code(err))
+		require.ErrorContains(t, err, `Volume "pvc-vol" is in use by node "node-1", detach it to expand`)
+		require.False(t, updated)
+	}
+
+	// Detach the volume and expand it.
+	_, err = controller.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{
+		VolumeId: "remote/pvc-vol",
+		NodeId:   "node-1",
+	})
+	require.NoError(t, err)
+	require.NotContains(t, config, volumeAttachedNodesConfigKey)
+
+	_, err = controller.ControllerExpandVolume(context.Background(), expandReq)
+	require.NoError(t, err)
+	require.True(t, updated)
+}
+
+func TestControllerExpandVolumeAttachmentRecord(t *testing.T) {
+	blockCapability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
+	}
+
+	volDevice := map[string]string{"type": "disk", "source": "pvc-vol", "pool": "remote"}
+
+	tests := []struct {
+		Name          string
+		AttachedNodes string
+		NodeDevices   map[string]map[string]map[string]string
+		expectCode    codes.Code
+		expectUpdated bool
+	}{
+		{
+			// Attachments of older driver versions are recorded once the
+			// volume is published or unpublished again, as tested in
+			// TestControllerExpandVolumeUnrecordedAttachment.
+			Name:          "No attachment recorded",
+			NodeDevices:   map[string]map[string]map[string]string{"node-1": {"pvc-vol": volDevice}},
+			expectUpdated: true,
+		},
+		{
+			Name:          "Attachment recorded by another controller",
+			AttachedNodes: "node-1,node-2",
+			NodeDevices: map[string]map[string]map[string]string{
+				"node-1": {},
+				"node-2": {getDeviceName("remote", "pvc-vol"): volDevice},
+			},
+			expectCode: codes.FailedPrecondition,
+		},
+		{
+			Name:          "Recorded nodes without the volume device",
+			AttachedNodes: "node-1,node-3",
+			NodeDevices:   map[string]map[string]map[string]string{"node-1": {}},
+			expectUpdated: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var updated bool
+			fakeClient := newFakeInstanceDevLXDServer(test.NodeDevices)
+			fakeClient.getVolFunc = func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+				config := map[string]string{"size": "1073741824"}
+				if test.AttachedNodes != "" {
+					config[volumeAttachedNodesConfigKey] = test.AttachedNodes
+				}
+
+				return &api.DevLXDStorageVolume{Name: name, ContentType: "block", Config: config}, "", nil
+			}
+
+			fakeClient.updateVolFunc = func(pool string, volType string, name string, volume api.DevLXDStorageVolumePut, ETag string) (lxdClient.DevLXDOperation, error) {
+				updated = true
+				require.Equal(t, test.AttachedNodes, volume.Config[volumeAttachedNodesConfigKey])
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+			_, err := controller.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+				VolumeId:         "remote/pvc-vol",
+				CapacityRange:    &csi.CapacityRange{RequiredBytes: 2147483648},
+				VolumeCapability: blockCapability,
+			})
+			require.Equal(t, test.expectCode, status.Code(err))
+			require.Equal(t, test.expectUpdated, updated)
+
+			if test.expectCode == codes.FailedPrecondition {
+				info := requireErrorInfo(t, err, codes.FailedPrecondition)
+				require.Equal(t, ErrorReasonVolumeInUse, info.Reason)
+				require.Equal(t, map[string]string{"volume": "pvc-vol", "node": "node-2"}, info.Metadata)
+			}
+		})
+	}
+}
+
+func TestControllerExpandVolumeUnrecordedAttachment(t *testing.T) {
+	blockCapability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
+	}
+
+	// The volume was attached by an older version of the driver, which did
+	// not record the attachment.
+	nodeDevices := map[string]map[string]map[string]string{
+		"node-1": {"pvc-vol": {"type": "disk", "source": "pvc-vol", "pool": "remote"}},
+	}
+
+	fakeClient := newFakeInstanceDevLXDServer(nodeDevices)
+	getVol := fakeClient.getVolFunc
+	fakeClient.getVolFunc = func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+		vol, etag, err := getVol(pool, volType, name)
+		if vol.Config == nil {
+			vol.Config = map[string]string{}
+		}
+
+		vol.Config["size"] = "1073741824"
+		return vol, etag, err
+	}
+
+	// LXD refuses to resize the block volume while it is attached.
+	resizes := 0
+	updateVol := fakeClient.updateVolFunc
+	fakeClient.updateVolFunc = func(pool string, volType string, name string, volume api.DevLXDStorageVolumePut, ETag string) (lxdClient.DevLXDOperation, error) {
+		if volume.Config["size"] != "1073741824" {
+			resizes++
+			return nil, api.StatusErrorf(http.StatusLocked, "Cannot resize block volume while in use")
+		}
+
+		return updateVol(pool, volType, name, volume, ETag)
+	}
+
+	controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+	expand := func() error {
+		_, err := controller.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+			VolumeId:         "remote/pvc-vol",
+			CapacityRange:    &csi.CapacityRange{RequiredBytes: 2147483648},
+			VolumeCapability: blockCapability,
+		})
+
+		return err
+	}
+
+	// Without a record, the attachment is only detected by LXD.
+	err := expand()
+	info := requireErrorInfo(t, err, codes.FailedPrecondition)
+	require.Equal(t, ErrorReasonVolumeInUse, info.Reason)
+	require.Equal(t, map[string]string{"volume": "pvc-vol"}, info.Metadata)
+	require.Equal(t, 1, resizes)
+
+	// Publishing the volume on the node it is attached to records the
+	// attachment, after which the expansion is rejected upfront.
+	_, err = controller.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId:         "remote/pvc-vol",
+		NodeId:           "node-1",
+		VolumeCapability: blockCapability,
+	})
+	require.NoError(t, err)
+
+	err = expand()
+	info = requireErrorInfo(t, err, codes.FailedPrecondition)
+	require.Equal(t, ErrorReasonVolumeInUse, info.Reason)
+	require.Equal(t, map[string]string{"volume": "pvc-vol", "node": "node-1"}, info.Metadata)
+	require.Equal(t, 1, resizes)
+}
+
+func TestControllerPublishVolumeAttachedElsewhere(t *testing.T) {
+	newCapability := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+			AccessType: &csi.VolumeCapability_Block{
+				Block: &csi.VolumeCapability_BlockVolume{},
+			},
+		}
+	}
+
+	// Devices of each node instance.
+	nodeDevices := map[string]map[string]map[string]string{
+		"node-1": {},
+		"node-2": {},
+	}
+
+	fakeClient := newFakeInstanceDevLXDServer(nodeDevices)
+	controller := NewControllerServer(&Driver{devLXD: fakeClient})
+	devName := getDeviceName("remote", "pvc-vol")
+
+	publish := func(node string, mode csi.VolumeCapability_AccessMode_Mode) error {
+		_, err := controller.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+			VolumeId:         "remote/pvc-vol",
+			NodeId:           node,
+			VolumeCapability: newCapability(mode),
+		})
+
+		return err
+	}
+
+	// Attach the volume to the first node.
+	err := publish("node-1", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+	require.NoError(t, err)
+	require.Contains(t, nodeDevices["node-1"], devName)
+
+	// Attaching the volume to the same node again succeeds.
+	err = publish("node-1", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+	require.NoError(t, err)
+
+	// Attaching the volume to another node is rejected for single node access modes.
+	for _, mode := range []csi.VolumeCapability_AccessMode_Mode{
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+	} {
+		err = publish("node-2", mode)
+		require.ErrorContains(t, err, `Volume "pvc-vol" is already attached to node "node-1"`)
+
+		info := requireErrorInfo(t, err, codes.FailedPrecondition)
+		require.Equal(t, ErrorReasonVolumeInUse, info.Reason)
+		require.Equal(t, map[string]string{"volume": "pvc-vol", "node": "node-1"}, info.Metadata)
+		require.Empty(t, nodeDevices["node-2"])
+	}
+
+	// The attachment is also detected by another controller, for example,
+	// after a restart of the controller or a change of the leader.
+	_, err = NewControllerServer(&Driver{devLXD: fakeClient}).ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId:         "remote/pvc-vol",
+		NodeId:           "node-2",
+		VolumeCapability: newCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+	})
+	require.ErrorContains(t, err, `Volume "pvc-vol" is already attached to node "node-1"`)
+	require.Empty(t, nodeDevices["node-2"])
+
+	// The check is skipped for multi node access modes.
+	err = publish("node-2", csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)
+	require.NoError(t, err)
+	require.Contains(t, nodeDevices["node-2"], devName)
+
+	// Once the volume is detached from the other nodes, it can be attached again.
+	delete(nodeDevices["node-1"], devName)
+	delete(nodeDevices["node-2"], devName)
+
+	err = publish("node-2", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+	require.NoError(t, err)
+	require.Contains(t, nodeDevices["node-2"], devName)
+}
+
+func TestControllerUnpublishVolumeRecordsLegacyAttachment(t *testing.T) {
+	capability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
+	}
+
+	// The volume was attached by an older version of the driver, which
+	// neither used the hashed device name nor recorded the attachment.
+	nodeDevices := map[string]map[string]map[string]string{
+		"node-1": {"pvc-vol": {"type": "disk", "source": "pvc-vol", "pool": "remote"}},
+		"node-2": {},
+	}
+
+	fakeClient := newFakeInstanceDevLXDServer(nodeDevices)
+	updateInst := fakeClient.updateInstFunc
+	fakeClient.updateInstFunc = func(name string, inst api.DevLXDInstancePut, ETag string) error {
+		return api.StatusErrorf(http.StatusInternalServerError, "Failed to detach disk")
+	}
+
+	controller := NewControllerServer(&Driver{devLXD: fakeClient})
+
+	unpublish := func() error {
+		_, err := controller.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{
+			VolumeId: "remote/pvc-vol",
+			NodeId:   "node-1",
+		})
+
+		return err
+	}
+
+	publish := func() error {
+		_, err := controller.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+			VolumeId:         "remote/pvc-vol",
+			NodeId:           "node-2",
+			VolumeCapability: capability,
+		})
+
+		return err
+	}
+
+	// The attachment is recorded even though the volume cannot be detached,
+	// so that the volume is not attached to another node.
+	err := unpublish()
+	require.Equal(t, codes.Internal, status.Code(err))
+
+	err = publish()
+	require.ErrorContains(t, err, `Volume "pvc-vol" is already attached to node "node-1"`)
+	require.Empty(t, nodeDevices["node-2"])
+
+	// Once the volume is detached, the record is removed.
+	fakeClient.updateInstFunc = updateInst
+
+	err = unpublish()
+	require.NoError(t, err)
+	require.Empty(t, nodeDevices["node-1"])
+
+	vol, _, err := fakeClient.GetStoragePoolVolume("remote", "custom", "pvc-vol")
+	require.NoError(t, err)
+	require.NotContains(t, vol.Config, volumeAttachedNodesConfigKey)
+
+	err = publish()
+	require.NoError(t, err)
+}
+
+// newFakePoolDevLXDServer returns a fake devLXD server whose storage pools use
+// the given driver, which is the only storage driver supported by the server.
+func newFakePoolDevLXDServer(driver string, remote bool) *fakeDevLXDServer {
+	return &fakeDevLXDServer{
+		getPoolFunc: func(pool string) (*api.DevLXDStoragePool, string, error) {
+			return &api.DevLXDStoragePool{Name: pool, Driver: driver}, "", nil
+		},
+		getStateFunc: func() (*api.DevLXDGet, error) {
+			return &api.DevLXDGet{
+				DevLXDGetUntrusted: api.DevLXDGetUntrusted{
+					SupportedStorageDrivers: []api.DevLXDServerStorageDriverInfo{
+						{Name: driver, Remote: remote},
+					},
+				},
+			}, nil
+		},
+	}
+}
+
+// newFakeInstanceDevLXDServer returns a fake devLXD server with existing volumes
+// in remote ceph storage pools, whose instances have the devices from the given
+// map keyed by instance name. Instance updates add and remove the devices in
+// the map, and updates of instances missing from the map fail. Volume updates
+// store the volume configuration in memory.
+func newFakeInstanceDevLXDServer(instanceDevices map[string]map[string]map[string]string) *fakeDevLXDServer {
+	volumeConfigs := make(map[string]map[string]string)
+	server := newFakePoolDevLXDServer("ceph", true)
+	server.getVolFunc = func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+		return &api.DevLXDStorageVolume{Name: name, ContentType: "block", Config: maps.Clone(volumeConfigs[pool+"/"+name])}, "", nil
+	}
+
+	server.updateVolFunc = func(pool string, volType string, name string, volume api.DevLXDStorageVolumePut, ETag string) (lxdClient.DevLXDOperation, error) {
+		volumeConfigs[pool+"/"+name] = volume.Config
+		return &fakeDevLXDOperation{}, nil
+	}
+
+	server.getInstFunc = func(name string) (*api.DevLXDInstance, string, error) {
+		devices, ok := instanceDevices[name]
+		if !ok {
+			return nil, "", api.StatusErrorf(http.StatusNotFound, "Instance not found")
+		}
+
+		return &api.DevLXDInstance{Name: name, Devices: maps.Clone(devices)}, "", nil
+	}
+
+	server.updateInstFunc = func(name string, inst api.DevLXDInstancePut, ETag string) error {
+		devices, ok := instanceDevices[name]
+		if !ok {
+			return api.StatusErrorf(http.StatusNotFound, "Instance not found")
+		}
+
+		for devName, dev := range inst.Devices {
+			if dev == nil {
+				delete(devices, devName)
+			} else {
+				devices[devName] = dev
+			}
+		}
+
+		return nil
+	}
+
+	return server
+}
+
+func TestControllerPublishVolumeExistingDevice(t *testing.T) {
+	devName := getDeviceName("remote", "pvc-vol")
+	volDevice := map[string]string{"type": "disk", "source": "pvc-vol", "pool": "remote"}
+
+	tests := []struct {
+		Name          string
+		Devices       map[string]map[string]string
+		expectDevices map[string]map[string]string
+		expectCode    codes.Code
+	}{
+		{
+			Name:          "Device absent",
+			Devices:       map[string]map[string]string{},
+			expectDevices: map[string]map[string]string{devName: volDevice},
+		},
+		{
+			Name:          "Device present and matching",
+			Devices:       map[string]map[string]string{devName: volDevice},
+			expectDevices: map[string]map[string]string{devName: volDevice},
+		},
+		{
+			Name:       "Device present with different source",
+			Devices:    map[string]map[string]string{devName: {"type": "disk", "source": "other-vol", "pool": "remote"}},
+			expectCode: codes.AlreadyExists,
+		},
+		{
+			Name:       "Device present with different pool",
+			Devices:    map[string]map[string]string{devName: {"type": "disk", "source": "pvc-vol", "pool": "other-pool"}},
+			expectCode: codes.AlreadyExists,
+		},
+		{
+			Name:       "Device present with different type",
+			Devices:    map[string]map[string]string{devName: {"type": "nic", "network": "lxdbr0"}},
+			expectCode: codes.AlreadyExists,
+		},
+		{
+			Name:       "Device present with different read-only mode",
+			Devices:    map[string]map[string]string{devName: {"type": "disk", "source": "pvc-vol", "pool": "remote", "readonly": "true"}},
+			expectCode: codes.AlreadyExists,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			devices := maps.Clone(test.Devices)
+			controller := NewControllerServer(&Driver{devLXD: newFakeInstanceDevLXDServer(map[string]map[string]map[string]string{"node": devices})})
+
+			resp, err := controller.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+				VolumeId: "remote/pvc-vol",
+				NodeId:   "node",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+					AccessType: &csi.VolumeCapability_Block{
+						Block: &csi.VolumeCapability_BlockVolume{},
+					},
+				},
+			})
+			if test.expectCode != codes.OK {
+				require.Equal(t, test.expectCode, status.Code(err))
+
+				// The unrelated device is not clobbered.
+				require.Equal(t, test.Devices, devices)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, devName, resp.PublishContext[PublishContextDeviceName])
+			require.Equal(t, test.expectDevices, devices)
+		})
+	}
+}
+
+func TestControllerPublishVolumeNodeInstanceMap(t *testing.T) {
+	devName := getDeviceName("remote", "pvc-vol")
+
+	tests := []struct {
+		Name            string
+		NodeInstanceMap map[string]string
+		NodeID          string
+		expectInstance  string
+		expectCode      codes.Code
+	}{
+		{
+			Name:           "Node ID is the instance name by default",
+			NodeID:         "worker-1",
+			expectInstance: "worker-1",
+		},
+		{
+			Name:            "Mapped node",
+			NodeInstanceMap: map[string]string{"k8s-worker-2": "worker-2"},
+			NodeID:          "k8s-worker-2",
+			expectInstance:  "worker-2",
+		},
+		{
+			Name:            "Unmapped node falls back to the node ID",
+			NodeInstanceMap: map[string]string{"k8s-worker-2": "worker-2"},
+			NodeID:          "worker-1",
+			expectInstance:  "worker-1",
+		},
+		{
+			Name:            "Mapped node without instance",
+			NodeInstanceMap: map[string]string{"k8s-worker-3": "worker-3"},
+			NodeID:          "k8s-worker-3",
+			expectCode:      codes.NotFound,
+		},
+		{
+			Name:       "Unmapped node without instance",
+			NodeID:     "k8s-worker-2",
+			expectCode: codes.NotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			instances := map[string]map[string]map[string]string{
+				"worker-1": {},
+				"worker-2": {},
+			}
+
+			controller := NewControllerServer(&Driver{
+				devLXD:          newFakeInstanceDevLXDServer(instances),
+				nodeInstanceMap: test.NodeInstanceMap,
+			})
+
+			volumeCapability := &csi.VolumeCapability{
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+				AccessType: &csi.VolumeCapability_Block{
+					Block: &csi.VolumeCapability_BlockVolume{},
+				},
+			}
+
+			_, err := controller.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+				VolumeId:         "remote/pvc-vol",
+				NodeId:           test.NodeID,
+				VolumeCapability: volumeCapability,
+			})
+			if test.expectCode != codes.OK {
+				require.Equal(t, test.expectCode, status.Code(err))
+				return
+			}
+
+			require.NoError(t, err)
+
+			// The device is attached to the resolved instance only.
+			for name, devices := range instances {
+				_, ok := devices[devName]
+				require.Equal(t, name == test.expectInstance, ok, "Unexpected device state on instance %q", name)
+			}
+
+			_, err = controller.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{
+				VolumeId: "remote/pvc-vol",
+				NodeId:   test.NodeID,
+			})
+			require.NoError(t, err)
+			require.Empty(t, instances[test.expectInstance])
+		})
+	}
+}
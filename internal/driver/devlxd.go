@@ -0,0 +1,64 @@
+package driver
+
+import (
+	"github.com/canonical/lxd-csi-driver/internal/devlxd"
+	lxdClient "github.com/canonical/lxd/client"
+	"github.com/canonical/lxd/shared/api"
+)
+
+// DevLXDClient is the subset of the devLXD API used by the driver.
+type DevLXDClient interface {
+	// Client configuration.
+	UseTarget(name string) DevLXDClient
+	UseBearerToken(bearerToken string) DevLXDClient
+
+	// DevLXD info/state.
+	GetState() (*api.DevLXDGet, error)
+
+	// DevLXD instance devices.
+	GetInstance(instName string) (*api.DevLXDInstance, string, error)
+	UpdateInstance(instName string, inst api.DevLXDInstancePut, ETag string) error
+
+	// DevLXD storage pools.
+	GetStoragePool(poolName string) (*api.DevLXDStoragePool, string, error)
+
+	// DevLXD storage volumes.
+	GetStoragePoolVolume(poolName string, volType string, volName string) (*api.DevLXDStorageVolume, string, error)
+	CreateStoragePoolVolume(poolName string, vol api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error)
+	UpdateStoragePoolVolume(poolName string, volType string, volName string, vol api.DevLXDStorageVolumePut, ETag string) (lxdClient.DevLXDOperation, error)
+	DeleteStoragePoolVolume(poolName string, volType string, volName string) (lxdClient.DevLXDOperation, error)
+
+	// DevLXD storage volume snapshots.
+	GetStoragePoolVolumeSnapshots(poolName string, volType string, volName string) ([]api.DevLXDStorageVolumeSnapshot, error)
+	GetStoragePoolVolumeSnapshot(poolName string, volType string, volName string, snapshotName string) (*api.DevLXDStorageVolumeSnapshot, string, error)
+	CreateStoragePoolVolumeSnapshot(poolName string, volType string, volName string, snapshot api.DevLXDStorageVolumeSnapshotsPost) (lxdClient.DevLXDOperation, error)
+	DeleteStoragePoolVolumeSnapshot(poolName string, volType string, volName string, snapshotName string) (lxdClient.DevLXDOperation, error)
+}
+
+// Ensure the adapter satisfies the DevLXDClient interface.
+var _ DevLXDClient = devLXDServerAdapter{}
+
+// devLXDServerAdapter adapts lxdClient.DevLXDServer to the DevLXDClient interface.
+type devLXDServerAdapter struct {
+	lxdClient.DevLXDServer
+}
+
+// UseTarget returns a client that targets the given cluster member.
+func (c devLXDServerAdapter) UseTarget(name string) DevLXDClient {
+	return devLXDServerAdapter{c.DevLXDServer.UseTarget(name)}
+}
+
+// UseBearerToken returns a client that authenticates using the given bearer token.
+func (c devLXDServerAdapter) UseBearerToken(bearerToken string) DevLXDClient {
+	return devLXDServerAdapter{c.DevLXDServer.UseBearerToken(bearerToken)}
+}
+
+// connectDevLXD establishes a connection to the devLXD server at the specified endpoint.
+func connectDevLXD(endpoint string, bearerToken string) (DevLXDClient, error) {
+	client, err := devlxd.Connect(endpoint, bearerToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return devLXDServerAdapter{client}, nil
+}
@@ -2,21 +2,29 @@ package driver
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/sync/semaphore"
 	"google.golang.org/grpc"
 	"k8s.io/klog/v2"
 
 	"github.com/canonical/lxd-csi-driver/internal/devlxd"
 	"github.com/canonical/lxd-csi-driver/internal/fs"
 	"github.com/canonical/lxd-csi-driver/internal/utils"
-	lxdClient "github.com/canonical/lxd/client"
 	"github.com/canonical/lxd/shared/api"
 	lxdValidate "github.com/canonical/lxd/shared/validate"
 )
@@ -25,10 +33,6 @@ import (
 // It is set during the build.
 var driverVersion = "dev"
 
-// driverFileSystemMountPath is the path where the CSI driver mounts
-// the filesystem volumes.
-const driverFileSystemMountPath = "/mnt/lxd-csi"
-
 // Default CSI driver configuration values.
 const (
 	// DefaultDriverName is the default name of the CSI driver.
@@ -44,9 +48,52 @@ const (
 	// DefaultDevLXDEndpoint is the default unix socket path for connecting to DevLXD.
 	DefaultDevLXDEndpoint = "unix:///dev/lxd/sock"
 
+	// DefaultFileSystemMountPath is the default path within the node instance
+	// where LXD mounts the filesystem volumes attached by the CSI driver.
+	DefaultFileSystemMountPath = "/mnt/lxd-csi"
+
 	// DefaultDevLXDTokenFile is the default path to the file containing the bearer token
 	// for authenticating with devLXD.
 	DefaultDevLXDTokenFile = "/etc/lxd-csi-driver/token"
+
+	// DefaultCreateVolumeCancelPolicy is the default policy applied to volumes
+	// created by a cancelled CreateVolume request.
+	DefaultCreateVolumeCancelPolicy = CreateVolumeCancelPolicyKeep
+
+	// DefaultDeleteVolumeSnapshotsPolicy is the default policy applied to the
+	// LXD snapshots of a volume that is being deleted.
+	DefaultDeleteVolumeSnapshotsPolicy = DeleteVolumeSnapshotsPolicyRefuse
+
+	// DefaultUnmountRetries is the default number of attempts to unmount a volume.
+	DefaultUnmountRetries = 20
+
+	// DefaultUnmountRetryInterval is the default initial interval between attempts to unmount a volume.
+	DefaultUnmountRetryInterval = 500 * time.Millisecond
+)
+
+// Policies applied to a volume that was successfully created in LXD after
+// the CreateVolume request has been cancelled.
+const (
+	// CreateVolumeCancelPolicyKeep keeps the created volume and completes
+	// the request as if it was not cancelled. A volume whose creation is still
+	// in progress is returned by a retried request with the same parameters.
+	CreateVolumeCancelPolicyKeep = "keep"
+
+	// CreateVolumeCancelPolicyDelete deletes the created volume on a best-effort
+	// basis and fails the request.
+	CreateVolumeCancelPolicyDelete = "delete"
+)
+
+// Policies applied to the LXD snapshots of a volume that is being deleted.
+// LXD deletes the snapshots together with the volume.
+const (
+	// DeleteVolumeSnapshotsPolicyRefuse fails the DeleteVolume request
+	// while the volume has snapshots.
+	DeleteVolumeSnapshotsPolicyRefuse = "refuse"
+
+	// DeleteVolumeSnapshotsPolicyDelete deletes the snapshots of the volume
+	// before the volume itself.
+	DeleteVolumeSnapshotsPolicyDelete = "delete"
 )
 
 const (
@@ -55,6 +102,26 @@ const (
 	AnnotationLXDClusterMember = "lxd.csi.canonical.com/cluster-member"
 )
 
+const (
+	// PublishContextDeviceName is the publish context key containing the name
+	// of the LXD disk device through which the volume is attached to the node.
+	PublishContextDeviceName = "deviceName"
+
+	// PublishContextContentType is the publish context key containing the
+	// content type ("filesystem" or "block") of the volume attached to the node.
+	PublishContextContentType = "contentType"
+)
+
+const (
+	// volumeNameSuffixMaxLength is the maximum length of the volume name
+	// part that follows the prefix.
+	volumeNameSuffixMaxLength = 48
+
+	// volumeNameHashLength is the length of the hash appended to sanitized
+	// volume names.
+	volumeNameHashLength = 8
+)
+
 const (
 	// ParameterStoragePool is the name of the storage class parameter
 	// that specifies the LXD storage pool to use.
@@ -79,6 +146,167 @@ const (
 	// ParameterPVName contains the name of the PV that represents the LXD volume.
 	// It is passed to the controller by the CSI provisioner.
 	ParameterPVName = "csi.storage.k8s.io/pv/name"
+
+	// ParameterSnapshotsSchedule is the name of the storage class parameter
+	// that sets the cron schedule for automatic snapshots of the LXD volume.
+	//
+	// Such snapshots are created and managed by LXD and are not visible
+	// as Kubernetes VolumeSnapshots.
+	ParameterSnapshotsSchedule = "snapshots.schedule"
+
+	// ParameterSnapshotsExpiry is the name of the storage class parameter
+	// that controls when automatic LXD volume snapshots are deleted
+	// (for example, "1d" or "2w 3d").
+	ParameterSnapshotsExpiry = "snapshots.expiry"
+
+	// ParameterSnapshotsPattern is the name of the storage class parameter
+	// that sets the name template of automatic LXD volume snapshots
+	// (for example, "snap-{{ creation_date|date:'2006-01-02' }}" or "auto%d").
+	ParameterSnapshotsPattern = "snapshots.pattern"
+
+	// ParameterBlockFilesystem is the name of the storage class parameter
+	// that sets the filesystem LXD formats a block-backed volume with.
+	// Applies only to filesystem volumes.
+	ParameterBlockFilesystem = "block.filesystem"
+
+	// ParameterBlockMountOptions is the name of the storage class parameter
+	// that sets the options LXD uses when mounting a block-backed volume
+	// (for example, "noatime"). Applies only to filesystem volumes.
+	ParameterBlockMountOptions = "block.mount_options"
+
+	// ParameterUID is the name of the storage class parameter that sets
+	// the user ID owning the root of a filesystem volume. The ownership is
+	// applied by the node server when the volume is published, regardless
+	// of the pod's fsGroup.
+	ParameterUID = "uid"
+
+	// ParameterGID is the name of the storage class parameter that sets
+	// the group ID owning the root of a filesystem volume. The ownership is
+	// applied by the node server when the volume is published, regardless
+	// of the pod's fsGroup.
+	ParameterGID = "gid"
+
+	// ParameterSubPath is the name of the storage class parameter or volume
+	// attribute that selects a subdirectory of a filesystem volume (for
+	// example, "data/app") to publish instead of the volume root. The
+	// subdirectory is created by the node server if it does not exist.
+	ParameterSubPath = "subPath"
+
+	// ParameterAccessibleMembers is the name of the storage class parameter
+	// that lists the LXD cluster members (comma separated) from which a remote
+	// storage pool is reachable. Volumes are then accessible only from nodes
+	// running on the listed members. By default, volumes on remote storage pools
+	// are accessible from all nodes.
+	ParameterAccessibleMembers = "accessibleMembers"
+
+	// ParameterMkfsOptions is the name of the storage class parameter that
+	// would pass custom options to mkfs. LXD formats the volumes itself and
+	// does not expose mkfs options, therefore the parameter is rejected.
+	ParameterMkfsOptions = "mkfsOptions"
+
+	// ParameterVolumeNamePrefix is the name of the storage class parameter
+	// that sets the prefix used for names of LXD volumes created for the
+	// storage class. It takes precedence over the prefixes configured on
+	// the driver.
+	ParameterVolumeNamePrefix = "volumeNamePrefix"
+
+	// ParameterSnapshotNamePrefix is the name of the volume snapshot class
+	// parameter that sets the prefix used for names of LXD volume snapshots
+	// created for the volume snapshot class. It takes precedence over the
+	// prefix configured on the driver.
+	ParameterSnapshotNamePrefix = "snapshotNamePrefix"
+
+	// ParameterMaxVolumeSize is the name of the storage class parameter that
+	// sets the maximum size of volumes created for the storage class (for
+	// example, "100GiB"). Requests for larger volumes are rejected.
+	ParameterMaxVolumeSize = "maxVolumeSize"
+
+	// ParameterMinVolumeSize is the name of the storage class parameter that
+	// sets the minimum size of volumes created for the storage class (for
+	// example, "1GiB"). Requests for smaller volumes are rounded up.
+	ParameterMinVolumeSize = "minVolumeSize"
+
+	// ParameterDefaultVolumeMode is the name of the storage class parameter
+	// that sets the content type ("block" or "filesystem") of volumes whose
+	// capabilities specify neither the block nor the mount access type.
+	// It never overrides the access type requested by the capabilities.
+	ParameterDefaultVolumeMode = "defaultVolumeMode"
+
+	// ParameterContentType is the name of the storage class parameter that
+	// pins the content type ("block" or "filesystem") of volumes created for
+	// the storage class. Requests whose capabilities ask for a different
+	// content type are rejected.
+	ParameterContentType = "contentType"
+
+	// ParameterLVMStripes is the name of the storage class parameter that
+	// sets the number of stripes of the logical volume backing the LXD volume.
+	// Applies only to storage pools using the LVM driver.
+	ParameterLVMStripes = "lvm.stripes"
+
+	// ParameterLVMStripesSize is the name of the storage class parameter that
+	// sets the size of a single stripe of the logical volume backing the LXD
+	// volume (for example, "64KiB"). Applies only to storage pools using the
+	// LVM driver.
+	ParameterLVMStripesSize = "lvm.stripes.size"
+
+	// ParameterLimitsRead is the name of the storage class parameter that
+	// limits the read rate of the LXD disk device, either in bytes per second
+	// (for example, "10MB") or in operations per second (for example, "100iops").
+	// The limit is applied to the disk device when the volume is attached to a node.
+	ParameterLimitsRead = "limits.read"
+
+	// ParameterLimitsWrite is the name of the storage class parameter that
+	// limits the write rate of the LXD disk device, in the same format as
+	// [ParameterLimitsRead].
+	ParameterLimitsWrite = "limits.write"
+
+	// ParameterLimitsMax is the name of the storage class parameter that
+	// limits both the read and write rate of the LXD disk device. It cannot
+	// be combined with [ParameterLimitsRead] or [ParameterLimitsWrite].
+	ParameterLimitsMax = "limits.max"
+
+	// ParameterIOBus is the name of the storage class parameter that sets
+	// the bus of the LXD disk device in virtual machines (for example,
+	// "virtio-blk"). Applies only to block volumes.
+	ParameterIOBus = "io.bus"
+
+	// ParameterIOCache is the name of the storage class parameter that sets
+	// the caching mode of the LXD disk device in virtual machines ("none",
+	// "writeback", or "unsafe"). Applies only to block volumes.
+	ParameterIOCache = "io.cache"
+
+	// ParameterProtected is the name of the storage class parameter that,
+	// when set to "true", protects the LXD volume from being deleted by the
+	// driver, for example due to a misconfigured reclaim policy.
+	ParameterProtected = "protected"
+
+	// ParameterSourceSnapshotPool is the name of the storage class parameter
+	// that sets the storage pool of an existing LXD volume snapshot from which
+	// volumes are created. It must be set together with [ParameterSourceSnapshotVolume]
+	// and [ParameterSourceSnapshotName] and cannot be combined with a volume
+	// content source of the request.
+	ParameterSourceSnapshotPool = "sourceSnapshotPool"
+
+	// ParameterSourceSnapshotVolume is the name of the storage class parameter
+	// that sets the name of the LXD volume of the source volume snapshot.
+	// See [ParameterSourceSnapshotPool].
+	ParameterSourceSnapshotVolume = "sourceSnapshotVolume"
+
+	// ParameterSourceSnapshotName is the name of the storage class parameter
+	// that sets the name of the source LXD volume snapshot.
+	// See [ParameterSourceSnapshotPool].
+	ParameterSourceSnapshotName = "sourceSnapshotName"
+)
+
+const (
+	// volumeConfigProtected is the LXD volume configuration key that marks
+	// the volume as protected from deletion. It is set when the volume is
+	// created for a storage class with [ParameterProtected] enabled.
+	volumeConfigProtected = "user.lxd-csi.protected"
+
+	// volumeConfigAllowDelete is the LXD volume configuration key that,
+	// when set to "true", allows the deletion of a protected volume.
+	volumeConfigAllowDelete = "user.lxd-csi.allow-delete"
 )
 
 // DriverOptions contains the configurable options for the driver.
@@ -89,17 +317,98 @@ type DriverOptions struct {
 	// CSI endpoint (unix).
 	Endpoint string
 
-	// DevLXD endpoint (unix).
+	// DevLXD endpoint (unix), or a comma separated list of endpoints
+	// that are tried in order until the driver authenticates with one.
 	DevLXDEndpoint string
 
+	// Path to the file containing the devLXD bearer token.
+	// Defaults to [DefaultDevLXDTokenFile] if empty.
+	DevLXDTokenFile string
+
+	// DevLXDConnector establishes a connection to the devLXD server at the
+	// given endpoint using the given bearer token. It allows the driver to be
+	// run in-process against a fake devLXD server.
+	// Defaults to connecting to the devLXD unix socket if nil.
+	DevLXDConnector func(endpoint string, bearerToken string) (DevLXDClient, error)
+
 	// Prefix used for LXD volume names.
 	VolumeNamePrefix string
 
+	// Prefixes used for LXD volume names per storage pool. For volumes in
+	// the listed storage pools, they take precedence over VolumeNamePrefix.
+	PoolPrefixMap map[string]string
+
+	// Prefix used for LXD volume snapshot names. If empty, the prefix of
+	// the requested snapshot name is used, the same as for volume names.
+	SnapshotNamePrefix string
+
+	// Path within the node instance where LXD mounts the filesystem volumes.
+	// It must be the same for controller and node servers.
+	// Defaults to [DefaultFileSystemMountPath] if empty.
+	FileSystemMountPath string
+
 	// ID of the node where the driver is running.
 	NodeID string
 
+	// Names of the LXD instances per Kubernetes node ID. Nodes that are not
+	// listed are expected to run in the LXD instance named after the node ID.
+	NodeInstanceMap map[string]string
+
 	// IsController indicates whether to start controller server.
 	IsController bool
+
+	// EnableSnapshots indicates whether the controller server advertises
+	// and serves volume snapshot requests.
+	EnableSnapshots bool
+
+	// LeaderElection indicates whether the controller server takes part in a
+	// Kubernetes lease-based leader election, and serves controller requests
+	// only while it is the leader.
+	LeaderElection bool
+
+	// Namespace of the leader election lease.
+	// Defaults to the namespace of the pod if empty.
+	LeaderElectionNamespace string
+
+	// DistributedLocking indicates whether the controller server guards volumes
+	// against concurrent requests from other controller replicas using locks
+	// stored in LXD.
+	DistributedLocking bool
+
+	// Policy applied to volumes created by a cancelled CreateVolume request.
+	// Defaults to [DefaultCreateVolumeCancelPolicy] if empty.
+	CreateVolumeCancelPolicy string
+
+	// Policy applied to the LXD snapshots of a volume that is being deleted.
+	// Defaults to [DefaultDeleteVolumeSnapshotsPolicy] if empty.
+	DeleteVolumeSnapshotsPolicy string
+
+	// Maximum number of long-running LXD operations the controller server
+	// runs concurrently. Zero means unlimited.
+	MaxConcurrentOperations int
+
+	// Maximum number of disk devices attached to the node, including devices
+	// not managed by the driver. The node server reports the remaining number
+	// of volumes that can be attached to the node. Zero means unlimited.
+	MaxVolumesPerNode int
+
+	// Number of attempts to unmount a volume. With the default interval, the
+	// default number of attempts makes a failing unmount retried for about 35s.
+	// Defaults to [DefaultUnmountRetries] if zero.
+	UnmountRetries int
+
+	// Initial interval between attempts to unmount a volume, doubled after
+	// each attempt up to 2 seconds, or the interval itself if greater.
+	// Defaults to [DefaultUnmountRetryInterval] if zero.
+	UnmountRetryInterval time.Duration
+
+	// Mode of the directories created as mount targets of filesystem volumes.
+	// Defaults to [fs.DefaultMountTargetDirMode] if zero.
+	MountTargetDirMode os.FileMode
+
+	// Mode of the files created as mount targets of block volumes.
+	// Defaults to [fs.DefaultMountTargetFileMode] if zero.
+	MountTargetFileMode os.FileMode
 }
 
 // Driver represents a CSI driver for LXD.
@@ -115,13 +424,20 @@ type Driver struct {
 	controllerCapabilities []*csi.ControllerServiceCapability
 	nodeCapabilities       []*csi.NodeServiceCapability
 
-	// DevLXD.
-	devLXD         lxdClient.DevLXDServer
+	// DevLXD. The endpoint may contain a comma separated list of endpoints.
+	devLXD         DevLXDClient
 	devLXDEndpoint string
 
+	// DevLXD endpoint the driver has connected to. It is tried first when
+	// the driver reconnects to devLXD.
+	activeDevLXDEndpoint string
+
 	// Path to the file containing the bearer token for authenticating with devLXD.
 	devLXDTokenFile string
 
+	// Function used to connect to devLXD.
+	devLXDConnector func(endpoint string, bearerToken string) (DevLXDClient, error)
+
 	// Whether file containing devLXD bearer token needs to be re-read.
 	hasDevLXDTokenChanged bool
 
@@ -129,9 +445,59 @@ type Driver struct {
 	location    string
 	isClustered bool
 
+	// Plugin manifest reported by the identity server. It is refreshed
+	// whenever the devLXD server information is retrieved.
+	manifest map[string]string
+
 	// Prefix used for LXD volume names.
 	volumeNamePrefix string
 
+	// Prefixes used for LXD volume names per storage pool.
+	poolPrefixMap map[string]string
+
+	// Names of the LXD instances per Kubernetes node ID.
+	nodeInstanceMap map[string]string
+
+	// Prefix used for LXD volume snapshot names.
+	snapshotNamePrefix string
+
+	// Path within the node instance where LXD mounts the filesystem volumes.
+	fileSystemMountPath string
+
+	// Whether volume snapshots are enabled.
+	enableSnapshots bool
+
+	// Whether leader election is enabled, the namespace of its lease, and
+	// whether the driver is currently the leader.
+	leaderElection          bool
+	leaderElectionNamespace string
+	isLeader                atomic.Bool
+
+	// Whether volumes are guarded by locks stored in LXD.
+	distributedLocking bool
+
+	// Policy applied to volumes created by a cancelled CreateVolume request.
+	createVolumeCancelPolicy string
+
+	// Policy applied to the LXD snapshots of a volume that is being deleted.
+	deleteVolumeSnapshotsPolicy string
+
+	// Maximum number of concurrent long-running LXD operations, and the
+	// semaphore enforcing it. The semaphore is nil if unlimited.
+	maxConcurrentOperations int
+	operations              *semaphore.Weighted
+
+	// Maximum number of disk devices attached to the node. Zero means unlimited.
+	maxVolumesPerNode int
+
+	// Number of attempts and interval between them when unmounting a volume.
+	unmountRetries       int
+	unmountRetryInterval time.Duration
+
+	// Modes of the mount targets created for filesystem and block volumes.
+	mountTargetDirMode  os.FileMode
+	mountTargetFileMode os.FileMode
+
 	// gRPC server.
 	server *grpc.Server
 
@@ -146,15 +512,138 @@ func NewDriver(opts DriverOptions) *Driver {
 		version:          driverVersion,
 		endpoint:         opts.Endpoint,
 		devLXDEndpoint:   opts.DevLXDEndpoint,
-		devLXDTokenFile:  DefaultDevLXDTokenFile,
+		devLXDTokenFile:  opts.DevLXDTokenFile,
+		devLXDConnector:  opts.DevLXDConnector,
 		volumeNamePrefix: opts.VolumeNamePrefix,
+		poolPrefixMap:    opts.PoolPrefixMap,
 		nodeID:           opts.NodeID,
+		nodeInstanceMap:  opts.NodeInstanceMap,
 		isController:     opts.IsController,
+
+		fileSystemMountPath:         opts.FileSystemMountPath,
+		snapshotNamePrefix:          opts.SnapshotNamePrefix,
+		enableSnapshots:             opts.EnableSnapshots,
+		leaderElection:              opts.LeaderElection,
+		leaderElectionNamespace:     opts.LeaderElectionNamespace,
+		distributedLocking:          opts.DistributedLocking,
+		createVolumeCancelPolicy:    opts.CreateVolumeCancelPolicy,
+		deleteVolumeSnapshotsPolicy: opts.DeleteVolumeSnapshotsPolicy,
+		maxConcurrentOperations:     opts.MaxConcurrentOperations,
+		maxVolumesPerNode:           opts.MaxVolumesPerNode,
+		unmountRetries:              opts.UnmountRetries,
+		unmountRetryInterval:        opts.UnmountRetryInterval,
+		mountTargetDirMode:          opts.MountTargetDirMode,
+		mountTargetFileMode:         opts.MountTargetFileMode,
+	}
+
+	if d.devLXDEndpoint == "" {
+		d.devLXDEndpoint = DefaultDevLXDEndpoint
+	}
+
+	if d.devLXDTokenFile == "" {
+		d.devLXDTokenFile = DefaultDevLXDTokenFile
+	}
+
+	if d.devLXDConnector == nil {
+		d.devLXDConnector = connectDevLXD
+	}
+
+	if d.fileSystemMountPath == "" {
+		d.fileSystemMountPath = DefaultFileSystemMountPath
+	}
+
+	if d.createVolumeCancelPolicy == "" {
+		d.createVolumeCancelPolicy = DefaultCreateVolumeCancelPolicy
+	}
+
+	if d.deleteVolumeSnapshotsPolicy == "" {
+		d.deleteVolumeSnapshotsPolicy = DefaultDeleteVolumeSnapshotsPolicy
+	}
+
+	if d.maxConcurrentOperations > 0 {
+		d.operations = semaphore.NewWeighted(int64(d.maxConcurrentOperations))
+	}
+
+	if d.unmountRetries == 0 {
+		d.unmountRetries = DefaultUnmountRetries
+	}
+
+	if d.unmountRetryInterval == 0 {
+		d.unmountRetryInterval = DefaultUnmountRetryInterval
+	}
+
+	if d.mountTargetDirMode == 0 {
+		d.mountTargetDirMode = fs.DefaultMountTargetDirMode
+	}
+
+	if d.mountTargetFileMode == 0 {
+		d.mountTargetFileMode = fs.DefaultMountTargetFileMode
 	}
 
 	return d
 }
 
+// ParsePoolPrefixMap parses a comma separated list of "<pool>=<prefix>" pairs
+// into a map of storage pool names to volume name prefixes.
+func ParsePoolPrefixMap(value string) (map[string]string, error) {
+	prefixes := make(map[string]string)
+	if value == "" {
+		return prefixes, nil
+	}
+
+	for entry := range strings.SplitSeq(value, ",") {
+		pool, prefix, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || pool == "" || prefix == "" {
+			return nil, fmt.Errorf("Invalid pool prefix mapping %q: Expected format \"<pool>=<prefix>\"", entry)
+		}
+
+		_, ok = prefixes[pool]
+		if ok {
+			return nil, fmt.Errorf("Invalid pool prefix mapping %q: Duplicate storage pool %q", entry, pool)
+		}
+
+		prefixes[pool] = prefix
+	}
+
+	return prefixes, nil
+}
+
+// ParseNodeInstanceMap parses a comma separated list of "<node>=<instance>" pairs
+// into a map of Kubernetes node IDs to LXD instance names.
+func ParseNodeInstanceMap(value string) (map[string]string, error) {
+	instances := make(map[string]string)
+	if value == "" {
+		return instances, nil
+	}
+
+	for entry := range strings.SplitSeq(value, ",") {
+		node, instance, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || node == "" || instance == "" {
+			return nil, fmt.Errorf("Invalid node instance mapping %q: Expected format \"<node>=<instance>\"", entry)
+		}
+
+		_, ok = instances[node]
+		if ok {
+			return nil, fmt.Errorf("Invalid node instance mapping %q: Duplicate node %q", entry, node)
+		}
+
+		instances[node] = instance
+	}
+
+	return instances, nil
+}
+
+// instanceName returns the name of the LXD instance of the node with the given ID.
+// It defaults to the node ID if the node is not listed in the node instance map.
+func (d *Driver) instanceName(nodeID string) string {
+	instance, ok := d.nodeInstanceMap[nodeID]
+	if ok {
+		return instance
+	}
+
+	return nodeID
+}
+
 // Version returns the driver version.
 func (d *Driver) Version() string {
 	return d.version
@@ -172,12 +661,94 @@ func (d *Driver) Validate() error {
 		return fmt.Errorf("Volume name prefix %q is not valid: %w", d.volumeNamePrefix, err)
 	}
 
+	for pool, prefix := range d.poolPrefixMap {
+		err := lxdValidate.IsHostname(prefix)
+		if err != nil {
+			return fmt.Errorf("Volume name prefix %q for storage pool %q is not valid: %w", prefix, pool, err)
+		}
+	}
+
+	for node, instance := range d.nodeInstanceMap {
+		err := lxdValidate.IsHostname(instance)
+		if err != nil {
+			return fmt.Errorf("Instance name %q for node %q is not valid: %w", instance, node, err)
+		}
+	}
+
+	err = lxdValidate.Optional(lxdValidate.IsHostname)(d.snapshotNamePrefix)
+	if err != nil {
+		return fmt.Errorf("Snapshot name prefix %q is not valid: %w", d.snapshotNamePrefix, err)
+	}
+
+	// Validate devLXD endpoints.
+	if d.devLXDEndpoint != "" {
+		_, err = devlxd.ParseEndpoints(d.devLXDEndpoint)
+		if err != nil {
+			return fmt.Errorf("DevLXD endpoint %q is not valid: %w", d.devLXDEndpoint, err)
+		}
+	}
+
+	// Validate filesystem mount path.
+	if d.fileSystemMountPath != "" && !filepath.IsAbs(d.fileSystemMountPath) {
+		return fmt.Errorf("Filesystem mount path %q must be an absolute path", d.fileSystemMountPath)
+	}
+
+	// Validate leader election configuration.
+	if d.leaderElection && !d.isController {
+		return errors.New("Leader election is only supported by the controller server")
+	}
+
+	err = lxdValidate.Optional(lxdValidate.IsHostname)(d.leaderElectionNamespace)
+	if err != nil {
+		return fmt.Errorf("Leader election namespace %q is not valid: %w", d.leaderElectionNamespace, err)
+	}
+
+	// Validate create volume cancel policy.
+	err = lxdValidate.Optional(lxdValidate.IsOneOf(CreateVolumeCancelPolicyKeep, CreateVolumeCancelPolicyDelete))(d.createVolumeCancelPolicy)
+	if err != nil {
+		return fmt.Errorf("Create volume cancel policy %q is not valid: %w", d.createVolumeCancelPolicy, err)
+	}
+
+	// Validate delete volume snapshots policy.
+	err = lxdValidate.Optional(lxdValidate.IsOneOf(DeleteVolumeSnapshotsPolicyRefuse, DeleteVolumeSnapshotsPolicyDelete))(d.deleteVolumeSnapshotsPolicy)
+	if err != nil {
+		return fmt.Errorf("Delete volume snapshots policy %q is not valid: %w", d.deleteVolumeSnapshotsPolicy, err)
+	}
+
+	// Validate maximum number of concurrent operations.
+	if d.maxConcurrentOperations < 0 {
+		return fmt.Errorf("Maximum concurrent operations %d cannot be negative", d.maxConcurrentOperations)
+	}
+
+	// Validate maximum number of volumes per node.
+	if d.maxVolumesPerNode < 0 {
+		return fmt.Errorf("Maximum volumes per node %d cannot be negative", d.maxVolumesPerNode)
+	}
+
+	// Validate unmount retry configuration.
+	if d.unmountRetries < 0 {
+		return fmt.Errorf("Unmount retries %d cannot be negative", d.unmountRetries)
+	}
+
+	if d.unmountRetryInterval < 0 {
+		return fmt.Errorf("Unmount retry interval %q cannot be negative", d.unmountRetryInterval)
+	}
+
+	// Validate mount target modes. Only permission bits are allowed.
+	if d.mountTargetDirMode&^os.ModePerm != 0 {
+		return fmt.Errorf("Mount target directory mode %#o is not valid: Only permission bits are allowed", uint32(d.mountTargetDirMode))
+	}
+
+	if d.mountTargetFileMode&^os.ModePerm != 0 {
+		return fmt.Errorf("Mount target file mode %#o is not valid: Only permission bits are allowed", uint32(d.mountTargetFileMode))
+	}
+
 	return nil
 }
 
 // DevLXDClient returns the connected DevLXD client.
 // If devLXD token has changed, or connection has not been established yet, a new client is returned.
-func (d *Driver) DevLXDClient() (lxdClient.DevLXDServer, error) {
+func (d *Driver) DevLXDClient() (DevLXDClient, error) {
 	// Return connected client if it exists.
 	d.lock.Lock()
 	defer d.lock.Unlock()
@@ -187,30 +758,85 @@ func (d *Driver) DevLXDClient() (lxdClient.DevLXDServer, error) {
 		return d.devLXD, nil
 	}
 
-	var devLXDClient lxdClient.DevLXDServer
+	var devLXDClient DevLXDClient
+	var info *api.DevLXDGet
 
 	// Read token from the mounted file.
-	tokenBytes, err := os.ReadFile(d.devLXDTokenFile)
+	token, err := d.readDevLXDToken()
 	if err != nil {
-		return nil, fmt.Errorf("Failed reading DevLXD bearer token from file %q: %w", d.devLXDTokenFile, err)
+		return nil, err
 	}
 
-	token := string(tokenBytes)
-
 	// If the client is initialized, but the token has changed, update it.
 	if d.devLXD != nil && d.hasDevLXDTokenChanged {
 		// Update client with new token.
 		devLXDClient = d.devLXD.UseBearerToken(token)
+
+		info, err = getTrustedDevLXDState(devLXDClient)
+		if err != nil {
+			return nil, err
+		}
 	} else {
 		// Connect to DevLXD because DevLXD client is not initialized yet.
-		devLXDClient, err = devlxd.Connect(d.devLXDEndpoint, token)
+		devLXDClient, info, err = d.connectDevLXDEndpoints(token)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	d.devLXD = devLXDClient
+	d.location = info.Location
+	d.isClustered = info.Environment.ServerClustered
+	d.manifest = pluginManifest(info)
+	d.hasDevLXDTokenChanged = false
+
+	return d.devLXD, nil
+}
+
+// connectDevLXDEndpoints connects to the configured devLXD endpoints in order,
+// and returns the client and server information of the first endpoint the
+// driver can authenticate with. The endpoint the driver has connected to
+// before is tried first. The caller must hold the driver lock.
+func (d *Driver) connectDevLXDEndpoints(token string) (DevLXDClient, *api.DevLXDGet, error) {
+	endpoints, err := devlxd.ParseEndpoints(d.devLXDEndpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	i := slices.Index(endpoints, d.activeDevLXDEndpoint)
+	if i > 0 {
+		endpoints = slices.Insert(slices.Delete(endpoints, i, i+1), 0, d.activeDevLXDEndpoint)
+	}
+
+	errs := make([]error, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		client, err := d.devLXDConnector(endpoint, token)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Failed to connect to devLXD at %q: %w", endpoint, err))
+			continue
+		}
+
+		info, err := getTrustedDevLXDState(client)
 		if err != nil {
-			return nil, fmt.Errorf("Failed to connect to devLXD: %w", err)
+			errs = append(errs, fmt.Errorf("Failed to use devLXD at %q: %w", endpoint, err))
+			continue
 		}
+
+		if endpoint != d.activeDevLXDEndpoint && len(endpoints) > 1 {
+			klog.InfoS("Selected devLXD endpoint", "endpoint", endpoint)
+		}
+
+		d.activeDevLXDEndpoint = endpoint
+		return client, info, nil
 	}
 
-	// Refresh DevLXD server information.
-	info, err := devLXDClient.GetState()
+	return nil, nil, errors.Join(errs...)
+}
+
+// getTrustedDevLXDState retrieves the devLXD server information using the given
+// client, and ensures the client is trusted by the devLXD server.
+func getTrustedDevLXDState(client DevLXDClient) (*api.DevLXDGet, error) {
+	info, err := client.GetState()
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get LXD server info: %w", err)
 	}
@@ -222,12 +848,66 @@ func (d *Driver) DevLXDClient() (lxdClient.DevLXDServer, error) {
 		return nil, errors.New("Failed to authenticate with DevLXD server: Client is not trusted")
 	}
 
-	d.devLXD = devLXDClient
-	d.location = info.Location
-	d.isClustered = info.Environment.ServerClustered
-	d.hasDevLXDTokenChanged = false
+	return info, nil
+}
 
-	return d.devLXD, nil
+// pluginManifest returns the plugin manifest describing the LXD server
+// the driver is connected to. It is meant for debugging and therefore
+// must not contain any sensitive information, such as the bearer token.
+func pluginManifest(info *api.DevLXDGet) map[string]string {
+	storageDrivers := make([]string, 0, len(info.SupportedStorageDrivers))
+	for _, driver := range info.SupportedStorageDrivers {
+		storageDrivers = append(storageDrivers, driver.Name)
+	}
+
+	slices.Sort(storageDrivers)
+
+	manifest := map[string]string{
+		"lxdAPIVersion":     info.APIVersion,
+		"lxdClustered":      strconv.FormatBool(info.Environment.ServerClustered),
+		"lxdStorageDrivers": strings.Join(storageDrivers, ","),
+	}
+
+	if info.Location != "" {
+		manifest["lxdLocation"] = info.Location
+	}
+
+	return manifest
+}
+
+// readDevLXDToken reads the devLXD bearer token from the configured token file.
+// Surrounding whitespace is trimmed, as token files are often written with a
+// trailing newline which would otherwise result in an invalid bearer token.
+func (d *Driver) readDevLXDToken() (string, error) {
+	tokenBytes, err := os.ReadFile(d.devLXDTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("Failed reading DevLXD bearer token from file %q: %w", d.devLXDTokenFile, err)
+	}
+
+	token := strings.TrimSpace(string(tokenBytes))
+	if token == "" {
+		return "", fmt.Errorf("DevLXD bearer token file %q is empty", d.devLXDTokenFile)
+	}
+
+	return token, nil
+}
+
+// watchDevLXDTokenFile watches the configured devLXD token file for changes.
+// When the file changes, the token is re-read on the next devLXD operation.
+func (d *Driver) watchDevLXDTokenFile(ctx context.Context) error {
+	handleTokenFileChange := func(path string) {
+		klog.InfoS("DevLXD token file has changed, will re-read it on next operation", "path", path)
+		d.lock.Lock()
+		d.hasDevLXDTokenChanged = true
+		d.lock.Unlock()
+	}
+
+	err := fs.WatchFile(ctx, d.devLXDTokenFile, handleTokenFileChange)
+	if err != nil {
+		return fmt.Errorf("Failed to watch DevLXD token file %q for changes: %w", d.devLXDTokenFile, err)
+	}
+
+	return nil
 }
 
 // Run starts CSI driver gRPC server.
@@ -248,22 +928,27 @@ func (d *Driver) Run() error {
 	}
 
 	// Connect to devLXD.
-	_, err = d.DevLXDClient()
+	err = d.connectDevLXDOnStartup(ctx)
 	if err != nil {
 		return err
 	}
 
 	// Watch for token file changes.
-	handleTokenFileChange := func(path string) {
-		klog.InfoS("DevLXD token file has changed, will re-read it on next operation", "path", path)
-		d.lock.Lock()
-		d.hasDevLXDTokenChanged = true
-		d.lock.Unlock()
+	err = d.watchDevLXDTokenFile(ctx)
+	if err != nil {
+		return err
 	}
 
-	err = fs.WatchFile(ctx, d.devLXDTokenFile, handleTokenFileChange)
-	if err != nil {
-		return fmt.Errorf("Failed to watch DevLXD token file %q for changes: %w", d.devLXDTokenFile, err)
+	// Campaign for the leadership of the controller server. The gRPC server
+	// is started regardless, but rejects controller requests until the
+	// driver becomes the leader.
+	if d.isController && d.leaderElection {
+		lock, err := d.newLeaderElectionLock()
+		if err != nil {
+			return err
+		}
+
+		go d.runLeaderElection(ctx, lock)
 	}
 
 	// Construct gRPC unix address.
@@ -282,23 +967,20 @@ func (d *Driver) Run() error {
 
 	defer func() { _ = listener.Close() }()
 
-	d.server = grpc.NewServer()
+	d.lock.Lock()
+	d.server = grpc.NewServer(grpc.ChainUnaryInterceptor(recoverUnaryInterceptor, d.leaderUnaryInterceptor))
+	d.lock.Unlock()
 
 	// Register CSI services.
 	csi.RegisterIdentityServer(d.server, NewIdentityServer(d))
 
 	if d.isController {
-		d.SetControllerServiceCapabilities(
-			csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-			csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
-			csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
-			csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
-			csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
-		)
+		d.SetControllerServiceCapabilities(d.controllerServiceCapabilities()...)
 
 		csi.RegisterControllerServer(d.server, NewControllerServer(d))
 	} else {
-		d.SetNodeServiceCapabilities()
+		d.SetNodeServiceCapabilities(nodeServiceCapabilities()...)
+
 		csi.RegisterNodeServer(d.server, NewNodeServer(d))
 	}
 
@@ -312,6 +994,17 @@ func (d *Driver) Run() error {
 	return nil
 }
 
+// Stop gracefully stops the gRPC server started by [Driver.Run].
+func (d *Driver) Stop() {
+	d.lock.Lock()
+	server := d.server
+	d.lock.Unlock()
+
+	if server != nil {
+		server.GracefulStop()
+	}
+}
+
 // SetControllerServiceCapabilities sets the controller service capabilities.
 func (d *Driver) SetControllerServiceCapabilities(caps ...csi.ControllerServiceCapability_RPC_Type) {
 	capabilities := make([]*csi.ControllerServiceCapability, len(caps))
@@ -323,6 +1016,45 @@ func (d *Driver) SetControllerServiceCapabilities(caps ...csi.ControllerServiceC
 	d.controllerCapabilities = capabilities
 }
 
+// controllerServiceCapabilities returns the controller service capabilities
+// supported by the driver with its current configuration.
+func (d *Driver) controllerServiceCapabilities() []csi.ControllerServiceCapability_RPC_Type {
+	caps := []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+		csi.ControllerServiceCapability_RPC_MODIFY_VOLUME,
+		csi.ControllerServiceCapability_RPC_PUBLISH_READONLY,
+	}
+
+	if d.enableSnapshots {
+		caps = append(caps, csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT)
+	}
+
+	return caps
+}
+
+// nodeServiceCapabilities returns the node service capabilities supported
+// by the driver.
+func nodeServiceCapabilities() []csi.NodeServiceCapability_RPC_Type {
+	return []csi.NodeServiceCapability_RPC_Type{
+		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+		csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
+	}
+}
+
+// hasControllerServiceCapability returns true if the given controller service capability is enabled.
+func (d *Driver) hasControllerServiceCapability(c csi.ControllerServiceCapability_RPC_Type) bool {
+	for _, capability := range d.controllerCapabilities {
+		if capability.GetRpc().GetType() == c {
+			return true
+		}
+	}
+
+	return false
+}
+
 // SetNodeServiceCapabilities sets the node service capabilities.
 func (d *Driver) SetNodeServiceCapabilities(caps ...csi.NodeServiceCapability_RPC_Type) {
 	capabilities := make([]*csi.NodeServiceCapability, len(caps))
@@ -334,6 +1066,17 @@ func (d *Driver) SetNodeServiceCapabilities(caps ...csi.NodeServiceCapability_RP
 	d.nodeCapabilities = capabilities
 }
 
+// hasNodeServiceCapability returns true if the given node service capability is enabled.
+func (d *Driver) hasNodeServiceCapability(c csi.NodeServiceCapability_RPC_Type) bool {
+	for _, capability := range d.nodeCapabilities {
+		if capability.GetRpc().GetType() == c {
+			return true
+		}
+	}
+
+	return false
+}
+
 // getVolumeID constructs a unique volume ID based on the cluster member,
 // storage pool name, and volume name.
 // Returned value is in format "[<clusterMember>:]<poolName>/<volumeName>".
@@ -347,6 +1090,106 @@ func getVolumeID(clusterMember string, poolName string, volName string) string {
 	return volumeID
 }
 
+// getVolumeName derives the LXD volume name from the name of the CSI volume.
+//
+// Names in format "<prefix>-<uuid>", such as "pvc-<uuid>" generated by the
+// external-provisioner, are shortened by removing all dashes from the UUID.
+// Names without a dash are used as a whole. The given prefix overrides the
+// prefix from the name. If neither is available, [DefaultVolumeNamePrefix]
+// is used.
+//
+// Characters that are not allowed in LXD volume names are removed. If the
+// name had to be sanitized or shortened, a hash of the original name is
+// appended to keep the volume names unique.
+// Returned value is in format "<prefix>-<suffix>".
+func getVolumeName(name string, prefix string) (string, error) {
+	if name == "" {
+		return "", errors.New("Volume name cannot be empty")
+	}
+
+	suffix := name
+	namePrefix, nameSuffix, found := strings.Cut(name, "-")
+	if found && lxdValidate.IsHostname(namePrefix) == nil {
+		suffix = nameSuffix
+		if prefix == "" {
+			prefix = namePrefix
+		}
+	}
+
+	if prefix == "" {
+		prefix = DefaultVolumeNamePrefix
+	}
+
+	suffix = strings.ReplaceAll(suffix, "-", "")
+	sanitized := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+
+		return -1
+	}, suffix)
+
+	// Cap the suffix length, so that the volume name stays within the limits
+	// of LXD storage drivers. UUIDs without dashes are 32 characters long.
+	if len(sanitized) > volumeNameSuffixMaxLength {
+		sanitized = sanitized[:volumeNameSuffixMaxLength-volumeNameHashLength]
+	}
+
+	if sanitized == "" || sanitized != suffix {
+		hash := sha256.Sum256([]byte(name))
+		sanitized += hex.EncodeToString(hash[:])[:volumeNameHashLength]
+	}
+
+	return prefix + "-" + sanitized, nil
+}
+
+// getDeviceName returns the name of the LXD disk device used to attach the
+// volume to a node. The name is derived from a hash of the pool and volume
+// names, which keeps it short and avoids collisions with user-defined devices.
+// Returned value is in format "csi-<hash>".
+func getDeviceName(poolName string, volName string) string {
+	hash := sha256.Sum256([]byte(poolName + "/" + volName))
+	return "csi-" + hex.EncodeToString(hash[:])[:16]
+}
+
+// isVolumeDevice checks whether the given instance device is a disk device
+// backed by the volume from the given storage pool.
+func isVolumeDevice(dev map[string]string, poolName string, volName string) bool {
+	return dev["type"] == "disk" && dev["source"] == volName && dev["pool"] == poolName
+}
+
+// getPublishContext returns the publish context that allows the node plugin
+// to find the attached volume without guessing.
+func getPublishContext(devName string, contentType string) map[string]string {
+	return map[string]string{
+		PublishContextDeviceName:  devName,
+		PublishContextContentType: contentType,
+	}
+}
+
+// isDriverDevice checks whether the given instance device was attached by the
+// driver, either using the hashed device name or the volume name used by older
+// versions of the driver.
+func isDriverDevice(devName string, dev map[string]string) bool {
+	if dev["type"] != "disk" || dev["pool"] == "" || dev["source"] == "" {
+		return false
+	}
+
+	return devName == getDeviceName(dev["pool"], dev["source"]) || devName == dev["source"]
+}
+
+// isVolumeAttached checks whether any device of the given instance is a disk
+// device backed by the volume from the given storage pool.
+func isVolumeAttached(inst *api.DevLXDInstance, poolName string, volName string) bool {
+	for _, dev := range inst.Devices {
+		if isVolumeDevice(dev, poolName, volName) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // splitVolumeID splits an internal volume ID separated into cluster member name,
 // pool name, and volume name.
 func splitVolumeID(volumeID string) (clusterMember string, poolName string, volName string, err error) {
@@ -366,6 +1209,50 @@ func splitVolumeID(volumeID string) (clusterMember string, poolName string, volN
 	return clusterMember, parts[0], parts[1], nil
 }
 
+// getSnapshotName derives the LXD volume snapshot name from the name of the
+// CSI snapshot, the same way as [getVolumeName] derives volume names.
+// The resulting name is validated against the LXD snapshot naming rules.
+func getSnapshotName(name string, prefix string) (string, error) {
+	if name == "" {
+		return "", errors.New("Snapshot name cannot be empty")
+	}
+
+	snapshotName, err := getVolumeName(name, prefix)
+	if err != nil {
+		return "", err
+	}
+
+	err = validateSnapshotName(snapshotName)
+	if err != nil {
+		return "", fmt.Errorf("Snapshot name %q is not valid: %w", snapshotName, err)
+	}
+
+	return snapshotName, nil
+}
+
+// validateSnapshotName checks whether the given name is a valid LXD volume
+// snapshot name. Slashes and colons are rejected as well, as they are used as
+// separators in snapshot IDs.
+func validateSnapshotName(name string) error {
+	if name == "" {
+		return errors.New("Name cannot be empty")
+	}
+
+	if name == "." || name == ".." {
+		return fmt.Errorf("Name cannot be %q", name)
+	}
+
+	if strings.ContainsAny(name, "/:") {
+		return errors.New("Name cannot contain slashes or colons")
+	}
+
+	if strings.ContainsFunc(name, unicode.IsSpace) {
+		return errors.New("Name cannot contain whitespace characters")
+	}
+
+	return nil
+}
+
 // splitSnapshotID splits an internal volume snapshot ID separated into cluster member name,
 // pool name, volume name, and snapshot name.
 func splitSnapshotID(snapshotID string) (clusterMember string, poolName string, volName string, snapshotName string, err error) {
@@ -1,9 +1,20 @@
 package driver
 
 import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/canonical/lxd/shared/api"
 )
 
 func TestValidateDriver(t *testing.T) {
@@ -40,6 +51,195 @@ func TestValidateDriver(t *testing.T) {
 			},
 			expectError: "Name must be 1-63 characters long",
 		},
+		{
+			Name: "Ensure valid pool volume name prefixes are accepted",
+			Driver: &Driver{
+				volumeNamePrefix: "csi",
+				poolPrefixMap:    map[string]string{"fast": "prod", "slow": "scratch"},
+			},
+			expectError: "",
+		},
+		{
+			Name: "Ensure invalid pool volume name prefix is rejected",
+			Driver: &Driver{
+				volumeNamePrefix: "csi",
+				poolPrefixMap:    map[string]string{"fast": "-prod"},
+			},
+			expectError: `Volume name prefix "-prod" for storage pool "fast" is not valid`,
+		},
+		{
+			Name: "Ensure invalid node instance name is rejected",
+			Driver: &Driver{
+				volumeNamePrefix: "csi",
+				nodeInstanceMap:  map[string]string{"worker-1": "k8s_worker_1"},
+			},
+			expectError: `Instance name "k8s_worker_1" for node "worker-1" is not valid`,
+		},
+		{
+			Name: "Ensure invalid snapshot name prefix is rejected",
+			Driver: &Driver{
+				volumeNamePrefix:   "csi",
+				snapshotNamePrefix: "snap_shot",
+			},
+			expectError: "Snapshot name prefix",
+		},
+		{
+			Name: "Ensure negative maximum concurrent operations are rejected",
+			Driver: &Driver{
+				volumeNamePrefix:        "csi",
+				maxConcurrentOperations: -1,
+			},
+			expectError: "Maximum concurrent operations -1 cannot be negative",
+		},
+		{
+			Name: "Ensure valid mount target modes are accepted",
+			Driver: &Driver{
+				volumeNamePrefix:    "csi",
+				mountTargetDirMode:  0755,
+				mountTargetFileMode: 0666,
+			},
+			expectError: "",
+		},
+		{
+			Name: "Ensure mount target directory mode with special bits is rejected",
+			Driver: &Driver{
+				volumeNamePrefix:   "csi",
+				mountTargetDirMode: 0755 | os.ModeSetuid,
+			},
+			expectError: "Mount target directory mode",
+		},
+		{
+			Name: "Ensure mount target file mode with special bits is rejected",
+			Driver: &Driver{
+				volumeNamePrefix:    "csi",
+				mountTargetFileMode: 0666 | os.ModeSticky,
+			},
+			expectError: "Mount target file mode",
+		},
+		{
+			Name: "Ensure valid create volume cancel policy is accepted",
+			Driver: &Driver{
+				volumeNamePrefix:         "csi",
+				createVolumeCancelPolicy: CreateVolumeCancelPolicyDelete,
+			},
+			expectError: "",
+		},
+		{
+			Name: "Ensure invalid create volume cancel policy is rejected",
+			Driver: &Driver{
+				volumeNamePrefix:         "csi",
+				createVolumeCancelPolicy: "ignore",
+			},
+			expectError: `Create volume cancel policy "ignore" is not valid`,
+		},
+		{
+			Name: "Ensure valid delete volume snapshots policy is accepted",
+			Driver: &Driver{
+				volumeNamePrefix:            "csi",
+				deleteVolumeSnapshotsPolicy: DeleteVolumeSnapshotsPolicyDelete,
+			},
+			expectError: "",
+		},
+		{
+			Name: "Ensure invalid delete volume snapshots policy is rejected",
+			Driver: &Driver{
+				volumeNamePrefix:            "csi",
+				deleteVolumeSnapshotsPolicy: "keep",
+			},
+			expectError: `Delete volume snapshots policy "keep" is not valid`,
+		},
+		{
+			Name: "Ensure leader election is accepted for the controller server",
+			Driver: &Driver{
+				volumeNamePrefix:        "csi",
+				isController:            true,
+				leaderElection:          true,
+				leaderElectionNamespace: "lxd-csi",
+			},
+			expectError: "",
+		},
+		{
+			Name: "Ensure leader election is rejected for the node server",
+			Driver: &Driver{
+				volumeNamePrefix: "csi",
+				leaderElection:   true,
+			},
+			expectError: "Leader election is only supported by the controller server",
+		},
+		{
+			Name: "Ensure invalid leader election namespace is rejected",
+			Driver: &Driver{
+				volumeNamePrefix:        "csi",
+				isController:            true,
+				leaderElection:          true,
+				leaderElectionNamespace: "lxd_csi",
+			},
+			expectError: `Leader election namespace "lxd_csi" is not valid`,
+		},
+		{
+			Name: "Ensure absolute filesystem mount path is accepted",
+			Driver: &Driver{
+				volumeNamePrefix:    "csi",
+				fileSystemMountPath: "/var/lib/lxd-csi",
+			},
+			expectError: "",
+		},
+		{
+			Name: "Ensure relative filesystem mount path is rejected",
+			Driver: &Driver{
+				volumeNamePrefix:    "csi",
+				fileSystemMountPath: "mnt/lxd-csi",
+			},
+			expectError: `Filesystem mount path "mnt/lxd-csi" must be an absolute path`,
+		},
+		{
+			Name: "Ensure negative unmount retries are rejected",
+			Driver: &Driver{
+				volumeNamePrefix: "csi",
+				unmountRetries:   -1,
+			},
+			expectError: "Unmount retries -1 cannot be negative",
+		},
+		{
+			Name: "Ensure negative unmount retry interval is rejected",
+			Driver: &Driver{
+				volumeNamePrefix:     "csi",
+				unmountRetryInterval: -time.Second,
+			},
+			expectError: `Unmount retry interval "-1s" cannot be negative`,
+		},
+		{
+			Name: "Ensure multiple devLXD endpoints are accepted",
+			Driver: &Driver{
+				volumeNamePrefix: "csi",
+				devLXDEndpoint:   "unix:///run/lxd/sock,unix:///dev/lxd/sock",
+			},
+			expectError: "",
+		},
+		{
+			Name: "Ensure devLXD endpoint that is not a unix socket is rejected",
+			Driver: &Driver{
+				volumeNamePrefix: "csi",
+				devLXDEndpoint:   "unix:///dev/lxd/sock,tcp://127.0.0.1:8443",
+			},
+			expectError: `Unsupported scheme "tcp"`,
+		},
+		{
+			Name: "Ensure empty devLXD endpoint in list is rejected",
+			Driver: &Driver{
+				volumeNamePrefix: "csi",
+				devLXDEndpoint:   "unix:///dev/lxd/sock,",
+			},
+			expectError: "Endpoint cannot be empty",
+		},
+		{
+			Name: "Ensure duplicate devLXD endpoints are rejected",
+			Driver: &Driver{
+				volumeNamePrefix: "csi",
+				devLXDEndpoint:   "unix:///dev/lxd/sock,unix:///dev/lxd/sock",
+			},
+			expectError: `Duplicate endpoint "unix:///dev/lxd/sock"`,
+		},
 	}
 
 	for _, test := range tests {
@@ -57,3 +257,714 @@ func TestValidateDriver(t *testing.T) {
 		})
 	}
 }
+
+func TestNewDriverDevLXDTokenFile(t *testing.T) {
+	// Default token file is used when not configured.
+	d := NewDriver(DriverOptions{})
+	require.Equal(t, DefaultDevLXDTokenFile, d.devLXDTokenFile)
+
+	tokenFile := filepath.Join(t.TempDir(), "custom-token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("initial-token"), 0o600))
+
+	d = NewDriver(DriverOptions{DevLXDTokenFile: tokenFile})
+	require.Equal(t, tokenFile, d.devLXDTokenFile)
+
+	// Token is read from the custom path.
+	token, err := d.readDevLXDToken()
+	require.NoError(t, err)
+	require.Equal(t, "initial-token", token)
+
+	// Custom path is watched for changes.
+	require.NoError(t, d.watchDevLXDTokenFile(t.Context()))
+	require.NoError(t, os.WriteFile(tokenFile, []byte("rotated-token"), 0o600))
+
+	require.Eventually(t, func() bool {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		return d.hasDevLXDTokenChanged
+	}, time.Second, 10*time.Millisecond)
+
+	token, err = d.readDevLXDToken()
+	require.NoError(t, err)
+	require.Equal(t, "rotated-token", token)
+}
+
+// fakeTokenDevLXDServer records the bearer token used by the devLXD client.
+type fakeTokenDevLXDServer struct {
+	fakeDevLXDServer
+
+	token string
+}
+
+func (f *fakeTokenDevLXDServer) UseBearerToken(token string) DevLXDClient {
+	f.token = token
+	return f
+}
+
+func (f *fakeTokenDevLXDServer) GetState() (*api.DevLXDGet, error) {
+	if f.token != "secret-token" {
+		return &api.DevLXDGet{DevLXDGetUntrusted: api.DevLXDGetUntrusted{Auth: api.AuthUntrusted}}, nil
+	}
+
+	return &api.DevLXDGet{DevLXDGetUntrusted: api.DevLXDGetUntrusted{Auth: api.AuthTrusted}}, nil
+}
+
+func TestDevLXDClientTokenTrimmed(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Content     string
+		expectError string
+	}{
+		{
+			Name:    "Token without trailing newline",
+			Content: "secret-token",
+		},
+		{
+			Name:    "Token with trailing newline",
+			Content: "secret-token\n",
+		},
+		{
+			Name:    "Token with surrounding whitespace",
+			Content: "  secret-token \r\n",
+		},
+		{
+			Name:        "Empty token",
+			Content:     "\n",
+			expectError: "is empty",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			tokenFile := filepath.Join(t.TempDir(), "token")
+			require.NoError(t, os.WriteFile(tokenFile, []byte(test.Content), 0o600))
+
+			// Simulate token refresh on an already connected client.
+			fakeClient := &fakeTokenDevLXDServer{}
+			d := NewDriver(DriverOptions{DevLXDTokenFile: tokenFile})
+			d.devLXD = fakeClient
+			d.hasDevLXDTokenChanged = true
+
+			_, err := d.DevLXDClient()
+			if test.expectError != "" {
+				require.ErrorContains(t, err, test.expectError)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, "secret-token", fakeClient.token)
+			require.False(t, d.hasDevLXDTokenChanged)
+		})
+	}
+}
+
+func TestDevLXDClientReconnect(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	writeToken := func(token string) {
+		require.NoError(t, os.WriteFile(tokenFile, []byte(token), 0o600))
+	}
+
+	fakeClient := &fakeTokenDevLXDServer{}
+
+	var connects []string
+	d := NewDriver(DriverOptions{
+		DevLXDTokenFile: tokenFile,
+		DevLXDConnector: func(endpoint string, bearerToken string) (DevLXDClient, error) {
+			connects = append(connects, bearerToken)
+			fakeClient.token = bearerToken
+			return fakeClient, nil
+		},
+	})
+
+	// Missing token file.
+	_, err := d.DevLXDClient()
+	require.ErrorContains(t, err, "Failed reading DevLXD bearer token")
+	require.Empty(t, connects)
+	require.Nil(t, d.devLXD)
+
+	// Untrusted client is rejected on first connect.
+	writeToken("wrong-token")
+
+	_, err = d.DevLXDClient()
+	require.ErrorContains(t, err, "Client is not trusted")
+	require.Equal(t, []string{"wrong-token"}, connects)
+	require.Nil(t, d.devLXD)
+
+	// First connect.
+	writeToken("secret-token")
+
+	client, err := d.DevLXDClient()
+	require.NoError(t, err)
+	require.Same(t, fakeClient, client)
+	require.Equal(t, []string{"wrong-token", "secret-token"}, connects)
+
+	// Connected client is reused, and the token file is not read again
+	// until the token is reported as changed.
+	writeToken("rotated-token")
+
+	for range 3 {
+		client, err = d.DevLXDClient()
+		require.NoError(t, err)
+		require.Same(t, fakeClient, client)
+	}
+
+	require.Len(t, connects, 2)
+	require.Equal(t, "secret-token", fakeClient.token)
+
+	// Changed token is applied to the existing client without reconnecting.
+	// The client is rejected as the rotated token is not trusted, and the
+	// token is re-read on the next call.
+	d.hasDevLXDTokenChanged = true
+
+	_, err = d.DevLXDClient()
+	require.ErrorContains(t, err, "Client is not trusted")
+	require.Equal(t, "rotated-token", fakeClient.token)
+	require.True(t, d.hasDevLXDTokenChanged)
+
+	writeToken("secret-token")
+
+	client, err = d.DevLXDClient()
+	require.NoError(t, err)
+	require.Same(t, fakeClient, client)
+	require.Equal(t, "secret-token", fakeClient.token)
+	require.False(t, d.hasDevLXDTokenChanged)
+	require.Len(t, connects, 2)
+
+	// Concurrent callers share the connected client.
+	d.devLXD = nil
+	connects = nil
+
+	errs := make(chan error, 10)
+	for range cap(errs) {
+		go func() {
+			_, err := d.DevLXDClient()
+			errs <- err
+		}()
+	}
+
+	for range cap(errs) {
+		require.NoError(t, <-errs)
+	}
+
+	require.Equal(t, []string{"secret-token"}, connects)
+}
+
+func TestDevLXDClientEndpoints(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret-token"), 0o600))
+
+	trustedClient := &fakeDevLXDServer{
+		getStateFunc: func() (*api.DevLXDGet, error) {
+			return &api.DevLXDGet{DevLXDGetUntrusted: api.DevLXDGetUntrusted{Auth: api.AuthTrusted}}, nil
+		},
+	}
+
+	untrustedClient := &fakeDevLXDServer{
+		getStateFunc: func() (*api.DevLXDGet, error) {
+			return &api.DevLXDGet{DevLXDGetUntrusted: api.DevLXDGetUntrusted{Auth: api.AuthUntrusted}}, nil
+		},
+	}
+
+	// Endpoints and the clients they connect to. Endpoints without a client fail to connect.
+	clients := map[string]DevLXDClient{
+		"unix:///run/lxd/untrusted.sock": untrustedClient,
+		"unix:///run/lxd/trusted.sock":   trustedClient,
+		"unix:///dev/lxd/sock":           trustedClient,
+	}
+
+	var attempts []string
+	connector := func(endpoint string, bearerToken string) (DevLXDClient, error) {
+		attempts = append(attempts, endpoint)
+
+		client, ok := clients[endpoint]
+		if !ok {
+			return nil, errors.New("Socket does not exist")
+		}
+
+		return client, nil
+	}
+
+	d := NewDriver(DriverOptions{
+		DevLXDEndpoint:  "unix:///run/lxd/missing.sock, unix:///run/lxd/untrusted.sock,unix:///run/lxd/trusted.sock,unix:///dev/lxd/sock",
+		DevLXDTokenFile: tokenFile,
+		DevLXDConnector: connector,
+	})
+
+	// Endpoints are tried in order until the driver authenticates with one.
+	client, err := d.DevLXDClient()
+	require.NoError(t, err)
+	require.Same(t, trustedClient, client)
+	require.Equal(t, "unix:///run/lxd/trusted.sock", d.activeDevLXDEndpoint)
+	require.Equal(t, []string{"unix:///run/lxd/missing.sock", "unix:///run/lxd/untrusted.sock", "unix:///run/lxd/trusted.sock"}, attempts)
+
+	// The working endpoint is tried first on reconnect.
+	d.devLXD = nil
+	attempts = nil
+
+	_, err = d.DevLXDClient()
+	require.NoError(t, err)
+	require.Equal(t, []string{"unix:///run/lxd/trusted.sock"}, attempts)
+
+	// If the working endpoint fails, the remaining endpoints are tried in order.
+	delete(clients, "unix:///run/lxd/trusted.sock")
+	d.devLXD = nil
+	attempts = nil
+
+	_, err = d.DevLXDClient()
+	require.NoError(t, err)
+	require.Equal(t, "unix:///dev/lxd/sock", d.activeDevLXDEndpoint)
+	require.Equal(t, []string{"unix:///run/lxd/trusted.sock", "unix:///run/lxd/missing.sock", "unix:///run/lxd/untrusted.sock", "unix:///dev/lxd/sock"}, attempts)
+
+	// An error for each endpoint is returned if none of them works.
+	delete(clients, "unix:///dev/lxd/sock")
+	d.devLXD = nil
+
+	_, err = d.DevLXDClient()
+	require.ErrorContains(t, err, `Failed to connect to devLXD at "unix:///run/lxd/missing.sock": Socket does not exist`)
+	require.ErrorContains(t, err, `Failed to use devLXD at "unix:///run/lxd/untrusted.sock": Failed to authenticate with DevLXD server: Client is not trusted`)
+	require.ErrorContains(t, err, `Failed to connect to devLXD at "unix:///dev/lxd/sock": Socket does not exist`)
+}
+
+func TestGetPluginInfoManifest(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret-token"), 0o600))
+
+	fakeClient := &fakeDevLXDServer{
+		getStateFunc: func() (*api.DevLXDGet, error) {
+			return &api.DevLXDGet{
+				DevLXDGetUntrusted: api.DevLXDGetUntrusted{
+					APIVersion: "1.0",
+					Location:   "lxd01",
+					Auth:       api.AuthTrusted,
+					SupportedStorageDrivers: []api.DevLXDServerStorageDriverInfo{
+						{Name: "zfs", Remote: false},
+						{Name: "ceph", Remote: true},
+					},
+				},
+				Environment: api.DevLXDServerEnvironment{ServerClustered: true},
+			}, nil
+		},
+	}
+
+	d := NewDriver(DriverOptions{
+		Name:            DefaultDriverName,
+		DevLXDTokenFile: tokenFile,
+		DevLXDConnector: func(endpoint string, bearerToken string) (DevLXDClient, error) {
+			return fakeClient, nil
+		},
+	})
+
+	identity := NewIdentityServer(d)
+
+	// Manifest is empty until the driver connects to devLXD.
+	resp, err := identity.GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+	require.NoError(t, err)
+	require.Equal(t, DefaultDriverName, resp.Name)
+	require.Empty(t, resp.Manifest)
+
+	_, err = d.DevLXDClient()
+	require.NoError(t, err)
+
+	resp, err = identity.GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"lxdAPIVersion":     "1.0",
+		"lxdClustered":      "true",
+		"lxdLocation":       "lxd01",
+		"lxdStorageDrivers": "ceph,zfs",
+	}, resp.Manifest)
+}
+
+func TestGetDeviceName(t *testing.T) {
+	devName := getDeviceName("remote", "pvc-9f8b3c1e2d4a4b6c8e0f1a2b3c4d5e6f")
+
+	// Device name is deterministic and short.
+	require.Equal(t, devName, getDeviceName("remote", "pvc-9f8b3c1e2d4a4b6c8e0f1a2b3c4d5e6f"))
+	require.Regexp(t, `^csi-[0-9a-f]{16}$`, devName)
+
+	// Device name depends on both the storage pool and the volume name.
+	require.NotEqual(t, devName, getDeviceName("local", "pvc-9f8b3c1e2d4a4b6c8e0f1a2b3c4d5e6f"))
+	require.NotEqual(t, devName, getDeviceName("remote", "pvc-0f8b3c1e2d4a4b6c8e0f1a2b3c4d5e6f"))
+}
+
+func TestNewDriverFileSystemMountPath(t *testing.T) {
+	// Default mount path is used when not configured.
+	d := NewDriver(DriverOptions{})
+	require.Equal(t, DefaultFileSystemMountPath, d.fileSystemMountPath)
+
+	d = NewDriver(DriverOptions{FileSystemMountPath: "/var/lib/lxd-csi"})
+	require.Equal(t, "/var/lib/lxd-csi", d.fileSystemMountPath)
+}
+
+func TestControllerServiceCapabilitiesSnapshots(t *testing.T) {
+	tests := []struct {
+		Name            string
+		EnableSnapshots bool
+	}{
+		{Name: "Snapshots enabled", EnableSnapshots: true},
+		{Name: "Snapshots disabled", EnableSnapshots: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			d := NewDriver(DriverOptions{EnableSnapshots: test.EnableSnapshots})
+			d.devLXD = &fakeDevLXDServer{}
+			d.SetControllerServiceCapabilities(d.controllerServiceCapabilities()...)
+
+			require.Equal(t, test.EnableSnapshots, d.hasControllerServiceCapability(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT))
+			require.True(t, d.hasControllerServiceCapability(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME))
+
+			controller := NewControllerServer(d)
+
+			_, err := controller.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{})
+			if test.EnableSnapshots {
+				require.NotEqual(t, codes.Unimplemented, status.Code(err))
+			} else {
+				require.Equal(t, codes.Unimplemented, status.Code(err))
+			}
+
+			_, err = controller.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{})
+			if test.EnableSnapshots {
+				require.NotEqual(t, codes.Unimplemented, status.Code(err))
+			} else {
+				require.Equal(t, codes.Unimplemented, status.Code(err))
+			}
+		})
+	}
+}
+
+func TestParsePoolPrefixMap(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Value       string
+		expectMap   map[string]string
+		expectError string
+	}{
+		{
+			Name:      "Empty value",
+			Value:     "",
+			expectMap: map[string]string{},
+		},
+		{
+			Name:      "Single mapping",
+			Value:     "fast=prod",
+			expectMap: map[string]string{"fast": "prod"},
+		},
+		{
+			Name:      "Multiple mappings with surrounding whitespace",
+			Value:     "fast=prod, slow=scratch",
+			expectMap: map[string]string{"fast": "prod", "slow": "scratch"},
+		},
+		{
+			Name:        "Missing separator",
+			Value:       "fast",
+			expectError: `Invalid pool prefix mapping "fast"`,
+		},
+		{
+			Name:        "Missing prefix",
+			Value:       "fast=",
+			expectError: `Invalid pool prefix mapping "fast="`,
+		},
+		{
+			Name:        "Missing pool",
+			Value:       "=prod",
+			expectError: `Invalid pool prefix mapping "=prod"`,
+		},
+		{
+			Name:        "Trailing comma",
+			Value:       "fast=prod,",
+			expectError: `Invalid pool prefix mapping ""`,
+		},
+		{
+			Name:        "Duplicate pool",
+			Value:       "fast=prod,fast=scratch",
+			expectError: `Duplicate storage pool "fast"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			prefixes, err := ParsePoolPrefixMap(test.Value)
+			if test.expectError != "" {
+				require.ErrorContains(t, err, test.expectError)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.expectMap, prefixes)
+		})
+	}
+}
+
+func TestParseNodeInstanceMap(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Value       string
+		expectMap   map[string]string
+		expectError string
+	}{
+		{
+			Name:      "Empty value",
+			Value:     "",
+			expectMap: map[string]string{},
+		},
+		{
+			Name:      "Multiple mappings with surrounding whitespace",
+			Value:     "worker-1=k8s-worker-1, worker-2=k8s-worker-2",
+			expectMap: map[string]string{"worker-1": "k8s-worker-1", "worker-2": "k8s-worker-2"},
+		},
+		{
+			Name:        "Missing instance",
+			Value:       "worker-1=",
+			expectError: `Invalid node instance mapping "worker-1="`,
+		},
+		{
+			Name:        "Duplicate node",
+			Value:       "worker-1=k8s-worker-1,worker-1=k8s-worker-2",
+			expectError: `Duplicate node "worker-1"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			instances, err := ParseNodeInstanceMap(test.Value)
+			if test.expectError != "" {
+				require.ErrorContains(t, err, test.expectError)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.expectMap, instances)
+		})
+	}
+}
+
+func TestGetVolumeName(t *testing.T) {
+	tests := []struct {
+		Name        string
+		VolumeName  string
+		Prefix      string
+		expectName  string
+		expectMatch string
+		expectError string
+	}{
+		{
+			Name:       "Provisioner generated name",
+			VolumeName: "pvc-1f0e5a3c-8d2b-4c6e-9f71-0a2b3c4d5e6f",
+			expectName: "pvc-1f0e5a3c8d2b4c6e9f710a2b3c4d5e6f",
+		},
+		{
+			Name:       "Provisioner generated name with prefix override",
+			VolumeName: "pvc-1f0e5a3c-8d2b-4c6e-9f71-0a2b3c4d5e6f",
+			Prefix:     "csi",
+			expectName: "csi-1f0e5a3c8d2b4c6e9f710a2b3c4d5e6f",
+		},
+		{
+			Name:       "Custom name without a dash",
+			VolumeName: "customname",
+			expectName: "csi-customname",
+		},
+		{
+			Name:       "Custom name without a dash with prefix override",
+			VolumeName: "customname",
+			Prefix:     "prod",
+			expectName: "prod-customname",
+		},
+		{
+			Name:        "Custom name with invalid characters",
+			VolumeName:  "custom_name",
+			expectMatch: `^csi-customname[0-9a-f]{8}$`,
+		},
+		{
+			Name:        "Custom name with invalid prefix",
+			VolumeName:  "my_pvc-data",
+			expectMatch: `^csi-mypvcdata[0-9a-f]{8}$`,
+		},
+		{
+			Name:        "Name without suffix",
+			VolumeName:  "pvc-",
+			expectMatch: `^pvc-[0-9a-f]{8}$`,
+		},
+		{
+			Name:        "Long custom name",
+			VolumeName:  strings.Repeat("a", 100),
+			expectMatch: `^csi-a{40}[0-9a-f]{8}$`,
+		},
+		{
+			Name:        "Empty name",
+			VolumeName:  "",
+			expectError: "Volume name cannot be empty",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			volName, err := getVolumeName(test.VolumeName, test.Prefix)
+			if test.expectError != "" {
+				require.ErrorContains(t, err, test.expectError)
+				return
+			}
+
+			require.NoError(t, err)
+			if test.expectMatch != "" {
+				require.Regexp(t, test.expectMatch, volName)
+			} else {
+				require.Equal(t, test.expectName, volName)
+			}
+		})
+	}
+
+	// Names that differ only in removed characters must not collide.
+	volName1, err := getVolumeName("custom_name", "")
+	require.NoError(t, err)
+	volName2, err := getVolumeName("custom.name", "")
+	require.NoError(t, err)
+	require.NotEqual(t, volName1, volName2)
+}
+
+func TestGetSnapshotName(t *testing.T) {
+	tests := []struct {
+		Name           string
+		SnapshotName   string
+		Prefix         string
+		SourceVolumeID string
+		expectName     string
+		expectMatch    string
+		expectError    string
+	}{
+		{
+			Name:           "Snapshotter generated name",
+			SnapshotName:   "snapshot-5d1c7b2e-9a4f-4e3b-8c6d-7f8e9a0b1c2d",
+			SourceVolumeID: "remote/csi-1f0e5a3c8d2b4c6e9f710a2b3c4d5e6f",
+			expectName:     "snapshot-5d1c7b2e9a4f4e3b8c6d7f8e9a0b1c2d",
+		},
+		{
+			Name:           "Snapshotter generated name with prefix override",
+			SnapshotName:   "snapshot-5d1c7b2e-9a4f-4e3b-8c6d-7f8e9a0b1c2d",
+			Prefix:         "backup",
+			SourceVolumeID: "member1:local/csi-1f0e5a3c8d2b4c6e9f710a2b3c4d5e6f",
+			expectName:     "backup-5d1c7b2e9a4f4e3b8c6d7f8e9a0b1c2d",
+		},
+		{
+			Name:           "Custom name without a dash",
+			SnapshotName:   "nightly",
+			SourceVolumeID: "remote/csi-vol",
+			expectName:     "csi-nightly",
+		},
+		{
+			Name:           "Custom name with separator characters",
+			SnapshotName:   "daily/backup:1",
+			SourceVolumeID: "member1:remote/csi-vol",
+			expectMatch:    `^csi-dailybackup1[0-9a-f]{8}$`,
+		},
+		{
+			Name:         "Empty name",
+			SnapshotName: "",
+			expectError:  "Snapshot name cannot be empty",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			snapshotName, err := getSnapshotName(test.SnapshotName, test.Prefix)
+			if test.expectError != "" {
+				require.ErrorContains(t, err, test.expectError)
+				return
+			}
+
+			require.NoError(t, err)
+			if test.expectMatch != "" {
+				require.Regexp(t, test.expectMatch, snapshotName)
+			} else {
+				require.Equal(t, test.expectName, snapshotName)
+			}
+
+			// Ensure the snapshot ID round-trips.
+			expectTarget, expectPool, expectVolume, err := splitVolumeID(test.SourceVolumeID)
+			require.NoError(t, err)
+
+			target, poolName, volName, parsedSnapshotName, err := splitSnapshotID(test.SourceVolumeID + "/" + snapshotName)
+			require.NoError(t, err)
+			require.Equal(t, expectTarget, target)
+			require.Equal(t, expectPool, poolName)
+			require.Equal(t, expectVolume, volName)
+			require.Equal(t, snapshotName, parsedSnapshotName)
+		})
+	}
+}
+
+func TestValidateSnapshotName(t *testing.T) {
+	tests := []struct {
+		Name         string
+		SnapshotName string
+		expectError  string
+	}{
+		{Name: "Valid name", SnapshotName: "snapshot-abc123"},
+		{Name: "Empty name", SnapshotName: "", expectError: "Name cannot be empty"},
+		{Name: "Dot", SnapshotName: ".", expectError: `Name cannot be "."`},
+		{Name: "Double dot", SnapshotName: "..", expectError: `Name cannot be ".."`},
+		{Name: "Slash", SnapshotName: "snap/shot", expectError: "Name cannot contain slashes or colons"},
+		{Name: "Colon", SnapshotName: "snap:shot", expectError: "Name cannot contain slashes or colons"},
+		{Name: "Whitespace", SnapshotName: "snap shot", expectError: "Name cannot contain whitespace characters"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			err := validateSnapshotName(test.SnapshotName)
+			if test.expectError != "" {
+				require.ErrorContains(t, err, test.expectError)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestIsVolumeAttached(t *testing.T) {
+	tests := []struct {
+		Name         string
+		Devices      map[string]map[string]string
+		expectResult bool
+	}{
+		{
+			Name:         "No devices",
+			expectResult: false,
+		},
+		{
+			Name: "Volume attached using device name",
+			Devices: map[string]map[string]string{
+				getDeviceName("remote", "pvc-vol"): {"type": "disk", "pool": "remote", "source": "pvc-vol"},
+			},
+			expectResult: true,
+		},
+		{
+			Name: "Volume attached by older driver version",
+			Devices: map[string]map[string]string{
+				"pvc-vol": {"type": "disk", "pool": "remote", "source": "pvc-vol"},
+			},
+			expectResult: true,
+		},
+		{
+			Name: "Volume with the same name from another pool",
+			Devices: map[string]map[string]string{
+				"data": {"type": "disk", "pool": "local", "source": "pvc-vol"},
+			},
+			expectResult: false,
+		},
+		{
+			Name: "Other devices",
+			Devices: map[string]map[string]string{
+				"eth0": {"type": "nic", "network": "lxdbr0"},
+				"root": {"type": "disk", "pool": "remote", "path": "/"},
+			},
+			expectResult: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			inst := &api.DevLXDInstance{Name: "node", Devices: test.Devices}
+			require.Equal(t, test.expectResult, isVolumeAttached(inst, "remote", "pvc-vol"))
+		})
+	}
+}
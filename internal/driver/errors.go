@@ -0,0 +1,69 @@
+package driver
+
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Reasons attached as ErrorInfo details to the errors returned by the controller.
+// They allow clients to distinguish the cause of an error without parsing the
+// human-readable error message.
+const (
+	// ErrorReasonStoragePoolNotFound indicates that the storage pool does not exist.
+	ErrorReasonStoragePoolNotFound = "STORAGE_POOL_NOT_FOUND"
+
+	// ErrorReasonVolumeNotFound indicates that the volume does not exist.
+	ErrorReasonVolumeNotFound = "VOLUME_NOT_FOUND"
+
+	// ErrorReasonUnsupportedStorageDriver indicates that the storage driver of
+	// the storage pool does not support the requested operation.
+	ErrorReasonUnsupportedStorageDriver = "UNSUPPORTED_STORAGE_DRIVER"
+
+	// ErrorReasonVolumeSizeTooLarge indicates that the requested volume size
+	// exceeds the maximum volume size of the storage class.
+	ErrorReasonVolumeSizeTooLarge = "VOLUME_SIZE_TOO_LARGE"
+
+	// ErrorReasonVolumeSizeLimitTooSmall indicates that the volume size limit
+	// of the request is below the minimum volume size.
+	ErrorReasonVolumeSizeLimitTooSmall = "VOLUME_SIZE_LIMIT_TOO_SMALL"
+
+	// ErrorReasonVolumeShrinkUnsupported indicates that the requested volume size
+	// is smaller than the current volume size.
+	ErrorReasonVolumeShrinkUnsupported = "VOLUME_SHRINK_UNSUPPORTED"
+
+	// ErrorReasonVolumeInUse indicates that the volume cannot be modified
+	// while it is attached to a node.
+	ErrorReasonVolumeInUse = "VOLUME_IN_USE"
+
+	// ErrorReasonVolumeProtected indicates that the volume is protected
+	// from deletion.
+	ErrorReasonVolumeProtected = "VOLUME_PROTECTED"
+
+	// ErrorReasonVolumeHasSnapshots indicates that the volume cannot be deleted
+	// while it has snapshots.
+	ErrorReasonVolumeHasSnapshots = "VOLUME_HAS_SNAPSHOTS"
+)
+
+// errorInfoDomain is the domain of the ErrorInfo details, which identifies
+// the driver as the source of the error reasons.
+const errorInfoDomain = DefaultDriverName
+
+// statusWithReason returns a gRPC status error with the given code and message,
+// and attaches ErrorInfo details with the given reason and metadata. The message
+// is the same as the one of the corresponding [status.Errorf] error.
+func statusWithReason(code codes.Code, reason string, metadata map[string]string, format string, args ...any) error {
+	st := status.Newf(code, format, args...)
+
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   errorInfoDomain,
+		Metadata: metadata,
+	})
+	if err != nil {
+		// Details cannot be attached to a status with the OK code.
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
@@ -0,0 +1,169 @@
+package driver
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/canonical/lxd/shared/api"
+)
+
+// requireErrorInfo asserts that the given error is a gRPC status error with
+// the given code and a single ErrorInfo detail, and returns the detail.
+func requireErrorInfo(t *testing.T, err error, code codes.Code) *errdetails.ErrorInfo {
+	t.Helper()
+
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, code, st.Code())
+
+	details := st.Details()
+	require.Len(t, details, 1)
+
+	info, ok := details[0].(*errdetails.ErrorInfo)
+	require.True(t, ok, "Expected ErrorInfo detail, got %T", details[0])
+	require.Equal(t, DefaultDriverName, info.Domain)
+
+	return info
+}
+
+func TestStatusWithReason(t *testing.T) {
+	err := statusWithReason(codes.NotFound, ErrorReasonStoragePoolNotFound, map[string]string{"storagePool": "local"}, "CreateVolume: Storage pool %q not found", "local")
+
+	require.Equal(t, `CreateVolume: Storage pool "local" not found`, status.Convert(err).Message())
+
+	info := requireErrorInfo(t, err, codes.NotFound)
+	require.Equal(t, ErrorReasonStoragePoolNotFound, info.Reason)
+	require.Equal(t, map[string]string{"storagePool": "local"}, info.Metadata)
+
+	// Details cannot be attached to an OK status.
+	err = statusWithReason(codes.OK, ErrorReasonStoragePoolNotFound, nil, "OK")
+	require.NoError(t, err)
+}
+
+func TestControllerErrorDetails(t *testing.T) {
+	const GiB = 1024 * 1024 * 1024
+
+	mountCapability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{},
+		},
+	}
+
+	lvmClient := func(getVol func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error)) *fakeDevLXDServer {
+		client := newFakePoolDevLXDServer("lvm", false)
+		client.getVolFunc = getVol
+		return client
+	}
+
+	tests := []struct {
+		Name           string
+		Client         *fakeDevLXDServer
+		Call           func(controller *controllerServer) error
+		expectCode     codes.Code
+		expectMessage  string
+		expectReason   string
+		expectMetadata map[string]string
+	}{
+		{
+			Name: "CreateVolume with missing storage pool",
+			Client: &fakeDevLXDServer{
+				getPoolFunc: func(pool string) (*api.DevLXDStoragePool, string, error) {
+					return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage pool not found")
+				},
+			},
+			Call: func(controller *controllerServer) error {
+				_, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+					Name:               "pvc-3b8e1f2a-9c4d-4e6f-a0b1-c2d3e4f5a6b7",
+					CapacityRange:      &csi.CapacityRange{RequiredBytes: GiB},
+					VolumeCapabilities: []*csi.VolumeCapability{mountCapability},
+					Parameters:         map[string]string{ParameterStoragePool: "missing"},
+				})
+				return err
+			},
+			expectCode:     codes.NotFound,
+			expectMessage:  `Failed to retrieve storage pool "missing"`,
+			expectReason:   ErrorReasonStoragePoolNotFound,
+			expectMetadata: map[string]string{"storagePool": "missing"},
+		},
+		{
+			Name:   "CreateVolume above maximum volume size",
+			Client: lvmClient(nil),
+			Call: func(controller *controllerServer) error {
+				_, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+					Name:               "pvc-3b8e1f2a-9c4d-4e6f-a0b1-c2d3e4f5a6b7",
+					CapacityRange:      &csi.CapacityRange{RequiredBytes: 2 * GiB},
+					VolumeCapabilities: []*csi.VolumeCapability{mountCapability},
+					Parameters: map[string]string{
+						ParameterStoragePool:   "local",
+						ParameterMaxVolumeSize: "1GiB",
+					},
+				})
+				return err
+			},
+			expectCode:     codes.OutOfRange,
+			expectMessage:  "Requested volume size 2.00GiB exceeds the maximum volume size 1.00GiB of the storage class",
+			expectReason:   ErrorReasonVolumeSizeTooLarge,
+			expectMetadata: map[string]string{"requestedBytes": "2147483648", "maxBytes": "1073741824"},
+		},
+		{
+			Name: "ExpandVolume with missing volume",
+			Client: lvmClient(func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+				return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage volume not found")
+			}),
+			Call: func(controller *controllerServer) error {
+				_, err := controller.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+					VolumeId:         "local/pvc-vol",
+					CapacityRange:    &csi.CapacityRange{RequiredBytes: GiB},
+					VolumeCapability: mountCapability,
+				})
+				return err
+			},
+			expectCode:     codes.NotFound,
+			expectMessage:  "Storage volume not found",
+			expectReason:   ErrorReasonVolumeNotFound,
+			expectMetadata: map[string]string{"storagePool": "local", "volume": "pvc-vol"},
+		},
+		{
+			Name: "ExpandVolume shrinking volume",
+			Client: lvmClient(func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+				return &api.DevLXDStorageVolume{Name: name, Config: map[string]string{"size": "2147483648"}}, "", nil
+			}),
+			Call: func(controller *controllerServer) error {
+				_, err := controller.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+					VolumeId:         "local/pvc-vol",
+					CapacityRange:    &csi.CapacityRange{RequiredBytes: GiB},
+					VolumeCapability: mountCapability,
+				})
+				return err
+			},
+			expectCode:     codes.InvalidArgument,
+			expectMessage:  `Requested size "1.00GiB" is less than the current size "2.00GiB"`,
+			expectReason:   ErrorReasonVolumeShrinkUnsupported,
+			expectMetadata: map[string]string{"requestedBytes": "1073741824", "currentBytes": "2147483648"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			controller := NewControllerServer(&Driver{devLXD: test.Client})
+
+			err := test.Call(controller)
+			require.Error(t, err)
+			require.ErrorContains(t, err, test.expectMessage)
+
+			info := requireErrorInfo(t, err, test.expectCode)
+			require.Equal(t, test.expectReason, info.Reason)
+			require.Equal(t, test.expectMetadata, info.Metadata)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package driver
 
 import (
 	"context"
+	"maps"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
@@ -23,7 +24,9 @@ func NewIdentityServer(driver *Driver) *identityServer {
 	}
 }
 
-// GetPluginInfo retrieves the plugin information.
+// GetPluginInfo retrieves the plugin information. The manifest describes
+// the LXD server the driver is connected to, and is empty if the driver
+// has not connected to devLXD yet.
 func (i *identityServer) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
 	if i.driver.name == "" {
 		return nil, status.Error(codes.Unavailable, "Driver is missing name")
@@ -33,9 +36,14 @@ func (i *identityServer) GetPluginInfo(ctx context.Context, req *csi.GetPluginIn
 		return nil, status.Error(codes.Unavailable, "Driver is missing version")
 	}
 
+	i.driver.lock.Lock()
+	manifest := maps.Clone(i.driver.manifest)
+	i.driver.lock.Unlock()
+
 	return &csi.GetPluginInfoResponse{
 		Name:          i.driver.name,
 		VendorVersion: i.driver.version,
+		Manifest:      manifest,
 	}, nil
 }
 
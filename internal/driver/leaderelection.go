@@ -0,0 +1,131 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+// Timings of the leader election, matching the defaults of the CSI sidecars.
+const (
+	leaderElectionLeaseDuration = 15 * time.Second
+	leaderElectionRenewDeadline = 10 * time.Second
+	leaderElectionRetryPeriod   = 5 * time.Second
+)
+
+// serviceAccountNamespaceFile contains the namespace of the pod, which is used
+// for the leader election lease if no namespace is configured.
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// leaderElectionLeaseName returns the name of the lease used for the leader
+// election of the controller server, derived from the driver name.
+func leaderElectionLeaseName(driverName string) string {
+	return strings.ReplaceAll(driverName, ".", "-")
+}
+
+// newLeaderElectionLock creates the Kubernetes lease lock used for the leader
+// election of the controller server. The lease is created in the configured
+// namespace, or in the namespace of the pod if none is configured.
+func (d *Driver) newLeaderElectionLock() (resourcelock.Interface, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load in-cluster Kubernetes configuration for leader election: %w", err)
+	}
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create Kubernetes client for leader election: %w", err)
+	}
+
+	namespace := d.leaderElectionNamespace
+	if namespace == "" {
+		content, err := os.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to determine leader election namespace: %w", err)
+		}
+
+		namespace = strings.TrimSpace(string(content))
+	}
+
+	// The pod name is used as the identity of the replica.
+	identity, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to determine leader election identity: %w", err)
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      leaderElectionLeaseName(d.name),
+			Namespace: namespace,
+		},
+		Client: client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	return lock, nil
+}
+
+// runLeaderElection campaigns for the leadership using the given lock until
+// the context is done. The controller server serves requests only while it is
+// the leader. When the leadership is lost, the replica campaigns again, so that
+// it is ready to take over from the new leader.
+func (d *Driver) runLeaderElection(ctx context.Context, lock resourcelock.Interface) {
+	config := leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaderElectionLeaseDuration,
+		RenewDeadline:   leaderElectionRenewDeadline,
+		RetryPeriod:     leaderElectionRetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            lock.Describe(),
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				klog.InfoS("Started leading, serving controller requests", "lease", lock.Describe(), "identity", lock.Identity())
+				d.isLeader.Store(true)
+			},
+			OnStoppedLeading: func() {
+				d.isLeader.Store(false)
+				klog.InfoS("Stopped leading, rejecting controller requests", "lease", lock.Describe(), "identity", lock.Identity())
+			},
+			OnNewLeader: func(identity string) {
+				klog.InfoS("New leader elected", "lease", lock.Describe(), "leader", identity)
+			},
+		},
+	}
+
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, config)
+	}
+}
+
+// leaderUnaryInterceptor is a gRPC unary server interceptor that rejects
+// controller requests with [codes.Unavailable] while leader election is enabled
+// and the driver is not the leader, so that the sidecars retry them. Requests
+// for the controller capabilities are always served, as the sidecars query them
+// on startup regardless of the leadership.
+func (d *Driver) leaderUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !d.leaderElection || d.isLeader.Load() {
+		return handler(ctx, req)
+	}
+
+	service, method := path.Split(info.FullMethod)
+	if service != "/csi.v1.Controller/" || method == "ControllerGetCapabilities" {
+		return handler(ctx, req)
+	}
+
+	return nil, status.Errorf(codes.Unavailable, "%s: Controller server is not the leader", method)
+}
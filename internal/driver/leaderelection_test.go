@@ -0,0 +1,83 @@
+package driver
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestLeaderUnaryInterceptor(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "csi.sock")
+
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	d := &Driver{name: DefaultDriverName, version: "test", devLXD: &fakeDevLXDServer{}, isController: true, leaderElection: true}
+	d.SetControllerServiceCapabilities(d.controllerServiceCapabilities()...)
+
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(recoverUnaryInterceptor, d.leaderUnaryInterceptor))
+	csi.RegisterIdentityServer(server, NewIdentityServer(d))
+	csi.RegisterControllerServer(server, NewControllerServer(d))
+
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	identity := csi.NewIdentityClient(conn)
+	controller := csi.NewControllerClient(conn)
+
+	// Controller requests are rejected until the driver becomes the leader.
+	_, err = controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, "CreateVolume: Controller server is not the leader", status.Convert(err).Message())
+
+	_, err = controller.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "remote/pvc-vol"})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	// Identity requests and controller capabilities are served regardless.
+	_, err = identity.Probe(context.Background(), &csi.ProbeRequest{})
+	require.NoError(t, err)
+
+	caps, err := controller.ControllerGetCapabilities(context.Background(), &csi.ControllerGetCapabilitiesRequest{})
+	require.NoError(t, err)
+	require.NotEmpty(t, caps.Capabilities)
+
+	// The leader serves controller requests, which then fail on validation.
+	d.isLeader.Store(true)
+
+	_, err = controller.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Losing the leadership rejects controller requests again.
+	d.isLeader.Store(false)
+
+	_, err = controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestLeaderUnaryInterceptorDisabled(t *testing.T) {
+	d := &Driver{isController: true}
+
+	called := false
+	handler := func(ctx context.Context, req any) (any, error) {
+		called = true
+		return &csi.CreateVolumeResponse{}, nil
+	}
+
+	info := &grpc.UnaryServerInfo{FullMethod: csi.Controller_CreateVolume_FullMethodName}
+
+	_, err := d.leaderUnaryInterceptor(context.Background(), &csi.CreateVolumeRequest{}, info, handler)
+	require.NoError(t, err)
+	require.True(t, called)
+}
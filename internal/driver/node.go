@@ -2,21 +2,40 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unsafe"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/sys/unix"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
 
 	"github.com/canonical/lxd-csi-driver/internal/fs"
+	"github.com/canonical/lxd-csi-driver/internal/lxderrors"
 )
 
+// nodeVolumeLimitCacheTTL is the duration for which the number of volumes that
+// can be attached to the node is cached by the node server.
+var nodeVolumeLimitCacheTTL = 30 * time.Second
+
 type nodeServer struct {
 	driver *Driver
 
+	// Cached number of volumes that can be attached to the node.
+	volumeLimit          int64
+	volumeLimitExpiresAt time.Time
+	volumeLimitLock      sync.Mutex
+
 	// Must be embedded for forward compatibility.
 	csi.UnimplementedNodeServer
 }
@@ -36,20 +55,133 @@ func (n *nodeServer) NodeGetCapabilities(_ context.Context, _ *csi.NodeGetCapabi
 }
 
 // NodeGetInfo returns the information about the node on which the plugin is running.
+// The cluster member segment is reported only when LXD is clustered, as the
+// location of the node instance is empty otherwise.
 func (n *nodeServer) NodeGetInfo(_ context.Context, _ *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-	return &csi.NodeGetInfoResponse{
-		NodeId: n.driver.nodeID,
-		AccessibleTopology: &csi.Topology{
+	var topology *csi.Topology
+	if n.driver.isClustered {
+		topology = &csi.Topology{
 			Segments: map[string]string{
 				AnnotationLXDClusterMember: n.driver.location,
 			},
-		},
+		}
+	}
+
+	return &csi.NodeGetInfoResponse{
+		NodeId:             n.driver.nodeID,
+		MaxVolumesPerNode:  n.getMaxVolumesPerNode(),
+		AccessibleTopology: topology,
 	}, nil
 }
 
+// getMaxVolumesPerNode returns the number of volumes that can be attached to
+// the node, which is the configured maximum reduced by the number of disk devices
+// of the node instance that are not managed by the driver. Volumes attached by
+// the driver are not subtracted, as Kubernetes counts them against the limit.
+// The result is cached to avoid querying devLXD on each call. Zero means
+// unlimited.
+func (n *nodeServer) getMaxVolumesPerNode() int64 {
+	if n.driver.maxVolumesPerNode <= 0 {
+		return 0
+	}
+
+	n.volumeLimitLock.Lock()
+	defer n.volumeLimitLock.Unlock()
+
+	if time.Now().Before(n.volumeLimitExpiresAt) {
+		return n.volumeLimit
+	}
+
+	devices, err := n.getNodeDevices()
+	if err != nil {
+		// Report the configured maximum without caching it, so that
+		// the next call retries to retrieve the instance devices.
+		klog.ErrorS(err, "Failed to retrieve node instance devices, reporting configured maximum volumes per node", "node", n.driver.nodeID)
+		return int64(n.driver.maxVolumesPerNode)
+	}
+
+	limit := remainingVolumeSlots(n.driver.maxVolumesPerNode, devices)
+
+	n.volumeLimit = limit
+	n.volumeLimitExpiresAt = time.Now().Add(nodeVolumeLimitCacheTTL)
+
+	return limit
+}
+
+// getNodeDevices returns the devices of the LXD instance of the node.
+func (n *nodeServer) getNodeDevices() (map[string]map[string]string, error) {
+	client, err := n.driver.DevLXDClient()
+	if err != nil {
+		return nil, err
+	}
+
+	inst, _, err := client.GetInstance(n.driver.instanceName(n.driver.nodeID))
+	if err != nil {
+		return nil, err
+	}
+
+	return inst.Devices, nil
+}
+
+// remainingVolumeSlots returns the number of volumes that can be attached to
+// an instance with the given devices, given the maximum number of disk devices.
+// Disk devices attached by the driver are not subtracted. The result is at
+// least one, as zero would be interpreted as unlimited.
+func remainingVolumeSlots(maxDevices int, devices map[string]map[string]string) int64 {
+	remaining := maxDevices
+	for name, dev := range devices {
+		if dev["type"] == "disk" && !isDriverDevice(name, dev) {
+			remaining--
+		}
+	}
+
+	return int64(max(remaining, 1))
+}
+
+// Volume context keys set by kubelet when the CSIDriver enables podInfoOnMount.
+const (
+	volumeContextPodName      = "csi.storage.k8s.io/pod.name"
+	volumeContextPodNamespace = "csi.storage.k8s.io/pod.namespace"
+	volumeContextPodUID       = "csi.storage.k8s.io/pod.uid"
+)
+
+// getPodLogValues returns the key/value pairs identifying the pod consuming the
+// volume, which kubelet passes in the volume context only if podInfoOnMount is
+// enabled for the driver. Missing values are omitted.
+func getPodLogValues(volumeContext map[string]string) []any {
+	var values []any
+
+	podName := volumeContext[volumeContextPodName]
+	if podName != "" {
+		values = append(values, "pod", klog.KRef(volumeContext[volumeContextPodNamespace], podName))
+	}
+
+	podUID := volumeContext[volumeContextPodUID]
+	if podUID != "" {
+		values = append(values, "podUID", podUID)
+	}
+
+	return values
+}
+
 // NodePublishVolume mounts a filesystem volume or maps a block volume into the pod’s
-// target path on this node.
+// target path on this node. Failures are logged along with the identity of the
+// consuming pod, if known, to ease debugging of mount failures.
 func (n *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	logValues := append([]any{"volumeID", req.VolumeId, "targetPath", req.TargetPath}, getPodLogValues(req.VolumeContext)...)
+
+	resp, err := n.publishVolume(ctx, req, logValues)
+	if err != nil {
+		klog.ErrorS(err, "Failed to publish volume", logValues...)
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// publishVolume implements [nodeServer.NodePublishVolume]. The given key/value
+// pairs are included in the log messages.
+func (n *nodeServer) publishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest, logValues []any) (*csi.NodePublishVolumeResponse, error) {
 	err := ValidateVolumeCapabilities(req.VolumeCapability)
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "NodePublishVolume: %v", err)
@@ -70,35 +202,58 @@ func (n *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume: Volume capability must specify either block or filesystem access type")
 	}
 
-	// Mount options for the bind mount.
-	// If the volume is read-only, add "ro" option as well.
-	mountOptions := []string{"bind"}
-	if req.Readonly {
-		mountOptions = append(mountOptions, "ro")
-	}
-
-	mounted, err := fs.IsMountPoint(targetPath)
-	if err != nil {
-		return nil, status.Error(codes.Internal, fmt.Sprintf("NodePublishVolume: %v", err))
+	// Volumes published by older versions of the driver do not have the
+	// content type in the publish context.
+	publishedContentType := req.PublishContext[PublishContextContentType]
+	if publishedContentType != "" && publishedContentType != contentType {
+		return nil, status.Errorf(codes.InvalidArgument, "NodePublishVolume: Volume %q is attached to the node as %q, but %q access type is requested", volName, publishedContentType, contentType)
 	}
 
-	if mounted {
-		// Already mounted, nothing to do.
-		return &csi.NodePublishVolumeResponse{}, nil
-	}
+	// Mount options for the bind mount.
+	mountOptions := []string{"bind"}
 
 	var sourcePath string
 
 	switch req.VolumeCapability.AccessType.(type) {
 	case *csi.VolumeCapability_Block:
+		// Volumes published by older versions of the driver do not have
+		// the device name in the publish context and use the volume name.
+		devName := req.PublishContext[PublishContextDeviceName]
+		if devName == "" {
+			devName = volName
+		}
+
 		// Get the disk device path for the block volume.
-		sourcePath, err = getDiskDevicePath(volName)
+		sourcePath, err = getDiskDevicePath(devName)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "NodePublishVolume: Source device for volume %q not found: %v", volName, err)
 		}
+
+		// The "ro" bind mount option does not prevent writes through the
+		// device node, so mark the block device itself as read-only. The
+		// attribute is cleared once no read-only publish of the device
+		// remains on the node, or the volume is detached from the node.
+		// As the attribute applies to the whole device, a read-only and
+		// a read-write publish of the same device cannot coexist.
+		mounts, err := fs.GetMountsOf(sourcePath)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "NodePublishVolume: %v", err)
+		}
+
+		err = checkBlockDevicePublishMode(mounts, targetPath, req.Readonly)
+		if err != nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "NodePublishVolume: Cannot publish volume %q: %v", volName, err)
+		}
+
+		if req.Readonly {
+			err = fs.SetBlockDeviceReadOnly(sourcePath, true)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "NodePublishVolume: %v", err)
+			}
+		}
 	case *csi.VolumeCapability_Mount:
 		// Construct the source path for the filesystem volume.
-		sourcePath = filepath.Join(driverFileSystemMountPath, volName)
+		sourcePath = filepath.Join(n.driver.fileSystemMountPath, volName)
 
 		// Read mount flags from the request.
 		mnt := req.VolumeCapability.GetMount()
@@ -108,12 +263,92 @@ func (n *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 		if !fs.PathExists(sourcePath) {
 			return nil, status.Errorf(codes.NotFound, "NodePublishVolume: Source path %q not found", sourcePath)
 		}
+
+		// Publish only a subdirectory of the volume, if requested.
+		subPath := req.VolumeContext[ParameterSubPath]
+		if subPath != "" {
+			err = validateSubPath(subPath)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "NodePublishVolume: Invalid %q in volume context: %v", ParameterSubPath, err)
+			}
+
+			sourcePath, err = createSubPath(sourcePath, subPath)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "NodePublishVolume: %v", err)
+			}
+		}
+
+		// Apply the ownership of the published directory configured in the storage class.
+		// The source path shares the root with the target path, but changing it
+		// before the bind mount ensures that a failure does not leave the target
+		// mounted with incorrect ownership, and works for read-only mounts.
+		uid, gid, err := getVolumeOwner(req.VolumeContext)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "NodePublishVolume: %v", err)
+		}
+
+		if uid >= 0 || gid >= 0 {
+			err = os.Chown(sourcePath, uid, gid)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "NodePublishVolume: Failed to change ownership of volume %q: %v", volName, err)
+			}
+		}
 	default:
 		return nil, status.Errorf(codes.InvalidArgument, "NodePublishVolume: Unsupported access type %q", req.VolumeCapability.AccessType)
 	}
 
+	mounted, err := fs.IsMounted(targetPath)
+	if err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("NodePublishVolume: %v", err))
+	}
+
+	if mounted {
+		isMountOf, err := fs.IsMountOf(sourcePath, targetPath)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "NodePublishVolume: %v", err)
+		}
+
+		if isMountOf {
+			// Already mounted, nothing to do.
+			return &csi.NodePublishVolumeResponse{}, nil
+		}
+
+		// The target path is mounted, but not from the expected source. This can
+		// happen when a previous unpublish failed part way, so remove the stale mount.
+		klog.InfoS("Removing stale mount from target path", logValues...)
+		err = fs.Unmount(ctx, targetPath, n.driver.unmountRetries, n.driver.unmountRetryInterval)
+		if err != nil {
+			return nil, status.Errorf(lxderrors.ToGRPCCode(err), "NodePublishVolume: Failed to remove stale mount: %v", err)
+		}
+	}
+
+	// If the volume is read-only, add "ro" option last, so that it takes
+	// precedence over a conflicting "rw" mount flag.
+	if req.Readonly {
+		mountOptions = append(mountOptions, "ro")
+	}
+
+	// Derive the mount propagation from the normalized mount flags.
+	propagation, mountOptions := fs.ParseMountPropagation(fs.NormalizeMountOptions(mountOptions))
+
+	// Create the mount target with the configured mode, and set its group
+	// to the requested volume mount group, if any.
+	target := fs.MountTarget{
+		DirMode:  n.driver.mountTargetDirMode,
+		FileMode: n.driver.mountTargetFileMode,
+		GID:      -1,
+	}
+
+	mountGroup := req.VolumeCapability.GetMount().GetVolumeMountGroup()
+	if mountGroup != "" {
+		target.GID, err = parseOwnerID(mountGroup)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "NodePublishVolume: Invalid volume mount group %q: %v", mountGroup, err)
+		}
+	}
+
 	// Bind mount the volume to the target path (application container).
-	err = fs.Mount(sourcePath, targetPath, contentType, mountOptions)
+	err = fs.Mount(sourcePath, targetPath, contentType, mountOptions, propagation, target)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "NodePublishVolume: %v", err)
 	}
@@ -129,28 +364,361 @@ func (n *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpub
 		return nil, status.Error(codes.InvalidArgument, "NodeUnpublishVolume: Target path not provided")
 	}
 
-	err := fs.Unmount(targetPath)
+	// A read-only publish of a block volume also marks the block device
+	// read-only. Find the device before unmounting the target, so that
+	// a later read-write publish on the node can write to the device.
+	devicePath, err := fs.GetReadOnlyBlockDevice(targetPath)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "NodeUnpublishVolume: %v", err)
 	}
 
+	err = fs.Unmount(ctx, targetPath, n.driver.unmountRetries, n.driver.unmountRetryInterval)
+	if err != nil {
+		return nil, status.Errorf(lxderrors.ToGRPCCode(err), "NodeUnpublishVolume: %v", err)
+	}
+
+	if devicePath != "" {
+		err = clearBlockDeviceReadOnly(devicePath)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "NodeUnpublishVolume: %v", err)
+		}
+	}
+
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
-// getDiskDevicePath returns the disk device path for a given volume name.
-func getDiskDevicePath(volName string) (string, error) {
+// checkBlockDevicePublishMode checks that the block device with the given mounts
+// can be published at the target path in the requested mode. Kubelet publishes
+// a block volume for each pod at a separate target path in the same directory,
+// so only mounts in that directory are considered. Other mounts of the device,
+// such as the ones used by kubelet to map the device into pods, are ignored.
+func checkBlockDevicePublishMode(mounts []fs.MountInfo, targetPath string, readOnly bool) error {
+	for _, mount := range mounts {
+		if mount.MountPoint == targetPath || filepath.Dir(mount.MountPoint) != filepath.Dir(targetPath) {
+			continue
+		}
+
+		if mount.IsReadOnly() == readOnly {
+			continue
+		}
+
+		if readOnly {
+			return fmt.Errorf("Device is published read-write at %q", mount.MountPoint)
+		}
+
+		return fmt.Errorf("Device is published read-only at %q", mount.MountPoint)
+	}
+
+	return nil
+}
+
+// clearBlockDeviceReadOnly clears the read-only attribute of the block device
+// at the given path, unless the device is still published read-only on the node.
+// A device that no longer exists has been detached, which resets the attribute.
+func clearBlockDeviceReadOnly(devicePath string) error {
+	_, err := os.Stat(devicePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	mounts, err := fs.GetMountsOf(devicePath)
+	if err != nil {
+		return err
+	}
+
+	if slices.ContainsFunc(mounts, fs.MountInfo.IsReadOnly) {
+		return nil
+	}
+
+	return fs.SetBlockDeviceReadOnly(devicePath, false)
+}
+
+// NodeGetVolumeStats returns the capacity and inode usage of a volume published
+// at the given volume path. If volume condition reporting is enabled, the response
+// also reports whether the filesystem is in an abnormal state.
+func (n *nodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats: Volume ID not provided")
+	}
+
+	volumePath := req.VolumePath
+	if volumePath == "" {
+		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats: Volume path not provided")
+	}
+
+	info, err := os.Stat(volumePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "NodeGetVolumeStats: Volume path %q not found", volumePath)
+		}
+
+		return nil, status.Errorf(codes.Internal, "NodeGetVolumeStats: %v", err)
+	}
+
+	// Block volumes are published as device nodes, for which filesystem
+	// statistics are not available. Only the total size of the device is
+	// reported, which is read from the device without contacting LXD.
+	if !info.IsDir() {
+		size, err := blockDeviceSize(volumePath)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "NodeGetVolumeStats: %v", err)
+		}
+
+		return &csi.NodeGetVolumeStatsResponse{
+			Usage: []*csi.VolumeUsage{
+				{
+					Unit:  csi.VolumeUsage_BYTES,
+					Total: size,
+				},
+			},
+		}, nil
+	}
+
+	reportCondition := n.driver.hasNodeServiceCapability(csi.NodeServiceCapability_RPC_VOLUME_CONDITION)
+
+	var stat unix.Statfs_t
+	err = unix.Statfs(volumePath, &stat)
+	if err != nil {
+		// An I/O error indicates that the underlying filesystem is corrupted
+		// or the device is failing. Report it as an abnormal volume condition
+		// instead of failing the request.
+		if reportCondition && errors.Is(err, unix.EIO) {
+			return &csi.NodeGetVolumeStatsResponse{
+				VolumeCondition: &csi.VolumeCondition{
+					Abnormal: true,
+					Message:  fmt.Sprintf("Failed to get filesystem statistics: %v", err),
+				},
+			}, nil
+		}
+
+		return nil, status.Errorf(codes.Internal, "NodeGetVolumeStats: Failed to get filesystem statistics for %q: %v", volumePath, err)
+	}
+
+	blockSize := uint64(stat.Bsize)
+
+	resp := &csi.NodeGetVolumeStatsResponse{
+		Usage: []*csi.VolumeUsage{
+			{
+				Unit:      csi.VolumeUsage_BYTES,
+				Total:     int64(stat.Blocks * blockSize),
+				Available: int64(stat.Bavail * blockSize),
+				Used:      int64((stat.Blocks - stat.Bfree) * blockSize),
+			},
+			{
+				Unit:      csi.VolumeUsage_INODES,
+				Total:     int64(stat.Files),
+				Available: int64(stat.Ffree),
+				Used:      int64(stat.Files - stat.Ffree),
+			},
+		},
+	}
+
+	if reportCondition {
+		// The mount table distinguishes a read-only bind mount requested by
+		// the pod from a filesystem that became read-only, for example, due
+		// to errors. If it cannot be read, the condition is derived from the
+		// filesystem statistics only.
+		mountInfo, err := fs.GetMountInfo(volumePath)
+		if err != nil {
+			klog.ErrorS(err, "Failed to get mount information", "volumeID", req.VolumeId, "volumePath", volumePath)
+		}
+
+		resp.VolumeCondition = getVolumeCondition(&stat, mountInfo)
+	}
+
+	return resp, nil
+}
+
+// getVolumeCondition derives the volume condition from the filesystem statistics
+// and the mount information of the volume path, if available. The volume is reported
+// as abnormal when the filesystem is unexpectedly read-only or when it has run out
+// of inodes while free space is still available.
+//
+// Without mount information, any read-only mount is reported as abnormal. With it,
+// only a read-only filesystem is reported, as a read-only bind mount is expected
+// when the volume is published as read-only.
+func getVolumeCondition(stat *unix.Statfs_t, mountInfo *fs.MountInfo) *csi.VolumeCondition {
+	if mountInfo != nil {
+		if mountInfo.IsFilesystemReadOnly() {
+			return &csi.VolumeCondition{
+				Abnormal: true,
+				Message:  fmt.Sprintf("Filesystem %q on %q is read-only, possibly due to filesystem errors", mountInfo.FSType, mountInfo.Source),
+			}
+		}
+	} else if stat.Flags&unix.ST_RDONLY != 0 {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  "Filesystem is mounted read-only",
+		}
+	}
+
+	// Some filesystems (e.g. btrfs) allocate inodes dynamically and
+	// report zero total inodes, so the check is skipped for them.
+	if stat.Files > 0 && stat.Ffree == 0 && stat.Bavail > 0 {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("Filesystem has no free inodes left while %d bytes are still available", stat.Bavail*uint64(stat.Bsize)),
+		}
+	}
+
+	return &csi.VolumeCondition{
+		Abnormal: false,
+		Message:  "Volume is healthy",
+	}
+}
+
+// getVolumeOwner returns the user and group IDs that should own the root of
+// a filesystem volume, as configured in the volume context. An ID that is not
+// configured is returned as -1, which leaves it unchanged when passed to chown.
+func getVolumeOwner(volumeContext map[string]string) (uid int, gid int, err error) {
+	uid = -1
+	gid = -1
+
+	value, ok := volumeContext[ParameterUID]
+	if ok {
+		uid, err = parseOwnerID(value)
+		if err != nil {
+			return -1, -1, fmt.Errorf("Invalid %q in volume context: %w", ParameterUID, err)
+		}
+	}
+
+	value, ok = volumeContext[ParameterGID]
+	if ok {
+		gid, err = parseOwnerID(value)
+		if err != nil {
+			return -1, -1, fmt.Errorf("Invalid %q in volume context: %w", ParameterGID, err)
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// subPathDirMode is the mode of the directories created for the subpath of
+// a filesystem volume.
+const subPathDirMode os.FileMode = 0755
+
+// validateSubPath checks that the given subpath of a filesystem volume is
+// relative and cannot escape the volume root.
+func validateSubPath(subPath string) error {
+	if filepath.IsAbs(subPath) {
+		return fmt.Errorf("Subpath %q must be relative to the volume root", subPath)
+	}
+
+	if slices.Contains(strings.Split(subPath, "/"), "..") {
+		return fmt.Errorf("Subpath %q must not contain %q", subPath, "..")
+	}
+
+	return nil
+}
+
+// createSubPath creates the directories of the given subpath under the volume
+// root, if missing, and returns the path of the subdirectory. Symbolic links
+// are not followed, as they could point outside of the volume root.
+func createSubPath(root string, subPath string) (string, error) {
+	path := root
+
+	for _, elem := range strings.Split(filepath.Clean(subPath), "/") {
+		if elem == "." {
+			continue
+		}
+
+		path = filepath.Join(path, elem)
+
+		info, err := os.Lstat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			err = os.Mkdir(path, subPathDirMode)
+			if err != nil && !errors.Is(err, os.ErrExist) {
+				return "", fmt.Errorf("Failed to create subpath directory %q: %w", path, err)
+			}
+
+			// The directory may have been created concurrently.
+			info, err = os.Lstat(path)
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("Failed to stat subpath %q: %w", path, err)
+		}
+
+		if !info.IsDir() {
+			return "", fmt.Errorf("Subpath %q is not a directory", path)
+		}
+	}
+
+	return path, nil
+}
+
+// parseOwnerID parses a user or group ID, which must be a non-negative integer.
+func parseOwnerID(value string) (int, error) {
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		return -1, fmt.Errorf("ID %q is not an integer", value)
+	}
+
+	if id < 0 {
+		return -1, fmt.Errorf("ID %q cannot be negative", value)
+	}
+
+	return id, nil
+}
+
+// blockDeviceSize returns the size of the block device at the given path in
+// bytes. The path is either the device path resolved by [getDiskDevicePath]
+// or the target path where the device node is bind mounted when published.
+// The size is read using the BLKGETSIZE64 ioctl. If the ioctl is not supported
+// for the given file, the size is determined by seeking to its end instead.
+func blockDeviceSize(path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to open block device %q: %w", path, err)
+	}
+
+	defer func() { _ = file.Close() }()
+
+	var size uint64
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, file.Fd(), unix.BLKGETSIZE64, uintptr(unsafe.Pointer(&size)))
+	if errno == 0 {
+		return int64(size), nil
+	}
+
+	if errno != unix.ENOTTY && errno != unix.EINVAL {
+		return 0, fmt.Errorf("Failed to get size of block device %q: %w", path, errno)
+	}
+
+	end, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get size of block device %q: %w", path, err)
+	}
+
+	return end, nil
+}
+
+// diskDevicesByIDPath is the directory containing the links to disk devices
+// named after their serial, which for LXD disks includes the device name.
+// It is a variable so that tests can replace it.
+var diskDevicesByIDPath = "/dev/disk/by-id"
+
+// getDiskDevicePath returns the disk device path for a given LXD device name.
+// The device name is the name of the instance device, which is either the
+// hashed device name (see [getDeviceName]) or the volume name for volumes
+// attached by older versions of the driver.
+func getDiskDevicePath(devName string) (string, error) {
+	return findDiskDevicePath(diskDevicesByIDPath, devName)
+}
+
+// findDiskDevicePath returns the disk device path for a given LXD device name
+// by looking up the disk links in the given directory.
+func findDiskDevicePath(basePath string, devName string) (string, error) {
 	// LXD uses a prefix of a device name and "-" is replaced with "--".
 	// To match the device, we first extract the disk name from the device name by
 	// separating the name on "_lxd_" and then ensure the resulting substring is a
-	// prefix of the actual volume name.
-	basePath := "/dev/disk/by-id"
+	// prefix of the actual LXD device name.
 	devices, err := os.ReadDir(basePath)
 	if err != nil {
 		return "", fmt.Errorf("Failed to list disk devices: %v", err)
 	}
 
-	// Replace "-" with "--" in the volume name to match the device name format.
-	volDevName := strings.ReplaceAll(volName, "-", "--")
+	// Replace "-" with "--" in the device name to match the disk name format.
+	volDevName := strings.ReplaceAll(devName, "-", "--")
 
 	for _, device := range devices {
 		// Example device name: "scsi-0QEMU_QEMU_HARDDISK_lxd_pvc--8722b28c--a".
@@ -160,12 +728,12 @@ func getDiskDevicePath(volName string) (string, error) {
 			continue
 		}
 
-		// Device name suffix should be a prefix of a volume name.
+		// Device name suffix should be a prefix of the LXD device name.
 		if strings.HasPrefix(volDevName, suffix) {
 			devPath := filepath.Join(basePath, device.Name())
 			return filepath.EvalSymlinks(devPath)
 		}
 	}
 
-	return "", fmt.Errorf("Disk device not found for volume %q", volName)
+	return "", fmt.Errorf("Disk device not found for LXD device %q", devName)
 }
@@ -0,0 +1,37 @@
+//go:build loopback
+
+package driver
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestBlockDeviceSizeLoopback verifies the block device size is read using the
+// BLKGETSIZE64 ioctl. It requires root privileges to set up a loop device:
+//
+//	sudo go test -tags loopback -run TestBlockDeviceSizeLoopback ./internal/driver
+func TestBlockDeviceSizeLoopback(t *testing.T) {
+	const sizeBytes = 64 * 1024 * 1024
+
+	image := filepath.Join(t.TempDir(), "disk.img")
+	file, err := os.Create(image)
+	require.NoError(t, err)
+	require.NoError(t, file.Truncate(sizeBytes))
+	require.NoError(t, file.Close())
+
+	out, err := exec.Command("losetup", "--find", "--show", image).Output()
+	require.NoError(t, err)
+
+	device := strings.TrimSpace(string(out))
+	t.Cleanup(func() { _ = exec.Command("losetup", "--detach", device).Run() })
+
+	size, err := blockDeviceSize(device)
+	require.NoError(t, err)
+	require.Equal(t, int64(sizeBytes), size)
+}
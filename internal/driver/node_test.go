@@ -0,0 +1,801 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+
+	"github.com/canonical/lxd/shared/api"
+
+	"github.com/canonical/lxd-csi-driver/internal/fs"
+)
+
+func TestGetVolumeCondition(t *testing.T) {
+	tests := []struct {
+		Name           string
+		Stat           unix.Statfs_t
+		MountInfo      *fs.MountInfo
+		expectAbnormal bool
+		expectMessage  string
+	}{
+		{
+			Name: "Healthy filesystem",
+			Stat: unix.Statfs_t{
+				Bsize:  4096,
+				Blocks: 1000,
+				Bfree:  500,
+				Bavail: 450,
+				Files:  100,
+				Ffree:  50,
+			},
+			expectAbnormal: false,
+		},
+		{
+			Name: "Read-only filesystem",
+			Stat: unix.Statfs_t{
+				Bsize:  4096,
+				Blocks: 1000,
+				Bfree:  500,
+				Bavail: 450,
+				Files:  100,
+				Ffree:  50,
+				Flags:  unix.ST_RDONLY,
+			},
+			expectAbnormal: true,
+			expectMessage:  "read-only",
+		},
+		{
+			Name: "Inodes exhausted while space is available",
+			Stat: unix.Statfs_t{
+				Bsize:  4096,
+				Blocks: 1000,
+				Bfree:  500,
+				Bavail: 450,
+				Files:  100,
+				Ffree:  0,
+			},
+			expectAbnormal: true,
+			expectMessage:  "no free inodes",
+		},
+		{
+			Name: "Inodes and space exhausted",
+			Stat: unix.Statfs_t{
+				Bsize:  4096,
+				Blocks: 1000,
+				Files:  100,
+			},
+			expectAbnormal: false,
+		},
+		{
+			Name: "Read-only bind mount of a writable filesystem",
+			Stat: unix.Statfs_t{
+				Bsize:  4096,
+				Blocks: 1000,
+				Bfree:  500,
+				Bavail: 450,
+				Flags:  unix.ST_RDONLY,
+			},
+			MountInfo: &fs.MountInfo{
+				MountOptions: []string{"ro", "relatime"},
+				FSType:       "ext4",
+				Source:       "/dev/sdb",
+				SuperOptions: []string{"rw"},
+			},
+			expectAbnormal: false,
+		},
+		{
+			Name: "Filesystem remounted read-only due to errors",
+			Stat: unix.Statfs_t{
+				Bsize:  4096,
+				Blocks: 1000,
+				Bfree:  500,
+				Bavail: 450,
+				Flags:  unix.ST_RDONLY,
+			},
+			MountInfo: &fs.MountInfo{
+				MountOptions: []string{"ro", "relatime"},
+				FSType:       "ext4",
+				Source:       "/dev/sdb",
+				SuperOptions: []string{"ro", "errors=remount-ro"},
+			},
+			expectAbnormal: true,
+			expectMessage:  `Filesystem "ext4" on "/dev/sdb" is read-only`,
+		},
+		{
+			Name: "Writable mount with exhausted inodes",
+			Stat: unix.Statfs_t{
+				Bsize:  4096,
+				Blocks: 1000,
+				Bfree:  500,
+				Bavail: 450,
+				Files:  100,
+			},
+			MountInfo: &fs.MountInfo{
+				MountOptions: []string{"rw"},
+				SuperOptions: []string{"rw"},
+			},
+			expectAbnormal: true,
+			expectMessage:  "no free inodes",
+		},
+		{
+			Name: "Filesystem without inode accounting",
+			Stat: unix.Statfs_t{
+				Bsize:  4096,
+				Blocks: 1000,
+				Bfree:  500,
+				Bavail: 450,
+			},
+			expectAbnormal: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			cond := getVolumeCondition(&test.Stat, test.MountInfo)
+			require.Equal(t, test.expectAbnormal, cond.Abnormal)
+			require.Contains(t, cond.Message, test.expectMessage)
+		})
+	}
+}
+
+func TestGetVolumeOwner(t *testing.T) {
+	tests := []struct {
+		Name          string
+		VolumeContext map[string]string
+		expectUID     int
+		expectGID     int
+		expectError   bool
+	}{
+		{
+			Name:      "Ownership not configured",
+			expectUID: -1,
+			expectGID: -1,
+		},
+		{
+			Name:          "UID and GID",
+			VolumeContext: map[string]string{ParameterUID: "1000", ParameterGID: "2000"},
+			expectUID:     1000,
+			expectGID:     2000,
+		},
+		{
+			Name:          "Only GID",
+			VolumeContext: map[string]string{ParameterGID: "0"},
+			expectUID:     -1,
+			expectGID:     0,
+		},
+		{
+			Name:          "Negative UID",
+			VolumeContext: map[string]string{ParameterUID: "-1"},
+			expectError:   true,
+		},
+		{
+			Name:          "Non-numeric GID",
+			VolumeContext: map[string]string{ParameterGID: "users"},
+			expectError:   true,
+		},
+		{
+			Name:          "Empty UID",
+			VolumeContext: map[string]string{ParameterUID: ""},
+			expectError:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			uid, gid, err := getVolumeOwner(test.VolumeContext)
+			if test.expectError {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.expectUID, uid)
+			require.Equal(t, test.expectGID, gid)
+		})
+	}
+}
+
+func TestCreateSubPath(t *testing.T) {
+	tests := []struct {
+		Name        string
+		SubPath     string
+		Setup       func(t *testing.T, root string)
+		expectPath  string
+		expectError string
+	}{
+		{
+			Name:       "Missing subpath is created",
+			SubPath:    "data/app",
+			expectPath: "data/app",
+		},
+		{
+			Name:    "Existing subpath is reused",
+			SubPath: "data",
+			Setup: func(t *testing.T, root string) {
+				require.NoError(t, os.Mkdir(filepath.Join(root, "data"), 0o700))
+				require.NoError(t, os.WriteFile(filepath.Join(root, "data", "file"), nil, 0o600))
+			},
+			expectPath: "data",
+		},
+		{
+			Name:       "Current directory elements are ignored",
+			SubPath:    "./data/./app/",
+			expectPath: "data/app",
+		},
+		{
+			Name:        "Parent directory is rejected",
+			SubPath:     "../data",
+			expectError: `Subpath "../data" must not contain ".."`,
+		},
+		{
+			Name:        "Nested parent directory is rejected",
+			SubPath:     "data/../../app",
+			expectError: `Subpath "data/../../app" must not contain ".."`,
+		},
+		{
+			Name:        "Absolute path is rejected",
+			SubPath:     "/etc",
+			expectError: `Subpath "/etc" must be relative to the volume root`,
+		},
+		{
+			Name:    "Symbolic link is not followed",
+			SubPath: "link/app",
+			Setup: func(t *testing.T, root string) {
+				require.NoError(t, os.Symlink(t.TempDir(), filepath.Join(root, "link")))
+			},
+			expectError: "is not a directory",
+		},
+		{
+			Name:    "File is rejected",
+			SubPath: "file",
+			Setup: func(t *testing.T, root string) {
+				require.NoError(t, os.WriteFile(filepath.Join(root, "file"), nil, 0o600))
+			},
+			expectError: "is not a directory",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			root := t.TempDir()
+			if test.Setup != nil {
+				test.Setup(t, root)
+			}
+
+			err := validateSubPath(test.SubPath)
+			if err == nil {
+				var path string
+				path, err = createSubPath(root, test.SubPath)
+				if err == nil {
+					require.Equal(t, filepath.Join(root, test.expectPath), path)
+					require.DirExists(t, path)
+				}
+			}
+
+			if test.expectError != "" {
+				require.ErrorContains(t, err, test.expectError)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeSubPathTraversal(t *testing.T) {
+	driver := &Driver{fileSystemMountPath: t.TempDir()}
+	require.NoError(t, os.Mkdir(filepath.Join(driver.fileSystemMountPath, "pvc-vol"), 0o700))
+
+	node := NewNodeServer(driver)
+
+	targetPath := filepath.Join(t.TempDir(), "target")
+	_, err := node.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:   "remote/pvc-vol",
+		TargetPath: targetPath,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+		},
+		VolumeContext: map[string]string{ParameterSubPath: "../other"},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.ErrorContains(t, err, `Subpath "../other" must not contain ".."`)
+	require.NoDirExists(t, filepath.Join(driver.fileSystemMountPath, "other"))
+	require.NoFileExists(t, targetPath)
+}
+
+func TestNodePublishVolumeNilVolumeCapability(t *testing.T) {
+	node := NewNodeServer(&Driver{})
+
+	_, err := node.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:   "remote/pvc-vol",
+		TargetPath: "/var/lib/kubelet/pods/pod/volumes/pvc-vol/mount",
+	})
+	require.Error(t, err)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.ErrorContains(t, err, "Volume capability is missing")
+}
+
+func TestNodePublishVolumePublishContext(t *testing.T) {
+	// Look up block devices in an empty directory, so that the error
+	// reveals the device name the node plugin searched for.
+	defaultDiskDevicesPath := diskDevicesByIDPath
+	diskDevicesByIDPath = t.TempDir()
+	defer func() { diskDevicesByIDPath = defaultDiskDevicesPath }()
+
+	blockCapability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
+	}
+
+	mountCapability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{},
+		},
+	}
+
+	fakeClient := &fakeDevLXDServer{
+		getVolFunc: func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+			return &api.DevLXDStorageVolume{Name: name, ContentType: "block"}, "", nil
+		},
+		getInstFunc: func(name string) (*api.DevLXDInstance, string, error) {
+			return &api.DevLXDInstance{Name: name}, "", nil
+		},
+		updateInstFunc: func(name string, inst api.DevLXDInstancePut, ETag string) error {
+			return nil
+		},
+	}
+
+	driver := &Driver{devLXD: fakeClient, fileSystemMountPath: t.TempDir()}
+	controller := NewControllerServer(driver)
+	node := NewNodeServer(driver)
+
+	resp, err := controller.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId:         "remote/pvc-vol",
+		NodeId:           "node",
+		VolumeCapability: blockCapability,
+	})
+	require.NoError(t, err)
+
+	devName := getDeviceName("remote", "pvc-vol")
+	require.Equal(t, map[string]string{
+		PublishContextDeviceName:  devName,
+		PublishContextContentType: "block",
+	}, resp.PublishContext)
+
+	tests := []struct {
+		Name             string
+		VolumeCapability *csi.VolumeCapability
+		PublishContext   map[string]string
+		expectCode       codes.Code
+		expectError      string
+	}{
+		{
+			Name:             "Block volume is looked up by the published device name",
+			VolumeCapability: blockCapability,
+			PublishContext:   resp.PublishContext,
+			expectCode:       codes.Internal,
+			expectError:      `Disk device not found for LXD device "` + devName + `"`,
+		},
+		{
+			Name:             "Block volume published by older driver is looked up by the volume name",
+			VolumeCapability: blockCapability,
+			expectCode:       codes.Internal,
+			expectError:      `Disk device not found for LXD device "pvc-vol"`,
+		},
+		{
+			Name:             "Access type does not match the published content type",
+			VolumeCapability: mountCapability,
+			PublishContext:   resp.PublishContext,
+			expectCode:       codes.InvalidArgument,
+			expectError:      `Volume "pvc-vol" is attached to the node as "block", but "filesystem" access type is requested`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			_, err := node.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:         "remote/pvc-vol",
+				PublishContext:   test.PublishContext,
+				TargetPath:       filepath.Join(t.TempDir(), "target"),
+				VolumeCapability: test.VolumeCapability,
+			})
+			require.Equal(t, test.expectCode, status.Code(err))
+			require.ErrorContains(t, err, test.expectError)
+		})
+	}
+}
+
+func TestGetPodLogValues(t *testing.T) {
+	tests := []struct {
+		Name          string
+		VolumeContext map[string]string
+		expectValues  []any
+	}{
+		{
+			Name:          "Pod info not passed",
+			VolumeContext: map[string]string{ParameterStoragePool: "remote"},
+			expectValues:  nil,
+		},
+		{
+			Name: "Pod info passed",
+			VolumeContext: map[string]string{
+				volumeContextPodName:      "app",
+				volumeContextPodNamespace: "default",
+				volumeContextPodUID:       "0b6f7c38-4b0e-4d6c-9a3e-4f2b0e7e8a11",
+			},
+			expectValues: []any{
+				"pod", klog.KRef("default", "app"),
+				"podUID", "0b6f7c38-4b0e-4d6c-9a3e-4f2b0e7e8a11",
+			},
+		},
+		{
+			Name: "Pod UID missing",
+			VolumeContext: map[string]string{
+				volumeContextPodName:      "app",
+				volumeContextPodNamespace: "default",
+			},
+			expectValues: []any{"pod", klog.KRef("default", "app")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			require.Equal(t, test.expectValues, getPodLogValues(test.VolumeContext))
+		})
+	}
+}
+
+func TestNodePublishVolumeLogsPodInfo(t *testing.T) {
+	var buf bytes.Buffer
+	klog.LogToStderr(false)
+	klog.SetOutput(&buf)
+	defer func() {
+		klog.SetOutput(os.Stderr)
+		klog.LogToStderr(true)
+	}()
+
+	node := NewNodeServer(&Driver{})
+
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:   "remote/pvc-vol",
+		TargetPath: "/var/lib/kubelet/pods/pod/volumes/pvc-vol/mount",
+		VolumeContext: map[string]string{
+			volumeContextPodName:      "app",
+			volumeContextPodNamespace: "default",
+			volumeContextPodUID:       "0b6f7c38-4b0e-4d6c-9a3e-4f2b0e7e8a11",
+		},
+	}
+
+	// Failure is logged with the consuming pod.
+	_, err := node.NodePublishVolume(context.Background(), req)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	klog.Flush()
+
+	require.Contains(t, buf.String(), `"Failed to publish volume"`)
+	require.Contains(t, buf.String(), `volumeID="remote/pvc-vol"`)
+	require.Contains(t, buf.String(), `pod="default/app"`)
+	require.Contains(t, buf.String(), `podUID="0b6f7c38-4b0e-4d6c-9a3e-4f2b0e7e8a11"`)
+
+	// Missing pod info is omitted.
+	buf.Reset()
+	req.VolumeContext = nil
+
+	_, err = node.NodePublishVolume(context.Background(), req)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	klog.Flush()
+
+	require.Contains(t, buf.String(), `volumeID="remote/pvc-vol"`)
+	require.NotContains(t, buf.String(), "pod=")
+	require.NotContains(t, buf.String(), "podUID=")
+}
+
+func TestBlockDeviceSizeFallback(t *testing.T) {
+	// Regular files do not support the BLKGETSIZE64 ioctl, so the size
+	// is determined by seeking to the end of the file.
+	path := filepath.Join(t.TempDir(), "disk.img")
+	require.NoError(t, os.WriteFile(path, make([]byte, 4096), 0o600))
+
+	size, err := blockDeviceSize(path)
+	require.NoError(t, err)
+	require.Equal(t, int64(4096), size)
+
+	_, err = blockDeviceSize(filepath.Join(t.TempDir(), "missing"))
+	require.Error(t, err)
+}
+
+func TestCheckBlockDevicePublishMode(t *testing.T) {
+	const publishDir = "/var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish/pvc-1"
+
+	readWrite := fs.MountInfo{MountPoint: publishDir + "/pod-rw", MountOptions: []string{"rw"}}
+	readOnly := fs.MountInfo{MountPoint: publishDir + "/pod-ro", MountOptions: []string{"ro"}}
+
+	// Kubelet maps the published device into pods with read-write bind mounts.
+	kubeletMap := fs.MountInfo{MountPoint: "/var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/pvc-1/dev/pod-ro", MountOptions: []string{"rw"}}
+
+	tests := []struct {
+		Name        string
+		Mounts      []fs.MountInfo
+		TargetPath  string
+		ReadOnly    bool
+		expectError string
+	}{
+		{
+			Name:       "First read-only publish",
+			TargetPath: readOnly.MountPoint,
+			ReadOnly:   true,
+		},
+		{
+			Name:       "Second read-write publish",
+			Mounts:     []fs.MountInfo{readWrite},
+			TargetPath: publishDir + "/pod-rw-2",
+		},
+		{
+			Name:       "Second read-only publish",
+			Mounts:     []fs.MountInfo{readOnly, kubeletMap},
+			TargetPath: publishDir + "/pod-ro-2",
+			ReadOnly:   true,
+		},
+		{
+			Name:        "Read-only publish while published read-write",
+			Mounts:      []fs.MountInfo{readWrite},
+			TargetPath:  readOnly.MountPoint,
+			ReadOnly:    true,
+			expectError: "Device is published read-write at",
+		},
+		{
+			Name:        "Read-write publish while published read-only",
+			Mounts:      []fs.MountInfo{readOnly, kubeletMap},
+			TargetPath:  readWrite.MountPoint,
+			expectError: "Device is published read-only at",
+		},
+		{
+			Name:       "Repeated read-only publish at the same target",
+			Mounts:     []fs.MountInfo{readOnly},
+			TargetPath: readOnly.MountPoint,
+			ReadOnly:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			err := checkBlockDevicePublishMode(test.Mounts, test.TargetPath, test.ReadOnly)
+			if test.expectError != "" {
+				require.ErrorContains(t, err, test.expectError)
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestRemainingVolumeSlots(t *testing.T) {
+	tests := []struct {
+		Name          string
+		MaxDevices    int
+		Devices       map[string]map[string]string
+		expectedSlots int64
+	}{
+		{
+			Name:          "No devices",
+			MaxDevices:    10,
+			expectedSlots: 10,
+		},
+		{
+			Name:       "Root disk and non-disk devices",
+			MaxDevices: 10,
+			Devices: map[string]map[string]string{
+				"root": {"type": "disk", "path": "/", "pool": "local"},
+				"eth0": {"type": "nic", "network": "lxdbr0"},
+			},
+			expectedSlots: 9,
+		},
+		{
+			Name:       "Driver devices are not subtracted",
+			MaxDevices: 10,
+			Devices: map[string]map[string]string{
+				"root":                             {"type": "disk", "path": "/", "pool": "local"},
+				getDeviceName("remote", "pvc-vol"): {"type": "disk", "pool": "remote", "source": "pvc-vol"},
+				"pvc-legacy":                       {"type": "disk", "pool": "remote", "source": "pvc-legacy"},
+			},
+			expectedSlots: 9,
+		},
+		{
+			Name:       "Custom volumes attached outside of the driver",
+			MaxDevices: 10,
+			Devices: map[string]map[string]string{
+				"root": {"type": "disk", "path": "/", "pool": "local"},
+				"data": {"type": "disk", "pool": "remote", "source": "data-vol", "path": "/data"},
+				"logs": {"type": "disk", "source": "/var/log/host", "path": "/logs"},
+			},
+			expectedSlots: 7,
+		},
+		{
+			Name:       "At least one slot is reported",
+			MaxDevices: 2,
+			Devices: map[string]map[string]string{
+				"root": {"type": "disk", "path": "/", "pool": "local"},
+				"data": {"type": "disk", "pool": "remote", "source": "data-vol", "path": "/data"},
+				"logs": {"type": "disk", "source": "/var/log/host", "path": "/logs"},
+			},
+			expectedSlots: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			require.Equal(t, test.expectedSlots, remainingVolumeSlots(test.MaxDevices, test.Devices))
+		})
+	}
+}
+
+func TestNodeGetInfoTopology(t *testing.T) {
+	tests := []struct {
+		Name           string
+		Driver         *Driver
+		expectTopology *csi.Topology
+	}{
+		{
+			Name:   "Non-clustered LXD",
+			Driver: &Driver{nodeID: "node-1"},
+		},
+		{
+			Name:   "Non-clustered LXD with location",
+			Driver: &Driver{nodeID: "node-1", location: "none"},
+		},
+		{
+			Name:   "Clustered LXD",
+			Driver: &Driver{nodeID: "node-1", location: "lxd01", isClustered: true},
+			expectTopology: &csi.Topology{
+				Segments: map[string]string{AnnotationLXDClusterMember: "lxd01"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			node := NewNodeServer(test.Driver)
+
+			resp, err := node.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+			require.NoError(t, err)
+			require.Equal(t, "node-1", resp.NodeId)
+			require.Equal(t, test.expectTopology, resp.AccessibleTopology)
+		})
+	}
+}
+
+func TestNodeGetInfoMaxVolumesPerNode(t *testing.T) {
+	calls := 0
+	devices := map[string]map[string]string{
+		"root": {"type": "disk", "path": "/", "pool": "local"},
+		"data": {"type": "disk", "pool": "remote", "source": "data-vol", "path": "/data"},
+	}
+
+	client := &fakeDevLXDServer{
+		getInstFunc: func(name string) (*api.DevLXDInstance, string, error) {
+			calls++
+			if devices == nil {
+				return nil, "", api.StatusErrorf(http.StatusInternalServerError, "Failed to load instance")
+			}
+
+			return &api.DevLXDInstance{Name: name, Devices: devices}, "", nil
+		},
+	}
+
+	node := NewNodeServer(&Driver{nodeID: "node-1", devLXD: client, maxVolumesPerNode: 16})
+
+	resp, err := node.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	require.NoError(t, err)
+	require.Equal(t, int64(14), resp.MaxVolumesPerNode)
+	require.Equal(t, 1, calls)
+
+	// The result is cached.
+	resp, err = node.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	require.NoError(t, err)
+	require.Equal(t, int64(14), resp.MaxVolumesPerNode)
+	require.Equal(t, 1, calls)
+
+	// The configured maximum is reported if the instance cannot be retrieved
+	// after the cache expires.
+	devices = nil
+	node.volumeLimitExpiresAt = time.Time{}
+
+	resp, err = node.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	require.NoError(t, err)
+	require.Equal(t, int64(16), resp.MaxVolumesPerNode)
+	require.Equal(t, 2, calls)
+
+	// Zero means unlimited and the instance is not queried.
+	node = NewNodeServer(&Driver{nodeID: "node-1", devLXD: client})
+
+	resp, err = node.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	require.NoError(t, err)
+	require.Zero(t, resp.MaxVolumesPerNode)
+	require.Equal(t, 2, calls)
+}
+
+func TestFindDiskDevicePath(t *testing.T) {
+	devName := getDeviceName("remote", "pvc-vol")
+	otherDevName := getDeviceName("remote", "pvc-other")
+
+	dir := t.TempDir()
+	byID := filepath.Join(dir, "by-id")
+	require.NoError(t, os.Mkdir(byID, 0o755))
+
+	// Create disks and their links named after the LXD device names.
+	disks := map[string]string{
+		"sda": "scsi-0QEMU_QEMU_HARDDISK_lxd_root",
+		"sdb": "scsi-0QEMU_QEMU_HARDDISK_lxd_" + strings.ReplaceAll(devName, "-", "--"),
+		"sdc": "scsi-0QEMU_QEMU_HARDDISK_lxd_" + strings.ReplaceAll(otherDevName, "-", "--"),
+		"sdd": "scsi-0QEMU_QEMU_HARDDISK_lxd_pvc--legacy",
+	}
+
+	for disk, link := range disks {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, disk), nil, 0o600))
+		require.NoError(t, os.Symlink(filepath.Join(dir, disk), filepath.Join(byID, link)))
+	}
+
+	tests := []struct {
+		Name        string
+		DevName     string
+		expectDisk  string
+		expectError bool
+	}{
+		{
+			Name:       "Hashed device name",
+			DevName:    devName,
+			expectDisk: "sdb",
+		},
+		{
+			Name:       "Other hashed device name",
+			DevName:    otherDevName,
+			expectDisk: "sdc",
+		},
+		{
+			Name:       "Legacy device name",
+			DevName:    "pvc-legacy",
+			expectDisk: "sdd",
+		},
+		{
+			Name:        "Volume name of volume attached using hashed device name",
+			DevName:     "pvc-vol",
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			path, err := findDiskDevicePath(byID, test.DevName)
+			if test.expectError {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, filepath.Join(dir, test.expectDisk), path)
+		})
+	}
+}
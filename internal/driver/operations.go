@@ -0,0 +1,64 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	lxdClient "github.com/canonical/lxd/client"
+)
+
+// operationSlotTimeout is the maximum duration runOperation waits for a free
+// LXD operation slot before giving up with [codes.ResourceExhausted], so that
+// the caller backs off instead of piling up requests in the controller.
+var operationSlotTimeout = 10 * time.Second
+
+// operationLimitError is returned when no LXD operation slot becomes free in time.
+// It maps to [codes.ResourceExhausted].
+type operationLimitError struct {
+	limit int
+}
+
+// Error returns the error message.
+func (e operationLimitError) Error() string {
+	return fmt.Sprintf("Maximum number of %d concurrent LXD operations reached", e.limit)
+}
+
+// GRPCStatus returns the gRPC status of the error.
+func (e operationLimitError) GRPCStatus() *status.Status {
+	return status.New(codes.ResourceExhausted, e.Error())
+}
+
+// runOperation starts a long-running LXD operation using the given function and
+// waits for it to complete. If the number of concurrent operations is limited,
+// runOperation first waits for a free slot. Waiters are served in the order
+// they arrived and give up once the context is done, or with [operationLimitError]
+// once [operationSlotTimeout] elapses.
+func (d *Driver) runOperation(ctx context.Context, start func() (lxdClient.DevLXDOperation, error)) error {
+	if d.operations != nil {
+		acquireCtx, cancel := context.WithTimeout(ctx, operationSlotTimeout)
+		err := d.operations.Acquire(acquireCtx, 1)
+		cancel()
+		if err != nil {
+			// Report the request's own deadline or cancellation as is.
+			if ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+				return fmt.Errorf("Failed waiting for a free LXD operation slot: %w", err)
+			}
+
+			return operationLimitError{limit: d.maxConcurrentOperations}
+		}
+
+		defer d.operations.Release(1)
+	}
+
+	op, err := start()
+	if err != nil {
+		return err
+	}
+
+	return op.WaitContext(ctx)
+}
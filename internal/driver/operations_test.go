@@ -0,0 +1,184 @@
+package driver
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/canonical/lxd-csi-driver/internal/lxderrors"
+	lxdClient "github.com/canonical/lxd/client"
+	"github.com/canonical/lxd/shared/api"
+)
+
+// slowDevLXDOperation implements lxdClient.DevLXDOperation for an operation
+// that completes after the given delay.
+type slowDevLXDOperation struct {
+	lxdClient.DevLXDOperation
+
+	delay time.Duration
+	done  func()
+}
+
+func (f *slowDevLXDOperation) WaitContext(ctx context.Context) error {
+	defer f.done()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(f.delay):
+		return nil
+	}
+}
+
+func TestRunOperationConcurrency(t *testing.T) {
+	tests := []struct {
+		Name          string
+		MaxOperations int
+		Operations    int
+		expectMax     int32
+	}{
+		{
+			Name:          "Unlimited operations",
+			MaxOperations: 0,
+			Operations:    5,
+			expectMax:     5,
+		},
+		{
+			Name:          "Single operation at a time",
+			MaxOperations: 1,
+			Operations:    5,
+			expectMax:     1,
+		},
+		{
+			Name:          "Limited operations",
+			MaxOperations: 2,
+			Operations:    5,
+			expectMax:     2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			d := NewDriver(DriverOptions{MaxConcurrentOperations: test.MaxOperations})
+
+			var running atomic.Int32
+			var maxRunning atomic.Int32
+
+			start := func() (lxdClient.DevLXDOperation, error) {
+				current := running.Add(1)
+				for {
+					old := maxRunning.Load()
+					if current <= old || maxRunning.CompareAndSwap(old, current) {
+						break
+					}
+				}
+
+				return &slowDevLXDOperation{
+					delay: 50 * time.Millisecond,
+					done:  func() { running.Add(-1) },
+				}, nil
+			}
+
+			var wg sync.WaitGroup
+			errs := make(chan error, test.Operations)
+			for range test.Operations {
+				wg.Go(func() {
+					errs <- d.runOperation(context.Background(), start)
+				})
+			}
+
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				require.NoError(t, err)
+			}
+
+			require.Equal(t, test.expectMax, maxRunning.Load())
+		})
+	}
+}
+
+func TestRunOperationContextDone(t *testing.T) {
+	d := NewDriver(DriverOptions{MaxConcurrentOperations: 1})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	// Occupy the only operation slot until released.
+	go func() {
+		_ = d.runOperation(context.Background(), func() (lxdClient.DevLXDOperation, error) {
+			close(started)
+			<-release
+			return &slowDevLXDOperation{done: func() {}}, nil
+		})
+	}()
+
+	<-started
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var called bool
+	err := d.runOperation(ctx, func() (lxdClient.DevLXDOperation, error) {
+		called = true
+		return &slowDevLXDOperation{done: func() {}}, nil
+	})
+	require.Error(t, err)
+	require.False(t, called)
+	require.Equal(t, codes.DeadlineExceeded, lxderrors.ToGRPCCode(err))
+}
+
+func TestRunOperationLimitReached(t *testing.T) {
+	defaultTimeout := operationSlotTimeout
+	operationSlotTimeout = 50 * time.Millisecond
+	defer func() { operationSlotTimeout = defaultTimeout }()
+
+	d := NewDriver(DriverOptions{MaxConcurrentOperations: 1})
+	d.devLXD = &fakeDevLXDServer{
+		getVolFunc: func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+			return &api.DevLXDStorageVolume{Name: name}, "", nil
+		},
+	}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	// Occupy the only operation slot until released.
+	go func() {
+		_ = d.runOperation(context.Background(), func() (lxdClient.DevLXDOperation, error) {
+			close(started)
+			<-release
+			return &slowDevLXDOperation{done: func() {}}, nil
+		})
+	}()
+
+	<-started
+	defer close(release)
+
+	// Request without a deadline gives up once the slot timeout elapses.
+	var called bool
+	err := d.runOperation(context.Background(), func() (lxdClient.DevLXDOperation, error) {
+		called = true
+		return &slowDevLXDOperation{done: func() {}}, nil
+	})
+	require.EqualError(t, err, "Maximum number of 1 concurrent LXD operations reached")
+	require.False(t, called)
+	require.Equal(t, codes.ResourceExhausted, lxderrors.ToGRPCCode(err))
+	require.False(t, isRetryable(err))
+
+	// The limit is surfaced to the caller of the controller.
+	controller := NewControllerServer(d)
+
+	_, err = controller.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "remote/pvc-vol"})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.ErrorContains(t, err, "Maximum number of 1 concurrent LXD operations reached")
+}
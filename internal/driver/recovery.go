@@ -0,0 +1,32 @@
+package driver
+
+import (
+	"context"
+	"path"
+	"runtime/debug"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// recoverUnaryInterceptor is a gRPC unary server interceptor that recovers from
+// panics in the request handlers. The panic and its stack trace are logged, and
+// the caller receives an Internal error, so that a single faulty request does not
+// take down the whole gRPC server.
+func recoverUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+
+		klog.ErrorS(nil, "Recovered from panic in gRPC handler", "method", info.FullMethod, "panic", r, "stack", string(debug.Stack()))
+
+		resp = nil
+		err = status.Errorf(codes.Internal, "%s: Unexpected internal error", path.Base(info.FullMethod))
+	}()
+
+	return handler(ctx, req)
+}
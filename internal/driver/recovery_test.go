@@ -0,0 +1,61 @@
+package driver
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// panickingIdentityServer is an identity server whose Probe handler panics.
+type panickingIdentityServer struct {
+	*identityServer
+}
+
+func (s *panickingIdentityServer) Probe(_ context.Context, _ *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	var resp *csi.ProbeResponse
+	_ = resp.Ready.Value // Nil pointer dereference.
+	return resp, nil
+}
+
+func TestRecoverUnaryInterceptor(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "csi.sock")
+
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	d := &Driver{name: DefaultDriverName, version: "test"}
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(recoverUnaryInterceptor))
+	csi.RegisterIdentityServer(server, &panickingIdentityServer{identityServer: NewIdentityServer(d)})
+
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	client := csi.NewIdentityClient(conn)
+
+	// The panic is returned to the caller as an Internal error.
+	_, err = client.Probe(context.Background(), &csi.ProbeRequest{})
+	require.Error(t, err)
+	require.Equal(t, codes.Internal, status.Code(err))
+	require.Equal(t, "Probe: Unexpected internal error", status.Convert(err).Message())
+
+	// The server keeps serving requests, including the one that panicked.
+	resp, err := client.GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+	require.NoError(t, err)
+	require.Equal(t, DefaultDriverName, resp.Name)
+
+	_, err = client.Probe(context.Background(), &csi.ProbeRequest{})
+	require.Equal(t, codes.Internal, status.Code(err))
+}
@@ -0,0 +1,164 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+
+	"github.com/canonical/lxd-csi-driver/internal/devlxd"
+	"github.com/canonical/lxd-csi-driver/internal/lxderrors"
+	"github.com/canonical/lxd/shared/api"
+)
+
+var (
+	// retryAttempts is the maximum number of attempts made by withRetry.
+	retryAttempts = 5
+
+	// retryBaseDelay is the delay before the first retry. The delay is doubled
+	// after each subsequent attempt, up to retryMaxDelay.
+	retryBaseDelay = 200 * time.Millisecond
+
+	// retryMaxDelay is the upper bound for the delay between two attempts.
+	retryMaxDelay = 3 * time.Second
+
+	// instanceUpdateAttempts is the maximum number of attempts made by
+	// withInstanceUpdateRetry.
+	instanceUpdateAttempts = 3
+
+	// devLXDStartupTimeout is the maximum duration the driver waits on startup
+	// for the devLXD socket to appear.
+	devLXDStartupTimeout = 2 * time.Minute
+)
+
+// withRetry calls fn until it succeeds, returns a non-retryable error, the maximum
+// number of attempts is reached, or the context is done. An error is considered
+// retryable if it maps to [codes.Unavailable] or [codes.ResourceExhausted].
+// Between attempts, withRetry waits for an exponentially increasing delay with
+// random jitter applied, to avoid retrying concurrent requests in lockstep.
+//
+// Only calls that can be safely repeated are retried: reads, deletions whose
+// missing resource is treated as success, and updates that fail as a whole on
+// an ETag mismatch. Creations are not retried, as a creation that succeeded
+// before the error was returned fails on retry with a conflict instead.
+func withRetry(ctx context.Context, fn func() error) error {
+	delay := retryBaseDelay
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || !isRetryable(err) || attempt >= retryAttempts {
+			return err
+		}
+
+		// Apply jitter in range [delay/2, delay).
+		jitter := delay / 2
+		if jitter > 0 {
+			jitter += rand.N(jitter)
+		}
+
+		timer := time.NewTimer(jitter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		delay = min(delay*2, retryMaxDelay)
+	}
+}
+
+// withInstanceUpdateRetry calls fn with the given ETag of the instance. If fn fails
+// because the instance was modified in the meantime (ETag mismatch), the instance
+// is retrieved again and fn is retried with the new ETag, until the maximum number
+// of attempts is reached or the context is done. This avoids failing the request
+// when other volumes are concurrently attached to or detached from the same node.
+// The update made by fn must therefore not depend on the rest of the instance.
+func withInstanceUpdateRetry(ctx context.Context, client DevLXDClient, instName string, etag string, fn func(etag string) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn(etag)
+		if err == nil || !api.StatusErrorCheck(err, http.StatusPreconditionFailed) || attempt >= instanceUpdateAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		klog.V(4).InfoS("Instance was modified concurrently, retrying update", "instance", instName, "attempt", attempt)
+
+		getErr := withRetry(ctx, func() error {
+			var err error
+			_, etag, err = client.GetInstance(instName)
+			return err
+		})
+		if getErr != nil {
+			return fmt.Errorf("Failed to retrieve instance %q: %w", instName, getErr)
+		}
+	}
+}
+
+// connectDevLXDOnStartup connects to devLXD, retrying with exponential backoff
+// while the devLXD socket does not exist yet, which is expected if the driver
+// starts before LXD exposes the socket. Other errors, such as a socket path
+// that exists but is not a socket, are returned immediately.
+func (d *Driver) connectDevLXDOnStartup(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, devLXDStartupTimeout)
+	defer cancel()
+
+	delay := retryBaseDelay
+
+	for {
+		_, err := d.DevLXDClient()
+		if err == nil || !isDevLXDSocketPending(err) {
+			return err
+		}
+
+		klog.InfoS("Waiting for devLXD socket", "retryIn", delay, "err", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		delay = min(delay*2, retryMaxDelay)
+	}
+}
+
+// isDevLXDSocketPending returns true if the given error indicates that the
+// devLXD socket does not exist yet. An error caused by a path that is not a
+// socket is never considered pending, even if other endpoints are missing.
+func isDevLXDSocketPending(err error) bool {
+	return errors.Is(err, devlxd.ErrSocketNotFound) && !errors.Is(err, devlxd.ErrNotSocket)
+}
+
+// isRetryable returns true if the given error is considered transient.
+// Both gRPC status errors and LXD API errors are recognized.
+func isRetryable(err error) bool {
+	// Requests over the LXD operation limit have already waited for a free
+	// slot, so the error is returned for the caller to back off.
+	if errors.As(err, &operationLimitError{}) {
+		return false
+	}
+
+	code := lxderrors.ToGRPCCode(err)
+
+	s, ok := status.FromError(err)
+	if ok {
+		code = s.Code()
+	}
+
+	switch code {
+	case codes.Unavailable, codes.ResourceExhausted:
+		return true
+	}
+
+	return false
+}
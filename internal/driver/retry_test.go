@@ -0,0 +1,393 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/canonical/lxd-csi-driver/internal/devlxd"
+	lxdClient "github.com/canonical/lxd/client"
+	"github.com/canonical/lxd/shared/api"
+)
+
+func TestWithRetry(t *testing.T) {
+	// Speed up retries.
+	oldBaseDelay := retryBaseDelay
+	oldMaxDelay := retryMaxDelay
+	retryBaseDelay = time.Millisecond
+	retryMaxDelay = time.Millisecond
+	t.Cleanup(func() {
+		retryBaseDelay = oldBaseDelay
+		retryMaxDelay = oldMaxDelay
+	})
+
+	tests := []struct {
+		Name        string
+		Errors      []error
+		expectCalls int
+		expectErr   bool
+	}{
+		{
+			Name:        "Success on first attempt",
+			Errors:      []error{nil},
+			expectCalls: 1,
+		},
+		{
+			Name: "Success after transient LXD errors",
+			Errors: []error{
+				api.StatusErrorf(http.StatusPreconditionFailed, "ETag mismatch"),
+				api.StatusErrorf(http.StatusPreconditionFailed, "ETag mismatch"),
+				nil,
+			},
+			expectCalls: 3,
+		},
+		{
+			Name: "Success after resource exhausted error",
+			Errors: []error{
+				status.Error(codes.ResourceExhausted, "Too many requests"),
+				nil,
+			},
+			expectCalls: 2,
+		},
+		{
+			Name: "Non-retryable error is returned immediately",
+			Errors: []error{
+				api.StatusErrorf(http.StatusNotFound, "Not found"),
+				nil,
+			},
+			expectCalls: 1,
+			expectErr:   true,
+		},
+		{
+			Name: "Retries are bounded",
+			Errors: []error{
+				status.Error(codes.Unavailable, "Unavailable"),
+				status.Error(codes.Unavailable, "Unavailable"),
+				status.Error(codes.Unavailable, "Unavailable"),
+				status.Error(codes.Unavailable, "Unavailable"),
+				status.Error(codes.Unavailable, "Unavailable"),
+				nil,
+			},
+			expectCalls: retryAttempts,
+			expectErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			calls := 0
+			err := withRetry(context.Background(), func() error {
+				err := test.Errors[calls]
+				calls++
+				return err
+			})
+
+			require.Equal(t, test.expectCalls, calls)
+
+			if test.expectErr {
+				// The error from the last attempt is returned.
+				require.ErrorIs(t, err, test.Errors[calls-1])
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestWithRetryContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := 0
+	err := withRetry(ctx, func() error {
+		calls++
+		return status.Error(codes.Unavailable, "Unavailable")
+	})
+
+	require.Error(t, err)
+	require.Equal(t, 1, calls)
+}
+
+func TestWithInstanceUpdateRetry(t *testing.T) {
+	etagMismatch := api.StatusErrorf(http.StatusPreconditionFailed, "ETag mismatch")
+
+	tests := []struct {
+		Name        string
+		Errors      []error
+		expectETags []string
+		expectErr   error
+	}{
+		{
+			Name:        "Success on first attempt",
+			Errors:      []error{nil},
+			expectETags: []string{"etag-0"},
+		},
+		{
+			Name:        "Conflict on first attempt succeeds on second",
+			Errors:      []error{etagMismatch, nil},
+			expectETags: []string{"etag-0", "etag-1"},
+		},
+		{
+			Name:        "Attempts are bounded",
+			Errors:      []error{etagMismatch, etagMismatch, etagMismatch, nil},
+			expectETags: []string{"etag-0", "etag-1", "etag-2"},
+			expectErr:   etagMismatch,
+		},
+		{
+			Name:        "Other errors are not retried",
+			Errors:      []error{api.StatusErrorf(http.StatusBadRequest, "Invalid devices"), nil},
+			expectETags: []string{"etag-0"},
+			expectErr:   api.StatusErrorf(http.StatusBadRequest, "Invalid devices"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			reads := 0
+			client := &fakeDevLXDServer{
+				getInstFunc: func(name string) (*api.DevLXDInstance, string, error) {
+					require.Equal(t, "node-1", name)
+					reads++
+					return &api.DevLXDInstance{Name: name}, "etag-" + strconv.Itoa(reads), nil
+				},
+			}
+
+			var etags []string
+			err := withInstanceUpdateRetry(context.Background(), client, "node-1", "etag-0", func(etag string) error {
+				etags = append(etags, etag)
+				return test.Errors[len(etags)-1]
+			})
+
+			require.Equal(t, test.expectErr, err)
+			require.Equal(t, test.expectETags, etags)
+
+			// The instance is re-read before each retry only.
+			require.Equal(t, len(test.expectETags)-1, reads)
+		})
+	}
+}
+
+func TestControllerPublishVolumeETagConflict(t *testing.T) {
+	devices := map[string]map[string]string{}
+	client := newFakeInstanceDevLXDServer(map[string]map[string]map[string]string{"node": devices})
+
+	// The instance is modified concurrently after it was retrieved by the
+	// controller, so that the first update fails with an ETag mismatch.
+	getInst := client.getInstFunc
+	reads := 0
+	client.getInstFunc = func(name string) (*api.DevLXDInstance, string, error) {
+		reads++
+		inst, _, err := getInst(name)
+		return inst, strconv.Itoa(reads), err
+	}
+
+	updateInst := client.updateInstFunc
+	conflict := true
+	var etags []string
+	client.updateInstFunc = func(name string, inst api.DevLXDInstancePut, etag string) error {
+		etags = append(etags, etag)
+		if conflict {
+			conflict = false
+			return api.StatusErrorf(http.StatusPreconditionFailed, "ETag mismatch")
+		}
+
+		return updateInst(name, inst, etag)
+	}
+
+	controller := NewControllerServer(&Driver{devLXD: client})
+
+	_, err := controller.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId: "remote/pvc-vol",
+		NodeId:   "node",
+		VolumeCapability: &csi.VolumeCapability{
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+			AccessType: &csi.VolumeCapability_Block{
+				Block: &csi.VolumeCapability_BlockVolume{},
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1", "2"}, etags)
+	require.Contains(t, devices, getDeviceName("remote", "pvc-vol"))
+
+	// Detaching the volume is retried the same way.
+	conflict = true
+	etags = nil
+	_, err = controller.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{
+		VolumeId: "remote/pvc-vol",
+		NodeId:   "node",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"3", "4"}, etags)
+	require.Empty(t, devices)
+}
+
+func TestControllerRetriesRepeatableCalls(t *testing.T) {
+	// Speed up retries.
+	oldBaseDelay := retryBaseDelay
+	retryBaseDelay = time.Millisecond
+	t.Cleanup(func() { retryBaseDelay = oldBaseDelay })
+
+	unavailable := api.StatusErrorf(http.StatusServiceUnavailable, "LXD is unavailable")
+
+	var creates, deletes int
+	client := newFakePoolDevLXDServer("ceph", true)
+	client.getVolFunc = func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+		if name == "pvc-vol" {
+			return &api.DevLXDStorageVolume{Name: name}, "", nil
+		}
+
+		return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage volume not found")
+	}
+
+	client.createVolFunc = func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+		creates++
+		return nil, unavailable
+	}
+
+	client.deleteSnapFunc = func(pool string, volType string, volName string, name string) (lxdClient.DevLXDOperation, error) {
+		deletes++
+		if deletes == 1 {
+			return nil, unavailable
+		}
+
+		return &fakeDevLXDOperation{}, nil
+	}
+
+	d := &Driver{devLXD: client}
+	d.SetControllerServiceCapabilities(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT)
+
+	controller := NewControllerServer(d)
+
+	// A failed volume creation is reported with the original error, and
+	// left to the retried request.
+	_, err := controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:          "pvc-new",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 1024 * 1024 * 1024},
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			},
+		},
+		Parameters: map[string]string{ParameterStoragePool: "remote"},
+	})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, 1, creates)
+
+	// A failed snapshot deletion is retried.
+	_, err = controller.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{
+		SnapshotId: "remote/pvc-vol/snapshot-1",
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, deletes)
+}
+
+func TestConnectDevLXDOnStartup(t *testing.T) {
+	// Speed up retries.
+	oldBaseDelay := retryBaseDelay
+	oldMaxDelay := retryMaxDelay
+	retryBaseDelay = time.Millisecond
+	retryMaxDelay = time.Millisecond
+	t.Cleanup(func() {
+		retryBaseDelay = oldBaseDelay
+		retryMaxDelay = oldMaxDelay
+	})
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret-token"), 0o600))
+
+	trustedClient := &fakeDevLXDServer{
+		getStateFunc: func() (*api.DevLXDGet, error) {
+			return &api.DevLXDGet{DevLXDGetUntrusted: api.DevLXDGetUntrusted{Auth: api.AuthTrusted}}, nil
+		},
+	}
+
+	tests := []struct {
+		Name        string
+		Errors      []error
+		expectCalls int
+	}{
+		{
+			Name:        "Socket exists",
+			Errors:      []error{nil},
+			expectCalls: 1,
+		},
+		{
+			Name:        "Socket appears later",
+			Errors:      []error{devlxd.ErrSocketNotFound, devlxd.ErrSocketNotFound, nil},
+			expectCalls: 3,
+		},
+		{
+			Name:        "Path is not a socket",
+			Errors:      []error{devlxd.ErrNotSocket, nil},
+			expectCalls: 1,
+		},
+		{
+			Name:        "Other connection error",
+			Errors:      []error{errors.New("Permission denied"), nil},
+			expectCalls: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			calls := 0
+			d := NewDriver(DriverOptions{
+				DevLXDTokenFile: tokenFile,
+				DevLXDConnector: func(endpoint string, bearerToken string) (DevLXDClient, error) {
+					err := test.Errors[calls]
+					calls++
+					if err != nil {
+						return nil, err
+					}
+
+					return trustedClient, nil
+				},
+			})
+
+			err := d.connectDevLXDOnStartup(context.Background())
+			require.Equal(t, test.expectCalls, calls)
+
+			// The error from the last attempt is returned.
+			if test.Errors[calls-1] != nil {
+				require.ErrorIs(t, err, test.Errors[calls-1])
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestConnectDevLXDOnStartupTimeout(t *testing.T) {
+	oldTimeout := devLXDStartupTimeout
+	devLXDStartupTimeout = 10 * time.Millisecond
+	t.Cleanup(func() {
+		devLXDStartupTimeout = oldTimeout
+	})
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret-token"), 0o600))
+
+	d := NewDriver(DriverOptions{
+		DevLXDTokenFile: tokenFile,
+		DevLXDConnector: func(endpoint string, bearerToken string) (DevLXDClient, error) {
+			return nil, devlxd.ErrSocketNotFound
+		},
+	})
+
+	err := d.connectDevLXDOnStartup(context.Background())
+	require.ErrorIs(t, err, devlxd.ErrSocketNotFound)
+}
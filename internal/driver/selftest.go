@@ -0,0 +1,106 @@
+package driver
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/canonical/lxd/shared/api"
+)
+
+// SelfTest checks whether the driver can connect to devLXD using the configured
+// endpoints and token file, and whether it is trusted by the LXD server. It reports
+// the LXD server information and the drivers of the given storage pools to w.
+// The devLXD API does not allow listing storage pools, so only the given pools
+// are checked. It does not start the gRPC server. An error is returned if any
+// check fails, and each failure is reported along with a hint how to fix it.
+func (d *Driver) SelfTest(w io.Writer, storagePools []string) error {
+	var failures int
+
+	pass := func(format string, args ...any) {
+		_, _ = fmt.Fprintf(w, "[PASS] "+format+"\n", args...)
+	}
+
+	fail := func(err error, hint string) {
+		failures++
+		_, _ = fmt.Fprintf(w, "[FAIL] %v\n       %s\n", err, hint)
+	}
+
+	err := d.Validate()
+	if err != nil {
+		fail(err, "Fix the driver flags.")
+		return fmt.Errorf("Self-test failed: %d check(s) failed", failures)
+	}
+
+	pass("Driver configuration is valid")
+
+	_, err = d.readDevLXDToken()
+	if err != nil {
+		fail(err, fmt.Sprintf("Ensure the secret containing the devLXD token is mounted at %q.", d.devLXDTokenFile))
+		return fmt.Errorf("Self-test failed: %d check(s) failed", failures)
+	}
+
+	pass("DevLXD token file %q is readable", d.devLXDTokenFile)
+
+	client, err := d.DevLXDClient()
+	if err != nil {
+		fail(err, "Ensure the devLXD socket is exposed to the instance (security.devlxd) and the token belongs to an LXD identity trusted by the server.")
+		return fmt.Errorf("Self-test failed: %d check(s) failed", failures)
+	}
+
+	info, err := client.GetState()
+	if err != nil {
+		fail(fmt.Errorf("Failed to get LXD server info: %w", err), "Ensure the LXD server is reachable.")
+		return fmt.Errorf("Self-test failed: %d check(s) failed", failures)
+	}
+
+	pass("Connected to devLXD at %q and trusted by the LXD server", d.activeDevLXDEndpoint)
+
+	location := info.Location
+	if location == "" {
+		location = "none"
+	}
+
+	storageDrivers := make([]string, 0, len(info.SupportedStorageDrivers))
+	for _, driver := range info.SupportedStorageDrivers {
+		storageDrivers = append(storageDrivers, driver.Name)
+	}
+
+	slices.Sort(storageDrivers)
+
+	_, _ = fmt.Fprintf(w, "       LXD API version: %s\n", info.APIVersion)
+	_, _ = fmt.Fprintf(w, "       LXD clustered: %t\n", info.Environment.ServerClustered)
+	_, _ = fmt.Fprintf(w, "       LXD location: %s\n", location)
+	_, _ = fmt.Fprintf(w, "       LXD storage drivers: %s\n", strings.Join(storageDrivers, ", "))
+
+	for _, poolName := range storagePools {
+		pool, _, err := client.GetStoragePool(poolName)
+		if err != nil {
+			fail(fmt.Errorf("Failed to retrieve storage pool %q: %w", poolName, err), "Ensure the storage pool exists and the LXD identity of the token has access to it.")
+			continue
+		}
+
+		var driver *api.DevLXDServerStorageDriverInfo
+		for _, storageDriver := range info.SupportedStorageDrivers {
+			if storageDriver.Name == pool.Driver {
+				driver = &storageDriver
+				break
+			}
+		}
+
+		supported, reason := isSupportedStorageDriver(driver)
+		if !supported {
+			fail(fmt.Errorf("Storage pool %q uses unsupported driver %q: %s", poolName, pool.Driver, reason), "Use a storage pool with a block or filesystem storage driver.")
+			continue
+		}
+
+		pass("Storage pool %q is accessible and uses driver %q (remote: %t)", poolName, pool.Driver, driver.Remote)
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("Self-test failed: %d check(s) failed", failures)
+	}
+
+	return nil
+}
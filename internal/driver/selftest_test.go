@@ -0,0 +1,135 @@
+package driver
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/canonical/lxd/shared/api"
+)
+
+func TestSelfTest(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret-token"), 0o600))
+
+	trustedState := func() (*api.DevLXDGet, error) {
+		return &api.DevLXDGet{
+			DevLXDGetUntrusted: api.DevLXDGetUntrusted{
+				APIVersion: "1.0",
+				Location:   "lxd01",
+				Auth:       api.AuthTrusted,
+				SupportedStorageDrivers: []api.DevLXDServerStorageDriverInfo{
+					{Name: "zfs", Remote: false},
+					{Name: "ceph", Remote: true},
+					{Name: "cephobject", Remote: true},
+				},
+			},
+			Environment: api.DevLXDServerEnvironment{ServerClustered: true},
+		}, nil
+	}
+
+	getPool := func(pool string) (*api.DevLXDStoragePool, string, error) {
+		switch pool {
+		case "local":
+			return &api.DevLXDStoragePool{Name: pool, Driver: "zfs"}, "", nil
+		case "remote":
+			return &api.DevLXDStoragePool{Name: pool, Driver: "ceph"}, "", nil
+		case "objects":
+			return &api.DevLXDStoragePool{Name: pool, Driver: "cephobject"}, "", nil
+		default:
+			return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage pool not found")
+		}
+	}
+
+	tests := []struct {
+		Name         string
+		TokenFile    string
+		Client       *fakeDevLXDServer
+		StoragePools []string
+		expectError  string
+		expectOutput []string
+	}{
+		{
+			Name:        "Missing token file",
+			TokenFile:   filepath.Join(t.TempDir(), "missing"),
+			Client:      &fakeDevLXDServer{getStateFunc: trustedState},
+			expectError: "Self-test failed: 1 check(s) failed",
+			expectOutput: []string{
+				"[PASS] Driver configuration is valid",
+				"[FAIL] Failed reading DevLXD bearer token from file",
+			},
+		},
+		{
+			Name:      "Untrusted client",
+			TokenFile: tokenFile,
+			Client: &fakeDevLXDServer{
+				getStateFunc: func() (*api.DevLXDGet, error) {
+					return &api.DevLXDGet{DevLXDGetUntrusted: api.DevLXDGetUntrusted{Auth: api.AuthUntrusted}}, nil
+				},
+			},
+			expectError: "Self-test failed: 1 check(s) failed",
+			expectOutput: []string{
+				"[PASS] DevLXD token file",
+				"[FAIL] Failed to use devLXD at \"unix:///dev/lxd/sock\": Failed to authenticate with DevLXD server: Client is not trusted",
+				"security.devlxd",
+			},
+		},
+		{
+			Name:         "Supported storage pools",
+			TokenFile:    tokenFile,
+			Client:       &fakeDevLXDServer{getStateFunc: trustedState, getPoolFunc: getPool},
+			StoragePools: []string{"local", "remote"},
+			expectOutput: []string{
+				"[PASS] Connected to devLXD at \"unix:///dev/lxd/sock\" and trusted by the LXD server",
+				"LXD API version: 1.0",
+				"LXD clustered: true",
+				"LXD location: lxd01",
+				"LXD storage drivers: ceph, cephobject, zfs",
+				"[PASS] Storage pool \"local\" is accessible and uses driver \"zfs\" (remote: false)",
+				"[PASS] Storage pool \"remote\" is accessible and uses driver \"ceph\" (remote: true)",
+			},
+		},
+		{
+			Name:         "Missing and unsupported storage pools",
+			TokenFile:    tokenFile,
+			Client:       &fakeDevLXDServer{getStateFunc: trustedState, getPoolFunc: getPool},
+			StoragePools: []string{"missing", "objects", "local"},
+			expectError:  "Self-test failed: 2 check(s) failed",
+			expectOutput: []string{
+				"[FAIL] Failed to retrieve storage pool \"missing\": Storage pool not found",
+				"[FAIL] Storage pool \"objects\" uses unsupported driver \"cephobject\"",
+				"[PASS] Storage pool \"local\" is accessible and uses driver \"zfs\" (remote: false)",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			d := NewDriver(DriverOptions{
+				Name:             DefaultDriverName,
+				DevLXDEndpoint:   DefaultDevLXDEndpoint,
+				VolumeNamePrefix: DefaultVolumeNamePrefix,
+				DevLXDTokenFile:  test.TokenFile,
+				DevLXDConnector: func(endpoint string, bearerToken string) (DevLXDClient, error) {
+					return test.Client, nil
+				},
+			})
+
+			var out bytes.Buffer
+			err := d.SelfTest(&out, test.StoragePools)
+			if test.expectError == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, test.expectError)
+			}
+
+			for _, line := range test.expectOutput {
+				require.Contains(t, out.String(), line)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"k8s.io/klog/v2"
+
+	"github.com/canonical/lxd-csi-driver/internal/lxderrors"
+	"github.com/canonical/lxd/shared/api"
+)
+
+// VolumeLockVolumeName is the name of the LXD custom volume created in each
+// storage pool to hold the distributed locks of the volumes in that pool.
+// The locks are stored only in the volume configuration, and the volume is
+// never exposed as a CSI volume.
+const VolumeLockVolumeName = "lxd-csi-locks"
+
+// volumeLockVolumeSize is the size of the lock volume. Its content is never
+// used, so it is created as a block volume, which does not need to fit a
+// filesystem, with the smallest size supported by all storage drivers (the
+// default LVM extent size).
+const volumeLockVolumeSize = "4MiB"
+
+// lockVolumeContentType returns the content type of the lock volume in a storage
+// pool with the given driver. Storage drivers that do not support custom block
+// volumes use a filesystem volume without a size, which takes no space.
+func lockVolumeContentType(driver string) string {
+	switch driver {
+	case "cephfs", "dir":
+		return "filesystem"
+	default:
+		return "block"
+	}
+}
+
+// volumeLockConfigPrefix is the prefix of the configuration keys of the lock
+// volume. Each key holds the lock of a single volume, named after the key suffix.
+const volumeLockConfigPrefix = "user.lxd-csi.lock."
+
+// volumeLockTTL is the duration after which a lock is considered abandoned,
+// for example, because the controller holding it crashed. Another controller
+// can then take over the lock.
+var volumeLockTTL = 30 * time.Minute
+
+// errVolumeLocked is returned when the lock of a volume is held by another
+// controller.
+var errVolumeLocked = errors.New("Volume is locked by another controller")
+
+// volumeLocker guards volumes against concurrent requests from multiple
+// controller replicas. It complements the per-process locks, which only
+// serialize requests within a single controller.
+type volumeLocker interface {
+	// Lock acquires the lock of the volume with the given ID. On success, it
+	// returns a function that releases the lock. If the lock is held by
+	// another controller, an error wrapping [errVolumeLocked] is returned.
+	Lock(ctx context.Context, volumeID string) (func(), error)
+}
+
+// lxdVolumeLocker implements [volumeLocker] using the configuration of a
+// dedicated LXD custom volume in the storage pool of the locked volume. Locks
+// are stored as "<holder> <expiry>" values of the volume configuration, and
+// updated using the volume ETag, so that only one controller acquires a lock.
+type lxdVolumeLocker struct {
+	driver *Driver
+
+	// Identity of the controller holding the locks.
+	holder string
+}
+
+// newLXDVolumeLocker returns a volume locker storing the locks in LXD under
+// the given holder identity.
+func newLXDVolumeLocker(driver *Driver, holder string) *lxdVolumeLocker {
+	return &lxdVolumeLocker{
+		driver: driver,
+		holder: holder,
+	}
+}
+
+// volumeLockHolder returns the identity under which the driver holds volume
+// locks. The pod name is used, falling back to the node ID.
+func volumeLockHolder(driver *Driver) string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return driver.nodeID
+	}
+
+	return hostname
+}
+
+// Lock acquires the lock of the volume with the given ID.
+func (l *lxdVolumeLocker) Lock(ctx context.Context, volumeID string) (func(), error) {
+	target, poolName, volName, err := splitVolumeID(volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := l.driver.DevLXDClient()
+	if err != nil {
+		return nil, err
+	}
+
+	// Locks of volumes on local storage pools are stored on the same cluster member.
+	if target != "" && l.driver.isClustered {
+		client = client.UseTarget(target)
+	}
+
+	key := volumeLockConfigPrefix + volName
+
+	err = l.ensureLockVolume(ctx, client, poolName)
+	if err != nil {
+		return nil, err
+	}
+
+	// An ETag mismatch is retried by withRetry with the updated lock volume.
+	err = withRetry(ctx, func() error {
+		return l.updateLock(ctx, client, poolName, func(config map[string]string) (bool, error) {
+			holder, expiry, ok := parseVolumeLock(config[key])
+			if ok && holder != l.holder && time.Now().Before(expiry) {
+				return false, fmt.Errorf("%w %q until %s", errVolumeLocked, holder, expiry.Format(time.RFC3339))
+			}
+
+			config[key] = formatVolumeLock(l.holder, time.Now().Add(volumeLockTTL))
+			return true, nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to lock volume %q: %w", volumeID, err)
+	}
+
+	unlock := func() {
+		// Release the lock even if the request has been cancelled.
+		ctx := context.WithoutCancel(ctx)
+
+		err := withRetry(ctx, func() error {
+			return l.updateLock(ctx, client, poolName, func(config map[string]string) (bool, error) {
+				holder, _, ok := parseVolumeLock(config[key])
+				if !ok || holder != l.holder {
+					// The lock has expired and has been taken over.
+					return false, nil
+				}
+
+				delete(config, key)
+				return true, nil
+			})
+		})
+		if err != nil {
+			// The lock expires eventually, so that the volume is not locked forever.
+			klog.ErrorS(err, "Failed to unlock volume", "volumeID", volumeID)
+		}
+	}
+
+	return unlock, nil
+}
+
+// lockVolume acquires the distributed lock of the volume with the given ID, if
+// distributed locking is enabled. It returns a function that releases the lock.
+func (c *controllerServer) lockVolume(ctx context.Context, volumeID string) (func(), error) {
+	if c.volumeLocks == nil {
+		return func() {}, nil
+	}
+
+	return c.volumeLocks.Lock(ctx, volumeID)
+}
+
+// volumeLockErrorCode returns the gRPC code for an error returned when locking
+// a volume. A volume locked by another controller is reported as [codes.Aborted],
+// the same as a volume locked by a concurrent request within the controller.
+func volumeLockErrorCode(err error) codes.Code {
+	if errors.Is(err, errVolumeLocked) {
+		return codes.Aborted
+	}
+
+	return lxderrors.ToGRPCCode(err)
+}
+
+// ensureLockVolume creates the lock volume in the given storage pool, unless
+// it already exists.
+func (l *lxdVolumeLocker) ensureLockVolume(ctx context.Context, client DevLXDClient, poolName string) error {
+	err := withRetry(ctx, func() error {
+		_, _, err := client.GetStoragePoolVolume(poolName, "custom", VolumeLockVolumeName)
+		return err
+	})
+	if err == nil {
+		return nil
+	}
+
+	if !api.StatusErrorCheck(err, http.StatusNotFound) {
+		return fmt.Errorf("Failed to retrieve lock volume %q from pool %q: %w", VolumeLockVolumeName, poolName, err)
+	}
+
+	var pool *api.DevLXDStoragePool
+	err = withRetry(ctx, func() error {
+		pool, _, err = client.GetStoragePool(poolName)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve storage pool %q: %w", poolName, err)
+	}
+
+	vol := api.DevLXDStorageVolumesPost{
+		Name:        VolumeLockVolumeName,
+		Type:        "custom",
+		ContentType: lockVolumeContentType(pool.Driver),
+		DevLXDStorageVolumePut: api.DevLXDStorageVolumePut{
+			Description: "Locks of the LXD CSI controllers",
+			Config:      map[string]string{},
+		},
+	}
+
+	if vol.ContentType == "block" {
+		vol.Config["size"] = volumeLockVolumeSize
+	}
+
+	err = withRetry(ctx, func() error {
+		op, err := client.CreateStoragePoolVolume(poolName, vol)
+		if err != nil {
+			return err
+		}
+
+		return op.WaitContext(ctx)
+	})
+
+	// The lock volume may have been created concurrently by another controller,
+	// or by a previous attempt, so a conflict makes the creation safe to retry.
+	if err != nil && !api.StatusErrorCheck(err, http.StatusConflict) {
+		return fmt.Errorf("Failed to create lock volume %q in pool %q: %w", VolumeLockVolumeName, poolName, err)
+	}
+
+	return nil
+}
+
+// updateLock reads the configuration of the lock volume, passes it to the
+// given function, and writes it back if the function reports a change. The
+// update fails with [http.StatusPreconditionFailed] if the lock volume has
+// been modified in the meantime.
+func (l *lxdVolumeLocker) updateLock(ctx context.Context, client DevLXDClient, poolName string, update func(config map[string]string) (bool, error)) error {
+	vol, etag, err := client.GetStoragePoolVolume(poolName, "custom", VolumeLockVolumeName)
+	if err != nil {
+		return err
+	}
+
+	config := maps.Clone(vol.Config)
+	if config == nil {
+		config = make(map[string]string)
+	}
+
+	changed, err := update(config)
+	if err != nil || !changed {
+		return err
+	}
+
+	volReq := api.DevLXDStorageVolumePut{
+		Description: vol.Description,
+		Config:      config,
+	}
+
+	op, err := client.UpdateStoragePoolVolume(poolName, "custom", VolumeLockVolumeName, volReq, etag)
+	if err != nil {
+		return err
+	}
+
+	return op.WaitContext(ctx)
+}
+
+// formatVolumeLock returns the configuration value of a lock held by the given
+// holder until the given expiry.
+func formatVolumeLock(holder string, expiry time.Time) string {
+	return holder + " " + expiry.UTC().Format(time.RFC3339)
+}
+
+// parseVolumeLock parses the configuration value of a lock. It returns false
+// if the value is empty or malformed, in which case the lock is not held.
+func parseVolumeLock(value string) (holder string, expiry time.Time, ok bool) {
+	holder, expiryValue, found := strings.Cut(value, " ")
+	if !found || holder == "" {
+		return "", time.Time{}, false
+	}
+
+	expiry, err := time.Parse(time.RFC3339, expiryValue)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+
+	return holder, expiry, true
+}
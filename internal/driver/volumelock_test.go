@@ -0,0 +1,401 @@
+package driver
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	lxdClient "github.com/canonical/lxd/client"
+	"github.com/canonical/lxd/shared/api"
+)
+
+// newFakeLockVolumeDevLXDServer returns a fake devLXD server storing the lock
+// volume in memory. The ETag of the lock volume is incremented on each update,
+// and updates with a stale ETag fail. The conflicts function, if set, is called
+// before each update and returns true to simulate a concurrent update.
+func newFakeLockVolumeDevLXDServer(conflicts func() bool) (*fakeDevLXDServer, func() *api.DevLXDStorageVolume) {
+	var lockVol *api.DevLXDStorageVolume
+	etag := 0
+
+	client := &fakeDevLXDServer{
+		getVolFunc: func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+			if name != VolumeLockVolumeName || lockVol == nil {
+				return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage volume not found")
+			}
+
+			vol := *lockVol
+			return &vol, strconv.Itoa(etag), nil
+		},
+		createVolFunc: func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+			if lockVol != nil {
+				return nil, api.StatusErrorf(http.StatusConflict, "Storage volume already exists")
+			}
+
+			lockVol = &api.DevLXDStorageVolume{Name: volume.Name, ContentType: volume.ContentType, Config: volume.Config}
+			return &fakeDevLXDOperation{}, nil
+		},
+		updateVolFunc: func(pool string, volType string, name string, volume api.DevLXDStorageVolumePut, ETag string) (lxdClient.DevLXDOperation, error) {
+			if conflicts != nil && conflicts() {
+				etag++
+			}
+
+			if ETag != strconv.Itoa(etag) {
+				return nil, api.StatusErrorf(http.StatusPreconditionFailed, "ETag doesn't match")
+			}
+
+			etag++
+			lockVol.Config = volume.Config
+			return &fakeDevLXDOperation{}, nil
+		},
+	}
+
+	return client, func() *api.DevLXDStorageVolume { return lockVol }
+}
+
+func TestLXDVolumeLocker(t *testing.T) {
+	// Speed up retries.
+	oldBaseDelay := retryBaseDelay
+	retryBaseDelay = time.Millisecond
+	t.Cleanup(func() { retryBaseDelay = oldBaseDelay })
+
+	// Simulate a concurrent update of the lock volume on the first update.
+	conflicted := false
+	client, lockVolume := newFakeLockVolumeDevLXDServer(func() bool {
+		if conflicted {
+			return false
+		}
+
+		conflicted = true
+		return true
+	})
+
+	d := &Driver{devLXD: client}
+	lockerA := newLXDVolumeLocker(d, "controller-a")
+	lockerB := newLXDVolumeLocker(d, "controller-b")
+
+	// The lock volume is created with the first lock.
+	unlockA, err := lockerA.Lock(context.Background(), "remote/pvc-1")
+	require.NoError(t, err)
+	require.True(t, conflicted)
+	require.NotNil(t, lockVolume())
+	require.Equal(t, "block", lockVolume().ContentType)
+	require.Equal(t, volumeLockVolumeSize, lockVolume().Config["size"])
+
+	holder, expiry, ok := parseVolumeLock(lockVolume().Config[volumeLockConfigPrefix+"pvc-1"])
+	require.True(t, ok)
+	require.Equal(t, "controller-a", holder)
+	require.True(t, expiry.After(time.Now()))
+
+	// Another controller cannot acquire the same lock, but can acquire others.
+	_, err = lockerB.Lock(context.Background(), "remote/pvc-1")
+	require.ErrorIs(t, err, errVolumeLocked)
+	require.Equal(t, codes.Aborted, volumeLockErrorCode(err))
+
+	unlockB, err := lockerB.Lock(context.Background(), "remote/pvc-2")
+	require.NoError(t, err)
+	require.Contains(t, lockVolume().Config, volumeLockConfigPrefix+"pvc-1")
+	require.Contains(t, lockVolume().Config, volumeLockConfigPrefix+"pvc-2")
+
+	// Releasing a lock removes only its own key.
+	unlockA()
+	require.NotContains(t, lockVolume().Config, volumeLockConfigPrefix+"pvc-1")
+	require.Contains(t, lockVolume().Config, volumeLockConfigPrefix+"pvc-2")
+
+	unlockB()
+	require.Equal(t, map[string]string{"size": volumeLockVolumeSize}, lockVolume().Config)
+
+	// The released lock can be acquired by another controller.
+	unlockB, err = lockerB.Lock(context.Background(), "remote/pvc-1")
+	require.NoError(t, err)
+	unlockB()
+
+	// An expired lock is taken over, and not released by its previous holder.
+	lockVolume().Config[volumeLockConfigPrefix+"pvc-3"] = formatVolumeLock("controller-a", time.Now().Add(-time.Minute))
+
+	unlockB, err = lockerB.Lock(context.Background(), "remote/pvc-3")
+	require.NoError(t, err)
+
+	holder, _, _ = parseVolumeLock(lockVolume().Config[volumeLockConfigPrefix+"pvc-3"])
+	require.Equal(t, "controller-b", holder)
+
+	lockVolume().Config[volumeLockConfigPrefix+"pvc-3"] = formatVolumeLock("controller-a", time.Now().Add(time.Minute))
+	unlockB()
+	require.Contains(t, lockVolume().Config, volumeLockConfigPrefix+"pvc-3")
+}
+
+func TestLXDVolumeLockerContentType(t *testing.T) {
+	tests := []struct {
+		Name              string
+		Driver            string
+		expectContentType string
+		expectConfig      map[string]string
+	}{
+		{
+			Name:              "Block lock volume on ZFS",
+			Driver:            "zfs",
+			expectContentType: "block",
+			expectConfig:      map[string]string{"size": volumeLockVolumeSize},
+		},
+		{
+			Name:              "Block lock volume on Ceph RBD",
+			Driver:            "ceph",
+			expectContentType: "block",
+			expectConfig:      map[string]string{"size": volumeLockVolumeSize},
+		},
+		{
+			Name:              "Filesystem lock volume on CephFS",
+			Driver:            "cephfs",
+			expectContentType: "filesystem",
+			expectConfig:      map[string]string{},
+		},
+		{
+			Name:              "Filesystem lock volume on dir",
+			Driver:            "dir",
+			expectContentType: "filesystem",
+			expectConfig:      map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			client, lockVolume := newFakeLockVolumeDevLXDServer(nil)
+			client.getPoolFunc = func(pool string) (*api.DevLXDStoragePool, string, error) {
+				return &api.DevLXDStoragePool{Name: pool, Driver: test.Driver}, "", nil
+			}
+
+			locker := newLXDVolumeLocker(&Driver{devLXD: client}, "controller-a")
+
+			unlock, err := locker.Lock(context.Background(), "remote/pvc-1")
+			require.NoError(t, err)
+			unlock()
+
+			require.Equal(t, test.expectContentType, lockVolume().ContentType)
+			require.Equal(t, test.expectConfig, lockVolume().Config)
+		})
+	}
+}
+
+func TestParseVolumeLock(t *testing.T) {
+	expiry := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		Name         string
+		Value        string
+		expectHolder string
+		expectExpiry time.Time
+		expectOK     bool
+	}{
+		{
+			Name:         "Valid lock",
+			Value:        formatVolumeLock("controller-a", expiry),
+			expectHolder: "controller-a",
+			expectExpiry: expiry,
+			expectOK:     true,
+		},
+		{
+			Name:  "Empty value",
+			Value: "",
+		},
+		{
+			Name:  "Missing expiry",
+			Value: "controller-a",
+		},
+		{
+			Name:  "Invalid expiry",
+			Value: "controller-a tomorrow",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			holder, expiry, ok := parseVolumeLock(test.Value)
+			require.Equal(t, test.expectOK, ok)
+			require.Equal(t, test.expectHolder, holder)
+			require.True(t, test.expectExpiry.Equal(expiry))
+		})
+	}
+}
+
+// fakeVolumeLocker records the volume locks acquired and released by the
+// controller server.
+type fakeVolumeLocker struct {
+	lockErr  error
+	held     map[string]bool
+	acquired []string
+	released []string
+}
+
+func (l *fakeVolumeLocker) Lock(_ context.Context, volumeID string) (func(), error) {
+	if l.lockErr != nil {
+		return nil, l.lockErr
+	}
+
+	if l.held[volumeID] {
+		return nil, errVolumeLocked
+	}
+
+	l.held[volumeID] = true
+	l.acquired = append(l.acquired, volumeID)
+
+	return func() {
+		delete(l.held, volumeID)
+		l.released = append(l.released, volumeID)
+	}, nil
+}
+
+func TestControllerVolumeLocks(t *testing.T) {
+	mountCapability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{},
+		},
+	}
+
+	// The volume name is derived from the PVC name without an explicit prefix.
+	pvcName := "pvc-7f3b2c1d-4e5a-4b6c-8d9e-0a1b2c3d4e5f"
+	volumeID := "remote/pvc-7f3b2c1d4e5a4b6c8d9e0a1b2c3d4e5f"
+
+	tests := []struct {
+		Name           string
+		PVCName        string
+		NamePrefix     string
+		VolumeID       string
+		Held           bool
+		LockErr        error
+		Delete         bool
+		expectCode     codes.Code
+		expectAcquired []string
+		expectReleased []string
+		expectLXDCalls int
+	}{
+		{
+			Name:           "CreateVolume acquires and releases the lock",
+			expectAcquired: []string{volumeID},
+			expectReleased: []string{volumeID},
+			expectLXDCalls: 1,
+		},
+		{
+			Name:       "CreateVolume fails if the volume is locked by another controller",
+			Held:       true,
+			expectCode: codes.Aborted,
+		},
+		{
+			Name:       "CreateVolume fails if the lock cannot be acquired",
+			LockErr:    api.StatusErrorf(http.StatusServiceUnavailable, "LXD is unavailable"),
+			expectCode: codes.Unavailable,
+		},
+		{
+			Name:           "DeleteVolume acquires and releases the lock",
+			Delete:         true,
+			expectAcquired: []string{volumeID},
+			expectReleased: []string{volumeID},
+			expectLXDCalls: 1,
+		},
+		{
+			Name:       "DeleteVolume fails if the volume is locked by another controller",
+			Delete:     true,
+			Held:       true,
+			expectCode: codes.Aborted,
+		},
+		{
+			Name:       "CreateVolume does not return the lock volume",
+			PVCName:    "locks",
+			NamePrefix: "lxd-csi",
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name:       "DeleteVolume does not delete the lock volume",
+			VolumeID:   "remote/" + VolumeLockVolumeName,
+			Delete:     true,
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name:           "DeleteVolume proceeds without lock if the storage pool does not exist",
+			Delete:         true,
+			LockErr:        api.StatusErrorf(http.StatusNotFound, "Storage pool not found"),
+			expectLXDCalls: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			lxdCalls := 0
+			volumeExists := test.Delete
+
+			fakeClient := newFakePoolDevLXDServer("ceph", true)
+			fakeClient.getVolFunc = func(pool string, volType string, name string) (*api.DevLXDStorageVolume, string, error) {
+				if !volumeExists {
+					return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage volume not found")
+				}
+
+				return &api.DevLXDStorageVolume{Name: name, Pool: pool, Type: volType}, "", nil
+			}
+
+			fakeClient.getSnapsFunc = func(pool string, volType string, volName string) ([]api.DevLXDStorageVolumeSnapshot, error) {
+				return nil, nil
+			}
+
+			fakeClient.createVolFunc = func(pool string, volume api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+				lxdCalls++
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			fakeClient.deleteVolFunc = func(pool string, volType string, name string) (lxdClient.DevLXDOperation, error) {
+				lxdCalls++
+				return &fakeDevLXDOperation{}, nil
+			}
+
+			locker := &fakeVolumeLocker{lockErr: test.LockErr, held: map[string]bool{}}
+			if test.Held {
+				locker.held[volumeID] = true
+			}
+
+			controller := NewControllerServer(&Driver{devLXD: fakeClient})
+			controller.volumeLocks = locker
+
+			reqVolumeID := volumeID
+			if test.VolumeID != "" {
+				reqVolumeID = test.VolumeID
+			}
+
+			reqName := pvcName
+			if test.PVCName != "" {
+				reqName = test.PVCName
+			}
+
+			parameters := map[string]string{ParameterStoragePool: "remote"}
+			if test.NamePrefix != "" {
+				parameters[ParameterVolumeNamePrefix] = test.NamePrefix
+			}
+
+			var err error
+			if test.Delete {
+				_, err = controller.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: reqVolumeID})
+			} else {
+				_, err = controller.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+					Name: reqName,
+					CapacityRange: &csi.CapacityRange{
+						RequiredBytes: 1024 * 1024 * 1024,
+					},
+					VolumeCapabilities: []*csi.VolumeCapability{mountCapability},
+					Parameters:         parameters,
+				})
+			}
+
+			require.Equal(t, test.expectCode, status.Code(err))
+			require.Equal(t, test.expectAcquired, locker.acquired)
+			require.Equal(t, test.expectReleased, locker.released)
+			require.Equal(t, test.expectLXDCalls, lxdCalls)
+			require.Equal(t, test.Held, locker.held[volumeID])
+		})
+	}
+}
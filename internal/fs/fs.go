@@ -5,10 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"maps"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -55,6 +58,33 @@ var mountFlagTypes = map[string]mountOption{
 	"sync":          {true, unix.MS_SYNCHRONOUS},
 }
 
+// MountPropagation represents the mount propagation of a published volume.
+type MountPropagation string
+
+const (
+	// MountPropagationNone makes the mount private. Mounts created on either side
+	// of the mount are not propagated to the other side.
+	MountPropagationNone MountPropagation = "None"
+
+	// MountPropagationHostToContainer makes the mount a slave mount. Mounts created
+	// on the host are propagated into the container, but not the other way around.
+	MountPropagationHostToContainer MountPropagation = "HostToContainer"
+
+	// MountPropagationBidirectional keeps the propagation inherited from the parent
+	// mount (typically shared), so mounts are propagated in both directions.
+	MountPropagationBidirectional MountPropagation = "Bidirectional"
+)
+
+// mountPropagationOptions maps mount options to the mount propagation.
+var mountPropagationOptions = map[string]MountPropagation{
+	"private":  MountPropagationNone,
+	"rprivate": MountPropagationNone,
+	"slave":    MountPropagationHostToContainer,
+	"rslave":   MountPropagationHostToContainer,
+	"shared":   MountPropagationBidirectional,
+	"rshared":  MountPropagationBidirectional,
+}
+
 // PathExists checks if the given path exists in the filesystem.
 func PathExists(name string) bool {
 	_, err := os.Lstat(name)
@@ -87,19 +117,290 @@ func ResolveMountOptions(options []string) (uintptr, string) {
 	return mountFlags, strings.Join(mountOptions, ",")
 }
 
-// IsMountPoint returns true if path is a mount point.
-func IsMountPoint(path string) (bool, error) {
+// mountOptionKey identifies mount options that override each other.
+type mountOptionKey struct {
+	flag uintptr
+	name string
+}
+
+// NormalizeMountOptions resolves conflicting mount options and removes duplicates.
+// Options that toggle the same mount flag, such as "ro" and "rw", and options
+// with the same key, such as "uid=1000" and "uid=0", override each other, where
+// the last one wins. Therefore, options that must take precedence should be
+// provided last. The order of the remaining options is preserved.
+func NormalizeMountOptions(options []string) []string {
+	seen := make(map[mountOptionKey]bool, len(options))
+	normalized := make([]string, 0, len(options))
+
+	// Walk the options backwards to keep the last option of each key.
+	for _, option := range slices.Backward(options) {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+
+		var key mountOptionKey
+
+		do, ok := mountFlagTypes[option]
+		if ok && do.flag != 0 {
+			key.flag = do.flag
+		} else {
+			key.name, _, _ = strings.Cut(option, "=")
+		}
+
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		normalized = append(normalized, option)
+	}
+
+	slices.Reverse(normalized)
+
+	return normalized
+}
+
+// ParseMountPropagation extracts the mount propagation from the given mount options.
+// Propagation options are removed from the returned mount options, because they cannot
+// be combined with other options in a single mount call. If multiple propagation options
+// are provided, the last one wins. If none is provided, [MountPropagationHostToContainer]
+// is returned.
+func ParseMountPropagation(options []string) (MountPropagation, []string) {
+	propagation := MountPropagationHostToContainer
+	remaining := make([]string, 0, len(options))
+
+	for _, option := range options {
+		p, ok := mountPropagationOptions[option]
+		if ok {
+			propagation = p
+			continue
+		}
+
+		remaining = append(remaining, option)
+	}
+
+	return propagation, remaining
+}
+
+// propagationFlags returns the mount flags used to change the propagation of the
+// mount at the target path. Zero is returned if propagation should not be changed.
+//
+// The cases are:
+//   - Bidirectional: propagation is not changed, so the mount keeps the shared
+//     propagation inherited from the parent mount.
+//   - None: mount is made private.
+//   - HostToContainer (default): mount is made a slave of the parent mount.
+//
+// For filesystem volumes, the propagation is applied recursively to all submounts.
+// Block volumes are bind mounts of a single device node which cannot have any
+// submounts, so the recursive flag is not needed.
+func propagationFlags(contentType string, propagation MountPropagation) uintptr {
+	var flags uintptr
+
+	switch propagation {
+	case MountPropagationBidirectional:
+		return 0
+	case MountPropagationNone:
+		flags = unix.MS_PRIVATE
+	default:
+		flags = unix.MS_SLAVE
+	}
+
+	if contentType == "filesystem" {
+		flags |= unix.MS_REC
+	}
+
+	return flags
+}
+
+// mountInfoPath is the path to the mount table of the current process.
+const mountInfoPath = "/proc/self/mountinfo"
+
+// IsMounted returns true if path is a mount point.
+// Bind mounts of device nodes reside on the same filesystem as their parent
+// directory and are not always detected by comparing device IDs, therefore
+// the mount table is consulted as well.
+func IsMounted(path string) (bool, error) {
 	mounter := kmount.New("")
 	mounted, err := mounter.IsMountPoint(path)
-	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+
 		return false, err
 	}
 
-	return mounted, nil
+	if mounted {
+		return true, nil
+	}
+
+	return isInMountInfo(mountInfoPath, path)
+}
+
+// mountInfoUnescaper reverts the octal escaping of special characters
+// in mount points listed in the mount table.
+var mountInfoUnescaper = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+// MountInfo contains information about a mount from the mount table.
+type MountInfo struct {
+	// MountPoint is the path where the filesystem is mounted.
+	MountPoint string
+
+	// MountOptions are the per-mount options, such as "ro" for a read-only bind mount.
+	MountOptions []string
+
+	// FSType is the type of the mounted filesystem.
+	FSType string
+
+	// Source is the mount source, such as the device path.
+	Source string
+
+	// SuperOptions are the options of the mounted filesystem (superblock), which
+	// are shared by all mounts of the filesystem.
+	SuperOptions []string
+}
+
+// IsReadOnly returns true if the mount is read-only.
+func (m MountInfo) IsReadOnly() bool {
+	return slices.Contains(m.MountOptions, "ro") || m.IsFilesystemReadOnly()
+}
+
+// IsFilesystemReadOnly returns true if the mounted filesystem itself is read-only,
+// regardless of the options of the individual mount.
+func (m MountInfo) IsFilesystemReadOnly() bool {
+	return slices.Contains(m.SuperOptions, "ro")
+}
+
+// GetMountInfo returns the mount table entry of the given mount point.
+// If the path is not a mount point, nil is returned.
+func GetMountInfo(path string) (*MountInfo, error) {
+	return findMountInfo(mountInfoPath, path)
+}
+
+// isInMountInfo checks whether the path is listed as a mount point in the
+// mount table at the given mountinfo path.
+func isInMountInfo(mountInfo string, path string) (bool, error) {
+	entry, err := findMountInfo(mountInfo, path)
+	if err != nil {
+		return false, err
+	}
+
+	return entry != nil, nil
+}
+
+// findMountInfo returns the entry of the mount table at the given mountinfo
+// path that is mounted at the given path. If multiple mounts are stacked on the
+// path, the topmost one is returned. If the path is not a mount point, nil is returned.
+func findMountInfo(mountInfo string, path string) (*MountInfo, error) {
+	// Mount points in the mount table have symlinks resolved.
+	resolvedPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		resolvedPath = filepath.Clean(path)
+	}
+
+	content, err := os.ReadFile(mountInfo)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read mount table %q: %w", mountInfo, err)
+	}
+
+	var entry *MountInfo
+
+	for line := range strings.SplitSeq(string(content), "\n") {
+		info, ok := parseMountInfoLine(line)
+		if ok && info.MountPoint == resolvedPath {
+			entry = &info
+		}
+	}
+
+	return entry, nil
+}
+
+// parseMountInfoLine parses a single line of the mount table in format:
+// "<id> <parent-id> <major>:<minor> <root> <mount-point> <options> [<optional>...] - <fstype> <source> <super-options>".
+func parseMountInfoLine(line string) (MountInfo, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 6 {
+		return MountInfo{}, false
+	}
+
+	info := MountInfo{
+		MountPoint:   mountInfoUnescaper.Replace(fields[4]),
+		MountOptions: strings.Split(fields[5], ","),
+	}
+
+	// Optional fields are terminated by a single hyphen.
+	separator := slices.Index(fields[6:], "-")
+	if separator >= 0 {
+		rest := fields[6+separator+1:]
+		if len(rest) > 0 {
+			info.FSType = rest[0]
+		}
+
+		if len(rest) > 1 {
+			info.Source = mountInfoUnescaper.Replace(rest[1])
+		}
+
+		if len(rest) > 2 {
+			info.SuperOptions = strings.Split(rest[2], ",")
+		}
+	}
+
+	return info, true
+}
+
+// IsMountOf checks whether the target path refers to the same block device
+// or filesystem object as the source path. It is used to verify that an
+// existing bind mount on the target path originates from the source path.
+func IsMountOf(sourcePath string, targetPath string) (bool, error) {
+	var source unix.Stat_t
+	err := unix.Stat(sourcePath, &source)
+	if err != nil {
+		return false, fmt.Errorf("Failed to stat %q: %w", sourcePath, err)
+	}
+
+	var target unix.Stat_t
+	err = unix.Stat(targetPath, &target)
+	if err != nil {
+		return false, fmt.Errorf("Failed to stat %q: %w", targetPath, err)
+	}
+
+	if source.Mode&unix.S_IFMT == unix.S_IFBLK {
+		return target.Mode&unix.S_IFMT == unix.S_IFBLK && source.Rdev == target.Rdev, nil
+	}
+
+	return source.Dev == target.Dev && source.Ino == target.Ino, nil
+}
+
+// DefaultMountTargetDirMode is the default mode of the directory created as
+// the mount target of a filesystem volume.
+const DefaultMountTargetDirMode os.FileMode = 0750
+
+// DefaultMountTargetFileMode is the default mode of the file created as the
+// mount target of a block volume.
+const DefaultMountTargetFileMode os.FileMode = 0660
+
+// MountTarget configures the mount target created by [Mount].
+type MountTarget struct {
+	// Mode of the directory created for filesystem volumes.
+	// Defaults to [DefaultMountTargetDirMode] if zero.
+	DirMode os.FileMode
+
+	// Mode of the file created for block volumes.
+	// Defaults to [DefaultMountTargetFileMode] if zero.
+	FileMode os.FileMode
+
+	// Group owning the created mount target.
+	// The group is left unchanged if negative.
+	GID int
 }
 
 // Mount mounts a volume to a target path.
-func Mount(sourcePath string, targetPath string, contentType string, mountOptions []string) error {
+// The target path is created according to the given mount target configuration.
+// After mounting, the propagation of the mount is changed according to
+// the requested mount propagation.
+func Mount(sourcePath string, targetPath string, contentType string, mountOptions []string, propagation MountPropagation, target MountTarget) error {
 	if sourcePath == "" {
 		return errors.New("Volume mount source path is not specified")
 	}
@@ -108,152 +409,568 @@ func Mount(sourcePath string, targetPath string, contentType string, mountOption
 		return errors.New("Volume mount target path is not specified")
 	}
 
+	err := createMountTarget(targetPath, contentType, target)
+	if err != nil {
+		return err
+	}
+
+	flags, mountOptionsStr := filesystem.ResolveMountOptions(mountOptions)
+
+	// Mount the filesystem
+	err = unix.Mount(sourcePath, targetPath, "", uintptr(flags), mountOptionsStr)
+	if err != nil {
+		return fmt.Errorf("Unable to mount %q at %q: %w", sourcePath, targetPath, err)
+	}
+
+	readonly := slices.Contains(mountOptions, "ro")
+
+	// Remount bind mounts in readonly mode if requested
+	if readonly && flags&unix.MS_BIND == unix.MS_BIND {
+		flags = unix.MS_RDONLY | unix.MS_BIND | unix.MS_REMOUNT
+		err = unix.Mount("", targetPath, "", uintptr(flags), "")
+		if err != nil {
+			return fmt.Errorf("Unable to mount %q in readonly mode: %w", targetPath, err)
+		}
+	}
+
+	propFlags := propagationFlags(contentType, propagation)
+	if propFlags != 0 {
+		err = unix.Mount("", targetPath, "", propFlags, "")
+		if err != nil {
+			return fmt.Errorf("Unable to set %q mount propagation on %q: %w", propagation, targetPath, err)
+		}
+	}
+
+	return nil
+}
+
+// SetBlockDeviceReadOnly sets or clears the read-only attribute of the block
+// device at the given path, which is equivalent to "blockdev --setro" and
+// "blockdev --setrw". A read-only bind mount of a device node does not prevent
+// writes through the device node, whereas the block layer rejects all writes
+// to a read-only block device.
+func SetBlockDeviceReadOnly(path string, readOnly bool) error {
+	file, err := os.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("Failed to open block device %q: %w", path, err)
+	}
+
+	defer func() { _ = file.Close() }()
+
+	value := 0
+	if readOnly {
+		value = 1
+	}
+
+	err = unix.IoctlSetPointerInt(int(file.Fd()), unix.BLKROSET, value)
+	if err != nil {
+		return fmt.Errorf("Failed to set read-only attribute of block device %q to %t: %w", path, readOnly, err)
+	}
+
+	return nil
+}
+
+// GetReadOnlyBlockDevice returns the path of the block device bind mounted
+// read-only at the given mount point. The path is derived from the device
+// number, and remains valid after the mount point is unmounted. If the path
+// is not a read-only bind mount of a block device, an empty string is returned.
+func GetReadOnlyBlockDevice(path string) (string, error) {
+	info, err := GetMountInfo(path)
+	if err != nil {
+		return "", err
+	}
+
+	if info == nil || !slices.Contains(info.MountOptions, "ro") {
+		return "", nil
+	}
+
+	var stat unix.Stat_t
+	err = unix.Stat(path, &stat)
+	if err != nil {
+		return "", fmt.Errorf("Failed to stat %q: %w", path, err)
+	}
+
+	if stat.Mode&unix.S_IFMT != unix.S_IFBLK {
+		return "", nil
+	}
+
+	major := unix.Major(uint64(stat.Rdev))
+	minor := unix.Minor(uint64(stat.Rdev))
+
+	return fmt.Sprintf("/dev/block/%d:%d", major, minor), nil
+}
+
+// GetMountsOf returns the entries of the mount table at which the given
+// source path is bind mounted.
+func GetMountsOf(sourcePath string) ([]MountInfo, error) {
+	return getMountsOf(mountInfoPath, sourcePath)
+}
+
+// getMountsOf returns the entries of the mount table at the given mountinfo
+// path at which the given source path is bind mounted.
+func getMountsOf(mountInfo string, sourcePath string) ([]MountInfo, error) {
+	_, err := os.Stat(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to stat %q: %w", sourcePath, err)
+	}
+
+	content, err := os.ReadFile(mountInfo)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read mount table %q: %w", mountInfo, err)
+	}
+
+	var mounts []MountInfo
+
+	for line := range strings.SplitSeq(string(content), "\n") {
+		info, ok := parseMountInfoLine(line)
+		if !ok {
+			continue
+		}
+
+		// Mount points that cannot be inspected, for example, because they
+		// have been unmounted concurrently, are not mounts of the source.
+		isMountOf, err := IsMountOf(sourcePath, info.MountPoint)
+		if err == nil && isMountOf {
+			mounts = append(mounts, info)
+		}
+	}
+
+	return mounts, nil
+}
+
+// createMountTarget creates the mount target for a volume of the given content
+// type. A directory is created for filesystem volumes and a file for block
+// volumes, as bind mounting a device node requires the target to be a file.
+// A stale target of the wrong type left from a previous mount is removed.
+func createMountTarget(targetPath string, contentType string, target MountTarget) error {
+	dirMode := target.DirMode
+	if dirMode == 0 {
+		dirMode = DefaultMountTargetDirMode
+	}
+
+	fileMode := target.FileMode
+	if fileMode == 0 {
+		fileMode = DefaultMountTargetFileMode
+	}
+
+	var mode os.FileMode
+
 	switch contentType {
 	case "filesystem":
-		err := os.MkdirAll(targetPath, 0750)
+		// Remove a stale target file left from a previous mount.
+		info, err := os.Lstat(targetPath)
+		if err == nil && !info.IsDir() {
+			err = removeMountTarget(targetPath)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = os.MkdirAll(targetPath, dirMode)
 		if err != nil {
 			return err
 		}
+
+		mode = dirMode
 	case "block":
-		// Mount a raw block device.
-		// Create the mount point as a file since bind mount device node
-		// requires it to be a file.
-		err := os.MkdirAll(filepath.Dir(targetPath), 0750)
+		// Remove a stale target directory left from a previous mount.
+		info, err := os.Lstat(targetPath)
+		if err == nil && info.IsDir() {
+			err = removeMountTarget(targetPath)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = os.MkdirAll(filepath.Dir(targetPath), dirMode)
 		if err != nil {
 			return fmt.Errorf("Failed to create target directory for bind mount: %v", err)
 		}
 
-		file, err := os.OpenFile(targetPath, os.O_CREATE|os.O_RDWR, 0660)
+		file, err := os.OpenFile(targetPath, os.O_CREATE|os.O_RDWR, fileMode)
 		if err != nil {
 			return fmt.Errorf("Failed to create target file for bind mount: %v", err)
 		}
 
 		_ = file.Close()
+
+		mode = fileMode
 	default:
 		return fmt.Errorf("Invalid content type %q", contentType)
 	}
 
-	flags, mountOptionsStr := filesystem.ResolveMountOptions(mountOptions)
-
-	// Mount the filesystem
-	err := unix.Mount(sourcePath, targetPath, "", uintptr(flags), mountOptionsStr)
+	// Apply the mode explicitly, as the mode used on creation is subject to umask.
+	err := os.Chmod(targetPath, mode)
 	if err != nil {
-		return fmt.Errorf("Unable to mount %q at %q: %w", sourcePath, targetPath, err)
+		return fmt.Errorf("Failed to set mode of mount target %q: %w", targetPath, err)
 	}
 
-	readonly := slices.Contains(mountOptions, "ro")
-
-	// Remount bind mounts in readonly mode if requested
-	if readonly && flags&unix.MS_BIND == unix.MS_BIND {
-		flags = unix.MS_RDONLY | unix.MS_BIND | unix.MS_REMOUNT
-		err = unix.Mount("", targetPath, "", uintptr(flags), "")
+	if target.GID >= 0 {
+		err = os.Lchown(targetPath, -1, target.GID)
 		if err != nil {
-			return fmt.Errorf("Unable to mount %q in readonly mode: %w", targetPath, err)
+			return fmt.Errorf("Failed to set group of mount target %q: %w", targetPath, err)
 		}
 	}
 
-	flags = unix.MS_REC | unix.MS_SLAVE
-	err = unix.Mount("", targetPath, "", uintptr(flags), "")
+	return nil
+}
+
+// commandRunner runs the command with the given arguments and returns its output.
+type commandRunner func(name string, args ...string) ([]byte, error)
+
+// runCommand runs the command and returns its standard output.
+func runCommand(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).Output()
+}
+
+// blkidExitNotFound is the exit code of blkid when no filesystem or partition
+// table is found on the probed device.
+const blkidExitNotFound = 2
+
+// DetectFilesystem returns the type of the filesystem on the given device,
+// such as "ext4" or "xfs". An empty string is returned if the device is not
+// formatted.
+func DetectFilesystem(device string) (string, error) {
+	return detectFilesystem(device, runCommand)
+}
+
+// detectFilesystem probes the device using blkid run by the given command runner.
+func detectFilesystem(device string, run commandRunner) (string, error) {
+	if device == "" {
+		return "", errors.New("Device path is not specified")
+	}
+
+	// Probe the device directly to bypass the blkid cache, which may be stale
+	// for a device that was just attached.
+	out, err := run("blkid", "--probe", "--output", "export", device)
 	if err != nil {
-		return fmt.Errorf("Unable to make mount %q private: %w", targetPath, err)
+		var exitErr interface{ ExitCode() int }
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == blkidExitNotFound {
+			return "", nil
+		}
+
+		return "", fmt.Errorf("Failed to detect filesystem on device %q: %w", device, err)
 	}
 
-	return nil
+	return parseBlkidOutput(device, out)
 }
 
+// parseBlkidOutput extracts the filesystem type from the blkid output in
+// export format, which consists of "KEY=value" lines.
+func parseBlkidOutput(device string, out []byte) (string, error) {
+	var fsType string
+	var ptType string
+
+	for line := range strings.SplitSeq(string(out), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "TYPE":
+			fsType = value
+		case "PTTYPE":
+			ptType = value
+		}
+	}
+
+	// A device with a partition table is not unformatted, even though it has
+	// no filesystem, and must not be formatted.
+	if fsType == "" && ptType != "" {
+		return "", fmt.Errorf("Device %q contains a %q partition table instead of a filesystem", device, ptType)
+	}
+
+	return fsType, nil
+}
+
+// unmountFunc unmounts the given path.
+type unmountFunc func(path string) error
+
+// maxUnmountRetryInterval is the upper bound for the delay between two attempts
+// to unmount a path.
+const maxUnmountRetryInterval = 2 * time.Second
+
 // Unmount unmounts and removes the mount path used for disk shares.
-func Unmount(path string) error {
+// Unmounting is attempted up to the given number of times, backing off
+// exponentially between attempts starting from the given interval. Retrying
+// stops when the context is done.
+func Unmount(ctx context.Context, path string, retries int, interval time.Duration) error {
 	if !PathExists(path) {
 		return nil
 	}
 
-	mounted, err := IsMountPoint(path)
+	mounted, err := IsMounted(path)
 	if err != nil {
 		return err
 	}
 
 	if mounted {
-		// Try unmounting a filesystem multiple times.
-		for range 20 {
-			err = unix.Unmount(path, 0)
-			if err == nil {
-				break
-			}
+		err = unmountWithRetry(ctx, path, retries, interval, func(path string) error {
+			return unix.Unmount(path, 0)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return removeMountTarget(path)
+}
 
-			time.Sleep(500 * time.Millisecond)
+// removeMountTarget removes the mount target path that is no longer mounted.
+// Block volumes are bind mounted onto a regular file and filesystem volumes
+// onto a directory. A directory is removed only if it is empty, as any content
+// was written while the volume was not mounted and must not be lost.
+func removeMountTarget(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
 		}
 
+		return err
+	}
+
+	switch {
+	case info.IsDir():
+		err = os.Remove(path)
 		if err != nil {
-			return fmt.Errorf("Failed to unmount %q: %w", path, err)
+			return fmt.Errorf("Failed to remove mount target directory %q: %w", path, err)
 		}
+	case info.Mode().IsRegular():
+		err = os.Remove(path)
+		if err != nil {
+			return fmt.Errorf("Failed to remove mount target file %q: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("Mount target %q is neither a file nor a directory", path)
 	}
 
-	err = os.Remove(path)
-	if err != nil {
-		return fmt.Errorf("Failed to remove %q: %w", path, err)
+	return nil
+}
+
+// unmountWithRetry calls the unmount function until it succeeds, the given
+// number of attempts is exhausted, or the context is done. The delay between
+// attempts starts at the given interval and doubles after each attempt, up to
+// [maxUnmountRetryInterval] or the given interval, whichever is greater.
+func unmountWithRetry(ctx context.Context, path string, retries int, interval time.Duration, unmount unmountFunc) error {
+	var err error
+
+	delay := interval
+
+	// Try unmounting a filesystem multiple times.
+	for attempt := range max(retries, 1) {
+		if attempt > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return fmt.Errorf("Failed to unmount %q: %w (last error: %w)", path, ctx.Err(), err)
+			case <-timer.C:
+			}
+
+			delay = min(delay*2, max(maxUnmountRetryInterval, interval))
+		}
+
+		err = unmount(path)
+		if err == nil {
+			return nil
+		}
 	}
 
-	return nil
+	return fmt.Errorf("Failed to unmount %q: %w", path, err)
 }
 
 // WatchFile sets up a file watcher for the file path and calls provided handler on file change.
+// To watch multiple files, use a single [Watcher] instead.
 func WatchFile(ctx context.Context, path string, fileChangeHandler func(path string)) error {
-	// Ensure the provided path is clean to avoid potential path mismatch.
-	path = filepath.Clean(path)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("Failed to setup file watcher for path %q: %v", path, err)
+	}
 
-	// Resolve symlinks in the provided path.
-	curRealPath, _ := filepath.EvalSymlinks(path)
+	w := newWatcher(watcher)
+
+	err = w.Add(path, fileChangeHandler)
+	if err != nil {
+		_ = watcher.Close()
+		return err
+	}
 
+	go w.run(ctx)
+
+	return nil
+}
+
+// Watcher watches multiple files for changes using a single fsnotify watcher.
+// Each file has its own change handler.
+type Watcher struct {
+	watcher *fsnotify.Watcher
+
+	mu    sync.Mutex
+	files map[string]*watchedFile
+}
+
+// watchedFile is a file watched by the [Watcher].
+type watchedFile struct {
+	// realPath is the path of the file with symlinks resolved.
+	realPath string
+
+	// handler is called when the file changes.
+	handler func(path string)
+}
+
+// NewWatcher returns a new file watcher. Files are watched until the context is done.
+func NewWatcher(ctx context.Context) (*Watcher, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		return fmt.Errorf("Failed to setup file watcher for path %q: %v", path, err)
+		return nil, fmt.Errorf("Failed to setup file watcher: %v", err)
+	}
+
+	w := newWatcher(watcher)
+	go w.run(ctx)
+
+	return w, nil
+}
+
+// newWatcher returns a file watcher using the given fsnotify watcher.
+func newWatcher(watcher *fsnotify.Watcher) *Watcher {
+	return &Watcher{
+		watcher: watcher,
+		files:   make(map[string]*watchedFile),
+	}
+}
+
+// Add starts watching the file path and calls the provided handler on file change.
+func (w *Watcher) Add(path string, fileChangeHandler func(path string)) error {
+	// Ensure the provided path is clean to avoid potential path mismatch.
+	path = filepath.Clean(path)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, ok := w.files[path]
+	if ok {
+		return fmt.Errorf("Path %q is already watched", path)
 	}
 
 	// Watch the directory of the provided path because Kubernetes uses
-	// symlink swap trick for updating the mounted files.
-	err = watcher.Add(filepath.Dir(path))
+	// symlink swap trick for updating the mounted files. Adding an already
+	// watched directory is a no-op.
+	err := w.watcher.Add(filepath.Dir(path))
 	if err != nil {
-		_ = watcher.Close()
 		return fmt.Errorf("Failed to watch path %q: %v", path, err)
 	}
 
-	// Watch for token file changes.
-	go func() {
-		defer func() { _ = watcher.Close() }()
-		for {
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
-					// Event channel closed.
-					klog.ErrorS(errors.New("FSNotify event channel closed"), "Stopped watching file", "path", path)
-					return
-				}
-
-				newRealPath, err := filepath.EvalSymlinks(path)
-				if err != nil {
-					klog.ErrorS(err, "Failed to resolve symlink for watched file", "path", path)
-				}
+	// Resolve symlinks in the provided path.
+	realPath, _ := filepath.EvalSymlinks(path)
 
-				// Check if the file was modified or created.
-				isFileContentChanged := filepath.Clean(event.Name) == path && (event.Has(fsnotify.Create) || event.Has(fsnotify.Write))
+	w.files[path] = &watchedFile{
+		realPath: realPath,
+		handler:  fileChangeHandler,
+	}
 
-				// Check if the file symlink changed. This occurs when Kubernetes updates
-				// the mounted secret/config file using the symlink swap trick.
-				isFileSymlinkChanged := newRealPath != "" && newRealPath != curRealPath
+	return nil
+}
 
-				if isFileContentChanged || isFileSymlinkChanged {
-					curRealPath = newRealPath
-					fileChangeHandler(path)
-				}
-			case err, ok := <-watcher.Errors:
-				if ok && err != nil {
-					klog.ErrorS(err, "Error watching file", "path", path)
+// logWatchError logs errors encountered while watching a file.
+// It is a variable so that tests can intercept the logged errors.
+var logWatchError = klog.ErrorS
+
+// run processes the events of the watcher until the context is done or the
+// watcher is closed. When the context is done, the watcher is closed and its
+// remaining events are drained. Only an unexpected closure of the watcher is
+// logged as an error.
+func (w *Watcher) run(ctx context.Context) {
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				// Event channel may be closed concurrently with the context
+				// being done, in which case this is an intended shutdown.
+				if ctx.Err() == nil {
+					logWatchError(errors.New("FSNotify event channel closed"), "Stopped watching files", "paths", w.paths())
 				}
-			case <-ctx.Done():
+
 				return
 			}
+
+			w.handleEvent(event)
+		case err, ok := <-w.watcher.Errors:
+			if ok && err != nil {
+				logWatchError(err, "Error watching files", "paths", w.paths())
+			}
+		case <-ctx.Done():
+			_ = w.watcher.Close()
+
+			// Drain events and errors that were queued before the watcher
+			// was closed, until both channels are closed.
+			events, errs := w.watcher.Events, w.watcher.Errors
+			for events != nil || errs != nil {
+				select {
+				case _, ok := <-events:
+					if !ok {
+						events = nil
+					}
+				case _, ok := <-errs:
+					if !ok {
+						errs = nil
+					}
+				}
+			}
+
+			klog.V(4).InfoS("Stopped watching files", "paths", w.paths())
+			return
 		}
-	}()
+	}
+}
 
-	return nil
+// handleEvent calls the handlers of the watched files in the directory of the
+// event that have changed. Handlers are called without holding the lock, so
+// they can add new files to the watcher.
+func (w *Watcher) handleEvent(event fsnotify.Event) {
+	eventPath := filepath.Clean(event.Name)
+	eventDir := filepath.Dir(eventPath)
+
+	var changed []string
+	var handlers []func(path string)
+
+	w.mu.Lock()
+	for path, file := range w.files {
+		if filepath.Dir(path) != eventDir {
+			continue
+		}
+
+		newRealPath, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			logWatchError(err, "Failed to resolve symlink for watched file", "path", path)
+		}
+
+		// Check if the file was modified or created.
+		isFileContentChanged := eventPath == path && (event.Has(fsnotify.Create) || event.Has(fsnotify.Write))
+
+		// Check if the file symlink changed. This occurs when Kubernetes updates
+		// the mounted secret/config file using the symlink swap trick.
+		isFileSymlinkChanged := newRealPath != "" && newRealPath != file.realPath
+
+		if isFileContentChanged || isFileSymlinkChanged {
+			file.realPath = newRealPath
+			changed = append(changed, path)
+			handlers = append(handlers, file.handler)
+		}
+	}
+
+	w.mu.Unlock()
+
+	for i, path := range changed {
+		handlers[i](path)
+	}
+}
+
+// paths returns the sorted list of watched file paths.
+func (w *Watcher) paths() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return slices.Sorted(maps.Keys(w.files))
 }
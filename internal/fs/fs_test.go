@@ -1,13 +1,17 @@
 package fs
 
 import (
+	"context"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
 )
 
 // waitUntil condition returns true or timeout is reached.
@@ -86,3 +90,780 @@ func Test_WatchFile_SymlinkSwap(t *testing.T) {
 	// Wait until change is detected and onChange handler triggered (hits >= 1).
 	waitUntil(t, time.Second, func() bool { return atomic.LoadInt32(&hits) >= 1 })
 }
+
+// Multiple files:
+// Watch two files in the same directory and one in another directory with
+// a single watcher, modify each file, expect only its handler to be triggered.
+func Test_Watcher_MultipleFiles(t *testing.T) {
+	dir := t.TempDir()
+	otherDir := t.TempDir()
+
+	files := []string{
+		filepath.Join(dir, "token"),
+		filepath.Join(dir, "ca.crt"),
+		filepath.Join(otherDir, "config"),
+	}
+
+	hits := make([]int32, len(files))
+
+	w, err := NewWatcher(t.Context())
+	require.NoError(t, err)
+
+	// Create all files before watching them, so that no events are queued
+	// for the files that are not yet modified.
+	for _, file := range files {
+		require.NoError(t, os.WriteFile(file, []byte("initial content"), 0o640))
+	}
+
+	for i, file := range files {
+		require.NoError(t, w.Add(file, func(path string) {
+			if path == file {
+				atomic.AddInt32(&hits[i], 1)
+			}
+		}))
+	}
+
+	// Watching the same file twice is rejected.
+	require.Error(t, w.Add(files[0], func(_ string) {}))
+
+	for i, file := range files {
+		// Modify file.
+		require.NoError(t, os.WriteFile(file, []byte("modified content"), 0o640))
+
+		// Wait until change is detected and only the handler of the modified file is triggered.
+		waitUntil(t, time.Second, func() bool { return atomic.LoadInt32(&hits[i]) >= 1 })
+
+		for j := i + 1; j < len(files); j++ {
+			require.Zero(t, atomic.LoadInt32(&hits[j]), "Handler of unmodified file %q triggered", files[j])
+		}
+	}
+}
+
+// Watcher shutdown:
+// Cancelling the context stops watching without logging an error, while
+// closing the watcher unexpectedly logs an error.
+func Test_WatchFile_Shutdown(t *testing.T) {
+	tests := []struct {
+		name        string
+		stop        func(cancel context.CancelFunc, watcher *fsnotify.Watcher)
+		expectError bool
+	}{
+		{
+			name: "Context cancelled",
+			stop: func(cancel context.CancelFunc, _ *fsnotify.Watcher) {
+				cancel()
+			},
+			expectError: false,
+		},
+		{
+			name: "Watcher closed externally",
+			stop: func(_ context.CancelFunc, watcher *fsnotify.Watcher) {
+				_ = watcher.Close()
+			},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var errorsLogged int32
+
+			oldLogWatchError := logWatchError
+			logWatchError = func(err error, msg string, keysAndValues ...any) {
+				atomic.AddInt32(&errorsLogged, 1)
+			}
+
+			t.Cleanup(func() { logWatchError = oldLogWatchError })
+
+			dir := t.TempDir()
+			file := filepath.Join(dir, "token")
+			require.NoError(t, os.WriteFile(file, []byte("content"), 0o640))
+
+			watcher, err := fsnotify.NewWatcher()
+			require.NoError(t, err)
+
+			w := newWatcher(watcher)
+			require.NoError(t, w.Add(file, func(_ string) {}))
+
+			ctx, cancel := context.WithCancel(t.Context())
+			defer cancel()
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				w.run(ctx)
+			}()
+
+			test.stop(cancel, watcher)
+
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("File watcher did not stop within 1s")
+			}
+
+			if test.expectError {
+				require.Equal(t, int32(1), atomic.LoadInt32(&errorsLogged))
+			} else {
+				require.Zero(t, atomic.LoadInt32(&errorsLogged))
+			}
+		})
+	}
+}
+
+// Propagation flags for different volume types and mount propagations.
+func Test_PropagationFlags(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		propagation MountPropagation
+		expectFlags uintptr
+	}{
+		{
+			name:        "Filesystem with default propagation",
+			contentType: "filesystem",
+			propagation: MountPropagationHostToContainer,
+			expectFlags: unix.MS_REC | unix.MS_SLAVE,
+		},
+		{
+			name:        "Filesystem with None propagation",
+			contentType: "filesystem",
+			propagation: MountPropagationNone,
+			expectFlags: unix.MS_REC | unix.MS_PRIVATE,
+		},
+		{
+			name:        "Filesystem with Bidirectional propagation",
+			contentType: "filesystem",
+			propagation: MountPropagationBidirectional,
+			expectFlags: 0,
+		},
+		{
+			name:        "Block with default propagation",
+			contentType: "block",
+			propagation: MountPropagationHostToContainer,
+			expectFlags: unix.MS_SLAVE,
+		},
+		{
+			name:        "Block with None propagation",
+			contentType: "block",
+			propagation: MountPropagationNone,
+			expectFlags: unix.MS_PRIVATE,
+		},
+		{
+			name:        "Block with Bidirectional propagation",
+			contentType: "block",
+			propagation: MountPropagationBidirectional,
+			expectFlags: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.expectFlags, propagationFlags(test.contentType, test.propagation))
+		})
+	}
+}
+
+// Propagation options are extracted from mount options.
+func Test_ParseMountPropagation(t *testing.T) {
+	propagation, options := ParseMountPropagation([]string{"bind", "ro"})
+	require.Equal(t, MountPropagationHostToContainer, propagation)
+	require.Equal(t, []string{"bind", "ro"}, options)
+
+	propagation, options = ParseMountPropagation([]string{"bind", "rshared", "noatime"})
+	require.Equal(t, MountPropagationBidirectional, propagation)
+	require.Equal(t, []string{"bind", "noatime"}, options)
+
+	propagation, options = ParseMountPropagation([]string{"bind", "rshared", "private"})
+	require.Equal(t, MountPropagationNone, propagation)
+	require.Equal(t, []string{"bind"}, options)
+}
+
+func Test_NormalizeMountOptions(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Options       []string
+		expectOptions []string
+	}{
+		{
+			Name:          "No options",
+			Options:       nil,
+			expectOptions: []string{},
+		},
+		{
+			Name:          "Options without conflicts",
+			Options:       []string{"bind", "noatime", "ro"},
+			expectOptions: []string{"bind", "noatime", "ro"},
+		},
+		{
+			Name:          "Duplicate options",
+			Options:       []string{"bind", "noatime", "bind", "discard", "discard"},
+			expectOptions: []string{"noatime", "bind", "discard"},
+		},
+		{
+			Name:          "Last read-only toggle wins",
+			Options:       []string{"bind", "rw", "noexec", "ro"},
+			expectOptions: []string{"bind", "noexec", "ro"},
+		},
+		{
+			Name:          "Last read-write toggle wins",
+			Options:       []string{"bind", "ro", "rw"},
+			expectOptions: []string{"bind", "rw"},
+		},
+		{
+			Name:          "Conflicting flag toggles",
+			Options:       []string{"nosuid", "sync", "suid", "async", "atime", "noatime"},
+			expectOptions: []string{"suid", "async", "noatime"},
+		},
+		{
+			Name:          "Last value of key-value option wins",
+			Options:       []string{"uid=1000", "bind", "uid=0"},
+			expectOptions: []string{"bind", "uid=0"},
+		},
+		{
+			Name:          "Empty options are removed",
+			Options:       []string{"bind", "", " ", " ro "},
+			expectOptions: []string{"bind", "ro"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			require.Equal(t, test.expectOptions, NormalizeMountOptions(test.Options))
+		})
+	}
+}
+
+func Test_UnmountWithRetry(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Retries       int
+		SucceedOn     int
+		Cancel        bool
+		expectCalls   int
+		expectError   bool
+		expectErrorIs error
+	}{
+		{
+			Name:        "Succeeds on first attempt",
+			Retries:     3,
+			SucceedOn:   1,
+			expectCalls: 1,
+		},
+		{
+			Name:        "Succeeds on last attempt",
+			Retries:     3,
+			SucceedOn:   3,
+			expectCalls: 3,
+		},
+		{
+			Name:        "Fails after all attempts",
+			Retries:     3,
+			SucceedOn:   4,
+			expectCalls: 3,
+			expectError: true,
+		},
+		{
+			// The doubled delays do not reduce the number of attempts.
+			Name:        "Backoff attempts the given number of times",
+			Retries:     8,
+			SucceedOn:   9,
+			expectCalls: 8,
+			expectError: true,
+		},
+		{
+			Name:        "Zero retries attempts once",
+			Retries:     0,
+			SucceedOn:   1,
+			expectCalls: 1,
+		},
+		{
+			Name:          "Stops retrying when context is cancelled",
+			Retries:       3,
+			SucceedOn:     3,
+			Cancel:        true,
+			expectCalls:   1,
+			expectError:   true,
+			expectErrorIs: context.Canceled,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			calls := 0
+			unmount := func(path string) error {
+				calls++
+				if test.Cancel {
+					cancel()
+				}
+
+				if calls < test.SucceedOn {
+					return unix.EBUSY
+				}
+
+				return nil
+			}
+
+			err := unmountWithRetry(ctx, "/mnt/test", test.Retries, time.Millisecond, unmount)
+			require.Equal(t, test.expectCalls, calls)
+
+			if test.expectError {
+				require.Error(t, err)
+				require.ErrorIs(t, err, unix.EBUSY)
+				if test.expectErrorIs != nil {
+					require.ErrorIs(t, err, test.expectErrorIs)
+				}
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_RemoveMountTarget(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Setup       func(t *testing.T, path string)
+		expectError string
+		expectExist bool
+	}{
+		{
+			Name:  "Missing target",
+			Setup: func(t *testing.T, path string) {},
+		},
+		{
+			Name: "Stale target file",
+			Setup: func(t *testing.T, path string) {
+				require.NoError(t, os.WriteFile(path, nil, 0660))
+			},
+		},
+		{
+			Name: "Stale empty target directory",
+			Setup: func(t *testing.T, path string) {
+				require.NoError(t, os.Mkdir(path, 0750))
+			},
+		},
+		{
+			Name: "Target directory with content",
+			Setup: func(t *testing.T, path string) {
+				require.NoError(t, os.Mkdir(path, 0750))
+				require.NoError(t, os.WriteFile(filepath.Join(path, "data"), []byte("data"), 0660))
+			},
+			expectError: "Failed to remove mount target directory",
+			expectExist: true,
+		},
+		{
+			Name: "Target symlink",
+			Setup: func(t *testing.T, path string) {
+				require.NoError(t, os.Symlink("/dev/null", path))
+			},
+			expectError: "is neither a file nor a directory",
+			expectExist: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "target")
+			test.Setup(t, path)
+
+			err := removeMountTarget(path)
+			if test.expectError != "" {
+				require.ErrorContains(t, err, test.expectError)
+			} else {
+				require.NoError(t, err)
+			}
+
+			require.Equal(t, test.expectExist, PathExists(path))
+		})
+	}
+}
+
+func Test_CreateMountTarget(t *testing.T) {
+	tests := []struct {
+		Name        string
+		ContentType string
+		Target      MountTarget
+		expectDir   bool
+		expectMode  os.FileMode
+		expectError string
+	}{
+		{
+			Name:        "Filesystem target with default mode",
+			ContentType: "filesystem",
+			Target:      MountTarget{GID: -1},
+			expectDir:   true,
+			expectMode:  DefaultMountTargetDirMode,
+		},
+		{
+			Name:        "Filesystem target with configured mode",
+			ContentType: "filesystem",
+			Target:      MountTarget{DirMode: 0755, GID: -1},
+			expectDir:   true,
+			expectMode:  0755,
+		},
+		{
+			Name:        "Block target with default mode",
+			ContentType: "block",
+			Target:      MountTarget{GID: -1},
+			expectMode:  DefaultMountTargetFileMode,
+		},
+		{
+			Name:        "Block target with configured mode",
+			ContentType: "block",
+			Target:      MountTarget{FileMode: 0666, GID: -1},
+			expectMode:  0666,
+		},
+		{
+			Name:        "Filesystem target with group of the current user",
+			ContentType: "filesystem",
+			Target:      MountTarget{DirMode: 0770, GID: os.Getgid()},
+			expectDir:   true,
+			expectMode:  0770,
+		},
+		{
+			Name:        "Invalid content type",
+			ContentType: "object",
+			Target:      MountTarget{GID: -1},
+			expectError: `Invalid content type "object"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			// Restrictive umask must not affect the configured mode.
+			oldUmask := unix.Umask(0077)
+			defer unix.Umask(oldUmask)
+
+			path := filepath.Join(t.TempDir(), "target")
+
+			err := createMountTarget(path, test.ContentType, test.Target)
+			if test.expectError != "" {
+				require.ErrorContains(t, err, test.expectError)
+				return
+			}
+
+			require.NoError(t, err)
+
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			require.Equal(t, test.expectDir, info.IsDir())
+			require.Equal(t, test.expectMode, info.Mode().Perm())
+
+			if test.Target.GID >= 0 {
+				var stat unix.Stat_t
+				require.NoError(t, unix.Stat(path, &stat))
+				require.Equal(t, uint32(test.Target.GID), stat.Gid)
+			}
+		})
+	}
+}
+
+func Test_IsMountOf(t *testing.T) {
+	dir := t.TempDir()
+
+	source := filepath.Join(dir, "source")
+	require.NoError(t, os.Mkdir(source, 0750))
+
+	other := filepath.Join(dir, "other")
+	require.NoError(t, os.Mkdir(other, 0750))
+
+	// Same directory is reported as the mount source.
+	isMountOf, err := IsMountOf(source, source)
+	require.NoError(t, err)
+	require.True(t, isMountOf)
+
+	// Different directory is reported as a stale target.
+	isMountOf, err = IsMountOf(source, other)
+	require.NoError(t, err)
+	require.False(t, isMountOf)
+
+	// Missing target results in an error.
+	_, err = IsMountOf(source, filepath.Join(dir, "missing"))
+	require.Error(t, err)
+}
+
+func Test_IsInMountInfo(t *testing.T) {
+	mountInfo := filepath.Join(t.TempDir(), "mountinfo")
+	content := `22 1 0:21 / /sys rw,nosuid,nodev,noexec,relatime shared:7 - sysfs sysfs rw
+25 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+310 25 8:16 / /var/lib/kubelet/pods/a1b2/volumes/kubernetes.io~csi/pvc-fs/mount rw,relatime shared:1 - ext4 /dev/sdb rw
+311 25 0:5 /sdc /var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish/pvc-block/a1b2 rw,nosuid shared:2 - devtmpfs udev rw,size=4k
+312 25 8:32 / /mnt/with\040space rw,relatime - ext4 /dev/sdd rw
+`
+	require.NoError(t, os.WriteFile(mountInfo, []byte(content), 0600))
+
+	tests := []struct {
+		Name          string
+		Path          string
+		expectMounted bool
+	}{
+		{
+			Name:          "Filesystem bind mount",
+			Path:          "/var/lib/kubelet/pods/a1b2/volumes/kubernetes.io~csi/pvc-fs/mount",
+			expectMounted: true,
+		},
+		{
+			Name:          "Block device bind mount",
+			Path:          "/var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish/pvc-block/a1b2",
+			expectMounted: true,
+		},
+		{
+			Name:          "Mount point with escaped space",
+			Path:          "/mnt/with space",
+			expectMounted: true,
+		},
+		{
+			Name:          "Unclean path of a mount point",
+			Path:          "/var/lib/kubelet/pods/a1b2/volumes/kubernetes.io~csi/pvc-fs/mount/",
+			expectMounted: true,
+		},
+		{
+			Name:          "Parent of a mount point",
+			Path:          "/var/lib/kubelet/pods/a1b2/volumes/kubernetes.io~csi/pvc-fs",
+			expectMounted: false,
+		},
+		{
+			Name:          "Path not in mount table",
+			Path:          "/var/lib/kubelet/pods/c3d4/volumes/kubernetes.io~csi/pvc-fs/mount",
+			expectMounted: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			mounted, err := isInMountInfo(mountInfo, test.Path)
+			require.NoError(t, err)
+			require.Equal(t, test.expectMounted, mounted)
+		})
+	}
+
+	// Missing mount table results in an error.
+	_, err := isInMountInfo(filepath.Join(t.TempDir(), "missing"), "/mnt")
+	require.Error(t, err)
+}
+
+func Test_GetMountsOf(t *testing.T) {
+	dir := t.TempDir()
+
+	// Hard links refer to the same file, the same as bind mounts of a file.
+	source := filepath.Join(dir, "source")
+	require.NoError(t, os.WriteFile(source, nil, 0600))
+
+	readOnlyMount := filepath.Join(dir, "ro")
+	require.NoError(t, os.Link(source, readOnlyMount))
+
+	readWriteMount := filepath.Join(dir, "rw")
+	require.NoError(t, os.Link(source, readWriteMount))
+
+	other := filepath.Join(dir, "other")
+	require.NoError(t, os.WriteFile(other, nil, 0600))
+
+	tests := []struct {
+		Name           string
+		MountInfo      string
+		expectMounts   []string
+		expectReadOnly []bool
+	}{
+		{
+			Name:           "Read-only and read-write mounts of the source",
+			MountInfo:      "311 25 0:5 /sdc " + readWriteMount + " rw,nosuid - devtmpfs udev rw\n312 25 0:5 /sdc " + readOnlyMount + " ro,nosuid - devtmpfs udev rw\n",
+			expectMounts:   []string{readWriteMount, readOnlyMount},
+			expectReadOnly: []bool{false, true},
+		},
+		{
+			Name:      "Mount of another file",
+			MountInfo: "313 25 0:5 /sdd " + other + " ro,nosuid - devtmpfs udev rw\n",
+		},
+		{
+			Name:      "Mount point that no longer exists",
+			MountInfo: "314 25 0:5 /sde " + filepath.Join(dir, "missing") + " ro,nosuid - devtmpfs udev rw\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			mountInfo := filepath.Join(t.TempDir(), "mountinfo")
+			require.NoError(t, os.WriteFile(mountInfo, []byte(test.MountInfo), 0600))
+
+			mounts, err := getMountsOf(mountInfo, source)
+			require.NoError(t, err)
+			require.Len(t, mounts, len(test.expectMounts))
+
+			for i, mount := range mounts {
+				require.Equal(t, test.expectMounts[i], mount.MountPoint)
+				require.Equal(t, test.expectReadOnly[i], mount.IsReadOnly())
+			}
+		})
+	}
+
+	// Missing source results in an error.
+	_, err := getMountsOf(filepath.Join(t.TempDir(), "mountinfo"), filepath.Join(dir, "missing"))
+	require.Error(t, err)
+}
+
+func Test_IsMounted(t *testing.T) {
+	// Missing path is not mounted.
+	mounted, err := IsMounted(filepath.Join(t.TempDir(), "missing"))
+	require.NoError(t, err)
+	require.False(t, mounted)
+
+	// Plain directory is not mounted.
+	mounted, err = IsMounted(t.TempDir())
+	require.NoError(t, err)
+	require.False(t, mounted)
+
+	// Procfs is always mounted.
+	mounted, err = IsMounted("/proc")
+	require.NoError(t, err)
+	require.True(t, mounted)
+}
+
+func Test_ParseMountInfoLine(t *testing.T) {
+	tests := []struct {
+		Name       string
+		Line       string
+		expectOK   bool
+		expectInfo MountInfo
+	}{
+		{
+			Name:     "Read-only bind mount",
+			Line:     "310 25 8:16 / /var/lib/kubelet/pods/a1b2/mount ro,relatime shared:1 - ext4 /dev/sdb rw,errors=remount-ro",
+			expectOK: true,
+			expectInfo: MountInfo{
+				MountPoint:   "/var/lib/kubelet/pods/a1b2/mount",
+				MountOptions: []string{"ro", "relatime"},
+				FSType:       "ext4",
+				Source:       "/dev/sdb",
+				SuperOptions: []string{"rw", "errors=remount-ro"},
+			},
+		},
+		{
+			Name:     "Read-only filesystem without optional fields",
+			Line:     "311 25 8:32 / /mnt/with\\040space rw - xfs /dev/sdc ro",
+			expectOK: true,
+			expectInfo: MountInfo{
+				MountPoint:   "/mnt/with space",
+				MountOptions: []string{"rw"},
+				FSType:       "xfs",
+				Source:       "/dev/sdc",
+				SuperOptions: []string{"ro"},
+			},
+		},
+		{
+			Name:     "Malformed line",
+			Line:     "311 25 8:32 /",
+			expectOK: false,
+		},
+		{
+			Name:     "Empty line",
+			expectOK: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			info, ok := parseMountInfoLine(test.Line)
+			require.Equal(t, test.expectOK, ok)
+			if test.expectOK {
+				require.Equal(t, test.expectInfo, info)
+			}
+		})
+	}
+
+	// Read-only state is derived from both the mount and filesystem options.
+	require.True(t, MountInfo{MountOptions: []string{"ro"}, SuperOptions: []string{"rw"}}.IsReadOnly())
+	require.False(t, MountInfo{MountOptions: []string{"ro"}, SuperOptions: []string{"rw"}}.IsFilesystemReadOnly())
+	require.True(t, MountInfo{MountOptions: []string{"rw"}, SuperOptions: []string{"ro"}}.IsFilesystemReadOnly())
+}
+
+// fakeExitError is a command error with an exit code.
+type fakeExitError int
+
+func (e fakeExitError) Error() string {
+	return "exit status " + strconv.Itoa(int(e))
+}
+
+func (e fakeExitError) ExitCode() int {
+	return int(e)
+}
+
+func Test_DetectFilesystem(t *testing.T) {
+	tests := []struct {
+		Name         string
+		Output       string
+		Err          error
+		expectFSType string
+		expectError  bool
+	}{
+		{
+			Name:         "Ext4 filesystem",
+			Output:       "DEVNAME=/dev/sdb\nUUID=4e1b7a0c-2f3e-4a56-9c1d-0a8b7c6d5e4f\nVERSION=1.0\nBLOCK_SIZE=4096\nTYPE=ext4\nUSAGE=filesystem\n",
+			expectFSType: "ext4",
+		},
+		{
+			Name:         "XFS filesystem",
+			Output:       "DEVNAME=/dev/sdb\nUUID=9b2d4c1e-7f3a-4e8b-a6c5-1d0e2f3a4b5c\nBLOCK_SIZE=512\nTYPE=xfs\nUSAGE=filesystem\n",
+			expectFSType: "xfs",
+		},
+		{
+			Name:         "Unformatted device",
+			Err:          fakeExitError(2),
+			expectFSType: "",
+		},
+		{
+			Name:        "Partition table",
+			Output:      "DEVNAME=/dev/sdb\nPTUUID=1a2b3c4d\nPTTYPE=gpt\n",
+			expectError: true,
+		},
+		{
+			Name:        "Blkid failure",
+			Err:         fakeExitError(4),
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			run := func(name string, args ...string) ([]byte, error) {
+				require.Equal(t, "blkid", name)
+				require.Equal(t, "/dev/sdb", args[len(args)-1])
+				return []byte(test.Output), test.Err
+			}
+
+			fsType, err := detectFilesystem("/dev/sdb", run)
+			if test.expectError {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.expectFSType, fsType)
+		})
+	}
+}
+
+func Test_UnmountWithRetryCancelDuringBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	calls := 0
+	unmount := func(path string) error {
+		calls++
+		return unix.EBUSY
+	}
+
+	// Cancel the context while waiting for the next attempt.
+	timer := time.AfterFunc(10*time.Millisecond, cancel)
+	defer timer.Stop()
+
+	start := time.Now()
+	err := unmountWithRetry(ctx, "/mnt/test", 3, time.Minute, unmount)
+	require.Less(t, time.Since(start), time.Minute)
+	require.Equal(t, 1, calls)
+	require.ErrorIs(t, err, context.Canceled)
+	require.ErrorIs(t, err, unix.EBUSY)
+}
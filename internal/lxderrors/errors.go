@@ -6,19 +6,33 @@ import (
 	"net/http"
 
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/canonical/lxd/shared/api"
 )
 
 // ToGRPCCode maps the given error to a gRPC error code.
 // It recognizes both standard Go errors as well as LXD API errors.
+// Errors that carry a gRPC status keep their code.
 // If the error is not recognized, an internal error is returned.
 func ToGRPCCode(err error) codes.Code {
 	if err == nil {
 		return codes.OK
 	}
 
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &grpcErr) && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+		return grpcErr.GRPCStatus().Code()
+	}
+
 	switch {
+	// Context errors are checked first, as the LXD API error returned for
+	// a cancelled or timed out request may wrap them. The cancellation or
+	// deadline is the actual cause of the failure in that case.
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
 	case api.StatusErrorCheck(err, http.StatusBadRequest): // 400
 		return codes.InvalidArgument
 	case api.StatusErrorCheck(err, http.StatusUnauthorized): // 401
@@ -48,10 +62,15 @@ func ToGRPCCode(err error) codes.Code {
 		// volume is released, and the error will indicate that the volume supports only
 		// offline expansion.
 		return codes.FailedPrecondition
-	case errors.Is(err, context.DeadlineExceeded):
+	case api.StatusErrorCheck(err, http.StatusRequestTimeout), api.StatusErrorCheck(err, http.StatusGatewayTimeout): // 408, 504
 		return codes.DeadlineExceeded
-	case errors.Is(err, context.Canceled):
-		return codes.Canceled
+	case api.StatusErrorCheck(err, http.StatusTooManyRequests), api.StatusErrorCheck(err, http.StatusServiceUnavailable): // 429, 503
+		// LXD is temporarily unable to handle the request, for example
+		// when it is overloaded or restarting. Return [codes.Unavailable]
+		// to let the sidecars retry the request.
+		return codes.Unavailable
+	case api.StatusErrorCheck(err, http.StatusInternalServerError): // 500
+		return codes.Internal
 	}
 
 	return codes.Internal
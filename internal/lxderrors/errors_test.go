@@ -0,0 +1,140 @@
+package lxderrors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/canonical/lxd/shared/api"
+)
+
+func TestToGRPCCode(t *testing.T) {
+	tests := []struct {
+		Name       string
+		Err        error
+		expectCode codes.Code
+	}{
+		{
+			Name:       "No error",
+			Err:        nil,
+			expectCode: codes.OK,
+		},
+		{
+			Name:       "Bad request",
+			Err:        api.StatusErrorf(http.StatusBadRequest, "Bad request"),
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			Name:       "Unauthorized",
+			Err:        api.StatusErrorf(http.StatusUnauthorized, "Unauthorized"),
+			expectCode: codes.Unauthenticated,
+		},
+		{
+			Name:       "Forbidden",
+			Err:        api.StatusErrorf(http.StatusForbidden, "Forbidden"),
+			expectCode: codes.PermissionDenied,
+		},
+		{
+			Name:       "Not found",
+			Err:        api.StatusErrorf(http.StatusNotFound, "Not found"),
+			expectCode: codes.NotFound,
+		},
+		{
+			Name:       "Request timeout",
+			Err:        api.StatusErrorf(http.StatusRequestTimeout, "Request timeout"),
+			expectCode: codes.DeadlineExceeded,
+		},
+		{
+			Name:       "Conflict",
+			Err:        api.StatusErrorf(http.StatusConflict, "Conflict"),
+			expectCode: codes.AlreadyExists,
+		},
+		{
+			Name:       "Precondition failed",
+			Err:        api.StatusErrorf(http.StatusPreconditionFailed, "ETag mismatch"),
+			expectCode: codes.Unavailable,
+		},
+		{
+			Name:       "Locked",
+			Err:        api.StatusErrorf(http.StatusLocked, "Locked"),
+			expectCode: codes.FailedPrecondition,
+		},
+		{
+			Name:       "Too many requests",
+			Err:        api.StatusErrorf(http.StatusTooManyRequests, "Too many requests"),
+			expectCode: codes.Unavailable,
+		},
+		{
+			Name:       "Internal server error",
+			Err:        api.StatusErrorf(http.StatusInternalServerError, "Internal server error"),
+			expectCode: codes.Internal,
+		},
+		{
+			Name:       "Service unavailable",
+			Err:        api.StatusErrorf(http.StatusServiceUnavailable, "Service unavailable"),
+			expectCode: codes.Unavailable,
+		},
+		{
+			Name:       "Gateway timeout",
+			Err:        api.StatusErrorf(http.StatusGatewayTimeout, "Gateway timeout"),
+			expectCode: codes.DeadlineExceeded,
+		},
+		{
+			Name:       "Wrapped status error",
+			Err:        fmt.Errorf("Failed to create volume: %w", api.StatusErrorf(http.StatusServiceUnavailable, "Service unavailable")),
+			expectCode: codes.Unavailable,
+		},
+		{
+			Name:       "Unrecognized status error",
+			Err:        api.StatusErrorf(http.StatusTeapot, "I'm a teapot"),
+			expectCode: codes.Internal,
+		},
+		{
+			Name:       "Context deadline exceeded",
+			Err:        context.DeadlineExceeded,
+			expectCode: codes.DeadlineExceeded,
+		},
+		{
+			Name:       "Context canceled",
+			Err:        fmt.Errorf("Request aborted: %w", context.Canceled),
+			expectCode: codes.Canceled,
+		},
+		{
+			Name:       "LXD error wrapping context deadline",
+			Err:        fmt.Errorf("%w: %w", api.StatusErrorf(http.StatusInternalServerError, "Request failed"), context.DeadlineExceeded),
+			expectCode: codes.DeadlineExceeded,
+		},
+		{
+			Name:       "LXD error wrapping context cancellation",
+			Err:        errors.Join(api.StatusErrorf(http.StatusNotFound, "Not found"), context.Canceled),
+			expectCode: codes.Canceled,
+		},
+		{
+			Name:       "gRPC status error",
+			Err:        fmt.Errorf("Failed to create volume: %w", status.Error(codes.ResourceExhausted, "Too many operations")),
+			expectCode: codes.ResourceExhausted,
+		},
+		{
+			Name:       "gRPC status error wrapping context deadline",
+			Err:        fmt.Errorf("%w: %w", status.Error(codes.ResourceExhausted, "Too many operations"), context.DeadlineExceeded),
+			expectCode: codes.DeadlineExceeded,
+		},
+		{
+			Name:       "Unrecognized error",
+			Err:        errors.New("Unknown error"),
+			expectCode: codes.Internal,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			require.Equal(t, test.expectCode, ToGRPCCode(test.Err))
+		})
+	}
+}
@@ -2,8 +2,9 @@ package e2e
 
 import (
 	"context"
+	"maps"
 	"os"
-	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -14,69 +15,58 @@ import (
 	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/client-go/rest"
 
+	"github.com/canonical/lxd-csi-driver/internal/driver"
 	"github.com/canonical/lxd-csi-driver/test/e2e/specs"
 	"github.com/canonical/lxd-csi-driver/test/testutils"
-	lxd "github.com/canonical/lxd/client"
-	lxdConfig "github.com/canonical/lxd/lxc/config"
 	"github.com/canonical/lxd/shared/api"
 )
 
-var lxdClient lxd.InstanceServer
-
 const defaultClusteredStoragePool = "default"
 
 func TestE2e(t *testing.T) {
 	gomega.RegisterFailHandler(ginkgo.Fail)
 
-	// Configure default polling intervals and timeouts.
-	gomega.SetDefaultEventuallyPollingInterval(2 * time.Second)
-	gomega.SetDefaultEventuallyTimeout(120 * time.Second)
-	gomega.SetDefaultConsistentlyPollingInterval(2 * time.Second)
-	gomega.SetDefaultConsistentlyDuration(20 * time.Second)
-	gomega.EnforceDefaultTimeoutsWhenUsingContexts()
-
-	ginkgo.RunSpecs(t, "E2e Suite")
-}
-
-func getLXDClient() lxd.InstanceServer {
-	if lxdClient != nil {
-		return lxdClient
+	// Configure default polling intervals and timeouts. They can be
+	// overridden using environment variables, for example on slow CI.
+	durations := []struct {
+		envName      string
+		defaultValue time.Duration
+		set          func(time.Duration)
+	}{
+		{"TEST_EVENTUALLY_INTERVAL", 2 * time.Second, gomega.SetDefaultEventuallyPollingInterval},
+		{"TEST_EVENTUALLY_TIMEOUT", 120 * time.Second, gomega.SetDefaultEventuallyTimeout},
+		{"TEST_CONSISTENTLY_INTERVAL", 2 * time.Second, gomega.SetDefaultConsistentlyPollingInterval},
+		{"TEST_CONSISTENTLY_DURATION", 20 * time.Second, gomega.SetDefaultConsistentlyDuration},
 	}
 
-	var configDir string
-
-	// Determine LXD configuration directory. First check for the presence
-	// of the /var/snap/lxd directory. If the directory exists, use snap's
-	// config path. Otherwise fallback to non-snap config path.
-	_, err := os.Stat("/var/snap/lxd")
-	if err == nil || os.IsExist(err) {
-		configDir = "$HOME/snap/lxd/common/config"
-	} else {
-		configDir = "$HOME/.config/lxc"
-	}
-
-	configDir = os.ExpandEnv(configDir)
-	configPath := filepath.Join(configDir, "config.yml")
+	for _, d := range durations {
+		duration, err := testutils.GetEnvDuration(d.envName, d.defaultValue)
+		if err != nil {
+			t.Fatal(err)
+		}
 
-	// Try to load client config from determined configDir.
-	// Otherwise load default config.
-	config, err := lxdConfig.LoadConfig(configPath)
-	if err != nil {
-		config = lxdConfig.DefaultConfig()
+		d.set(duration)
 	}
 
-	lxdClient, err = config.GetInstanceServer(config.DefaultRemote)
-	gomega.Expect(err).NotTo(gomega.HaveOccurred(), "Failed to connect to LXD using default remote: %v", err)
+	gomega.EnforceDefaultTimeoutsWhenUsingContexts()
 
-	return lxdClient
+	ginkgo.RunSpecs(t, "E2e Suite")
 }
 
 func requiresStandaloneLXD() {
-	if getLXDClient().IsClustered() {
+	if testutils.GetLXDClient().IsClustered() {
 		ginkgo.Skip("SKIP: Test requires standalone LXD")
 	}
 }
 
+// requiresClusteredLXD skips the test unless tests against clustered LXD are
+// enabled using the TEST_LXD_CLUSTERED environment variable and LXD is clustered.
+func requiresClusteredLXD() {
+	if !testutils.IsClusteredTestEnvironment() {
+		ginkgo.Skip("SKIP: Test requires clustered LXD and TEST_LXD_CLUSTERED=true")
+	}
+}
+
 // getTestLXDStorageDrivers returns the list of LXD storage drivers to be used for testing.
 // It reads the TEST_LXD_STORAGE_DRIVERS environment variable, which should contain a comma-separated
 // list of drivers. If the variable is not set, it defaults to ["dir"].
@@ -105,7 +95,7 @@ func getTestLXDStorageDrivers() []ginkgo.TableEntry {
 // getTestLXDStoragePool creates a new LXD storage pool with the given driver for testing purposes.
 // It returns the name of the created storage pool and a cleanup function to delete it after use.
 func getTestLXDStoragePool(driver string) (poolName string, cleanup func()) {
-	lxdClient := getLXDClient()
+	lxdClient := testutils.GetLXDClient()
 
 	if lxdClient.IsClustered() {
 		// XXX: Clustered LXD is tested only with the default storage pool.
@@ -150,6 +140,14 @@ func getTestLXDStoragePool(driver string) (poolName string, cleanup func()) {
 	return poolName, cleanup
 }
 
+// waitLXDVolumeDeleted waits until the LXD custom volume with the given CSI volume ID
+// is removed from the given storage pool. This ensures that deleting a PVC does not
+// leak volumes in LXD.
+func waitLXDVolumeDeleted(ctx context.Context, poolName string, volumeID string) {
+	gomega.Expect(volumeID).To(gomega.ContainSubstring(poolName+"/"), "Volume %q is not in storage pool %q", volumeID, poolName)
+	testutils.WaitLXDVolumeGone(ctx, volumeID)
+}
+
 var _ = ginkgo.BeforeEach(func(ctx ginkgo.SpecContext) {
 	waitContainersReady(ctx, testutils.GetKubernetesClient(testutils.GetClientConfig()), "lxd-csi")
 })
@@ -169,6 +167,16 @@ var _ = ginkgo.AfterEach(func() {
 	}
 })
 
+var _ = ginkgo.Describe("[CSIDriver]", func() {
+	ginkgo.It("CSIDriver is installed with settings the driver relies on",
+		func(ctx ginkgo.SpecContext) {
+			csiDriver := specs.NewCSIDriver(testutils.GetClientConfig())
+			csiDriver.ExpectInstalled(ctx)
+		},
+		ginkgo.SpecTimeout(time.Minute),
+	)
+})
+
 var _ = ginkgo.DescribeTableSubtree("[Volume binding mode]", func(driver string) {
 	var cfg *rest.Config
 	var namespace = "default"
@@ -204,10 +212,12 @@ var _ = ginkgo.DescribeTableSubtree("[Volume binding mode]", func(driver string)
 
 			// Ensure the pod is running.
 			pod.WaitReady(ctx)
+			volumeID := pvc.BoundVolumeID(ctx)
 
 			// Cleanup.
 			pod.Delete(ctx)
 			pvc.Delete(ctx)
+			waitLXDVolumeDeleted(ctx, poolName, volumeID)
 		},
 		ginkgo.SpecTimeout(5*time.Minute),
 	)
@@ -236,10 +246,12 @@ var _ = ginkgo.DescribeTableSubtree("[Volume binding mode]", func(driver string)
 			// Ensure the pod is running and the PVC is bound.
 			pod.WaitReady(ctx)
 			pvc.WaitBound(ctx)
+			volumeID := pvc.BoundVolumeID(ctx)
 
 			// Cleanup.
 			pod.Delete(ctx)
 			pvc.Delete(ctx)
+			waitLXDVolumeDeleted(ctx, poolName, volumeID)
 		},
 		ginkgo.SpecTimeout(5*time.Minute),
 	)
@@ -282,11 +294,15 @@ var _ = ginkgo.DescribeTableSubtree("[Volume binding mode]", func(driver string)
 			pod.WaitReady(ctx)
 			pvcFS.WaitBound(ctx)
 			pvcBlock.WaitBound(ctx)
+			volumeIDFS := pvcFS.BoundVolumeID(ctx)
+			volumeIDBlock := pvcBlock.BoundVolumeID(ctx)
 
 			// Cleanup.
 			pod.Delete(ctx)
 			pvcFS.Delete(ctx)
 			pvcBlock.Delete(ctx)
+			waitLXDVolumeDeleted(ctx, poolName, volumeIDFS)
+			waitLXDVolumeDeleted(ctx, poolName, volumeIDBlock)
 		},
 		ginkgo.SpecTimeout(5*time.Minute),
 	)
@@ -347,6 +363,65 @@ var _ = ginkgo.DescribeTableSubtree("[Volume read/write]", func(driver string) {
 		ginkgo.SpecTimeout(5*time.Minute),
 	)
 
+	ginkgo.It("Write and read FS volume as non-root user sharing the fsGroup",
+		func(ctx ginkgo.SpecContext) {
+			poolName, cleanup := getTestLXDStoragePool(driver)
+			defer cleanup()
+
+			sc := specs.NewStorageClass(cfg, "sc", poolName)
+			sc.Create(ctx)
+			defer sc.ForceDelete(context.Background())
+
+			// Create FS PVC.
+			pvc := specs.NewPersistentVolumeClaim(cfg, "pvc", namespace).
+				WithStorageClassName(sc.Name)
+			pvc.Create(ctx)
+			defer pvc.ForceDelete(context.Background())
+
+			// Set only the fsGroup, so that Kubelet grants the group access to the
+			// volume while the main container keeps running as the default user.
+			fsGroup := int64(2000)
+			podSecurityContext := &corev1.PodSecurityContext{
+				FSGroup: &fsGroup,
+			}
+
+			// Create a pod with a sidecar running as a non-root user.
+			uid := int64(3000)
+			pod := specs.NewPod(cfg, "pod", namespace).
+				WithUserSidecar(uid).
+				WithPVC(pvc, "/mnt/test").
+				WithSecurityContext(podSecurityContext)
+			pod.Create(ctx)
+			defer pod.ForceDelete(context.Background())
+			pod.WaitReady(ctx)
+
+			// Ensure commands are executed as the non-root user.
+			out, err := pod.ExecAsUser(ctx, uid, []string{"id", "-u"})
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			gomega.Expect(strings.TrimSpace(out)).To(gomega.Equal("3000"))
+
+			// Write to the volume as the non-root user.
+			path := "/mnt/test/test.txt"
+			msg := []byte("This is a test of an FS volume written by a non-root user.")
+			err = pod.WriteFileAsUser(ctx, uid, path, msg)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+			// Read back the data as the non-root user and the default user.
+			data, err := pod.ReadFileAsUser(ctx, uid, path)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			gomega.Expect(data).To(gomega.Equal(msg))
+
+			data, err = pod.ReadFile(ctx, path)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			gomega.Expect(data).To(gomega.Equal(msg))
+
+			// Cleanup.
+			pod.Delete(ctx)
+			pvc.Delete(ctx)
+		},
+		ginkgo.SpecTimeout(5*time.Minute),
+	)
+
 	ginkgo.It("Write and read block volume",
 		func(ctx ginkgo.SpecContext) {
 			if driver == "dir" {
@@ -390,6 +465,76 @@ var _ = ginkgo.DescribeTableSubtree("[Volume read/write]", func(driver string) {
 		},
 		ginkgo.SpecTimeout(5*time.Minute),
 	)
+
+	ginkgo.It("Fail to write to read-only block volume",
+		func(ctx ginkgo.SpecContext) {
+			if driver == "dir" {
+				ginkgo.Skip("Skipping read-only block volume test for 'dir' driver, as it does not support block volumes")
+			}
+
+			poolName, cleanup := getTestLXDStoragePool(driver)
+			defer cleanup()
+
+			sc := specs.NewStorageClass(cfg, "sc", poolName)
+			sc.Create(ctx)
+			defer sc.ForceDelete(context.Background())
+
+			// Create block PVC.
+			pvc := specs.NewPersistentVolumeClaim(cfg, "pvc", namespace).
+				WithStorageClassName(sc.Name).
+				WithVolumeMode(corev1.PersistentVolumeBlock)
+			pvc.Create(ctx)
+			defer pvc.ForceDelete(context.Background())
+
+			// Write to the volume from a pod that uses the PVC in read-write mode.
+			dev := "/dev/vda42"
+			pod := specs.NewPod(cfg, "pod", namespace).WithPVC(pvc, dev)
+			pod.Create(ctx)
+			defer pod.ForceDelete(context.Background())
+			pod.WaitReady(ctx)
+
+			msg := []byte("This is a test of a read-only block volume.")
+			err := pod.WriteDevice(ctx, dev, msg)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+			pod.Delete(ctx)
+
+			// Create a pod that uses the PVC in read-only mode.
+			podRO := specs.NewPod(cfg, "pod-ro", namespace).WithReadOnlyPVC(pvc, dev)
+			podRO.Create(ctx)
+			defer podRO.ForceDelete(context.Background())
+			podRO.WaitReady(ctx)
+
+			// Ensure writing to the volume fails.
+			err = podRO.WriteDevice(ctx, dev, []byte("This must not be written."))
+			gomega.Expect(err).To(gomega.HaveOccurred())
+
+			// Ensure the original data is intact.
+			data, err := podRO.ReadDevice(ctx, dev, len(msg))
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			gomega.Expect(data).To(gomega.Equal(msg))
+
+			nodeName := podRO.NodeName(ctx)
+			podRO.Delete(ctx)
+
+			// Ensure the volume is writable again by a pod on the same node
+			// once it is no longer used in read-only mode.
+			podRW := specs.NewPod(cfg, "pod-rw", namespace).
+				WithPVC(pvc, dev).
+				WithNodeSelector(map[string]string{corev1.LabelHostname: nodeName})
+			podRW.Create(ctx)
+			defer podRW.ForceDelete(context.Background())
+			podRW.WaitReady(ctx)
+
+			err = podRW.WriteDevice(ctx, dev, msg)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+			// Cleanup.
+			podRW.Delete(ctx)
+			pvc.Delete(ctx)
+		},
+		ginkgo.SpecTimeout(5*time.Minute),
+	)
 }, getTestLXDStorageDrivers())
 
 var _ = ginkgo.DescribeTableSubtree("[Volume release]", func(driver string) {
@@ -446,10 +591,12 @@ var _ = ginkgo.DescribeTableSubtree("[Volume release]", func(driver string) {
 			data, err = pod2.ReadFile(ctx, path)
 			gomega.Expect(err).NotTo(gomega.HaveOccurred())
 			gomega.Expect(data).To(gomega.Equal(msg))
+			volumeID := pvc.BoundVolumeID(ctx)
 
 			// Cleanup.
 			pod2.Delete(ctx)
 			pvc.Delete(ctx)
+			waitLXDVolumeDeleted(ctx, poolName, volumeID)
 		},
 		ginkgo.SpecTimeout(5*time.Minute),
 	)
@@ -589,11 +736,22 @@ var _ = ginkgo.DescribeTableSubtree("[Volume expansion]", func(driver string) {
 			pod.WaitReady(ctx)
 			pvc.WaitBound(ctx)
 
+			// Ensure LXD volume matches the requested PVC.
+			volumeID := pvc.BoundVolumeID(ctx)
+			gomega.Expect(testutils.GetLXDVolume(volumeID)).To(gomega.SatisfyAll(
+				testutils.HaveLXDVolumeSize(64*1024*1024),
+				testutils.HaveLXDVolumeContentType("filesystem"),
+				testutils.HaveLXDVolumeDescription("Managed by Kubernetes PVC "+pvc.PrettyName()),
+			))
+
 			// Increase PVC size to 128MiB.
 			pvc = pvc.WithSize("128Mi")
 			pvc.Patch(ctx)
 			pvc.WaitResize(ctx)
 
+			// Ensure LXD volume has been expanded as well.
+			gomega.Expect(testutils.GetLXDVolume(volumeID)).To(testutils.HaveLXDVolumeSize(128 * 1024 * 1024))
+
 			// Cleanup.
 			pod.Delete(ctx)
 			pvc.Delete(ctx)
@@ -691,6 +849,74 @@ var _ = ginkgo.DescribeTableSubtree("[Volume expansion]", func(driver string) {
 	)
 }, getTestLXDStorageDrivers())
 
+var _ = ginkgo.DescribeTableSubtree("[Volume modification]", func(driver string) {
+	var cfg *rest.Config
+	var namespace = "default"
+
+	ginkgo.BeforeEach(func() {
+		cfg = testutils.GetClientConfig()
+	})
+
+	ginkgo.It("Modify volume snapshot schedule using VolumeAttributesClass",
+		func(ctx ginkgo.SpecContext) {
+			poolName, cleanup := getTestLXDStoragePool(driver)
+			defer cleanup()
+
+			sc := specs.NewStorageClass(cfg, "sc", poolName).
+				WithVolumeBindingMode(storagev1.VolumeBindingWaitForFirstConsumer)
+			sc.Create(ctx)
+			defer sc.ForceDelete(context.Background())
+
+			vac := specs.NewVolumeAttributesClass(cfg, "vac").
+				WithParameters(map[string]string{
+					"snapshots.schedule": "@daily",
+					"snapshots.expiry":   "1w",
+				})
+			vac.Create(ctx)
+			defer vac.ForceDelete(context.Background())
+
+			// Create PVC without VolumeAttributesClass.
+			pvc := specs.NewPersistentVolumeClaim(cfg, "pvc", namespace).
+				WithStorageClassName(sc.Name)
+			pvc.Create(ctx)
+			defer pvc.ForceDelete(context.Background())
+
+			// Create a pod that uses the PVC.
+			pod := specs.NewPod(cfg, "pod", namespace).WithPVC(pvc, "/mnt/test")
+			pod.Create(ctx)
+			defer pod.ForceDelete(context.Background())
+
+			// Ensure Pod is running and PVC is bound.
+			pod.WaitReady(ctx)
+			pvc.WaitBound(ctx)
+
+			// Ensure LXD volume has no snapshot schedule configured.
+			volumeID := pvc.BoundVolumeID(ctx)
+			gomega.Expect(testutils.GetLXDVolumeConfig(volumeID)).NotTo(gomega.HaveKey("snapshots.schedule"))
+
+			// Assign VolumeAttributesClass to the PVC.
+			pvc = pvc.WithVolumeAttributesClassName(vac.Name)
+			pvc.Patch(ctx)
+
+			// Ensure LXD volume configuration has been modified.
+			lxdVolumeConfig := func() map[string]string {
+				return testutils.GetLXDVolumeConfig(volumeID)
+			}
+
+			gomega.Eventually(lxdVolumeConfig).WithContext(ctx).Should(gomega.SatisfyAll(
+				gomega.HaveKeyWithValue("snapshots.schedule", "@daily"),
+				gomega.HaveKeyWithValue("snapshots.expiry", "1w"),
+			), "LXD volume configuration was not modified")
+
+			// Cleanup.
+			pod.Delete(ctx)
+			pvc.Delete(ctx)
+			vac.Delete(ctx)
+		},
+		ginkgo.SpecTimeout(5*time.Minute),
+	)
+}, getTestLXDStorageDrivers())
+
 var _ = ginkgo.DescribeTableSubtree("[Volume cloning]", func(driver string) {
 	var cfg *rest.Config
 	var namespace = "default"
@@ -884,6 +1110,28 @@ var _ = ginkgo.DescribeTableSubtree("[Volume snapshots]", func(driver string) {
 		ginkgo.SpecTimeout(5*time.Minute),
 	)
 
+	ginkgo.It("Fail to snapshot non-existent PVC",
+		func(ctx ginkgo.SpecContext) {
+			vsc := specs.NewVolumeSnapshotClass(cfg, "vsc")
+			vsc.Create(ctx)
+			defer vsc.ForceDelete(context.Background())
+
+			// Create volume snapshot of a PVC that does not exist.
+			pvcName := testutils.GenerateName("pvc-missing")
+			snapshot := specs.NewVolumeSnapshot(cfg, "snapshot", namespace, pvcName).
+				WithVolumeSnapshotClassName(vsc.Name)
+			snapshot.Create(ctx)
+			defer snapshot.ForceDelete(context.Background())
+
+			// Ensure the snapshot controller reports the missing PVC.
+			snapshot.WaitError(ctx, "failed to retrieve PVC "+pvcName)
+
+			// Cleanup.
+			snapshot.Delete(ctx)
+		},
+		ginkgo.SpecTimeout(5*time.Minute),
+	)
+
 	ginkgo.It("Snapshot as volume source",
 		func(ctx ginkgo.SpecContext) {
 			poolName, cleanup := getTestLXDStoragePool(driver)
@@ -970,4 +1218,209 @@ var _ = ginkgo.DescribeTableSubtree("[Volume snapshots]", func(driver string) {
 		},
 		ginkgo.SpecTimeout(5*time.Minute),
 	)
+
+	ginkgo.It("Block volume snapshot as volume source",
+		func(ctx ginkgo.SpecContext) {
+			if driver == "dir" {
+				ginkgo.Skip("Skipping block volume snapshot test for 'dir' driver, as it does not support volume size")
+			}
+
+			poolName, cleanup := getTestLXDStoragePool(driver)
+			defer cleanup()
+
+			sc := specs.NewStorageClass(cfg, "sc", poolName).
+				WithVolumeBindingMode(storagev1.VolumeBindingWaitForFirstConsumer)
+			sc.Create(ctx)
+			defer sc.ForceDelete(context.Background())
+
+			vsc := specs.NewVolumeSnapshotClass(cfg, "vsc")
+			vsc.Create(ctx)
+			defer vsc.ForceDelete(context.Background())
+
+			// Create block PVC.
+			pvc := specs.NewPersistentVolumeClaim(cfg, "pvc", namespace).
+				WithStorageClassName(sc.Name).
+				WithVolumeMode(corev1.PersistentVolumeBlock).
+				WithSize("64Mi")
+			pvc.Create(ctx)
+			defer pvc.ForceDelete(context.Background())
+
+			// Create a pod that uses the PVC.
+			dev := "/dev/vda42"
+			pod := specs.NewPod(cfg, "pod", namespace).WithPVC(pvc, dev)
+			pod.Create(ctx)
+			defer pod.ForceDelete(context.Background())
+			pod.WaitReady(ctx)
+
+			// Write to the volume.
+			msg := []byte("Initial content of a block volume.")
+			err := pod.WriteDevice(ctx, dev, msg)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+			// Create volume snapshot.
+			snapshot := specs.NewVolumeSnapshot(cfg, "snapshot", namespace, pvc.Name).
+				WithVolumeSnapshotClassName(vsc.Name)
+			snapshot.Create(ctx)
+			defer snapshot.ForceDelete(context.Background())
+			snapshot.WaitReadyToUse(ctx)
+
+			// Modify content to ensure it differs from the snapshot taken before.
+			err = pod.WriteDevice(ctx, dev, []byte("Modified content of a block volume."))
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+			// Create a new block PVC that uses the snapshot as a source.
+			restoredPVC := specs.NewPersistentVolumeClaim(cfg, "pvc-restored", namespace).
+				WithStorageClassName(sc.Name).
+				WithVolumeMode(corev1.PersistentVolumeBlock).
+				WithSourceSnapshot(snapshot.Name).
+				WithSize("64Mi")
+			restoredPVC.Create(ctx)
+			defer restoredPVC.ForceDelete(context.Background())
+
+			// Recreate a pod and use restored PVC for a new one.
+			pod.Delete(ctx)
+			pod = specs.NewPod(cfg, "pod", namespace).WithPVC(restoredPVC, dev)
+			pod.Create(ctx)
+			defer pod.ForceDelete(context.Background())
+			pod.WaitReady(ctx)
+			restoredPVC.WaitBound(ctx)
+
+			// Remove no longer needed snapshot and parent PVC.
+			snapshot.Delete(ctx)
+			pvc.Delete(ctx)
+
+			// Read back the raw bytes to confirm the block volume was restored from a snapshot.
+			data, err := pod.ReadDevice(ctx, dev, len(msg))
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			gomega.Expect(data).To(gomega.Equal(msg))
+
+			// Cleanup.
+			pod.Delete(ctx)
+			restoredPVC.Delete(ctx)
+		},
+		ginkgo.SpecTimeout(5*time.Minute),
+	)
 }, getTestLXDStorageDrivers())
+
+var _ = ginkgo.Describe("[Clustered volume snapshots]", func() {
+	var cfg *rest.Config
+	var namespace = "default"
+
+	ginkgo.BeforeEach(func() {
+		cfg = testutils.GetClientConfig()
+	})
+
+	ginkgo.It("Restore local volume snapshot on a different cluster member",
+		func(ctx ginkgo.SpecContext) {
+			requiresClusteredLXD()
+
+			lxdClient := testutils.GetLXDClient()
+			poolName := defaultClusteredStoragePool
+
+			// Snapshots of volumes on remote storage pools are reachable from
+			// all cluster members, so only local storage pools are relevant.
+			pool, _, err := lxdClient.GetStoragePool(poolName)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred(), "Failed to get storage pool %q", poolName)
+
+			server, _, err := lxdClient.GetServer()
+			gomega.Expect(err).NotTo(gomega.HaveOccurred(), "Failed to get LXD server")
+
+			for _, storageDriver := range server.Environment.StorageSupportedDrivers {
+				if storageDriver.Name == pool.Driver && storageDriver.Remote {
+					ginkgo.Skip("SKIP: Test requires storage pool " + poolName + " with a local driver, got " + pool.Driver)
+				}
+			}
+
+			// Pick nodes running on two different cluster members.
+			memberNodes := testutils.GetClusterMemberNodes(ctx, testutils.GetKubernetesClient(cfg))
+			members := slices.Sorted(maps.Keys(memberNodes))
+			if len(members) < 2 {
+				ginkgo.Skip("SKIP: Test requires Kubernetes nodes on at least two LXD cluster members")
+			}
+
+			sourceMember, restoreMember := members[0], members[1]
+
+			sc := specs.NewStorageClass(cfg, "sc", poolName).
+				WithVolumeBindingMode(storagev1.VolumeBindingWaitForFirstConsumer)
+			sc.Create(ctx)
+			defer sc.ForceDelete(context.Background())
+
+			vsc := specs.NewVolumeSnapshotClass(cfg, "vsc")
+			vsc.Create(ctx)
+			defer vsc.ForceDelete(context.Background())
+
+			// Create a PVC consumed by a pod on the source cluster member.
+			pvc := specs.NewPersistentVolumeClaim(cfg, "pvc", namespace).
+				WithStorageClassName(sc.Name).
+				WithSize("64Mi")
+			pvc.Create(ctx)
+			defer pvc.ForceDelete(context.Background())
+
+			mntPath := "/mnt/test"
+			filePath := "/mnt/test/test.txt"
+			pod := specs.NewPod(cfg, "pod", namespace).
+				WithPVC(pvc, mntPath).
+				WithNodeSelector(map[string]string{driver.AnnotationLXDClusterMember: sourceMember})
+			pod.Create(ctx)
+			defer pod.ForceDelete(context.Background())
+			pod.WaitReady(ctx)
+			pvc.WaitBound(ctx)
+
+			// Ensure the volume is created on the source cluster member.
+			gomega.Expect(pvc.BoundVolumeClusterMembers(ctx)).To(gomega.Equal([]string{sourceMember}))
+			gomega.Expect(testutils.GetLXDVolume(pvc.BoundVolumeID(ctx)).Location).To(gomega.Equal(sourceMember))
+
+			// Write to the volume.
+			msg := []byte("Content written on " + sourceMember + ".")
+			err = pod.WriteFile(ctx, filePath, msg)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+			// Create volume snapshot.
+			snapshot := specs.NewVolumeSnapshot(cfg, "snapshot", namespace, pvc.Name).
+				WithVolumeSnapshotClassName(vsc.Name)
+			snapshot.Create(ctx)
+			defer snapshot.ForceDelete(context.Background())
+			snapshot.WaitReadyToUse(ctx)
+
+			// Restore the snapshot into a new PVC consumed by a pod on the
+			// other cluster member.
+			restoredPVC := specs.NewPersistentVolumeClaim(cfg, "pvc-restored", namespace).
+				WithStorageClassName(sc.Name).
+				WithSourceSnapshot(snapshot.Name).
+				WithSize("64Mi")
+			restoredPVC.Create(ctx)
+			defer restoredPVC.ForceDelete(context.Background())
+
+			restoredPod := specs.NewPod(cfg, "pod-restored", namespace).
+				WithPVC(restoredPVC, mntPath).
+				WithNodeSelector(map[string]string{driver.AnnotationLXDClusterMember: restoreMember})
+			restoredPod.Create(ctx)
+			defer restoredPod.ForceDelete(context.Background())
+			restoredPod.WaitReady(ctx)
+			restoredPVC.WaitBound(ctx)
+
+			// Ensure the restored volume's topology matches the node of the
+			// consuming pod, and that the volume is located on its cluster member.
+			gomega.Expect(memberNodes[restoreMember]).To(gomega.ContainElement(restoredPod.NodeName(ctx)))
+			gomega.Expect(restoredPVC.BoundVolumeClusterMembers(ctx)).To(gomega.Equal([]string{restoreMember}))
+			gomega.Expect(testutils.GetLXDVolume(restoredPVC.BoundVolumeID(ctx)).Location).To(gomega.Equal(restoreMember))
+
+			// Read the data to confirm the volume was restored from the snapshot.
+			data, err := restoredPod.ReadFile(ctx, filePath)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			gomega.Expect(data).To(gomega.Equal(msg))
+
+			// Cleanup.
+			restoredPod.Delete(ctx)
+			pod.Delete(ctx)
+			snapshot.Delete(ctx)
+			restoredVolumeID := restoredPVC.BoundVolumeID(ctx)
+			volumeID := pvc.BoundVolumeID(ctx)
+			restoredPVC.Delete(ctx)
+			pvc.Delete(ctx)
+			waitLXDVolumeDeleted(ctx, poolName, restoredVolumeID)
+			waitLXDVolumeDeleted(ctx, poolName, volumeID)
+		},
+		ginkgo.SpecTimeout(5*time.Minute),
+	)
+})
@@ -0,0 +1,121 @@
+package specs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/ptr"
+
+	"github.com/canonical/lxd-csi-driver/internal/driver"
+	"github.com/canonical/lxd-csi-driver/test/testutils"
+)
+
+// CSIDriver represents the expected settings of the Kubernetes CSIDriver
+// object installed for the driver.
+type CSIDriver struct {
+	storagev1.CSIDriver
+	client kubernetes.Interface
+}
+
+// NewCSIDriver creates a new CSIDriver definition for the driver with the
+// settings the driver relies on. Volumes must be attached through
+// ControllerPublishVolume, pod information is not passed on mount, and
+// kubelet applies the fsGroup regardless of the volume's access mode.
+func NewCSIDriver(cfg *rest.Config) CSIDriver {
+	fsGroupPolicy := storagev1.FileFSGroupPolicy
+
+	manifest := storagev1.CSIDriver{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: driver.DefaultDriverName,
+		},
+		Spec: storagev1.CSIDriverSpec{
+			AttachRequired: ptr.To(true),
+			PodInfoOnMount: ptr.To(false),
+			FSGroupPolicy:  &fsGroupPolicy,
+		},
+	}
+
+	return CSIDriver{
+		CSIDriver: manifest,
+		client:    testutils.GetKubernetesClient(cfg),
+	}
+}
+
+// PrettyName returns the string consisting of CSIDriver's name.
+func (d CSIDriver) PrettyName() string {
+	return prettyName(d.Namespace, d.Name)
+}
+
+// WithAttachRequired sets whether the CSIDriver is expected to require attachment.
+func (d CSIDriver) WithAttachRequired(attachRequired bool) CSIDriver {
+	d.Spec.AttachRequired = ptr.To(attachRequired)
+	return d
+}
+
+// WithPodInfoOnMount sets whether the CSIDriver is expected to pass the pod
+// information on mount.
+func (d CSIDriver) WithPodInfoOnMount(podInfoOnMount bool) CSIDriver {
+	d.Spec.PodInfoOnMount = ptr.To(podInfoOnMount)
+	return d
+}
+
+// WithFSGroupPolicy sets the expected fsGroup policy of the CSIDriver.
+func (d CSIDriver) WithFSGroupPolicy(policy storagev1.FSGroupPolicy) CSIDriver {
+	d.Spec.FSGroupPolicy = &policy
+	return d
+}
+
+// State returns the actual state of the CSIDriver.
+func (d CSIDriver) State(ctx context.Context) (*storagev1.CSIDriver, error) {
+	return d.client.StorageV1().CSIDrivers().Get(ctx, d.Name, metav1.GetOptions{})
+}
+
+// StateString returns the state of the CSIDriver as a string.
+// This is useful to include in error messages when desired state is not achieved.
+func (d CSIDriver) StateString(ctx context.Context) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CSIDriver %q state:\n", d.PrettyName())
+
+	state, err := d.State(ctx)
+	if err != nil {
+		fmt.Fprintln(&b, "- Failed to get state:", err.Error())
+	} else {
+		fmt.Fprintln(&b, "- AttachRequired:", ptr.Deref(state.Spec.AttachRequired, false))
+		fmt.Fprintln(&b, "- PodInfoOnMount:", ptr.Deref(state.Spec.PodInfoOnMount, false))
+		fmt.Fprintln(&b, "- FSGroupPolicy:", ptr.Deref(state.Spec.FSGroupPolicy, ""))
+
+		if len(state.Spec.VolumeLifecycleModes) > 0 {
+			fmt.Fprintf(&b, "- VolumeLifecycleModes: %v\n", state.Spec.VolumeLifecycleModes)
+		}
+	}
+
+	return b.String()
+}
+
+// ExpectInstalled asserts that the CSIDriver is installed with the expected
+// attachRequired, podInfoOnMount, and fsGroupPolicy settings. This guards
+// against changes of the deployment manifests that break the attachment
+// of volumes or the ownership of their content.
+func (d CSIDriver) ExpectInstalled(ctx context.Context) {
+	ginkgo.By("Ensure CSIDriver " + d.PrettyName() + " is installed with expected settings")
+	d.expectInstalled(ctx)
+}
+
+// expectInstalled compares the settings of the installed CSIDriver with the
+// expected ones.
+func (d CSIDriver) expectInstalled(ctx context.Context) {
+	state, err := d.State(ctx)
+	gomega.Expect(err).NotTo(gomega.HaveOccurred(), "Failed to get CSIDriver %q", d.PrettyName())
+
+	stateString := d.StateString(ctx)
+	gomega.Expect(ptr.Deref(state.Spec.AttachRequired, false)).To(gomega.Equal(ptr.Deref(d.Spec.AttachRequired, false)), "CSIDriver %q has unexpected attachRequired\n%s", d.PrettyName(), stateString)
+	gomega.Expect(ptr.Deref(state.Spec.PodInfoOnMount, false)).To(gomega.Equal(ptr.Deref(d.Spec.PodInfoOnMount, false)), "CSIDriver %q has unexpected podInfoOnMount\n%s", d.PrettyName(), stateString)
+	gomega.Expect(ptr.Deref(state.Spec.FSGroupPolicy, "")).To(gomega.Equal(ptr.Deref(d.Spec.FSGroupPolicy, "")), "CSIDriver %q has unexpected fsGroupPolicy\n%s", d.PrettyName(), stateString)
+}
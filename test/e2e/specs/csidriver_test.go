@@ -0,0 +1,92 @@
+package specs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/onsi/gomega"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+
+	"github.com/canonical/lxd-csi-driver/internal/driver"
+)
+
+func TestCSIDriverExpectInstalled(t *testing.T) {
+	g := gomega.NewWithT(t)
+	gomega.RegisterTestingT(t)
+
+	ctx := context.Background()
+	newCSIDriver := func(attachRequired bool, podInfoOnMount bool, policy storagev1.FSGroupPolicy) *storagev1.CSIDriver {
+		return &storagev1.CSIDriver{
+			ObjectMeta: metav1.ObjectMeta{Name: driver.DefaultDriverName},
+			Spec: storagev1.CSIDriverSpec{
+				AttachRequired: ptr.To(attachRequired),
+				PodInfoOnMount: ptr.To(podInfoOnMount),
+				FSGroupPolicy:  &policy,
+			},
+		}
+	}
+
+	expected := CSIDriver{
+		CSIDriver: *newCSIDriver(true, false, storagev1.FileFSGroupPolicy),
+		client:    fake.NewClientset(),
+	}
+
+	// Missing CSIDriver causes a failure.
+	failures := gomega.InterceptGomegaFailures(func() { expected.expectInstalled(ctx) })
+	g.Expect(failures).To(gomega.ContainElement(gomega.ContainSubstring("Failed to get CSIDriver")))
+
+	tests := []struct {
+		Name          string
+		Installed     *storagev1.CSIDriver
+		expectFailure string
+	}{
+		{
+			Name:      "Expected settings",
+			Installed: newCSIDriver(true, false, storagev1.FileFSGroupPolicy),
+		},
+		{
+			Name:          "Attachment not required",
+			Installed:     newCSIDriver(false, false, storagev1.FileFSGroupPolicy),
+			expectFailure: "unexpected attachRequired",
+		},
+		{
+			Name:          "Pod info on mount",
+			Installed:     newCSIDriver(true, true, storagev1.FileFSGroupPolicy),
+			expectFailure: "unexpected podInfoOnMount",
+		},
+		{
+			Name:          "Different fsGroup policy",
+			Installed:     newCSIDriver(true, false, storagev1.NoneFSGroupPolicy),
+			expectFailure: "unexpected fsGroupPolicy",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			g := gomega.NewWithT(t)
+
+			d := expected
+			d.client = fake.NewClientset(test.Installed)
+
+			failures := gomega.InterceptGomegaFailures(func() { d.expectInstalled(ctx) })
+			if test.expectFailure == "" {
+				g.Expect(failures).To(gomega.BeEmpty())
+				return
+			}
+
+			g.Expect(failures).To(gomega.HaveLen(1))
+			g.Expect(failures[0]).To(gomega.ContainSubstring(test.expectFailure))
+			g.Expect(failures[0]).To(gomega.ContainSubstring("CSIDriver %q state:", driver.DefaultDriverName))
+		})
+	}
+
+	// Expected settings can be adjusted.
+	d := expected.WithPodInfoOnMount(true).WithFSGroupPolicy(storagev1.NoneFSGroupPolicy)
+	d.client = fake.NewClientset(newCSIDriver(true, true, storagev1.NoneFSGroupPolicy))
+
+	failures = gomega.InterceptGomegaFailures(func() { d.expectInstalled(ctx) })
+	g.Expect(failures).To(gomega.BeEmpty())
+}
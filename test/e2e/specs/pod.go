@@ -5,6 +5,9 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"maps"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -22,7 +25,25 @@ import (
 	"github.com/canonical/lxd-csi-driver/test/testutils"
 )
 
-const testContainerImage = "busybox:latest"
+// defaultTestContainerImage is the container image used for test pods, unless
+// overridden with the TEST_CONTAINER_IMAGE environment variable or [Pod.WithImage].
+//
+// The exec helpers rely on the image providing "sh", "base64", "dd", "sleep",
+// and "id" binaries, which is the case for busybox.
+const defaultTestContainerImage = "busybox:latest"
+
+// testContainerImage returns the container image used for test pods.
+func testContainerImage() string {
+	image := os.Getenv("TEST_CONTAINER_IMAGE")
+	if image == "" {
+		return defaultTestContainerImage
+	}
+
+	return image
+}
+
+// userSidecarPrefix is the name prefix of sidecar containers that run as a specific user.
+const userSidecarPrefix = "user-"
 
 // Pod represents a Kubernetes Pod.
 type Pod struct {
@@ -42,7 +63,7 @@ func NewPod(cfg *rest.Config, name string, namespace string) Pod {
 			Containers: []corev1.Container{
 				{
 					Name:            "container",
-					Image:           testContainerImage,
+					Image:           testContainerImage(),
 					ImagePullPolicy: corev1.PullIfNotPresent,
 					Command:         []string{"/bin/sh", "-c", "trap exit TERM; sleep infinity & wait"},
 				},
@@ -62,12 +83,38 @@ func (p Pod) PrettyName() string {
 	return prettyName(p.Namespace, p.Name)
 }
 
+// WithImage sets the container image of all Pod's containers.
+// The image must provide the tools required by the exec helpers,
+// see [defaultTestContainerImage].
+func (p Pod) WithImage(image string) Pod {
+	p.Spec.Containers = slices.Clone(p.Spec.Containers)
+	for i := range p.Spec.Containers {
+		p.Spec.Containers[i].Image = image
+	}
+
+	return p
+}
+
 // WithSecurityContext sets the Pod's security context.
 func (p Pod) WithSecurityContext(securityContext *corev1.PodSecurityContext) Pod {
 	p.Spec.SecurityContext = securityContext
 	return p
 }
 
+// WithNodeSelector restricts the Pod to the nodes with the given labels.
+// Unlike setting the node name directly, the Pod still goes through the
+// scheduler, which is required to provision volumes with the binding mode
+// WaitForFirstConsumer.
+func (p Pod) WithNodeSelector(labels map[string]string) Pod {
+	p.Spec.NodeSelector = maps.Clone(p.Spec.NodeSelector)
+	if p.Spec.NodeSelector == nil {
+		p.Spec.NodeSelector = make(map[string]string, len(labels))
+	}
+
+	maps.Copy(p.Spec.NodeSelector, labels)
+	return p
+}
+
 // WithPVC adds a PersistentVolumeClaim to the Pod's volumes.
 // The path is the mount path inside the container for filesystem volumes
 // and device path inside the container for block volumes.
@@ -81,16 +128,18 @@ func (p Pod) WithPVC(pvc PersistentVolumeClaim, path string) Pod {
 		},
 	})
 
-	if len(p.Spec.Containers) > 0 {
+	// Volume is added to all containers, so that user sidecars
+	// have access to the same volumes as the main container.
+	for i := range p.Spec.Containers {
 		if pvc.Spec.VolumeMode == nil || *pvc.Spec.VolumeMode == corev1.PersistentVolumeFilesystem {
 			// For filesystem volumes, we use the mount path.
-			p.Spec.Containers[0].VolumeMounts = append(p.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			p.Spec.Containers[i].VolumeMounts = append(p.Spec.Containers[i].VolumeMounts, corev1.VolumeMount{
 				Name:      pvc.Name,
 				MountPath: path,
 			})
 		} else {
 			// For block volumes, we use the device path.
-			p.Spec.Containers[0].VolumeDevices = append(p.Spec.Containers[0].VolumeDevices, corev1.VolumeDevice{
+			p.Spec.Containers[i].VolumeDevices = append(p.Spec.Containers[i].VolumeDevices, corev1.VolumeDevice{
 				Name:       pvc.Name,
 				DevicePath: path,
 			})
@@ -100,6 +149,39 @@ func (p Pod) WithPVC(pvc PersistentVolumeClaim, path string) Pod {
 	return p
 }
 
+// WithReadOnlyPVC adds a PersistentVolumeClaim to the Pod's volumes in
+// read-only mode. See [Pod.WithPVC] for the meaning of the path.
+func (p Pod) WithReadOnlyPVC(pvc PersistentVolumeClaim, path string) Pod {
+	p = p.WithPVC(pvc, path)
+	p.Spec.Volumes[len(p.Spec.Volumes)-1].PersistentVolumeClaim.ReadOnly = true
+
+	return p
+}
+
+// WithUserSidecar adds a sidecar container that runs as the given user and group ID.
+// The sidecar shares the volumes with the main container, which allows executing
+// commands against the Pod's volumes as a specific user using [Pod.ExecAsUser].
+func (p Pod) WithUserSidecar(uid int64) Pod {
+	sidecar := corev1.Container{
+		Name:            userSidecarPrefix + strconv.FormatInt(uid, 10),
+		Image:           testContainerImage(),
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"/bin/sh", "-c", "trap exit TERM; sleep infinity & wait"},
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser:  &uid,
+			RunAsGroup: &uid,
+		},
+	}
+
+	if len(p.Spec.Containers) > 0 {
+		sidecar.VolumeMounts = slices.Clone(p.Spec.Containers[0].VolumeMounts)
+		sidecar.VolumeDevices = slices.Clone(p.Spec.Containers[0].VolumeDevices)
+	}
+
+	p.Spec.Containers = append(p.Spec.Containers, sidecar)
+	return p
+}
+
 // State returns the actual state of the Pod.
 func (p Pod) State(ctx context.Context) (*corev1.Pod, error) {
 	return p.client.CoreV1().Pods(p.Namespace).Get(ctx, p.Name, metav1.GetOptions{})
@@ -151,6 +233,16 @@ func (p Pod) StateString(ctx context.Context) string {
 	return b.String()
 }
 
+// NodeName returns the name of the node the Pod is running on.
+// The Pod is expected to be scheduled.
+func (p Pod) NodeName(ctx context.Context) string {
+	state, err := p.State(ctx)
+	gomega.Expect(err).NotTo(gomega.HaveOccurred(), "Failed to get pod %q", p.PrettyName())
+	gomega.Expect(state.Spec.NodeName).NotTo(gomega.BeEmpty(), "Pod %q is not scheduled", p.PrettyName())
+
+	return state.Spec.NodeName
+}
+
 // Create creates the Pod in the Kubernetes cluster.
 func (p Pod) Create(ctx context.Context) {
 	ginkgo.By("Create Pod " + p.PrettyName())
@@ -204,6 +296,22 @@ func (p Pod) Exec(ctx context.Context, cmd []string) (string, error) {
 	return p.ExecContainer(ctx, p.Spec.Containers[0].Name, cmd)
 }
 
+// ExecAsUser executes a command in the Pod as the given user.
+// The Pod must have a user sidecar for the given user, see [Pod.WithUserSidecar].
+func (p Pod) ExecAsUser(ctx context.Context, uid int64, cmd []string) (string, error) {
+	container := userSidecarPrefix + strconv.FormatInt(uid, 10)
+
+	hasSidecar := slices.ContainsFunc(p.Spec.Containers, func(c corev1.Container) bool {
+		return c.Name == container
+	})
+
+	if !hasSidecar {
+		return "", fmt.Errorf("Failed to exec into Pod %q as user %d: Pod has no user sidecar", p.Name, uid)
+	}
+
+	return p.ExecContainer(ctx, container, cmd)
+}
+
 // ExecContainer executes a command in the pod's container and returns stdout.
 func (p Pod) ExecContainer(ctx context.Context, container string, cmd []string) (string, error) {
 	execOpts := &corev1.PodExecOptions{
@@ -246,23 +354,52 @@ func (p Pod) ExecContainer(ctx context.Context, container string, cmd []string)
 	return stdout.String(), nil
 }
 
-// WriteFile writes arbitrary bytes to a filesystem path inside the pod.
+// writeFileCmd returns the command that writes the given data to a file.
 // Data is base64-encoded before sending to avoid issues with shell quoting.
-func (p *Pod) WriteFile(ctx context.Context, path string, data []byte) error {
-	ginkgo.By("Write " + strconv.Itoa(len(data)) + " bytes to file " + path + " in pod " + p.PrettyName())
+func writeFileCmd(path string, data []byte) []string {
 	b64 := base64.StdEncoding.EncodeToString(data)
 	script := fmt.Sprintf(`
 set -e
 echo %q | base64 -d > %q
 `, b64, path)
-	_, err := p.Exec(ctx, []string{"sh", "-c", script})
+
+	return []string{"sh", "-c", script}
+}
+
+// readFileCmd returns the command that reads the base64-encoded contents of a file.
+func readFileCmd(path string) []string {
+	return []string{"sh", "-c", fmt.Sprintf("base64 %q", path)}
+}
+
+// WriteFile writes arbitrary bytes to a filesystem path inside the pod.
+func (p *Pod) WriteFile(ctx context.Context, path string, data []byte) error {
+	ginkgo.By("Write " + strconv.Itoa(len(data)) + " bytes to file " + path + " in pod " + p.PrettyName())
+	_, err := p.Exec(ctx, writeFileCmd(path, data))
+	return err
+}
+
+// WriteFileAsUser writes arbitrary bytes to a filesystem path inside the pod as the given user.
+func (p *Pod) WriteFileAsUser(ctx context.Context, uid int64, path string, data []byte) error {
+	ginkgo.By("Write " + strconv.Itoa(len(data)) + " bytes to file " + path + " in pod " + p.PrettyName() + " as user " + strconv.FormatInt(uid, 10))
+	_, err := p.ExecAsUser(ctx, uid, writeFileCmd(path, data))
 	return err
 }
 
 // ReadFile reads the entire contents of a file from inside the pod.
 func (p *Pod) ReadFile(ctx context.Context, path string) ([]byte, error) {
 	ginkgo.By("Read file " + path + " in pod " + p.PrettyName())
-	out, err := p.Exec(ctx, []string{"sh", "-c", fmt.Sprintf("base64 %q", path)})
+	out, err := p.Exec(ctx, readFileCmd(path))
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(out))
+}
+
+// ReadFileAsUser reads the entire contents of a file from inside the pod as the given user.
+func (p *Pod) ReadFileAsUser(ctx context.Context, uid int64, path string) ([]byte, error) {
+	ginkgo.By("Read file " + path + " in pod " + p.PrettyName() + " as user " + strconv.FormatInt(uid, 10))
+	out, err := p.ExecAsUser(ctx, uid, readFileCmd(path))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,31 @@
+package specs
+
+import (
+	"testing"
+
+	"github.com/onsi/gomega"
+	"k8s.io/client-go/rest"
+)
+
+func TestPodImage(t *testing.T) {
+	gomega.RegisterTestingT(t)
+	cfg := &rest.Config{Host: "https://127.0.0.1:6443"}
+
+	// Default image.
+	t.Setenv("TEST_CONTAINER_IMAGE", "")
+	pod := NewPod(cfg, "pod", "default")
+	gomega.Expect(pod.Spec.Containers[0].Image).To(gomega.Equal(defaultTestContainerImage))
+
+	// Image from environment variable.
+	t.Setenv("TEST_CONTAINER_IMAGE", "registry.example.com/busybox:1.36")
+	pod = NewPod(cfg, "pod", "default").WithUserSidecar(1000)
+	for _, c := range pod.Spec.Containers {
+		gomega.Expect(c.Image).To(gomega.Equal("registry.example.com/busybox:1.36"))
+	}
+
+	// Image override applies to all containers.
+	pod = pod.WithImage("mirror.example.com/busybox:latest")
+	for _, c := range pod.Spec.Containers {
+		gomega.Expect(c.Image).To(gomega.Equal("mirror.example.com/busybox:latest"))
+	}
+}
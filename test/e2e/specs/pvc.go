@@ -81,6 +81,12 @@ func (pvc PersistentVolumeClaim) WithStorageClassName(storageClassName string) P
 	return pvc
 }
 
+// WithVolumeAttributesClassName sets the VolumeAttributesClass for the PersistentVolumeClaim.
+func (pvc PersistentVolumeClaim) WithVolumeAttributesClassName(vacName string) PersistentVolumeClaim {
+	pvc.Spec.VolumeAttributesClassName = &vacName
+	return pvc
+}
+
 // WithSize sets the size of the PersistentVolumeClaim.
 // The size can be specified in bytes or in binary SI format.
 func (pvc PersistentVolumeClaim) WithSize(size string) PersistentVolumeClaim {
@@ -209,6 +215,19 @@ func (pvc PersistentVolumeClaim) ForceDelete(ctx context.Context) {
 	_ = pvc.delete(ctx, opts)
 }
 
+// BoundVolumeID returns the CSI volume ID (volume handle) of the PersistentVolume
+// bound to the PersistentVolumeClaim. The PVC is expected to be bound.
+func (pvc PersistentVolumeClaim) BoundVolumeID(ctx context.Context) string {
+	return testutils.GetBoundVolumeID(ctx, pvc.client, pvc.Namespace, pvc.Name)
+}
+
+// BoundVolumeClusterMembers returns the LXD cluster members that the topology
+// of the PersistentVolume bound to the PersistentVolumeClaim is restricted to.
+// The PVC is expected to be bound.
+func (pvc PersistentVolumeClaim) BoundVolumeClusterMembers(ctx context.Context) []string {
+	return testutils.GetBoundVolumeClusterMembers(ctx, pvc.client, pvc.Namespace, pvc.Name)
+}
+
 // WaitBound waits until the PersistentVolumeClaim is bound to a PersistentVolume.
 func (pvc PersistentVolumeClaim) WaitBound(ctx context.Context) {
 	ginkgo.By("Wait for PersistentVolumeClaim " + pvc.PrettyName() + " to be bound")
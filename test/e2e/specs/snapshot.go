@@ -96,7 +96,7 @@ func (snapshot VolumeSnapshot) StateString(ctx context.Context) string {
 		}
 
 		if state.Status.Error != nil {
-			fmt.Fprintf(&b, "- Error: %v\n", state.Status.Error.Message)
+			fmt.Fprintf(&b, "- Error: %v\n", ptr.Deref(state.Status.Error.Message, ""))
 		}
 	}
 
@@ -182,3 +182,19 @@ func (snapshot VolumeSnapshot) WaitGone(ctx context.Context) {
 
 	gomega.Eventually(snapshotGone).WithContext(ctx).Should(gomega.BeTrue(), "Snapshot %q is not gone\n%s", snapshot.PrettyName(), snapshot.StateString(ctx))
 }
+
+// WaitError waits until the VolumeSnapshot reports an error containing the given substring.
+// This is useful to test that snapshot creation fails with the expected error.
+func (snapshot VolumeSnapshot) WaitError(ctx context.Context, substring string) {
+	ginkgo.By("Wait for VolumeSnapshot " + snapshot.PrettyName() + " to report an error")
+	errorMessage := func(ctx context.Context) string {
+		state, err := snapshot.State(ctx)
+		if err != nil || state == nil || state.Status == nil || state.Status.Error == nil {
+			return ""
+		}
+
+		return ptr.Deref(state.Status.Error.Message, "")
+	}
+
+	gomega.Eventually(errorMessage).WithContext(ctx).Should(gomega.ContainSubstring(substring), "Snapshot %q does not report the expected error\n%s", snapshot.PrettyName(), snapshot.StateString(ctx))
+}
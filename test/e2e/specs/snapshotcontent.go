@@ -0,0 +1,164 @@
+package specs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	snapshotter "github.com/kubernetes-csi/external-snapshotter/client/v8/clientset/versioned"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/ptr"
+
+	"github.com/canonical/lxd-csi-driver/test/testutils"
+)
+
+// VolumeSnapshotContent represents a Kubernetes VolumeSnapshotContent.
+// Contents are usually created by the snapshot controller, therefore the spec
+// is only used to inspect an existing VolumeSnapshotContent.
+type VolumeSnapshotContent struct {
+	snapshotv1.VolumeSnapshotContent
+	k8sClient *kubernetes.Clientset
+	client    *snapshotter.Clientset
+}
+
+// NewVolumeSnapshotContent returns a VolumeSnapshotContent referencing an existing
+// VolumeSnapshotContent with the given name.
+func NewVolumeSnapshotContent(cfg *rest.Config, name string) VolumeSnapshotContent {
+	return newVolumeSnapshotContent(name, testutils.GetKubernetesClient(cfg), testutils.GetSnapshotterClient(cfg))
+}
+
+// newVolumeSnapshotContent returns a VolumeSnapshotContent that uses the given clients.
+func newVolumeSnapshotContent(name string, k8sClient *kubernetes.Clientset, client *snapshotter.Clientset) VolumeSnapshotContent {
+	manifest := snapshotv1.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+	}
+
+	return VolumeSnapshotContent{
+		VolumeSnapshotContent: manifest,
+		k8sClient:             k8sClient,
+		client:                client,
+	}
+}
+
+// BoundContent resolves the VolumeSnapshotContent bound to the VolumeSnapshot.
+// It waits until the VolumeSnapshot is bound to a VolumeSnapshotContent.
+func (snapshot VolumeSnapshot) BoundContent(ctx context.Context) VolumeSnapshotContent {
+	ginkgo.By("Resolve VolumeSnapshotContent bound to VolumeSnapshot " + snapshot.PrettyName())
+	var contentName string
+	isBound := func(ctx context.Context) bool {
+		state, err := snapshot.State(ctx)
+		if err != nil || state.Status == nil {
+			return false
+		}
+
+		contentName = ptr.Deref(state.Status.BoundVolumeSnapshotContentName, "")
+		return contentName != ""
+	}
+
+	gomega.Eventually(isBound).WithContext(ctx).Should(gomega.BeTrue(), "Snapshot %q is not bound to VolumeSnapshotContent\n%s", snapshot.PrettyName(), snapshot.StateString(ctx))
+
+	return newVolumeSnapshotContent(contentName, snapshot.k8sClient, snapshot.client)
+}
+
+// PrettyName returns the string consisting of VolumeSnapshotContent's name.
+func (content VolumeSnapshotContent) PrettyName() string {
+	return prettyName(content.Namespace, content.Name)
+}
+
+// Events returns the events related to the VolumeSnapshotContent.
+func (content VolumeSnapshotContent) Events(ctx context.Context) (*corev1.EventList, error) {
+	selector := fields.AndSelectors(
+		fields.OneTermEqualSelector("involvedObject.kind", "VolumeSnapshotContent"),
+		fields.OneTermEqualSelector("involvedObject.name", content.Name),
+	)
+
+	return content.k8sClient.CoreV1().Events("").List(ctx, metav1.ListOptions{
+		FieldSelector: selector.String(),
+	})
+}
+
+// State returns the actual state of the VolumeSnapshotContent.
+func (content VolumeSnapshotContent) State(ctx context.Context) (*snapshotv1.VolumeSnapshotContent, error) {
+	return content.client.SnapshotV1().VolumeSnapshotContents().Get(ctx, content.Name, metav1.GetOptions{})
+}
+
+// StateString returns the state of the VolumeSnapshotContent as a string.
+// This is useful to include in error messages when desired state is not achieved.
+func (content VolumeSnapshotContent) StateString(ctx context.Context) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "VolumeSnapshotContent %q state:\n", content.PrettyName())
+
+	state, err := content.State(ctx)
+	if err != nil {
+		fmt.Fprintln(&b, "- Failed to get state:", err.Error())
+	} else {
+		fmt.Fprintln(&b, "- DeletionPolicy:", state.Spec.DeletionPolicy)
+		fmt.Fprintln(&b, "- VolumeSnapshotRef:", prettyName(state.Spec.VolumeSnapshotRef.Namespace, state.Spec.VolumeSnapshotRef.Name))
+
+		if state.Status != nil {
+			fmt.Fprintln(&b, "- SnapshotHandle:", ptr.Deref(state.Status.SnapshotHandle, ""))
+			fmt.Fprintln(&b, "- ReadyToUse:", ptr.Deref(state.Status.ReadyToUse, false))
+
+			if state.Status.Error != nil {
+				fmt.Fprintf(&b, "- Error: %v\n", ptr.Deref(state.Status.Error.Message, ""))
+			}
+		}
+	}
+
+	events, err := content.Events(ctx)
+	if err != nil {
+		fmt.Fprintln(&b, "- Failed to get events:", err.Error())
+	} else {
+		for _, e := range events.Items {
+			fmt.Fprintf(&b, "- Event %s %s: %s\n", e.Type, e.Reason, e.Message)
+		}
+	}
+
+	return b.String()
+}
+
+// SnapshotHandle returns the snapshot handle of the VolumeSnapshotContent,
+// which corresponds to the snapshot ID returned by the CSI driver.
+func (content VolumeSnapshotContent) SnapshotHandle(ctx context.Context) string {
+	state, err := content.State(ctx)
+	gomega.Expect(err).NotTo(gomega.HaveOccurred(), "Failed to get VolumeSnapshotContent %q", content.PrettyName())
+	gomega.Expect(state.Status).NotTo(gomega.BeNil(), "VolumeSnapshotContent %q has no status\n%s", content.PrettyName(), content.StateString(ctx))
+
+	return ptr.Deref(state.Status.SnapshotHandle, "")
+}
+
+// WaitReady waits until the VolumeSnapshotContent is ready to be used.
+func (content VolumeSnapshotContent) WaitReady(ctx context.Context) {
+	ginkgo.By("Wait for VolumeSnapshotContent " + content.PrettyName() + " to be ready")
+	isReady := func(ctx context.Context) bool {
+		state, err := content.State(ctx)
+		if err != nil || state.Status == nil {
+			return false
+		}
+
+		return ptr.Deref(state.Status.ReadyToUse, false)
+	}
+
+	gomega.Eventually(isReady).WithContext(ctx).Should(gomega.BeTrue(), "VolumeSnapshotContent %q is not ready\n%s", content.PrettyName(), content.StateString(ctx))
+}
+
+// WaitGone waits until the VolumeSnapshotContent is no longer present in the Kubernetes cluster.
+func (content VolumeSnapshotContent) WaitGone(ctx context.Context) {
+	ginkgo.By("Wait for VolumeSnapshotContent " + content.PrettyName() + " to be gone")
+	contentGone := func(ctx context.Context) bool {
+		_, err := content.State(ctx)
+		return apierrors.IsNotFound(err)
+	}
+
+	gomega.Eventually(contentGone).WithContext(ctx).Should(gomega.BeTrue(), "VolumeSnapshotContent %q is not gone\n%s", content.PrettyName(), content.StateString(ctx))
+}
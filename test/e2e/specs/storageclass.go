@@ -10,6 +10,7 @@ import (
 	"github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -22,7 +23,7 @@ import (
 // StorageClass represents a Kubernetes StorageClass.
 type StorageClass struct {
 	storagev1.StorageClass
-	client *kubernetes.Clientset
+	client kubernetes.Interface
 }
 
 // NewStorageClass creates a new StorageClass definition with the given name
@@ -154,6 +155,7 @@ func (sc StorageClass) Delete(ctx context.Context) {
 	ginkgo.By("Delete StorageClass " + sc.PrettyName())
 	err := sc.delete(ctx, nil)
 	gomega.Expect(err).NotTo(gomega.HaveOccurred(), "Failed to delete StorageClass %q\n%s", sc.PrettyName(), sc.StateString(ctx))
+	sc.WaitGone(ctx)
 }
 
 // ForceDelete forcefully deletes the StorageClass from the Kubernetes cluster.
@@ -166,3 +168,48 @@ func (sc StorageClass) ForceDelete(ctx context.Context) {
 
 	_ = sc.delete(ctx, opts)
 }
+
+// WaitGone waits until the StorageClass is no longer present in the Kubernetes cluster.
+func (sc StorageClass) WaitGone(ctx context.Context) {
+	ginkgo.By("Wait for StorageClass " + sc.PrettyName() + " to be gone")
+	sc.waitGone(ctx)
+}
+
+// waitGone waits until the StorageClass is not found.
+func (sc StorageClass) waitGone(ctx context.Context) {
+	scGone := func(ctx context.Context) bool {
+		_, err := sc.State(ctx)
+		return apierrors.IsNotFound(err)
+	}
+
+	gomega.Eventually(scGone).WithContext(ctx).Should(gomega.BeTrue(), "StorageClass %q is not gone\n%s", sc.PrettyName(), sc.StateString(ctx))
+}
+
+// WaitNoVolumesReference waits until none of the PersistentVolumes in the
+// cluster reference the StorageClass. It fails if any PersistentVolume still
+// references the class once the wait times out.
+func (sc StorageClass) WaitNoVolumesReference(ctx context.Context) {
+	ginkgo.By("Wait for no PersistentVolumes to reference StorageClass " + sc.PrettyName())
+	sc.waitNoVolumesReference(ctx)
+}
+
+// waitNoVolumesReference waits until no PersistentVolume references the StorageClass.
+func (sc StorageClass) waitNoVolumesReference(ctx context.Context) {
+	referencingVolumes := func(ctx context.Context) ([]string, error) {
+		pvs, err := sc.client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		var names []string
+		for _, pv := range pvs.Items {
+			if pv.Spec.StorageClassName == sc.Name {
+				names = append(names, pv.Name)
+			}
+		}
+
+		return names, nil
+	}
+
+	gomega.Eventually(referencingVolumes).WithContext(ctx).Should(gomega.BeEmpty(), "PersistentVolumes still reference StorageClass %q", sc.PrettyName())
+}
@@ -0,0 +1,87 @@
+package specs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestStorageClassWaitGone(t *testing.T) {
+	g := gomega.NewWithT(t)
+	gomega.RegisterTestingT(t)
+	gomega.SetDefaultEventuallyTimeout(500 * time.Millisecond)
+	gomega.SetDefaultEventuallyPollingInterval(10 * time.Millisecond)
+	defer gomega.SetDefaultEventuallyTimeout(time.Second)
+	defer gomega.SetDefaultEventuallyPollingInterval(10 * time.Millisecond)
+
+	ctx := context.Background()
+	sc := StorageClass{
+		StorageClass: storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "sc"}},
+		client:       fake.NewClientset(),
+	}
+
+	// Missing StorageClass is gone immediately.
+	failures := gomega.InterceptGomegaFailures(func() { sc.waitGone(ctx) })
+	g.Expect(failures).To(gomega.BeEmpty())
+
+	// Existing StorageClass is reported as not gone. Eventually ignores the
+	// default timeout when a context is passed, so the wait is bounded by it.
+	_, err := sc.client.StorageV1().StorageClasses().Create(ctx, &sc.StorageClass, metav1.CreateOptions{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+
+	failures = gomega.InterceptGomegaFailures(func() { sc.waitGone(timeoutCtx) })
+	g.Expect(failures).NotTo(gomega.BeEmpty())
+
+	// StorageClass deleted while waiting.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = sc.delete(ctx, nil)
+	}()
+
+	failures = gomega.InterceptGomegaFailures(func() { sc.waitGone(ctx) })
+	g.Expect(failures).To(gomega.BeEmpty())
+}
+
+func TestStorageClassWaitNoVolumesReference(t *testing.T) {
+	g := gomega.NewWithT(t)
+	gomega.RegisterTestingT(t)
+	gomega.SetDefaultEventuallyTimeout(500 * time.Millisecond)
+	defer gomega.SetDefaultEventuallyTimeout(time.Second)
+
+	ctx := context.Background()
+	newPV := func(name string, className string) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       corev1.PersistentVolumeSpec{StorageClassName: className},
+		}
+	}
+
+	client := fake.NewClientset(newPV("pv-other", "other"))
+	sc := StorageClass{
+		StorageClass: storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "sc"}},
+		client:       client,
+	}
+
+	// Volumes of other classes are ignored.
+	failures := gomega.InterceptGomegaFailures(func() { sc.waitNoVolumesReference(ctx) })
+	g.Expect(failures).To(gomega.BeEmpty())
+
+	// Volume referencing the class causes a failure.
+	_, err := client.CoreV1().PersistentVolumes().Create(ctx, newPV("pv-sc", "sc"), metav1.CreateOptions{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+
+	failures = gomega.InterceptGomegaFailures(func() { sc.waitNoVolumesReference(timeoutCtx) })
+	g.Expect(failures).NotTo(gomega.BeEmpty())
+}
@@ -0,0 +1,126 @@
+package specs
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/canonical/lxd-csi-driver/internal/driver"
+	"github.com/canonical/lxd-csi-driver/test/testutils"
+)
+
+// VolumeAttributesClass represents a Kubernetes VolumeAttributesClass.
+type VolumeAttributesClass struct {
+	storagev1.VolumeAttributesClass
+	client kubernetes.Interface
+}
+
+// NewVolumeAttributesClass creates a new VolumeAttributesClass definition with the given name.
+func NewVolumeAttributesClass(cfg *rest.Config, namePrefix string) VolumeAttributesClass {
+	manifest := storagev1.VolumeAttributesClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: testutils.GenerateName(namePrefix),
+		},
+		DriverName: driver.DefaultDriverName,
+	}
+
+	return VolumeAttributesClass{
+		VolumeAttributesClass: manifest,
+		client:                testutils.GetKubernetesClient(cfg),
+	}
+}
+
+// PrettyName returns the string consisting of VolumeAttributesClass's name.
+func (vac VolumeAttributesClass) PrettyName() string {
+	return prettyName(vac.Namespace, vac.Name)
+}
+
+// WithParameters allows setting additional parameters for the VolumeAttributesClass.
+func (vac VolumeAttributesClass) WithParameters(params map[string]string) VolumeAttributesClass {
+	if vac.Parameters == nil {
+		vac.Parameters = make(map[string]string)
+	}
+
+	maps.Copy(vac.Parameters, params)
+	return vac
+}
+
+// State returns the actual state of the VolumeAttributesClass.
+func (vac VolumeAttributesClass) State(ctx context.Context) (*storagev1.VolumeAttributesClass, error) {
+	return vac.client.StorageV1().VolumeAttributesClasses().Get(ctx, vac.Name, metav1.GetOptions{})
+}
+
+// StateString returns the state of the VolumeAttributesClass as a string.
+// This is useful to include in error messages when desired state is not achieved.
+func (vac VolumeAttributesClass) StateString(ctx context.Context) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "VolumeAttributesClass %q state:\n", vac.PrettyName())
+
+	state, err := vac.State(ctx)
+	if err != nil {
+		fmt.Fprintln(&b, "- Failed to get state:", err.Error())
+	} else {
+		fmt.Fprintln(&b, "- DriverName:", state.DriverName)
+
+		if len(state.Parameters) > 0 {
+			fmt.Fprintf(&b, "- Parameters: %v\n", state.Parameters)
+		}
+	}
+
+	return b.String()
+}
+
+// Create creates the VolumeAttributesClass in the Kubernetes cluster.
+func (vac VolumeAttributesClass) Create(ctx context.Context) {
+	ginkgo.By("Create VolumeAttributesClass " + vac.PrettyName())
+	_, err := vac.client.StorageV1().VolumeAttributesClasses().Create(ctx, &vac.VolumeAttributesClass, metav1.CreateOptions{})
+	gomega.Expect(err).NotTo(gomega.HaveOccurred(), "Failed to create VolumeAttributesClass %q\n%s", vac.PrettyName(), vac.StateString(ctx))
+}
+
+// delete deletes the VolumeAttributesClass from the Kubernetes cluster.
+func (vac VolumeAttributesClass) delete(ctx context.Context, opts *metav1.DeleteOptions) error {
+	if opts == nil {
+		opts = &metav1.DeleteOptions{}
+	}
+
+	return vac.client.StorageV1().VolumeAttributesClasses().Delete(ctx, vac.Name, *opts)
+}
+
+// Delete deletes the VolumeAttributesClass from the Kubernetes cluster.
+func (vac VolumeAttributesClass) Delete(ctx context.Context) {
+	ginkgo.By("Delete VolumeAttributesClass " + vac.PrettyName())
+	err := vac.delete(ctx, nil)
+	gomega.Expect(err).NotTo(gomega.HaveOccurred(), "Failed to delete VolumeAttributesClass %q\n%s", vac.PrettyName(), vac.StateString(ctx))
+	vac.WaitGone(ctx)
+}
+
+// ForceDelete forcefully deletes the VolumeAttributesClass from the Kubernetes cluster.
+// It sets the grace period to 0 seconds to immediately remove the class.
+// This is useful for cleanup.
+func (vac VolumeAttributesClass) ForceDelete(ctx context.Context) {
+	opts := &metav1.DeleteOptions{
+		GracePeriodSeconds: new(int64),
+	}
+
+	_ = vac.delete(ctx, opts)
+}
+
+// WaitGone waits until the VolumeAttributesClass is no longer present in the Kubernetes cluster.
+func (vac VolumeAttributesClass) WaitGone(ctx context.Context) {
+	ginkgo.By("Wait for VolumeAttributesClass " + vac.PrettyName() + " to be gone")
+	vacGone := func(ctx context.Context) bool {
+		_, err := vac.State(ctx)
+		return apierrors.IsNotFound(err)
+	}
+
+	gomega.Eventually(vacGone).WithContext(ctx).Should(gomega.BeTrue(), "VolumeAttributesClass %q is not gone\n%s", vac.PrettyName(), vac.StateString(ctx))
+}
@@ -0,0 +1,279 @@
+package sanity
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	lxdClient "github.com/canonical/lxd/client"
+	"github.com/canonical/lxd/shared/api"
+
+	"github.com/canonical/lxd-csi-driver/internal/driver"
+)
+
+// fakeDevLXDOperation implements lxdClient.DevLXDOperation for an operation
+// that has already completed.
+type fakeDevLXDOperation struct {
+	lxdClient.DevLXDOperation
+}
+
+func (f *fakeDevLXDOperation) WaitContext(ctx context.Context) error {
+	return nil
+}
+
+// fakeDevLXDServer is an in-memory devLXD server backed by a single remote
+// storage pool. Only the methods used by the CSI driver are implemented.
+type fakeDevLXDServer struct {
+	driver.DevLXDClient
+
+	mu sync.Mutex
+
+	pool      api.DevLXDStoragePool
+	volumes   map[string]api.DevLXDStorageVolume
+	snapshots map[string]map[string]api.DevLXDStorageVolumeSnapshot
+	instances map[string]map[string]map[string]string
+}
+
+func newFakeDevLXDServer(poolName string) *fakeDevLXDServer {
+	return &fakeDevLXDServer{
+		pool: api.DevLXDStoragePool{
+			Name:   poolName,
+			Driver: "ceph",
+			Status: "Created",
+		},
+		volumes:   make(map[string]api.DevLXDStorageVolume),
+		snapshots: make(map[string]map[string]api.DevLXDStorageVolumeSnapshot),
+		instances: make(map[string]map[string]map[string]string),
+	}
+}
+
+func (f *fakeDevLXDServer) UseTarget(name string) driver.DevLXDClient {
+	return f
+}
+
+func (f *fakeDevLXDServer) UseBearerToken(bearerToken string) driver.DevLXDClient {
+	return f
+}
+
+func (f *fakeDevLXDServer) GetState() (*api.DevLXDGet, error) {
+	return &api.DevLXDGet{
+		DevLXDGetUntrusted: api.DevLXDGetUntrusted{
+			Auth: api.AuthTrusted,
+			SupportedStorageDrivers: []api.DevLXDServerStorageDriverInfo{
+				{Name: "ceph", Remote: true},
+			},
+		},
+	}, nil
+}
+
+func (f *fakeDevLXDServer) GetStoragePool(poolName string) (*api.DevLXDStoragePool, string, error) {
+	if poolName != f.pool.Name {
+		return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage pool not found")
+	}
+
+	pool := f.pool
+	return &pool, "", nil
+}
+
+func (f *fakeDevLXDServer) GetStoragePoolVolume(poolName string, volType string, volName string) (*api.DevLXDStorageVolume, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	vol, ok := f.volumes[volName]
+	if poolName != f.pool.Name || volType != "custom" || !ok {
+		return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage volume not found")
+	}
+
+	vol.Config = maps.Clone(vol.Config)
+	return &vol, "", nil
+}
+
+func (f *fakeDevLXDServer) CreateStoragePoolVolume(poolName string, req api.DevLXDStorageVolumesPost) (lxdClient.DevLXDOperation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if poolName != f.pool.Name {
+		return nil, api.StatusErrorf(http.StatusNotFound, "Storage pool not found")
+	}
+
+	_, ok := f.volumes[req.Name]
+	if ok {
+		return nil, api.StatusErrorf(http.StatusConflict, "Storage volume already exists")
+	}
+
+	if req.Source.Type == api.SourceTypeCopy {
+		sourceVolName, sourceSnapshotName, isSnapshot := strings.Cut(req.Source.Name, "/")
+
+		_, ok := f.volumes[sourceVolName]
+		if ok && isSnapshot {
+			_, ok = f.snapshots[sourceVolName][sourceSnapshotName]
+		}
+
+		if !ok {
+			return nil, api.StatusErrorf(http.StatusNotFound, "Source storage volume not found")
+		}
+	}
+
+	f.volumes[req.Name] = api.DevLXDStorageVolume{
+		Name:        req.Name,
+		Description: req.Description,
+		Pool:        poolName,
+		Type:        req.Type,
+		ContentType: req.ContentType,
+		Config:      maps.Clone(req.Config),
+	}
+
+	return &fakeDevLXDOperation{}, nil
+}
+
+func (f *fakeDevLXDServer) UpdateStoragePoolVolume(poolName string, volType string, volName string, req api.DevLXDStorageVolumePut, ETag string) (lxdClient.DevLXDOperation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	vol, ok := f.volumes[volName]
+	if poolName != f.pool.Name || volType != "custom" || !ok {
+		return nil, api.StatusErrorf(http.StatusNotFound, "Storage volume not found")
+	}
+
+	vol.Description = req.Description
+	vol.Config = maps.Clone(req.Config)
+	f.volumes[volName] = vol
+
+	return &fakeDevLXDOperation{}, nil
+}
+
+func (f *fakeDevLXDServer) DeleteStoragePoolVolume(poolName string, volType string, volName string) (lxdClient.DevLXDOperation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, ok := f.volumes[volName]
+	if poolName != f.pool.Name || volType != "custom" || !ok {
+		return nil, api.StatusErrorf(http.StatusNotFound, "Storage volume not found")
+	}
+
+	delete(f.volumes, volName)
+	delete(f.snapshots, volName)
+
+	return &fakeDevLXDOperation{}, nil
+}
+
+func (f *fakeDevLXDServer) GetStoragePoolVolumeSnapshots(poolName string, volType string, volName string) ([]api.DevLXDStorageVolumeSnapshot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, ok := f.volumes[volName]
+	if poolName != f.pool.Name || volType != "custom" || !ok {
+		return nil, api.StatusErrorf(http.StatusNotFound, "Storage volume not found")
+	}
+
+	snapshots := make([]api.DevLXDStorageVolumeSnapshot, 0, len(f.snapshots[volName]))
+	for _, snapshot := range f.snapshots[volName] {
+		snapshot.Config = maps.Clone(snapshot.Config)
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, nil
+}
+
+func (f *fakeDevLXDServer) GetStoragePoolVolumeSnapshot(poolName string, volType string, volName string, snapshotName string) (*api.DevLXDStorageVolumeSnapshot, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	snapshot, ok := f.snapshots[volName][snapshotName]
+	if poolName != f.pool.Name || volType != "custom" || !ok {
+		return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage volume snapshot not found")
+	}
+
+	snapshot.Config = maps.Clone(snapshot.Config)
+	return &snapshot, "", nil
+}
+
+func (f *fakeDevLXDServer) CreateStoragePoolVolumeSnapshot(poolName string, volType string, volName string, req api.DevLXDStorageVolumeSnapshotsPost) (lxdClient.DevLXDOperation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	vol, ok := f.volumes[volName]
+	if poolName != f.pool.Name || volType != "custom" || !ok {
+		return nil, api.StatusErrorf(http.StatusNotFound, "Storage volume not found")
+	}
+
+	_, ok = f.snapshots[volName][req.Name]
+	if ok {
+		return nil, api.StatusErrorf(http.StatusConflict, "Storage volume snapshot already exists")
+	}
+
+	if f.snapshots[volName] == nil {
+		f.snapshots[volName] = make(map[string]api.DevLXDStorageVolumeSnapshot)
+	}
+
+	f.snapshots[volName][req.Name] = api.DevLXDStorageVolumeSnapshot{
+		Name:        req.Name,
+		Description: req.Description,
+		ContentType: vol.ContentType,
+		Config:      maps.Clone(vol.Config),
+	}
+
+	return &fakeDevLXDOperation{}, nil
+}
+
+func (f *fakeDevLXDServer) DeleteStoragePoolVolumeSnapshot(poolName string, volType string, volName string, snapshotName string) (lxdClient.DevLXDOperation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, ok := f.snapshots[volName][snapshotName]
+	if poolName != f.pool.Name || volType != "custom" || !ok {
+		return nil, api.StatusErrorf(http.StatusNotFound, "Storage volume snapshot not found")
+	}
+
+	delete(f.snapshots[volName], snapshotName)
+
+	return &fakeDevLXDOperation{}, nil
+}
+
+// GetInstance returns the instance with the given name. Instances are created
+// on first access, as the sanity tests use arbitrary node IDs.
+func (f *fakeDevLXDServer) GetInstance(instName string) (*api.DevLXDInstance, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	devices := make(map[string]map[string]string, len(f.instances[instName]))
+	for name, device := range f.instances[instName] {
+		devices[name] = maps.Clone(device)
+	}
+
+	return &api.DevLXDInstance{Name: instName, Devices: devices}, "", nil
+}
+
+// UpdateInstance merges the given devices into the instance devices, where
+// a nil device removes the existing one. The mount path of each newly attached
+// filesystem volume is created to mimic LXD attaching the volume.
+func (f *fakeDevLXDServer) UpdateInstance(instName string, req api.DevLXDInstancePut, ETag string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.instances[instName] == nil {
+		f.instances[instName] = make(map[string]map[string]string)
+	}
+
+	for name, device := range req.Devices {
+		if device == nil {
+			delete(f.instances[instName], name)
+			continue
+		}
+
+		_, ok := f.instances[instName][name]
+		if !ok && device["type"] == "disk" && device["path"] != "" {
+			err := os.MkdirAll(device["path"], 0o755)
+			if err != nil {
+				return api.StatusErrorf(http.StatusInternalServerError, "Failed to create mount path: %v", err)
+			}
+		}
+
+		f.instances[instName][name] = maps.Clone(device)
+	}
+
+	return nil
+}
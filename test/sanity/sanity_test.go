@@ -0,0 +1,136 @@
+package sanity
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/canonical/lxd-csi-driver/internal/driver"
+)
+
+const sanityStoragePool = "sanity"
+
+// TestSanity runs the csi-sanity test suite against in-process controller
+// and node servers, which are backed by an in-memory devLXD server.
+//
+// The test is opt-in, as it requires the csi-sanity binary and root
+// privileges for the node tests. Set CSI_SANITY=1 to run it, and optionally
+// CSI_SANITY_BINARY to point to the csi-sanity binary if it is not in PATH.
+func TestSanity(t *testing.T) {
+	if os.Getenv("CSI_SANITY") == "" {
+		t.Skip("Set CSI_SANITY=1 to run the CSI sanity tests")
+	}
+
+	binary := os.Getenv("CSI_SANITY_BINARY")
+	if binary == "" {
+		binary = "csi-sanity"
+	}
+
+	binary, err := exec.LookPath(binary)
+	if err != nil {
+		t.Fatalf("Failed to find csi-sanity binary: %v", err)
+	}
+
+	tmpDir := t.TempDir()
+
+	tokenFile := filepath.Join(tmpDir, "token")
+	err = os.WriteFile(tokenFile, []byte("sanity"), 0o600)
+	if err != nil {
+		t.Fatalf("Failed to write devLXD token file: %v", err)
+	}
+
+	paramsFile := filepath.Join(tmpDir, "params.yaml")
+	err = os.WriteFile(paramsFile, []byte(driver.ParameterStoragePool+": "+sanityStoragePool+"\n"), 0o600)
+	if err != nil {
+		t.Fatalf("Failed to write test volume parameters file: %v", err)
+	}
+
+	devLXD := newFakeDevLXDServer(sanityStoragePool)
+	connector := func(endpoint string, bearerToken string) (driver.DevLXDClient, error) {
+		return devLXD, nil
+	}
+
+	controllerSocket := filepath.Join(tmpDir, "controller.sock")
+	nodeSocket := filepath.Join(tmpDir, "node.sock")
+	mountPath := filepath.Join(tmpDir, "volumes")
+
+	startDriver(t, driver.DriverOptions{
+		Endpoint:            "unix://" + controllerSocket,
+		DevLXDTokenFile:     tokenFile,
+		DevLXDConnector:     connector,
+		FileSystemMountPath: mountPath,
+		NodeID:              "sanity-node",
+		IsController:        true,
+		EnableSnapshots:     true,
+	}, controllerSocket)
+
+	startDriver(t, driver.DriverOptions{
+		Endpoint:            "unix://" + nodeSocket,
+		DevLXDTokenFile:     tokenFile,
+		DevLXDConnector:     connector,
+		FileSystemMountPath: mountPath,
+		NodeID:              "sanity-node",
+	}, nodeSocket)
+
+	cmd := exec.Command(binary,
+		"--csi.endpoint", "unix://"+nodeSocket,
+		"--csi.controllerendpoint", "unix://"+controllerSocket,
+		"--csi.testvolumeparameters", paramsFile,
+		"--csi.mountdir", filepath.Join(tmpDir, "mount"),
+		"--csi.stagingdir", filepath.Join(tmpDir, "staging"),
+	)
+
+	cmd.Stdout = testWriter{t}
+	cmd.Stderr = testWriter{t}
+
+	err = cmd.Run()
+	if err != nil {
+		t.Fatalf("CSI sanity tests failed: %v", err)
+	}
+}
+
+// startDriver runs the driver with the given options in the background and
+// waits until it listens on the given socket. The driver is stopped when the
+// test completes.
+func startDriver(t *testing.T, opts driver.DriverOptions, socket string) {
+	t.Helper()
+
+	d := driver.NewDriver(opts)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- d.Run()
+	}()
+
+	t.Cleanup(d.Stop)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-errCh:
+			t.Fatalf("Driver exited before serving requests: %v", err)
+		default:
+		}
+
+		_, err := os.Stat(socket)
+		if err == nil {
+			return
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	t.Fatalf("Timed out waiting for driver to listen on %q", socket)
+}
+
+// testWriter forwards the output of the csi-sanity binary to the test log.
+type testWriter struct {
+	t *testing.T
+}
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.t.Log(string(p))
+	return len(p), nil
+}
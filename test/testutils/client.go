@@ -1,13 +1,18 @@
 package testutils
 
 import (
+	"context"
 	"os"
 
 	snapshotter "github.com/kubernetes-csi/external-snapshotter/client/v8/clientset/versioned"
 	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/canonical/lxd-csi-driver/internal/driver"
 )
 
 // GetClientConfig reads the Kubeconfig file path from the K8S_KUBECONFIG_PATH
@@ -44,3 +49,65 @@ func GetSnapshotterClient(config *rest.Config) *snapshotter.Clientset {
 	gomega.Expect(err).NotTo(gomega.HaveOccurred())
 	return client
 }
+
+// getBoundPersistentVolume returns the PersistentVolume bound to the given
+// PersistentVolumeClaim. The PVC is expected to be bound.
+func getBoundPersistentVolume(ctx context.Context, client kubernetes.Interface, namespace string, pvcName string) *corev1.PersistentVolume {
+	pvc, err := client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
+	gomega.Expect(err).NotTo(gomega.HaveOccurred(), "Failed to get PVC %q", namespace+"/"+pvcName)
+	gomega.Expect(pvc.Spec.VolumeName).NotTo(gomega.BeEmpty(), "PVC %q is not bound", namespace+"/"+pvcName)
+
+	pv, err := client.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+	gomega.Expect(err).NotTo(gomega.HaveOccurred(), "Failed to get PV %q bound to PVC %q", pvc.Spec.VolumeName, namespace+"/"+pvcName)
+	gomega.Expect(pv.Spec.CSI).NotTo(gomega.BeNil(), "PV %q bound to PVC %q is not a CSI volume", pv.Name, namespace+"/"+pvcName)
+
+	return pv
+}
+
+// GetBoundVolumeID returns the CSI volume ID (volume handle) of the PersistentVolume
+// bound to the given PersistentVolumeClaim. The PVC is expected to be bound.
+func GetBoundVolumeID(ctx context.Context, client kubernetes.Interface, namespace string, pvcName string) string {
+	return getBoundPersistentVolume(ctx, client, namespace, pvcName).Spec.CSI.VolumeHandle
+}
+
+// GetBoundVolumeClusterMembers returns the LXD cluster members that the node
+// affinity of the PersistentVolume bound to the given PersistentVolumeClaim
+// restricts the volume to. The PVC is expected to be bound.
+func GetBoundVolumeClusterMembers(ctx context.Context, client kubernetes.Interface, namespace string, pvcName string) []string {
+	pv := getBoundPersistentVolume(ctx, client, namespace, pvcName)
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		return nil
+	}
+
+	var members []string
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		for _, expr := range term.MatchExpressions {
+			if expr.Key == driver.AnnotationLXDClusterMember && expr.Operator == corev1.NodeSelectorOpIn {
+				members = append(members, expr.Values...)
+			}
+		}
+	}
+
+	return members
+}
+
+// GetClusterMemberNodes returns the names of the Kubernetes nodes grouped by
+// the LXD cluster member they run on. The cluster member is taken from the
+// topology label that is set on the node once the node plugin is registered.
+// Nodes without the label are ignored.
+func GetClusterMemberNodes(ctx context.Context, client kubernetes.Interface) map[string][]string {
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	gomega.Expect(err).NotTo(gomega.HaveOccurred(), "Failed to list nodes")
+
+	memberNodes := make(map[string][]string)
+	for _, node := range nodes.Items {
+		member := node.Labels[driver.AnnotationLXDClusterMember]
+		if member == "" {
+			continue
+		}
+
+		memberNodes[member] = append(memberNodes[member], node.Name)
+	}
+
+	return memberNodes
+}
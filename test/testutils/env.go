@@ -0,0 +1,29 @@
+package testutils
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// GetEnvDuration returns the duration set in the environment variable with the
+// given name, or the default value if the variable is not set. The value must
+// be a positive duration in the format accepted by [time.ParseDuration], for
+// example "90s" or "5m".
+func GetEnvDuration(name string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid duration %q in environment variable %q: %w", value, name, err)
+	}
+
+	if duration <= 0 {
+		return 0, fmt.Errorf("Invalid duration %q in environment variable %q: Must be greater than zero", value, name)
+	}
+
+	return duration, nil
+}
@@ -0,0 +1,66 @@
+package testutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+)
+
+func TestGetEnvDuration(t *testing.T) {
+	const envName = "TEST_LXD_CSI_DURATION"
+
+	tests := []struct {
+		Name           string
+		Value          string
+		expectDuration time.Duration
+		expectError    string
+	}{
+		{
+			Name:           "Unset variable uses default",
+			Value:          "",
+			expectDuration: time.Minute,
+		},
+		{
+			Name:           "Seconds",
+			Value:          "90s",
+			expectDuration: 90 * time.Second,
+		},
+		{
+			Name:           "Composite duration",
+			Value:          "1m30s",
+			expectDuration: 90 * time.Second,
+		},
+		{
+			Name:        "Missing unit",
+			Value:       "90",
+			expectError: `Invalid duration "90" in environment variable "TEST_LXD_CSI_DURATION"`,
+		},
+		{
+			Name:        "Zero duration",
+			Value:       "0s",
+			expectError: `Invalid duration "0s" in environment variable "TEST_LXD_CSI_DURATION": Must be greater than zero`,
+		},
+		{
+			Name:        "Negative duration",
+			Value:       "-5s",
+			expectError: `Invalid duration "-5s" in environment variable "TEST_LXD_CSI_DURATION": Must be greater than zero`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			g := gomega.NewWithT(t)
+			t.Setenv(envName, test.Value)
+
+			duration, err := GetEnvDuration(envName, time.Minute)
+			if test.expectError != "" {
+				g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(test.expectError)))
+				return
+			}
+
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			g.Expect(duration).To(gomega.Equal(test.expectDuration))
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package testutils
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+
+	lxd "github.com/canonical/lxd/client"
+	lxdConfig "github.com/canonical/lxd/lxc/config"
+	"github.com/canonical/lxd/shared/api"
+)
+
+var lxdClient lxd.InstanceServer
+
+// GetLXDClient returns a client connected to the LXD server using the default
+// remote from the LXD client configuration. For the local LXD server, the client
+// connects over the LXD unix socket.
+func GetLXDClient() lxd.InstanceServer {
+	if lxdClient != nil {
+		return lxdClient
+	}
+
+	var configDir string
+
+	// Determine LXD configuration directory. First check for the presence
+	// of the /var/snap/lxd directory. If the directory exists, use snap's
+	// config path. Otherwise fallback to non-snap config path.
+	_, err := os.Stat("/var/snap/lxd")
+	if err == nil || os.IsExist(err) {
+		configDir = "$HOME/snap/lxd/common/config"
+	} else {
+		configDir = "$HOME/.config/lxc"
+	}
+
+	configDir = os.ExpandEnv(configDir)
+	configPath := filepath.Join(configDir, "config.yml")
+
+	// Try to load client config from determined configDir.
+	// Otherwise load default config.
+	config, err := lxdConfig.LoadConfig(configPath)
+	if err != nil {
+		config = lxdConfig.DefaultConfig()
+	}
+
+	lxdClient, err = config.GetInstanceServer(config.DefaultRemote)
+	gomega.Expect(err).NotTo(gomega.HaveOccurred(), "Failed to connect to LXD using default remote: %v", err)
+
+	return lxdClient
+}
+
+// IsClusteredTestEnvironment returns true if tests that require clustered LXD
+// are enabled using the TEST_LXD_CLUSTERED environment variable and the LXD
+// server is clustered. On standalone LXD, it returns false even when enabled,
+// so that such tests are skipped rather than failed.
+func IsClusteredTestEnvironment() bool {
+	value := os.Getenv("TEST_LXD_CLUSTERED")
+	if value == "" {
+		return false
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	gomega.Expect(err).NotTo(gomega.HaveOccurred(), "Invalid value %q for TEST_LXD_CLUSTERED environment variable: %v", value, err)
+
+	return enabled && GetLXDClient().IsClustered()
+}
+
+// splitVolumeID splits the CSI volume ID in format "[<clusterMember>:]<poolName>/<volumeName>"
+// into cluster member name, pool name, and volume name.
+func splitVolumeID(volumeID string) (clusterMember string, poolName string, volName string) {
+	if strings.Contains(volumeID, ":") {
+		clusterMember, volumeID, _ = strings.Cut(volumeID, ":")
+	}
+
+	poolName, volName, ok := strings.Cut(volumeID, "/")
+	gomega.Expect(ok).To(gomega.BeTrue(), "Invalid volume ID %q", volumeID)
+
+	return clusterMember, poolName, volName
+}
+
+// getLXDVolumeClient returns the LXD client targeting the cluster member referenced
+// by the given CSI volume ID, along with the volume's pool and name.
+func getLXDVolumeClient(volumeID string) (client lxd.InstanceServer, poolName string, volName string) {
+	clusterMember, poolName, volName := splitVolumeID(volumeID)
+
+	client = GetLXDClient()
+	if clusterMember != "" && client.IsClustered() {
+		client = client.UseTarget(clusterMember)
+	}
+
+	return client, poolName, volName
+}
+
+// GetLXDVolume returns the LXD custom volume referenced by the given CSI volume ID.
+func GetLXDVolume(volumeID string) *api.StorageVolume {
+	client, poolName, volName := getLXDVolumeClient(volumeID)
+
+	vol, _, err := client.GetStoragePoolVolume(poolName, "custom", volName)
+	gomega.Expect(err).NotTo(gomega.HaveOccurred(), "Failed to get LXD volume %q from storage pool %q", volName, poolName)
+
+	return vol
+}
+
+// GetLXDVolumeConfig returns the configuration of the LXD custom volume
+// referenced by the given CSI volume ID.
+func GetLXDVolumeConfig(volumeID string) map[string]string {
+	return GetLXDVolume(volumeID).Config
+}
+
+// lxdVolumeGetter is the subset of the LXD client used to look up custom volumes.
+type lxdVolumeGetter interface {
+	GetStoragePoolVolume(pool string, volType string, name string) (*api.StorageVolume, string, error)
+}
+
+// LXDVolumeExists returns true if the LXD custom volume referenced by the given
+// CSI volume ID exists.
+func LXDVolumeExists(volumeID string) (bool, error) {
+	client, poolName, volName := getLXDVolumeClient(volumeID)
+	return lxdVolumeExists(client, poolName, volName)
+}
+
+// lxdVolumeExists returns true if the LXD custom volume exists in the given
+// storage pool.
+func lxdVolumeExists(client lxdVolumeGetter, poolName string, volName string) (bool, error) {
+	_, _, err := client.GetStoragePoolVolume(poolName, "custom", volName)
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// WaitLXDVolumeGone waits until the LXD custom volume referenced by the given
+// CSI volume ID no longer exists. This ensures that the volume is actually
+// removed from LXD, and not only from Kubernetes.
+func WaitLXDVolumeGone(ctx context.Context, volumeID string) {
+	ginkgo.By("Wait for LXD volume " + volumeID + " to be gone")
+	client, poolName, volName := getLXDVolumeClient(volumeID)
+	waitLXDVolumeGone(ctx, client, poolName, volName)
+}
+
+// waitLXDVolumeGone polls LXD until the custom volume is not found in the
+// given storage pool. Errors other than not found are retried.
+func waitLXDVolumeGone(ctx context.Context, client lxdVolumeGetter, poolName string, volName string) {
+	volumeExists := func() (bool, error) {
+		return lxdVolumeExists(client, poolName, volName)
+	}
+
+	gomega.Eventually(volumeExists).WithContext(ctx).Should(gomega.BeFalse(), "LXD volume %q in storage pool %q is not gone", volName, poolName)
+}
@@ -0,0 +1,69 @@
+package testutils
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+
+	"github.com/canonical/lxd/shared/api"
+)
+
+// fakeLXDVolumeGetter reports a volume as existing until it is looked up
+// the configured number of times.
+type fakeLXDVolumeGetter struct {
+	calls       atomic.Int32
+	existsCalls int32
+	err         error
+}
+
+func (f *fakeLXDVolumeGetter) GetStoragePoolVolume(pool string, volType string, name string) (*api.StorageVolume, string, error) {
+	if f.calls.Add(1) <= f.existsCalls {
+		if f.err != nil {
+			return nil, "", f.err
+		}
+
+		return &api.StorageVolume{Name: name, Type: volType}, "", nil
+	}
+
+	return nil, "", api.StatusErrorf(http.StatusNotFound, "Storage volume not found")
+}
+
+func TestWaitLXDVolumeGone(t *testing.T) {
+	g := gomega.NewWithT(t)
+	gomega.RegisterTestingT(t)
+	gomega.SetDefaultEventuallyTimeout(500 * time.Millisecond)
+	gomega.SetDefaultEventuallyPollingInterval(10 * time.Millisecond)
+	defer gomega.SetDefaultEventuallyTimeout(time.Second)
+	defer gomega.SetDefaultEventuallyPollingInterval(10 * time.Millisecond)
+
+	ctx := context.Background()
+
+	// Missing volume is gone immediately.
+	client := &fakeLXDVolumeGetter{}
+	failures := gomega.InterceptGomegaFailures(func() { waitLXDVolumeGone(ctx, client, "pool", "vol") })
+	g.Expect(failures).To(gomega.BeEmpty())
+	g.Expect(client.calls.Load()).To(gomega.BeEquivalentTo(1))
+
+	// Volume deleted while waiting.
+	client = &fakeLXDVolumeGetter{existsCalls: 3}
+	failures = gomega.InterceptGomegaFailures(func() { waitLXDVolumeGone(ctx, client, "pool", "vol") })
+	g.Expect(failures).To(gomega.BeEmpty())
+	g.Expect(client.calls.Load()).To(gomega.BeEquivalentTo(4))
+
+	// Transient errors are retried.
+	client = &fakeLXDVolumeGetter{existsCalls: 2, err: api.StatusErrorf(http.StatusInternalServerError, "Database is busy")}
+	failures = gomega.InterceptGomegaFailures(func() { waitLXDVolumeGone(ctx, client, "pool", "vol") })
+	g.Expect(failures).To(gomega.BeEmpty())
+
+	// Leaked volume is reported once the context is done.
+	timeoutCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+
+	client = &fakeLXDVolumeGetter{existsCalls: 1 << 30}
+	failures = gomega.InterceptGomegaFailures(func() { waitLXDVolumeGone(timeoutCtx, client, "pool", "vol") })
+	g.Expect(failures).To(gomega.ContainElement(gomega.ContainSubstring(`LXD volume "vol" in storage pool "pool" is not gone`)))
+}
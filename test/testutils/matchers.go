@@ -0,0 +1,32 @@
+package testutils
+
+import (
+	"strconv"
+
+	"github.com/onsi/gomega"
+	"github.com/onsi/gomega/types"
+
+	"github.com/canonical/lxd/shared/api"
+)
+
+// HaveLXDVolumeSize succeeds if the LXD volume has the given size (in bytes) configured.
+func HaveLXDVolumeSize(sizeBytes int64) types.GomegaMatcher {
+	return gomega.WithTransform(func(vol *api.StorageVolume) string {
+		return vol.Config["size"]
+	}, gomega.Equal(strconv.FormatInt(sizeBytes, 10)))
+}
+
+// HaveLXDVolumeDescription succeeds if the LXD volume has the given description.
+func HaveLXDVolumeDescription(description string) types.GomegaMatcher {
+	return gomega.WithTransform(func(vol *api.StorageVolume) string {
+		return vol.Description
+	}, gomega.Equal(description))
+}
+
+// HaveLXDVolumeContentType succeeds if the LXD volume has the given content type
+// ("filesystem" or "block").
+func HaveLXDVolumeContentType(contentType string) types.GomegaMatcher {
+	return gomega.WithTransform(func(vol *api.StorageVolume) string {
+		return vol.ContentType
+	}, gomega.Equal(contentType))
+}